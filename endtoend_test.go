@@ -1,15 +1,44 @@
 package toydb
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/lfritz/toydb/planner"
+	"github.com/lfritz/toydb/query"
 	"github.com/lfritz/toydb/sql"
 	"github.com/lfritz/toydb/storage"
 	"github.com/lfritz/toydb/types"
 )
 
+func checkQuery(t *testing.T, db *storage.Database, query string, want *types.Relation) {
+	t.Helper()
+
+	stmt, err := sql.Parse(query)
+	if err != nil {
+		t.Fatalf("sql.Parse returned error: %v", err)
+	}
+
+	plan, err := planner.Plan(stmt, db)
+	if err != nil {
+		t.Fatalf("planner.Plan returned error: %v", err)
+	}
+
+	got, err := plan.Run(context.Background(), db)
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Query result for\n%s\ngot:\n%s\nwant:\n%s\n", query, got, want)
+	}
+}
+
 func TestAll(t *testing.T) {
 	sampleData := storage.GetSampleData()
 	query := `
@@ -30,18 +59,852 @@ where films.release_date > date '1924-01-01'`
 		},
 	}
 
-	stmt, err := sql.Parse(query)
+	checkQuery(t, sampleData.Database, query, want)
+}
+
+func TestNullComparison(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	query := `
+select people.name, films.name
+from people
+left join films on people.id = films.director
+where films.release_date < date '1925-01-01' or films.id is null`
+	want := &types.Relation{
+		Schema: types.TableSchema{
+			Columns: []types.ColumnSchema{
+				{"people.name", types.TypeText, false},
+				{"films.name", types.TypeText, true},
+			},
+		},
+		Rows: [][]types.Value{
+			[]types.Value{types.Txt("Buster Keaton"), types.Txt("Sherlock Jr.")},
+			[]types.Value{types.Txt("Charlie Chaplin"), types.Txt("The Kid")},
+			[]types.Value{types.Txt("Harold Lloyd"), types.NewNull(types.TypeText)},
+		},
+	}
+
+	checkQuery(t, sampleData.Database, query, want)
+}
+
+func TestArithmetic(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	query := `
+select films.name, films.id * 10 + films.director / 4
+from films
+where films.id % 2 = 1`
+	want := &types.Relation{
+		Schema: types.TableSchema{
+			Columns: []types.ColumnSchema{
+				{"films.name", types.TypeText, false},
+				{"?column?", types.TypeDecimal, false},
+			},
+		},
+		Rows: [][]types.Value{
+			[]types.Value{types.Txt("The General"), types.Dec("10.25")},
+			[]types.Value{types.Txt("Sherlock Jr."), types.Dec("30.25")},
+		},
+	}
+
+	checkQuery(t, sampleData.Database, query, want)
+}
+
+func TestOrderBy(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	query := `
+select people.name, films.name
+from people
+left join films on people.id = films.director
+order by films.release_date nulls first, 1 desc`
+	want := &types.Relation{
+		Schema: types.TableSchema{
+			Columns: []types.ColumnSchema{
+				{"people.name", types.TypeText, false},
+				{"films.name", types.TypeText, true},
+			},
+		},
+		Rows: [][]types.Value{
+			[]types.Value{types.Txt("Harold Lloyd"), types.NewNull(types.TypeText)},
+			[]types.Value{types.Txt("Charlie Chaplin"), types.Txt("The Kid")},
+			[]types.Value{types.Txt("Buster Keaton"), types.Txt("Sherlock Jr.")},
+			[]types.Value{types.Txt("Buster Keaton"), types.Txt("The General")},
+		},
+	}
+	checkQuery(t, sampleData.Database, query, want)
+}
+
+func TestLimit(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	query := `
+select films.name
+from films
+order by films.release_date desc
+limit 1 + 1 offset 1`
+	want := &types.Relation{
+		Schema: types.TableSchema{
+			Columns: []types.ColumnSchema{
+				{"films.name", types.TypeText, false},
+			},
+		},
+		Rows: [][]types.Value{
+			[]types.Value{types.Txt("Sherlock Jr.")},
+			[]types.Value{types.Txt("The Kid")},
+		},
+	}
+	checkQuery(t, sampleData.Database, query, want)
+}
+
+func TestGroupBy(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	query := `
+select people.name, count(films.id), min(films.release_date)
+from people
+left join films on people.id = films.director
+group by people.name
+order by count(films.id) desc, people.name`
+	want := &types.Relation{
+		Schema: types.TableSchema{
+			Columns: []types.ColumnSchema{
+				{"people.name", types.TypeText, false},
+				{"count", types.TypeDecimal, false},
+				{"min", types.TypeDate, true},
+			},
+		},
+		Rows: [][]types.Value{
+			[]types.Value{types.Txt("Buster Keaton"), types.Dec("2"), types.Dat(1924, 4, 21)},
+			[]types.Value{types.Txt("Charlie Chaplin"), types.Dec("1"), types.Dat(1921, 1, 21)},
+			[]types.Value{types.Txt("Harold Lloyd"), types.Dec("0"), types.NewNull(types.TypeDate)},
+		},
+	}
+	checkQuery(t, sampleData.Database, query, want)
+}
+
+func TestHaving(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	query := `
+select people.name
+from films
+join people on films.director = people.id
+group by people.name
+having count(*) > 1 and max(films.release_date) > date '1925-01-01'`
+	want := &types.Relation{
+		Schema: types.TableSchema{
+			Columns: []types.ColumnSchema{
+				{"people.name", types.TypeText, false},
+			},
+		},
+		Rows: [][]types.Value{
+			[]types.Value{types.Txt("Buster Keaton")},
+		},
+	}
+	checkQuery(t, sampleData.Database, query, want)
+}
+
+func TestDistinct(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	query := `
+select distinct films.director
+from people
+left join films on people.id = films.director
+order by films.director nulls first`
+	want := &types.Relation{
+		Schema: types.TableSchema{
+			Columns: []types.ColumnSchema{
+				{"films.director", types.TypeDecimal, true},
+			},
+		},
+		Rows: [][]types.Value{
+			[]types.Value{types.NewNull(types.TypeDecimal)},
+			[]types.Value{types.Dec("1")},
+			[]types.Value{types.Dec("2")},
+		},
+	}
+	checkQuery(t, sampleData.Database, query, want)
+
+	query = "select count(distinct director) from films"
+	want = &types.Relation{
+		Schema: types.TableSchema{
+			Columns: []types.ColumnSchema{
+				{"count", types.TypeDecimal, false},
+			},
+		},
+		Rows: [][]types.Value{
+			[]types.Value{types.Dec("2")},
+		},
+	}
+	checkQuery(t, sampleData.Database, query, want)
+}
+
+func TestAliases(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	query := `
+select people.name as director, count(*) films, count(*) > 1, count(*) < 3
+from films
+join people on films.director = people.id
+group by people.name
+having films > 1 or director = 'Charlie Chaplin'
+order by films`
+	want := &types.Relation{
+		Schema: types.TableSchema{
+			Columns: []types.ColumnSchema{
+				{"director", types.TypeText, false},
+				{"films", types.TypeDecimal, false},
+				{"?column?", types.TypeBoolean, false},
+				{"?column?_2", types.TypeBoolean, false},
+			},
+		},
+		Rows: [][]types.Value{
+			[]types.Value{types.Txt("Charlie Chaplin"), types.Dec("1"), types.Boo(false), types.Boo(true)},
+			[]types.Value{types.Txt("Buster Keaton"), types.Dec("2"), types.Boo(true), types.Boo(true)},
+		},
+	}
+	checkQuery(t, sampleData.Database, query, want)
+
+	query = "select count(director), count(distinct director) from films"
+	want = &types.Relation{
+		Schema: types.TableSchema{
+			Columns: []types.ColumnSchema{
+				{"count", types.TypeDecimal, false},
+				{"count_2", types.TypeDecimal, false},
+			},
+		},
+		Rows: [][]types.Value{
+			[]types.Value{types.Dec("3"), types.Dec("2")},
+		},
+	}
+	checkQuery(t, sampleData.Database, query, want)
+}
+
+func TestSelfJoin(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	query := `
+select f.name, g.name as later
+from films f
+join films as g on f.director = g.director and f.release_date < g.release_date`
+	want := &types.Relation{
+		Schema: types.TableSchema{
+			Columns: []types.ColumnSchema{
+				{"f.name", types.TypeText, false},
+				{"later", types.TypeText, false},
+			},
+		},
+		Rows: [][]types.Value{
+			[]types.Value{types.Txt("Sherlock Jr."), types.Txt("The General")},
+		},
+	}
+	checkQuery(t, sampleData.Database, query, want)
+}
+
+func TestMultiWayJoin(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	query := `
+select f.name, p.name, g.name
+from films f
+join people p on f.director = p.id
+join films g on g.director = p.id and g.id <> f.id
+order by f.id`
+	want := &types.Relation{
+		Schema: types.TableSchema{
+			Columns: []types.ColumnSchema{
+				{"f.name", types.TypeText, false},
+				{"p.name", types.TypeText, false},
+				{"g.name", types.TypeText, false},
+			},
+		},
+		Rows: [][]types.Value{
+			[]types.Value{types.Txt("The General"), types.Txt("Buster Keaton"), types.Txt("Sherlock Jr.")},
+			[]types.Value{types.Txt("Sherlock Jr."), types.Txt("Buster Keaton"), types.Txt("The General")},
+		},
+	}
+	checkQuery(t, sampleData.Database, query, want)
+
+	// the same query with a comma-separated list and a parenthesized join
+	query = `
+select f.name, p.name, g.name
+from films g, (films f join people p on f.director = p.id)
+where g.director = p.id and g.id <> f.id
+order by f.id`
+	checkQuery(t, sampleData.Database, query, want)
+}
+
+func TestCrossJoin(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	query := `
+select films.id, people.id
+from films cross join people
+where films.id < 3 and people.id > 1
+order by films.id, people.id`
+	want := &types.Relation{
+		Schema: types.TableSchema{
+			Columns: []types.ColumnSchema{
+				{"films.id", types.TypeDecimal, false},
+				{"people.id", types.TypeDecimal, false},
+			},
+		},
+		Rows: [][]types.Value{
+			[]types.Value{types.Dec("1"), types.Dec("2")},
+			[]types.Value{types.Dec("1"), types.Dec("3")},
+			[]types.Value{types.Dec("2"), types.Dec("2")},
+			[]types.Value{types.Dec("2"), types.Dec("3")},
+		},
+	}
+	checkQuery(t, sampleData.Database, query, want)
+}
+
+func TestFullOuterJoin(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	query := `
+select films.name, people.name
+from films
+full outer join people on films.director = people.id and films.id <> 1`
+	want := &types.Relation{
+		Schema: types.TableSchema{
+			Columns: []types.ColumnSchema{
+				{"films.name", types.TypeText, true},
+				{"people.name", types.TypeText, true},
+			},
+		},
+		Rows: [][]types.Value{
+			[]types.Value{types.Txt("The General"), types.NewNull(types.TypeText)},
+			[]types.Value{types.Txt("The Kid"), types.Txt("Charlie Chaplin")},
+			[]types.Value{types.Txt("Sherlock Jr."), types.Txt("Buster Keaton")},
+			[]types.Value{types.NewNull(types.TypeText), types.Txt("Harold Lloyd")},
+		},
+	}
+	checkQuery(t, sampleData.Database, query, want)
+}
+
+func createScores(t *testing.T, db *storage.Database) {
+	t.Helper()
+	scores, err := db.CreateTable("scores", types.TableSchema{
+		Columns: []types.ColumnSchema{
+			{"id", types.TypeDecimal, false},
+			{"score", types.TypeDecimal, false},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateTable returned error: %v", err)
+	}
+	scores.Rows = [][]types.Value{
+		{types.Dec("2"), types.Dec("8")},
+		{types.Dec("4"), types.Dec("7")},
+	}
+}
+
+func TestJoinUsing(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	createScores(t, sampleData.Database)
+
+	query := "select * from films join scores using (id)"
+	want := &types.Relation{
+		Schema: types.TableSchema{
+			Columns: []types.ColumnSchema{
+				{"id", types.TypeDecimal, false},
+				{"films.name", types.TypeText, false},
+				{"films.release_date", types.TypeDate, false},
+				{"films.director", types.TypeDecimal, false},
+				{"scores.score", types.TypeDecimal, false},
+			},
+		},
+		Rows: [][]types.Value{
+			[]types.Value{types.Dec("2"), types.Txt("The Kid"), types.Dat(1921, 1, 21), types.Dec("2"), types.Dec("8")},
+		},
+	}
+	checkQuery(t, sampleData.Database, query, want)
+
+	// a natural join uses the same column
+	query = "select * from films natural join scores"
+	checkQuery(t, sampleData.Database, query, want)
+
+	query = "select id, name, score from films natural full outer join scores order by id"
+	want = &types.Relation{
+		Schema: types.TableSchema{
+			Columns: []types.ColumnSchema{
+				{"id", types.TypeDecimal, true},
+				{"films.name", types.TypeText, true},
+				{"scores.score", types.TypeDecimal, true},
+			},
+		},
+		Rows: [][]types.Value{
+			[]types.Value{types.Dec("1"), types.Txt("The General"), types.NewNull(types.TypeDecimal)},
+			[]types.Value{types.Dec("2"), types.Txt("The Kid"), types.Dec("8")},
+			[]types.Value{types.Dec("3"), types.Txt("Sherlock Jr."), types.NewNull(types.TypeDecimal)},
+			[]types.Value{types.Dec("4"), types.NewNull(types.TypeText), types.Dec("7")},
+		},
+	}
+	checkQuery(t, sampleData.Database, query, want)
+
+	query = "select id, score from films right join scores using (id)"
+	want = &types.Relation{
+		Schema: types.TableSchema{
+			Columns: []types.ColumnSchema{
+				{"id", types.TypeDecimal, false},
+				{"scores.score", types.TypeDecimal, false},
+			},
+		},
+		Rows: [][]types.Value{
+			[]types.Value{types.Dec("2"), types.Dec("8")},
+			[]types.Value{types.Dec("4"), types.Dec("7")},
+		},
+	}
+	checkQuery(t, sampleData.Database, query, want)
+}
+
+func TestJoinStrategies(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	createScores(t, sampleData.Database)
+
+	queries := []string{
+		"select * from films join people on films.director = people.id",
+		"select * from people left join films on films.director = people.id",
+		"select * from films right join scores on films.id = scores.id",
+		"select * from films full join scores on scores.id = films.id",
+		"select * from films f join films g on f.director = g.director and f.id = g.id",
+		"select * from films natural full join scores",
+		"select * from films f join scores s using (id) join people p on p.id = f.director",
+	}
+	strategies := []planner.JoinStrategy{
+		planner.JoinStrategyHash,
+		planner.JoinStrategyMerge,
+	}
+	for _, query := range queries {
+		stmt, err := sql.Parse(query)
+		if err != nil {
+			t.Fatalf("sql.Parse returned error: %v", err)
+		}
+		plan, err := planner.PlanWithOptions(stmt, sampleData.Database, planner.Options{
+			JoinStrategy: planner.JoinStrategyNestedLoop,
+		})
+		if err != nil {
+			t.Fatalf("planner.PlanWithOptions returned error: %v", err)
+		}
+		want, err := plan.Run(context.Background(), sampleData.Database)
+		if err != nil {
+			t.Fatalf("Run returned error: %v", err)
+		}
+		if len(want.Rows) == 0 {
+			t.Fatalf("Query returned no rows: %s", query)
+		}
+
+		for _, strategy := range strategies {
+			plan, err := planner.PlanWithOptions(stmt, sampleData.Database, planner.Options{
+				JoinStrategy: strategy,
+			})
+			if err != nil {
+				t.Fatalf("planner.PlanWithOptions returned error: %v", err)
+			}
+			got, err := plan.Run(context.Background(), sampleData.Database)
+			if err != nil {
+				t.Fatalf("Run returned error: %v", err)
+			}
+			if !reflect.DeepEqual(got.Schema, want.Schema) ||
+				!reflect.DeepEqual(sortedRowKeys(got.Rows), sortedRowKeys(want.Rows)) {
+				t.Errorf("Query result for %s with %s join got:\n%s\nwant:\n%s\n", query, strategy, got, want)
+			}
+		}
+	}
+}
+
+// sortedRowKeys returns the rows in a canonical order, for comparing results regardless of order.
+func sortedRowKeys(rows [][]types.Value) []string {
+	keys := make([]string, len(rows))
+	for i, row := range rows {
+		keys[i] = types.RowKey(row)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	queries := []string{
+		"select id / 0 from films",
+		"select * from films where director % (id - id) = 1",
+		"select * from films join people on films.director / 0 = people.id",
+		"select director, sum(id / (director - 1)) from films group by director",
+	}
+	for _, input := range queries {
+		stmt, err := sql.Parse(input)
+		if err != nil {
+			t.Fatalf("sql.Parse returned error: %v", err)
+		}
+		plan, err := planner.Plan(stmt, sampleData.Database)
+		if err != nil {
+			t.Fatalf("planner.Plan returned error: %v", err)
+		}
+		_, err = plan.Run(context.Background(), sampleData.Database)
+		var runtimeError query.RuntimeError
+		if !errors.As(err, &runtimeError) {
+			t.Errorf("Run for %q returned %v, want RuntimeError", input, err)
+		}
+		if !errors.Is(err, types.ErrDivisionByZero) {
+			t.Errorf("Run for %q returned %v, want %v", input, err, types.ErrDivisionByZero)
+		}
+	}
+
+	stmt, err := sql.Parse("select * from films limit 1 / 0")
 	if err != nil {
 		t.Fatalf("sql.Parse returned error: %v", err)
 	}
+	if _, err := planner.Plan(stmt, sampleData.Database); err == nil {
+		t.Errorf("planner.Plan did not return error for division by zero in limit")
+	}
+}
 
+func TestMemoryLimit(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	stmt, err := sql.Parse("select * from films, people, films f, people p, films g")
+	if err != nil {
+		t.Fatalf("sql.Parse returned error: %v", err)
+	}
 	plan, err := planner.Plan(stmt, sampleData.Database)
 	if err != nil {
 		t.Fatalf("planner.Plan returned error: %v", err)
 	}
 
-	got := plan.Run(sampleData.Database)
-	if !reflect.DeepEqual(got, want) {
-		t.Errorf("Query result for\n%s\ngot:\n%s\nwant:\n%s\n", query, got, want)
+	ctx := query.WithMemoryAccountant(context.Background(), query.NewMemoryAccountant(10000))
+	_, err = plan.Run(ctx, sampleData.Database)
+	var memoryLimitError query.MemoryLimitError
+	if !errors.As(err, &memoryLimitError) {
+		t.Fatalf("Run returned %v, want MemoryLimitError", err)
+	}
+	if memoryLimitError.Node != "Join" {
+		t.Errorf("Run returned error for %s step, want Join", memoryLimitError.Node)
+	}
+
+	ctx = query.WithMemoryAccountant(context.Background(), query.NewMemoryAccountant(1000000))
+	if _, err := plan.Run(ctx, sampleData.Database); err != nil {
+		t.Errorf("Run returned error: %v", err)
+	}
+}
+
+func TestOrderBySpill(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	sampleData := storage.GetSampleData()
+	stmt, err := sql.Parse(`
+select f.name, p.name, g.release_date
+from films f, people p, films g, people q
+order by g.release_date desc, p.name, q.id nulls first`)
+	if err != nil {
+		t.Fatalf("sql.Parse returned error: %v", err)
+	}
+
+	var results []*types.Relation
+	for _, threshold := range []int{0, 500} {
+		plan, err := planner.PlanWithOptions(stmt, sampleData.Database, planner.Options{
+			SortSpillThreshold: threshold,
+		})
+		if err != nil {
+			t.Fatalf("planner.PlanWithOptions returned error: %v", err)
+		}
+		result, err := plan.Run(context.Background(), sampleData.Database)
+		if err != nil {
+			t.Fatalf("Run returned error: %v", err)
+		}
+		results = append(results, result)
+	}
+	if len(results[0].Rows) != 81 {
+		t.Fatalf("Query returned %d rows, want 81", len(results[0].Rows))
+	}
+	if !reflect.DeepEqual(results[0], results[1]) {
+		t.Errorf("Query result with spilling sort got:\n%s\nwant:\n%s\n", results[1], results[0])
+	}
+}
+
+func TestParallelism(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	createScores(t, sampleData.Database)
+
+	queries := []string{
+		"select * from films, people, films f, people p where f.id <> p.id",
+		"select f.name, p.name from films f left join people p on f.director = p.id",
+		"select * from films right join scores on films.id = scores.id",
+		"select p.name, count(*) from films f, people p, films g where f.director = p.id group by p.name",
+		"select distinct p.name from films f, people p, films g where f.director <> g.director",
+		"select f.name, p.name, g.id from films f, people p, films g order by g.id, f.name, p.name",
+	}
+	for _, input := range queries {
+		stmt, err := sql.Parse(input)
+		if err != nil {
+			t.Fatalf("sql.Parse returned error: %v", err)
+		}
+		var results []*types.Relation
+		for _, parallelism := range []int{1, 4} {
+			plan, err := planner.PlanWithOptions(stmt, sampleData.Database, planner.Options{
+				Parallelism: parallelism,
+			})
+			if err != nil {
+				t.Fatalf("planner.PlanWithOptions returned error: %v", err)
+			}
+			result, err := plan.Run(context.Background(), sampleData.Database)
+			if err != nil {
+				t.Fatalf("Run returned error: %v", err)
+			}
+			results = append(results, result)
+		}
+		want, got := results[0], results[1]
+		if len(want.Rows) == 0 {
+			t.Fatalf("Query returned no rows: %s", input)
+		}
+		// rows come out in the same order only if the query sorts them
+		same := reflect.DeepEqual(got, want)
+		if len(stmt.OrderBy) == 0 {
+			same = reflect.DeepEqual(got.Schema, want.Schema) &&
+				reflect.DeepEqual(sortedRowKeys(got.Rows), sortedRowKeys(want.Rows))
+		}
+		if !same {
+			t.Errorf("Query result for %s with parallelism got:\n%s\nwant:\n%s\n", input, got, want)
+		}
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	dir := t.TempDir()
+	sampleData := storage.GetSampleData()
+	db, err := storage.Open(dir)
+	if err != nil {
+		t.Fatalf("storage.Open returned error: %v", err)
+	}
+	for name, table := range map[string]*types.Relation{"films": sampleData.Films, "people": sampleData.People} {
+		if _, err := db.CreateTable(name, table.Schema); err != nil {
+			t.Fatalf("CreateTable returned error: %v", err)
+		}
+		for _, row := range table.Rows {
+			if err := db.Insert(name, row); err != nil {
+				t.Fatalf("Insert returned error: %v", err)
+			}
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+
+	// a query on the reopened database gives the same result as on the sample data, even with a
+	// buffer pool that can't hold both tables
+	pool, err := storage.NewBufferPool(1, storage.NewClock())
+	if err != nil {
+		t.Fatalf("storage.NewBufferPool returned error: %v", err)
+	}
+	db, err = storage.OpenWithBufferPool(dir, pool)
+	if err != nil {
+		t.Fatalf("storage.OpenWithBufferPool returned error: %v", err)
+	}
+	defer db.Close()
+	query := `
+select films.name, people.name
+from films
+join people on films.director = people.id
+where films.release_date > date '1924-01-01'`
+	want := &types.Relation{
+		Schema: types.TableSchema{
+			Columns: []types.ColumnSchema{
+				{"films.name", types.TypeText, false},
+				{"people.name", types.TypeText, false},
+			},
+		},
+		Rows: [][]types.Value{
+			[]types.Value{types.Txt("The General"), types.Txt("Buster Keaton")},
+			[]types.Value{types.Txt("Sherlock Jr."), types.Txt("Buster Keaton")},
+		},
+	}
+	checkQuery(t, db, query, want)
+	if stats := pool.Stats(); stats.Misses == 0 || stats.Evictions == 0 {
+		t.Errorf("buffer pool stats are %+v, want misses and evictions", stats)
+	}
+}
+
+func TestIndexes(t *testing.T) {
+	dir := t.TempDir()
+	db, err := storage.Open(dir)
+	if err != nil {
+		t.Fatalf("storage.Open returned error: %v", err)
+	}
+	_, err = db.CreateTable("readings", types.TableSchema{
+		Columns: []types.ColumnSchema{
+			{"sensor", types.TypeDecimal, false},
+			{"day", types.TypeDecimal, false},
+			{"value", types.TypeDecimal, true},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateTable returned error: %v", err)
+	}
+	for i := 0; i < 500; i++ {
+		value := types.Dec(strconv.Itoa(i * 7 % 101))
+		if i%13 == 0 {
+			value = types.NewNull(types.TypeDecimal)
+		}
+		row := []types.Value{types.Dec(strconv.Itoa(i % 10)), types.Dec(strconv.Itoa(i / 10)), value}
+		if err := db.Insert("readings", row); err != nil {
+			t.Fatalf("Insert returned error: %v", err)
+		}
+	}
+
+	queries := []string{
+		"select * from readings where sensor = 3",
+		"select * from readings where sensor = 3 and day >= 10 and day < 20",
+		"select * from readings where 5 < sensor and value > 50",
+		"select day, value from readings r where r.value <= 20 and r.sensor <> 1",
+		"select * from readings where value = 35",
+		"select * from readings where value = 1000",
+	}
+	// queries return the same rows with indexes as without them
+	var want []*types.Relation
+	for _, input := range queries {
+		result, _ := runQuery(t, db, input)
+		want = append(want, result)
+	}
+	execute(t, db, "create index readings_sensor_day on readings (sensor, day)")
+	execute(t, db, "create index readings_value on readings (value)")
+	check := func(indexed bool) {
+		t.Helper()
+		checkIndexedQueries(t, db, queries, want, "IndexScan", indexed)
+	}
+	check(true)
+
+	// the indexes are there after reopening the database, until they're dropped
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	db, err = storage.Open(dir)
+	if err != nil {
+		t.Fatalf("storage.Open returned error: %v", err)
+	}
+	defer db.Close()
+	check(true)
+	execute(t, db, "drop index readings_sensor_day")
+	execute(t, db, "drop index readings_value")
+	check(false)
+}
+
+// runQuery runs a query and returns its result and its printed plan.
+func runQuery(t *testing.T, db *storage.Database, input string) (*types.Relation, string) {
+	t.Helper()
+	stmt, err := sql.Parse(input)
+	if err != nil {
+		t.Fatalf("sql.Parse returned error: %v", err)
+	}
+	plan, err := planner.Plan(stmt, db)
+	if err != nil {
+		t.Fatalf("planner.Plan returned error: %v", err)
+	}
+	result, err := plan.Run(context.Background(), db)
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	printer := new(query.Printer)
+	plan.Print(printer)
+	return result, printer.String()
+}
+
+// execute parses and executes a statement such as "create index".
+func execute(t *testing.T, db *storage.Database, input string) {
+	t.Helper()
+	stmt, err := sql.ParseStatement(input)
+	if err != nil {
+		t.Fatalf("sql.ParseStatement returned error: %v", err)
+	}
+	if err := planner.Execute(stmt, db); err != nil {
+		t.Fatalf("planner.Execute returned error for %q: %v", input, err)
+	}
+}
+
+// checkIndexedQueries runs queries and compares their results, regardless of order, with the
+// results from before indexes were created. It also checks if their plans use a step, such as
+// IndexScan.
+func checkIndexedQueries(
+	t *testing.T,
+	db *storage.Database,
+	queries []string,
+	want []*types.Relation,
+	step string,
+	used bool,
+) {
+	t.Helper()
+	for i, input := range queries {
+		got, plan := runQuery(t, db, input)
+		if strings.Contains(plan, step+" {") != used {
+			t.Errorf("plan for %q is\n%s", input, plan)
+		}
+		if !reflect.DeepEqual(got.Schema, want[i].Schema) ||
+			!reflect.DeepEqual(sortedRowKeys(got.Rows), sortedRowKeys(want[i].Rows)) {
+			t.Errorf("Query result for %s got:\n%s\nwant:\n%s\n", input, got, want[i])
+		}
+	}
+}
+
+func TestIn(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	schema := types.TableSchema{
+		Columns: []types.ColumnSchema{
+			{"films.name", types.TypeText, false},
+		},
+	}
+	checkQuery(t, sampleData.Database, "select name from films where director in (2, 3)", &types.Relation{
+		Schema: schema,
+		Rows:   [][]types.Value{{types.Txt("The Kid")}},
+	})
+	checkQuery(t, sampleData.Database, "select name from films where id not in (1, 2)", &types.Relation{
+		Schema: schema,
+		Rows:   [][]types.Value{{types.Txt("Sherlock Jr.")}},
+	})
+}
+
+func TestHashIndexes(t *testing.T) {
+	db := storage.NewDatabase()
+	_, err := db.CreateTable("orders", types.TableSchema{
+		Columns: []types.ColumnSchema{
+			{"id", types.TypeDecimal, false},
+			{"customer", types.TypeText, false},
+			{"total", types.TypeDecimal, true},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateTable returned error: %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		total := types.Dec(strconv.Itoa(i * 37 % 500))
+		if i%11 == 0 {
+			total = types.NewNull(types.TypeDecimal)
+		}
+		row := []types.Value{types.Dec(strconv.Itoa(i)), types.Txt(fmt.Sprintf("customer %d", i%30)), total}
+		if err := db.Insert("orders", row); err != nil {
+			t.Fatalf("Insert returned error: %v", err)
+		}
+	}
+
+	queries := []string{
+		"select * from orders where id = 123",
+		"select * from orders where id in (5, 500, 5000, 5)",
+		"select total from orders o where 'customer 7' = o.customer and o.total > 100",
+		"select * from orders where customer in ('customer 3', 'customer 4') and id < 200",
+		"select * from orders where total = 74",
+	}
+	var want []*types.Relation
+	for _, input := range queries {
+		result, _ := runQuery(t, db, input)
+		want = append(want, result)
+	}
+	execute(t, db, "create index orders_id on orders using hash (id)")
+	execute(t, db, "create index orders_customer on orders using hash (customer)")
+	execute(t, db, "create index orders_total on orders using hash (total)")
+	checkIndexedQueries(t, db, queries, want, "HashIndexScan", true)
+
+	// queries that can't use a hash index
+	for _, input := range []string{
+		"select * from orders where id > 990",
+		"select * from orders where id not in (1, 2)",
+		"select * from orders where id = 1 or customer = 'customer 2'",
+	} {
+		if _, plan := runQuery(t, db, input); strings.Contains(plan, "HashIndexScan {") {
+			t.Errorf("plan for %q is\n%s", input, plan)
+		}
+	}
+
+	stmt, err := sql.ParseStatement("create index orders_id_2 on orders using bitmap (id)")
+	if err != nil {
+		t.Fatalf("sql.ParseStatement returned error: %v", err)
+	}
+	if err := planner.Execute(stmt, db); err == nil {
+		t.Errorf("planner.Execute did not return error for unknown index method")
 	}
 }
@@ -0,0 +1,263 @@
+package planner
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/lfritz/toydb/query"
+	"github.com/lfritz/toydb/sql"
+	"github.com/lfritz/toydb/types"
+)
+
+var aggregateFunctions = map[string]query.AggregateFunction{
+	"count": query.AggregateFunctionCount,
+	"sum":   query.AggregateFunctionSum,
+	"avg":   query.AggregateFunctionAvg,
+	"min":   query.AggregateFunctionMin,
+	"max":   query.AggregateFunctionMax,
+}
+
+// boundColumn is an expression that refers to a column of an intermediate result by its index. The
+// planner uses it to replace parts of an SQL expression that an earlier step has already computed,
+// e.g. the result of an aggregate function.
+type boundColumn struct {
+	index int
+}
+
+func (c boundColumn) String() string {
+	return fmt.Sprintf("BoundColumn(%d)", c.index)
+}
+
+// An aggregation collects the grouping expressions and aggregate function calls of a query and
+// rewrites expressions so they can be evaluated on the output of the query.Aggregate step.
+type aggregation struct {
+	input      types.TableSchema
+	groupBy    []sql.Expression
+	groups     []query.OutputColumn
+	calls      []*sql.FunctionCall
+	aggregates []query.AggregateColumn
+}
+
+func newAggregation(groupBy []sql.Expression, input types.TableSchema) (*aggregation, error) {
+	groups := make([]query.OutputColumn, len(groupBy))
+	for i, e := range groupBy {
+		converted, name, err := ConvertExpression(e, input)
+		if err != nil {
+			return nil, err
+		}
+		groups[i] = query.ComputedColumn(name, converted)
+	}
+	return &aggregation{
+		input:   input,
+		groupBy: groupBy,
+		groups:  groups,
+	}, nil
+}
+
+// rewrite replaces grouping expressions and aggregate function calls in e with references to the
+// output of the aggregate step. It returns an error if e refers to a column that's not grouped.
+func (a *aggregation) rewrite(e sql.Expression) (sql.Expression, error) {
+	for i, g := range a.groupBy {
+		if reflect.DeepEqual(e, g) {
+			return boundColumn{i}, nil
+		}
+	}
+
+	switch e := e.(type) {
+	case *sql.FunctionCall:
+		return a.rewriteFunctionCall(e)
+	case sql.ColumnReference:
+		converted, name, err := ConvertExpression(e, a.input)
+		if err != nil {
+			return nil, err
+		}
+		index := converted.(*query.ColumnReference).Index
+		for i, g := range a.groups {
+			if c, ok := g.Expression.(*query.ColumnReference); ok && c.Index == index {
+				return boundColumn{i}, nil
+			}
+		}
+		return nil, fmt.Errorf("column %s must appear in the group by clause or be used in an aggregate function", name)
+	case *sql.BinaryOperation:
+		left, err := a.rewrite(e.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := a.rewrite(e.Right)
+		if err != nil {
+			return nil, err
+		}
+		return &sql.BinaryOperation{Left: left, Operator: e.Operator, Right: right}, nil
+	case *sql.UnaryOperation:
+		operand, err := a.rewrite(e.Operand)
+		if err != nil {
+			return nil, err
+		}
+		return &sql.UnaryOperation{Operand: operand, Operator: e.Operator}, nil
+	case *sql.In:
+		operand, err := a.rewrite(e.Operand)
+		if err != nil {
+			return nil, err
+		}
+		list := make([]sql.Expression, len(e.List))
+		for i, x := range e.List {
+			list[i], err = a.rewrite(x)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return &sql.In{Operand: operand, List: list}, nil
+	case *sql.Alias:
+		expression, err := a.rewrite(e.Expression)
+		if err != nil {
+			return nil, err
+		}
+		return &sql.Alias{Expression: expression, Name: e.Name}, nil
+	}
+	return e, nil
+}
+
+func (a *aggregation) rewriteFunctionCall(call *sql.FunctionCall) (sql.Expression, error) {
+	name := strings.ToLower(call.Name)
+	function, ok := aggregateFunctions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function: %s", call.Name)
+	}
+
+	// identical calls are only computed once
+	for i, c := range a.calls {
+		if reflect.DeepEqual(c, call) {
+			return boundColumn{len(a.groups) + i}, nil
+		}
+	}
+
+	column := query.AggregateColumn{
+		Name:     name,
+		Function: function,
+		Distinct: call.Distinct,
+	}
+	switch {
+	case call.Star && function == query.AggregateFunctionCount:
+	case !call.Star && len(call.Arguments) == 1:
+		argument, _, err := ConvertExpression(call.Arguments[0], a.input)
+		if err != nil {
+			return nil, err
+		}
+		column.Argument = argument
+	default:
+		return nil, fmt.Errorf("invalid arguments for %s", name)
+	}
+
+	a.calls = append(a.calls, call)
+	a.aggregates = append(a.aggregates, column)
+	return boundColumn{len(a.groups) + len(a.calls) - 1}, nil
+}
+
+// hasAggregate returns true if the expression contains a call to an aggregate function.
+func hasAggregate(e sql.Expression) bool {
+	switch e := e.(type) {
+	case *sql.FunctionCall:
+		_, ok := aggregateFunctions[strings.ToLower(e.Name)]
+		return ok
+	case *sql.BinaryOperation:
+		return hasAggregate(e.Left) || hasAggregate(e.Right)
+	case *sql.UnaryOperation:
+		return hasAggregate(e.Operand)
+	case *sql.In:
+		if hasAggregate(e.Operand) {
+			return true
+		}
+		for _, x := range e.List {
+			if hasAggregate(x) {
+				return true
+			}
+		}
+		return false
+	case *sql.Alias:
+		return hasAggregate(e.Expression)
+	}
+	return false
+}
+
+// needsAggregation returns true if the query uses "group by", "having" or aggregate functions.
+func needsAggregation(stmt *sql.SelectStatement) bool {
+	if len(stmt.GroupBy) > 0 || stmt.Having != nil {
+		return true
+	}
+	if list, ok := stmt.What.(sql.ExpressionList); ok {
+		for _, e := range list.Expressions {
+			if hasAggregate(e) {
+				return true
+			}
+		}
+	}
+	for _, k := range stmt.OrderBy {
+		if hasAggregate(k.Expression) {
+			return true
+		}
+	}
+	return false
+}
+
+// convertAggregation adds an aggregate step to the plan, followed by a select step for the "having"
+// clause. It returns the select list and sort keys rewritten to refer to the output of the
+// aggregate step.
+func convertAggregation(stmt *sql.SelectStatement, plan query.Plan) (query.Plan, sql.SelectList, []sql.SortKey, error) {
+	list, ok := stmt.What.(sql.ExpressionList)
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("select * cannot be used with group by or aggregate functions")
+	}
+
+	a, err := newAggregation(stmt.GroupBy, plan.Schema())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	what := sql.ExpressionList{Expressions: make([]sql.Expression, len(list.Expressions))}
+	for i, e := range list.Expressions {
+		what.Expressions[i], err = a.rewrite(e)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	var having sql.Expression
+	if stmt.Having != nil {
+		having, err = a.rewrite(stmt.Having)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	orderBy := make([]sql.SortKey, len(stmt.OrderBy))
+	for i, k := range stmt.OrderBy {
+		orderBy[i] = k
+		if _, ok := k.Expression.(sql.Number); ok {
+			// position in the select list
+			continue
+		}
+		orderBy[i].Expression, err = a.rewrite(k.Expression)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	plan, err = query.NewAggregate(plan, a.groups, a.aggregates)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if having != nil {
+		condition, _, err := ConvertExpression(having, plan.Schema())
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		plan, err = query.NewSelect(plan, condition)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	return plan, what, orderBy, nil
+}
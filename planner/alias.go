@@ -0,0 +1,118 @@
+package planner
+
+import (
+	"fmt"
+
+	"github.com/lfritz/toydb/sql"
+)
+
+// defaultColumnName is the name of an output column that's computed by an expression without an
+// alias.
+const defaultColumnName = "?column?"
+
+// findAlias returns the expression in the select list with the given alias, if there is one.
+func findAlias(name string, what sql.SelectList) (sql.Expression, bool) {
+	list, ok := what.(sql.ExpressionList)
+	if !ok {
+		return nil, false
+	}
+	for _, e := range list.Expressions {
+		if alias, ok := e.(*sql.Alias); ok && alias.Name == name {
+			return alias.Expression, true
+		}
+	}
+	return nil, false
+}
+
+// resolveAliases returns a copy of the statement where references to aliases from the select list
+// in the "order by" and "having" clauses are replaced by the aliased expressions. An alias takes
+// precedence over an input column with the same name.
+//
+// In "order by", only a key that's just an alias is replaced, so "order by total" works but
+// "order by total + 1" refers to input columns only. In "having", aliases are replaced anywhere.
+func resolveAliases(stmt *sql.SelectStatement) *sql.SelectStatement {
+	result := *stmt
+
+	if len(stmt.OrderBy) > 0 {
+		result.OrderBy = make([]sql.SortKey, len(stmt.OrderBy))
+		for i, k := range stmt.OrderBy {
+			result.OrderBy[i] = k
+			r, ok := k.Expression.(sql.ColumnReference)
+			if !ok || r.Relation != "" {
+				continue
+			}
+			if e, ok := findAlias(r.Name, stmt.What); ok {
+				result.OrderBy[i].Expression = e
+			}
+		}
+	}
+
+	if stmt.Having != nil {
+		result.Having = replaceAliases(stmt.Having, stmt.What)
+	}
+
+	return &result
+}
+
+// replaceAliases replaces references to aliases from the select list anywhere in e.
+func replaceAliases(e sql.Expression, what sql.SelectList) sql.Expression {
+	switch e := e.(type) {
+	case sql.ColumnReference:
+		if e.Relation == "" {
+			if aliased, ok := findAlias(e.Name, what); ok {
+				return aliased
+			}
+		}
+	case *sql.BinaryOperation:
+		return &sql.BinaryOperation{
+			Left:     replaceAliases(e.Left, what),
+			Operator: e.Operator,
+			Right:    replaceAliases(e.Right, what),
+		}
+	case *sql.UnaryOperation:
+		return &sql.UnaryOperation{
+			Operand:  replaceAliases(e.Operand, what),
+			Operator: e.Operator,
+		}
+	case *sql.In:
+		list := make([]sql.Expression, len(e.List))
+		for i, x := range e.List {
+			list[i] = replaceAliases(x, what)
+		}
+		return &sql.In{Operand: replaceAliases(e.Operand, what), List: list}
+	}
+	// arguments of function calls are evaluated on input rows, so they can't refer to aliases
+	return e
+}
+
+// outputNames returns the names of the output columns for the select list, given the names
+// returned by ConvertExpression. Expressions without a name get the default name. Names that weren't
+// given explicitly with an alias are made unique by adding a number, so "select a + 1, b + 1" has
+// columns "?column?" and "?column?_2".
+func outputNames(expressions []sql.Expression, names []string) []string {
+	used := make(map[string]bool)
+	for i, e := range expressions {
+		if _, ok := e.(*sql.Alias); ok {
+			used[names[i]] = true
+		}
+	}
+
+	result := make([]string, len(names))
+	for i, e := range expressions {
+		if _, ok := e.(*sql.Alias); ok {
+			result[i] = names[i]
+			continue
+		}
+		name := names[i]
+		if name == "" {
+			name = defaultColumnName
+		}
+		unique := name
+		for n := 2; used[unique]; n++ {
+			unique = fmt.Sprintf("%s_%d", name, n)
+		}
+		used[unique] = true
+		result[i] = unique
+	}
+	return result
+}
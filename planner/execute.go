@@ -0,0 +1,37 @@
+package planner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lfritz/toydb/sql"
+	"github.com/lfritz/toydb/storage"
+)
+
+// Execute runs a statement that changes the database, such as "create index". Queries are run by
+// creating a plan for them instead.
+func Execute(stmt sql.Statement, db *storage.Database) error {
+	switch s := stmt.(type) {
+	case *sql.CreateIndexStatement:
+		method, err := convertIndexMethod(s.Method)
+		if err != nil {
+			return err
+		}
+		return db.CreateIndexUsing(s.Name, s.Table, s.Columns, method)
+	case *sql.DropIndexStatement:
+		return db.DropIndex(s.Name)
+	}
+	return fmt.Errorf("cannot execute statement: %s", stmt)
+}
+
+// convertIndexMethod returns the index method for the name in a "using" clause. Without one, indexes
+// are B+trees.
+func convertIndexMethod(name string) (storage.IndexMethod, error) {
+	switch strings.ToLower(name) {
+	case "", "btree":
+		return storage.IndexMethodBtree, nil
+	case "hash":
+		return storage.IndexMethodHash, nil
+	}
+	return 0, fmt.Errorf("unknown index method: %s", name)
+}
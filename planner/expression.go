@@ -25,6 +25,19 @@ func ConvertExpression(input sql.Expression, schema types.TableSchema) (query.Ex
 		return convertBinaryOperation(e, schema)
 	case *sql.UnaryOperation:
 		return convertUnaryOperation(e, schema)
+	case *sql.In:
+		return convertIn(e, schema)
+	case *sql.Alias:
+		converted, _, err := ConvertExpression(e.Expression, schema)
+		return converted, e.Name, err
+	case *sql.FunctionCall:
+		if _, ok := aggregateFunctions[strings.ToLower(e.Name)]; ok {
+			return nil, "", fmt.Errorf("aggregate function not allowed here: %s", e.Name)
+		}
+		return nil, "", fmt.Errorf("unknown function: %s", e.Name)
+	case boundColumn:
+		column := schema.Columns[e.index]
+		return query.NewColumnReference(e.index, column.Type), column.Name, nil
 	}
 	panic(fmt.Sprintf("unexpected sql.Expression: %T", input))
 }
@@ -46,7 +59,7 @@ func convertColumnReference(r sql.ColumnReference, schema types.TableSchema) (*q
 	}
 }
 
-func convertBinaryOperation(o *sql.BinaryOperation, schema types.TableSchema) (*query.BinaryOperation, string, error) {
+func convertBinaryOperation(o *sql.BinaryOperation, schema types.TableSchema) (query.Expression, string, error) {
 	left, _, err := ConvertExpression(o.Left, schema)
 	if err != nil {
 		return nil, "", err
@@ -55,6 +68,17 @@ func convertBinaryOperation(o *sql.BinaryOperation, schema types.TableSchema) (*
 	if err != nil {
 		return nil, "", err
 	}
+	switch o.Operator {
+	case sql.BinaryOperatorAnd, sql.BinaryOperatorOr:
+		operator := convertLogicalOperator(o.Operator)
+		expression, err := query.NewLogicalOperation(left, operator, right)
+		return expression, "", err
+	case sql.BinaryOperatorAdd, sql.BinaryOperatorSub, sql.BinaryOperatorMul,
+		sql.BinaryOperatorDiv, sql.BinaryOperatorMod:
+		operator := convertArithmeticOperator(o.Operator)
+		expression, err := query.NewArithmeticOperation(left, operator, right)
+		return expression, "", err
+	}
 	operator := convertBinaryOperator(o.Operator)
 	expression, err := query.NewBinaryOperation(left, operator, right)
 	return expression, "", err
@@ -78,6 +102,48 @@ func convertBinaryOperator(o sql.BinaryOperator) query.BinaryOperator {
 	panic(fmt.Sprintf("unexpected value for BinaryOperator: %v", o))
 }
 
+func convertLogicalOperator(o sql.BinaryOperator) query.LogicalOperator {
+	switch o {
+	case sql.BinaryOperatorAnd:
+		return query.LogicalOperatorAnd
+	case sql.BinaryOperatorOr:
+		return query.LogicalOperatorOr
+	}
+	panic(fmt.Sprintf("unexpected value for logical operator: %v", o))
+}
+
+func convertArithmeticOperator(o sql.BinaryOperator) query.ArithmeticOperator {
+	switch o {
+	case sql.BinaryOperatorAdd:
+		return query.ArithmeticOperatorAdd
+	case sql.BinaryOperatorSub:
+		return query.ArithmeticOperatorSub
+	case sql.BinaryOperatorMul:
+		return query.ArithmeticOperatorMul
+	case sql.BinaryOperatorDiv:
+		return query.ArithmeticOperatorDiv
+	case sql.BinaryOperatorMod:
+		return query.ArithmeticOperatorMod
+	}
+	panic(fmt.Sprintf("unexpected value for arithmetic operator: %v", o))
+}
+
+func convertIn(e *sql.In, schema types.TableSchema) (query.Expression, string, error) {
+	operand, _, err := ConvertExpression(e.Operand, schema)
+	if err != nil {
+		return nil, "", err
+	}
+	list := make([]query.Expression, len(e.List))
+	for i, x := range e.List {
+		list[i], _, err = ConvertExpression(x, schema)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	expression, err := query.NewIn(operand, list)
+	return expression, "", err
+}
+
 func convertUnaryOperation(o *sql.UnaryOperation, schema types.TableSchema) (*query.UnaryOperation, string, error) {
 	operand, _, err := ConvertExpression(o.Operand, schema)
 	if err != nil {
@@ -94,6 +160,10 @@ func convertUnaryOperator(o sql.UnaryOperator) query.UnaryOperator {
 		return query.UnaryOperatorIsNull
 	case sql.UnaryOperatorIsNotNull:
 		return query.UnaryOperatorIsNotNull
+	case sql.UnaryOperatorNot:
+		return query.UnaryOperatorNot
+	case sql.UnaryOperatorMinus:
+		return query.UnaryOperatorMinus
 	}
 	panic(fmt.Sprintf("unexpected value for UnaryOperator: %v", o))
 }
@@ -102,7 +172,7 @@ func FindColumn(input string, schema types.TableSchema) (index int, name string,
 	suffix := fmt.Sprintf(".%s", input)
 	var found bool
 	for i, col := range schema.Columns {
-		if strings.HasSuffix(col.Name, suffix) {
+		if col.Name == input || strings.HasSuffix(col.Name, suffix) {
 			if found {
 				err = fmt.Errorf("ambiguous column reference: %s", input)
 				return
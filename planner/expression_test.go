@@ -44,6 +44,17 @@ func TestConvertExpressionValid(t *testing.T) {
 			query.NewColumnReference(1, types.TypeText),
 			"films.name",
 		},
+		{
+			&sql.In{
+				sql.ColumnReference{"films", "id"},
+				[]sql.Expression{sql.Number{types.NewDecimal("4")}, sql.ColumnReference{"films", "director"}},
+			},
+			&query.In{
+				query.NewColumnReference(0, types.TypeDecimal),
+				[]query.Expression{query.NewConstant(types.Dec("4")), query.NewColumnReference(3, types.TypeDecimal)},
+			},
+			"",
+		},
 		{
 			&sql.BinaryOperation{
 				sql.Number{types.NewDecimal("4")},
@@ -68,6 +79,44 @@ func TestConvertExpressionValid(t *testing.T) {
 			},
 			"",
 		},
+		{
+			&sql.BinaryOperation{
+				sql.Boolean{true},
+				sql.BinaryOperatorOr,
+				&sql.UnaryOperation{
+					sql.Boolean{false},
+					sql.UnaryOperatorNot,
+				},
+			},
+			&query.LogicalOperation{
+				query.NewConstant(types.Boo(true)),
+				query.LogicalOperatorOr,
+				&query.UnaryOperation{
+					query.NewConstant(types.Boo(false)),
+					query.UnaryOperatorNot,
+				},
+			},
+			"",
+		},
+		{
+			&sql.BinaryOperation{
+				sql.ColumnReference{"films", "id"},
+				sql.BinaryOperatorMul,
+				&sql.UnaryOperation{
+					sql.ColumnReference{"films", "director"},
+					sql.UnaryOperatorMinus,
+				},
+			},
+			&query.ArithmeticOperation{
+				query.NewColumnReference(0, types.TypeDecimal),
+				query.ArithmeticOperatorMul,
+				&query.UnaryOperation{
+					query.NewColumnReference(3, types.TypeDecimal),
+					query.UnaryOperatorMinus,
+				},
+			},
+			"",
+		},
 	}
 
 	for _, c := range cases {
@@ -96,6 +145,10 @@ func TestConvertExpressionInvalid(t *testing.T) {
 		sql.ColumnReference{"films", "foo"},
 		&sql.BinaryOperation{sql.ColumnReference{"foo", "id"}, op, four},
 		&sql.BinaryOperation{sql.ColumnReference{"films", "name"}, op, four},
+		&sql.BinaryOperation{sql.Boolean{true}, sql.BinaryOperatorAnd, four},
+		&sql.BinaryOperation{sql.ColumnReference{"films", "name"}, sql.BinaryOperatorAdd, four},
+		&sql.In{sql.ColumnReference{"films", "name"}, []sql.Expression{four}},
+		&sql.In{sql.ColumnReference{"films", "id"}, []sql.Expression{sql.ColumnReference{"films", "foo"}}},
 	}
 
 	for _, c := range cases {
@@ -0,0 +1,353 @@
+package planner
+
+import (
+	"github.com/lfritz/toydb/query"
+	"github.com/lfritz/toydb/storage"
+	"github.com/lfritz/toydb/types"
+)
+
+// A columnComparison is a comparison between a column and a constant in a "where" clause, with the
+// column on the left.
+type columnComparison struct {
+	column   int
+	operator query.BinaryOperator
+	value    types.Value
+}
+
+// columnComparisons returns the comparisons between a column and a constant that are combined with
+// "and" in a condition, so every row matching the condition matches each of them.
+func columnComparisons(condition query.Expression) []columnComparison {
+	var result []columnComparison
+	for _, e := range conjuncts(condition) {
+		o, ok := e.(*query.BinaryOperation)
+		if !ok || o.Operator == query.BinaryOperatorNe {
+			continue
+		}
+		operator := o.Operator
+		column, isColumn := o.Left.(*query.ColumnReference)
+		constant, isConstant := o.Right.(*query.Constant)
+		if !isColumn || !isConstant {
+			column, isColumn = o.Right.(*query.ColumnReference)
+			constant, isConstant = o.Left.(*query.Constant)
+			operator = flipOperator(operator)
+		}
+		if !isColumn || !isConstant {
+			continue
+		}
+		value, err := constant.Evaluate(&types.Row{})
+		if err != nil || value.Null() {
+			continue
+		}
+		result = append(result, columnComparison{column.Index, operator, value})
+	}
+	return result
+}
+
+// A columnList is a condition "column in (...)" with a list of constants.
+type columnList struct {
+	column int
+	values []types.Value
+}
+
+// columnLists returns the conditions "column in (...)" with lists of constants that are combined with
+// "and" in a condition. Nulls are left out of the lists, since they never match.
+func columnLists(condition query.Expression) []columnList {
+	var result []columnList
+	for _, e := range conjuncts(condition) {
+		in, ok := e.(*query.In)
+		if !ok {
+			continue
+		}
+		column, isColumn := in.Operand.(*query.ColumnReference)
+		if !isColumn {
+			continue
+		}
+		list := columnList{column: column.Index}
+		constants := true
+		for _, x := range in.List {
+			constant, isConstant := x.(*query.Constant)
+			if !isConstant {
+				constants = false
+				break
+			}
+			value, err := constant.Evaluate(&types.Row{})
+			if err != nil {
+				constants = false
+				break
+			}
+			if !value.Null() {
+				list.values = append(list.values, value)
+			}
+		}
+		if constants {
+			result = append(result, list)
+		}
+	}
+	return result
+}
+
+// flipOperator returns the operator to use when swapping the operands of a comparison.
+func flipOperator(o query.BinaryOperator) query.BinaryOperator {
+	switch o {
+	case query.BinaryOperatorLt:
+		return query.BinaryOperatorGt
+	case query.BinaryOperatorGt:
+		return query.BinaryOperatorLt
+	case query.BinaryOperatorLe:
+		return query.BinaryOperatorGe
+	case query.BinaryOperatorGe:
+		return query.BinaryOperatorLe
+	}
+	return o
+}
+
+// useIndexes replaces the Load steps in a plan with index scans where the table has an index that
+// helps find the rows matching the condition of a "where" clause on the plan. The condition still has
+// to be checked for the rows the index finds.
+//
+// Each comparison and "in" condition that can use an index refers to a single table, so the Load
+// steps can be in the inputs of joins, or under Project steps that keep the column. That's also the
+// case for the side of an outer join that's filled in with nulls: an index scan may leave out rows
+// and so add rows padded with nulls, but the condition is never true for the nulls.
+func useIndexes(plan query.Plan, condition query.Expression, db *storage.Database) (query.Plan, error) {
+	return indexInputs(plan, columnComparisons(condition), columnLists(condition), db)
+}
+
+// indexInputs looks for Load steps in a plan that can use an index for comparisons and "in"
+// conditions on the plan's columns.
+func indexInputs(plan query.Plan, comparisons []columnComparison, lists []columnList, db *storage.Database) (query.Plan, error) {
+	if len(comparisons) == 0 && len(lists) == 0 {
+		return plan, nil
+	}
+	switch p := plan.(type) {
+	case *query.Load:
+		return useIndex(p, comparisons, lists, db)
+	case *query.Project:
+		var fromComparisons []columnComparison
+		for _, c := range comparisons {
+			if column, ok := p.Columns[c.column].Expression.(*query.ColumnReference); ok {
+				c.column = column.Index
+				fromComparisons = append(fromComparisons, c)
+			}
+		}
+		var fromLists []columnList
+		for _, l := range lists {
+			if column, ok := p.Columns[l.column].Expression.(*query.ColumnReference); ok {
+				l.column = column.Index
+				fromLists = append(fromLists, l)
+			}
+		}
+		from, err := indexInputs(p.From, fromComparisons, fromLists, db)
+		if err != nil {
+			return nil, err
+		}
+		return query.NewProject(from, p.Columns)
+	case *query.Join:
+		left, right, err := indexJoinInputs(p.Left, p.Right, comparisons, lists, db)
+		if err != nil {
+			return nil, err
+		}
+		return query.NewJoin(p.Type, left, right, p.Condition)
+	case *query.HashJoin:
+		left, right, err := indexJoinInputs(p.Left, p.Right, comparisons, lists, db)
+		if err != nil {
+			return nil, err
+		}
+		return query.NewHashJoin(p.Type, left, right, p.LeftKeys, p.RightKeys)
+	case *query.MergeJoin:
+		left, right, err := indexJoinInputs(p.Left, p.Right, comparisons, lists, db)
+		if err != nil {
+			return nil, err
+		}
+		return query.NewMergeJoin(p.Type, left, right, p.LeftKeys, p.RightKeys)
+	}
+	return plan, nil
+}
+
+// indexJoinInputs calls indexInputs for the inputs of a join, splitting comparisons and "in"
+// conditions on the join's columns between them.
+func indexJoinInputs(
+	left, right query.Plan,
+	comparisons []columnComparison,
+	lists []columnList,
+	db *storage.Database,
+) (query.Plan, query.Plan, error) {
+	offset := len(left.Schema().Columns)
+	var leftComparisons, rightComparisons []columnComparison
+	for _, c := range comparisons {
+		if c.column < offset {
+			leftComparisons = append(leftComparisons, c)
+		} else {
+			c.column -= offset
+			rightComparisons = append(rightComparisons, c)
+		}
+	}
+	var leftLists, rightLists []columnList
+	for _, l := range lists {
+		if l.column < offset {
+			leftLists = append(leftLists, l)
+		} else {
+			l.column -= offset
+			rightLists = append(rightLists, l)
+		}
+	}
+	left, err := indexInputs(left, leftComparisons, leftLists, db)
+	if err != nil {
+		return nil, nil, err
+	}
+	right, err = indexInputs(right, rightComparisons, rightLists, db)
+	if err != nil {
+		return nil, nil, err
+	}
+	return left, right, nil
+}
+
+// useIndex returns an IndexScan or HashIndexScan step to use instead of a Load step if the table has
+// an index that helps find the rows matching comparisons and "in" conditions on its columns.
+//
+// A B+tree index can be used with a key range narrowed by equalities on a prefix of the index's
+// columns followed by at most one column with a lower or upper bound. A hash index can only be used
+// if there's an equality or an "in" condition with constants for each of its columns. The planner
+// picks the index that uses the most columns, preferring equalities to bounds, and a hash index to a
+// B+tree index with equalities on as many columns.
+func useIndex(load *query.Load, comparisons []columnComparison, lists []columnList, db *storage.Database) (query.Plan, error) {
+	indexes, err := db.Indexes(load.TableName)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := db.Schema(load.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan query.Plan = load
+	best := 0
+	for _, ix := range indexes {
+		switch ix.Method {
+		case storage.IndexMethodHash:
+			keys, ok := hashKeys(ix, schema, comparisons, lists)
+			if score := 2*len(ix.Columns) + 1; ok && score > best {
+				plan = query.NewHashIndexScan(load, ix.Name, keys)
+				best = score
+			}
+		default:
+			r, score := indexRange(ix, schema, comparisons)
+			if score > best {
+				plan = query.NewIndexScan(load, ix.Name, r)
+				best = score
+			}
+		}
+	}
+	return plan, nil
+}
+
+// hashKeys returns the keys to look up in a hash index to find all rows matching a list of
+// comparisons and "in" conditions, or false if a column of the index has neither an equality nor an
+// "in" condition. With "in" conditions on several columns, it returns every combination of their
+// values.
+func hashKeys(ix storage.Index, schema types.TableSchema, comparisons []columnComparison, lists []columnList) ([][]types.Value, bool) {
+	keys := [][]types.Value{nil}
+	for _, name := range ix.Columns {
+		column, _, _ := schema.Column(name)
+		values, ok := columnValues(column, comparisons, lists)
+		if !ok {
+			return nil, false
+		}
+		var combined [][]types.Value
+		for _, key := range keys {
+			for _, v := range values {
+				combined = append(combined, append(key[:len(key):len(key)], v))
+			}
+		}
+		keys = combined
+	}
+	return keys, true
+}
+
+// columnValues returns the values a column can have in rows matching a list of comparisons and "in"
+// conditions, or false if there's no equality or "in" condition for it.
+func columnValues(column int, comparisons []columnComparison, lists []columnList) ([]types.Value, bool) {
+	for _, c := range comparisons {
+		if c.column == column && c.operator == query.BinaryOperatorEq {
+			return []types.Value{c.value}, true
+		}
+	}
+	for _, l := range lists {
+		if l.column == column {
+			return l.values, true
+		}
+	}
+	return nil, false
+}
+
+// indexRange returns the narrowest key range of an index that contains all rows matching a list of
+// comparisons, and a score that's higher the more columns of the index the range uses.
+func indexRange(ix storage.Index, schema types.TableSchema, comparisons []columnComparison) (storage.KeyRange, int) {
+	var prefix []types.Value
+	var lower, upper *columnComparison
+	for _, name := range ix.Columns {
+		column, _, _ := schema.Column(name)
+		equal := false
+		for _, c := range comparisons {
+			if c.column == column && c.operator == query.BinaryOperatorEq {
+				prefix = append(prefix, c.value)
+				equal = true
+				break
+			}
+		}
+		if equal {
+			continue
+		}
+		for i, c := range comparisons {
+			if c.column != column {
+				continue
+			}
+			switch c.operator {
+			case query.BinaryOperatorGt, query.BinaryOperatorGe:
+				if lower == nil || tighter(c, *lower, types.ComparedGt) {
+					lower = &comparisons[i]
+				}
+			case query.BinaryOperatorLt, query.BinaryOperatorLe:
+				if upper == nil || tighter(c, *upper, types.ComparedLt) {
+					upper = &comparisons[i]
+				}
+			}
+		}
+		break
+	}
+
+	var r storage.KeyRange
+	if len(prefix) > 0 {
+		r.Lower = &storage.Bound{Key: prefix, Inclusive: true}
+		r.Upper = &storage.Bound{Key: prefix, Inclusive: true}
+	}
+	if lower != nil {
+		r.Lower = &storage.Bound{
+			Key:       append(prefix[:len(prefix):len(prefix)], lower.value),
+			Inclusive: lower.operator == query.BinaryOperatorGe,
+		}
+	}
+	if upper != nil {
+		r.Upper = &storage.Bound{
+			Key:       append(prefix[:len(prefix):len(prefix)], upper.value),
+			Inclusive: upper.operator == query.BinaryOperatorLe,
+		}
+	}
+	score := 2 * len(prefix)
+	if lower != nil || upper != nil {
+		score++
+	}
+	return r, score
+}
+
+// tighter checks if bound a excludes more values than bound b, where direction is ComparedGt for
+// lower bounds and ComparedLt for upper bounds.
+func tighter(a, b columnComparison, direction types.Compared) bool {
+	switch a.value.Compare(b.value) {
+	case direction:
+		return true
+	case types.ComparedEq:
+		return a.operator == query.BinaryOperatorGt || a.operator == query.BinaryOperatorLt
+	}
+	return false
+}
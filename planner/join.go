@@ -0,0 +1,200 @@
+package planner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lfritz/toydb/query"
+	"github.com/lfritz/toydb/sql"
+	"github.com/lfritz/toydb/types"
+)
+
+// convertUsingJoin converts a natural join or a join with a "using" clause. The rows are joined on
+// equality of the join columns, and the output has each join column only once, with its unqualified
+// name, followed by the other columns of the left and right inputs. For a full outer join, the join
+// column is the first non-null value of the two inputs.
+func convertUsingJoin(f *sql.Join, joinType query.JoinType, left, right query.Plan, options Options) (query.Plan, error) {
+	leftSchema, rightSchema := left.Schema(), right.Schema()
+	names := f.Using
+	if f.Natural {
+		names = commonColumnNames(leftSchema, rightSchema)
+	}
+
+	offset := len(leftSchema.Columns)
+	leftIndexes := make([]int, len(names))
+	rightIndexes := make([]int, len(names))
+	seen := make(map[string]bool)
+	for i, name := range names {
+		if seen[name] {
+			return nil, fmt.Errorf("column %s appears more than once in using clause", name)
+		}
+		seen[name] = true
+
+		var err error
+		leftIndexes[i], err = findJoinColumn(name, leftSchema, "left")
+		if err != nil {
+			return nil, err
+		}
+		rightIndexes[i], err = findJoinColumn(name, rightSchema, "right")
+		if err != nil {
+			return nil, err
+		}
+		leftType := leftSchema.Columns[leftIndexes[i]].Type
+		rightType := rightSchema.Columns[rightIndexes[i]].Type
+		if leftType != rightType {
+			return nil, fmt.Errorf("join column %s has different types: %v and %v", name, leftType, rightType)
+		}
+	}
+
+	// without join columns, a natural join is a cross join
+	var condition query.Expression = query.NewConstant(types.Boo(true))
+	for i := range names {
+		t := leftSchema.Columns[leftIndexes[i]].Type
+		eq, err := query.NewBinaryOperation(
+			query.NewColumnReference(leftIndexes[i], t),
+			query.BinaryOperatorEq,
+			query.NewColumnReference(offset+rightIndexes[i], t),
+		)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			condition = eq
+			continue
+		}
+		condition, err = query.NewLogicalOperation(condition, query.LogicalOperatorAnd, eq)
+		if err != nil {
+			return nil, err
+		}
+	}
+	join, err := newJoin(joinType, left, right, condition, options)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := join.Schema()
+	var columns []query.OutputColumn
+	isJoinColumn := make(map[int]bool)
+	for i, name := range names {
+		l, r := leftIndexes[i], offset+rightIndexes[i]
+		isJoinColumn[l] = true
+		isJoinColumn[r] = true
+		t := schema.Columns[l].Type
+		var expression query.Expression
+		switch joinType {
+		case query.JoinTypeInner, query.JoinTypeLeftOuter:
+			expression = query.NewColumnReference(l, t)
+		case query.JoinTypeRightOuter:
+			expression = query.NewColumnReference(r, t)
+		case query.JoinTypeFullOuter:
+			expression, err = query.NewCoalesce(
+				query.NewColumnReference(l, t),
+				query.NewColumnReference(r, t),
+			)
+			if err != nil {
+				return nil, err
+			}
+		}
+		columns = append(columns, query.ComputedColumn(name, expression))
+	}
+	for i, c := range schema.Columns {
+		if !isJoinColumn[i] {
+			columns = append(columns, query.SimpleColumn(c.Name, i, c.Type))
+		}
+	}
+	return query.NewProject(join, columns)
+}
+
+// commonColumnNames returns the unqualified names of the columns that appear in both schemas, in
+// the order of the first schema.
+func commonColumnNames(a, b types.TableSchema) []string {
+	inB := make(map[string]bool)
+	for _, c := range b.Columns {
+		inB[unqualifiedName(c.Name)] = true
+	}
+	var result []string
+	seen := make(map[string]bool)
+	for _, c := range a.Columns {
+		name := unqualifiedName(c.Name)
+		if inB[name] && !seen[name] {
+			result = append(result, name)
+			seen[name] = true
+		}
+	}
+	return result
+}
+
+// findJoinColumn returns the index of the column with the given unqualified name. The side is
+// "left" or "right" and is used in error messages.
+func findJoinColumn(name string, schema types.TableSchema, side string) (int, error) {
+	index := -1
+	for i, c := range schema.Columns {
+		if unqualifiedName(c.Name) != name {
+			continue
+		}
+		if index != -1 {
+			return 0, fmt.Errorf("common column name %s appears more than once in %s table", name, side)
+		}
+		index = i
+	}
+	if index == -1 {
+		return 0, fmt.Errorf("column %s specified in using clause does not exist in %s table", name, side)
+	}
+	return index, nil
+}
+
+// unqualifiedName returns the column name without the table name, e.g. "id" for "films.id".
+func unqualifiedName(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// newJoin creates a plan step that joins left and right on the condition, which refers to columns
+// of the combined schema. If the condition is an equality of a left and a right column, or a
+// conjunction of such equalities, it can use a hash join or merge join, depending on the join
+// strategy; otherwise, it uses a nested-loop join.
+func newJoin(joinType query.JoinType, left, right query.Plan, condition query.Expression, options Options) (query.Plan, error) {
+	leftKeys, rightKeys, ok := equiJoinKeys(condition, len(left.Schema().Columns))
+	if ok {
+		switch options.JoinStrategy {
+		case JoinStrategyAuto, JoinStrategyHash:
+			return query.NewHashJoin(joinType, left, right, leftKeys, rightKeys)
+		case JoinStrategyMerge:
+			return query.NewMergeJoin(joinType, left, right, leftKeys, rightKeys)
+		}
+	}
+	return query.NewJoin(joinType, left, right, condition)
+}
+
+// equiJoinKeys checks if the join condition is a conjunction of equalities between a column of the
+// left input and a column of the right input. If so, it returns the columns as keys for the left
+// and right inputs.
+func equiJoinKeys(condition query.Expression, leftColumns int) (leftKeys, rightKeys []query.Expression, ok bool) {
+	for _, e := range conjuncts(condition) {
+		o, isComparison := e.(*query.BinaryOperation)
+		if !isComparison || o.Operator != query.BinaryOperatorEq {
+			return nil, nil, false
+		}
+		a, aIsColumn := o.Left.(*query.ColumnReference)
+		b, bIsColumn := o.Right.(*query.ColumnReference)
+		if !aIsColumn || !bIsColumn {
+			return nil, nil, false
+		}
+		if a.Index >= leftColumns {
+			a, b = b, a
+		}
+		if a.Index >= leftColumns || b.Index < leftColumns {
+			return nil, nil, false
+		}
+		leftKeys = append(leftKeys, query.NewColumnReference(a.Index, a.T))
+		rightKeys = append(rightKeys, query.NewColumnReference(b.Index-leftColumns, b.T))
+	}
+	return leftKeys, rightKeys, len(leftKeys) > 0
+}
+
+// conjuncts splits an expression into the parts that are combined with "and".
+func conjuncts(e query.Expression) []query.Expression {
+	if o, ok := e.(*query.LogicalOperation); ok && o.Operator == query.LogicalOperatorAnd {
+		return append(conjuncts(o.Left), conjuncts(o.Right)...)
+	}
+	return []query.Expression{e}
+}
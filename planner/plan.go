@@ -3,17 +3,73 @@ package planner
 import (
 	"errors"
 	"fmt"
+	"reflect"
+	"strconv"
 
 	"github.com/lfritz/toydb/query"
 	"github.com/lfritz/toydb/sql"
 	"github.com/lfritz/toydb/storage"
+	"github.com/lfritz/toydb/types"
 )
 
 var NotImplemented = errors.New("not implemented")
 
-// Plan creates a query plan for the query.
+// A JoinStrategy selects the plan step used for joins.
+type JoinStrategy int
+
+const (
+	// JoinStrategyAuto uses a hash join for equi-joins and a nested-loop join otherwise.
+	JoinStrategyAuto JoinStrategy = iota
+	JoinStrategyNestedLoop
+	JoinStrategyHash
+	JoinStrategyMerge
+)
+
+func (s JoinStrategy) String() string {
+	switch s {
+	case JoinStrategyAuto:
+		return "auto"
+	case JoinStrategyNestedLoop:
+		return "nested loop"
+	case JoinStrategyHash:
+		return "hash"
+	case JoinStrategyMerge:
+		return "merge"
+	}
+	panic(fmt.Sprintf("unexpected JoinStrategy: %d", s))
+}
+
+// Options control how the planner creates query plans. The zero value gives the default options.
+type Options struct {
+	// JoinStrategy selects the plan step for joins. Hash and merge joins can only be used for
+	// equi-joins, so other joins always use a nested-loop join.
+	JoinStrategy JoinStrategy
+
+	// SortSpillThreshold is the number of bytes of rows a sort holds in memory before it writes
+	// sorted runs to temporary files. Zero means sorts never spill.
+	SortSpillThreshold int
+
+	// Parallelism is the number of workers that scan tables, filter and join rows in parallel.
+	// Zero or one means queries run on a single goroutine. Rows are returned in no particular order
+	// unless the query has an ORDER BY clause.
+	Parallelism int
+}
+
+// Plan creates a query plan for the query with the default options.
 func Plan(stmt *sql.SelectStatement, db *storage.Database) (query.Plan, error) {
-	plan, err := convertTableReference(stmt.From, db)
+	return PlanWithOptions(stmt, db, Options{})
+}
+
+// PlanWithOptions creates a query plan for the query.
+func PlanWithOptions(stmt *sql.SelectStatement, db *storage.Database, options Options) (query.Plan, error) {
+	stmt = resolveAliases(stmt)
+
+	err := checkTableNames(stmt.From, make(map[string]bool))
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := convertTableReference(stmt.From, db, options)
 	if err != nil {
 		return nil, err
 	}
@@ -24,24 +80,59 @@ func Plan(stmt *sql.SelectStatement, db *storage.Database) (query.Plan, error) {
 		if err != nil {
 			return nil, err
 		}
+		plan, err = useIndexes(plan, condition, db)
+		if err != nil {
+			return nil, err
+		}
 		plan, err = query.NewSelect(plan, condition)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	switch what := stmt.What.(type) {
+	// With parallelism, the workers run the steps up to here, and also the select list unless the
+	// query aggregates or sorts rows.
+	gather := options.Parallelism > 1 && query.Parallelizable(plan)
+	if gather && (needsAggregation(stmt) || len(stmt.OrderBy) > 0) {
+		plan, err = query.NewGather(plan, options.Parallelism)
+		if err != nil {
+			return nil, err
+		}
+		gather = false
+	}
+
+	what := stmt.What
+	orderBy := stmt.OrderBy
+	if needsAggregation(stmt) {
+		plan, what, orderBy, err = convertAggregation(stmt, plan)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(orderBy) > 0 {
+		plan, err = convertOrderBy(orderBy, what, stmt.Distinct, plan, options)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch what := what.(type) {
 	case sql.Star:
 		// ok
 	case sql.ExpressionList:
 		schema := plan.Schema()
 		columns := make([]query.OutputColumn, len(what.Expressions))
+		names := make([]string, len(what.Expressions))
 		for i, e := range what.Expressions {
 			converted, name, err := ConvertExpression(e, schema)
 			if err != nil {
 				return nil, err
 			}
 			columns[i].Expression = converted
+			names[i] = name
+		}
+		for i, name := range outputNames(what.Expressions, names) {
 			columns[i].Name = name
 		}
 		plan, err = query.NewProject(plan, columns)
@@ -52,45 +143,219 @@ func Plan(stmt *sql.SelectStatement, db *storage.Database) (query.Plan, error) {
 		panic(fmt.Sprintf("unexpected SelectList: %T", stmt.What))
 	}
 
+	if gather {
+		plan, err = query.NewGather(plan, options.Parallelism)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if stmt.Distinct {
+		plan = query.NewDistinct(plan)
+	}
+
+	if stmt.Limit != nil || stmt.Offset != nil {
+		count, offset := -1, 0
+		if stmt.Limit != nil {
+			count, err = convertCount(stmt.Limit)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if stmt.Offset != nil {
+			offset, err = convertCount(stmt.Offset)
+			if err != nil {
+				return nil, err
+			}
+		}
+		plan, err = query.NewLimit(plan, offset, count)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return plan, nil
 }
 
-func convertTableReference(ref sql.TableReference, db *storage.Database) (query.Plan, error) {
+// convertCount evaluates the expression in a "limit" or "offset" clause, which must be a
+// non-negative integer constant.
+func convertCount(input sql.Expression) (int, error) {
+	expression, _, err := ConvertExpression(input, types.TableSchema{})
+	if err != nil {
+		return 0, err
+	}
+	value, err := expression.Evaluate(&types.Row{})
+	if err != nil {
+		return 0, err
+	}
+	if value.Type() != types.TypeDecimal || value.Null() {
+		return 0, fmt.Errorf("invalid row count: %v", value)
+	}
+	count, err := strconv.Atoi(value.String())
+	if err != nil || count < 0 {
+		return 0, fmt.Errorf("invalid row count: %v", value)
+	}
+	return count, nil
+}
+
+// convertOrderBy adds a sort step before the select list is evaluated. A number in the "order by"
+// clause refers to a column of the output by position.
+//
+// For "select distinct", the sort keys must appear in the select list. Duplicates are removed after
+// sorting, keeping the first row, so this makes sure the output stays sorted.
+func convertOrderBy(
+	keys []sql.SortKey,
+	what sql.SelectList,
+	distinct bool,
+	plan query.Plan,
+	options Options,
+) (query.Plan, error) {
+	schema := plan.Schema()
+	result := make([]query.SortKey, len(keys))
+	for i, k := range keys {
+		var expression query.Expression
+		if number, ok := k.Expression.(sql.Number); ok {
+			position, err := strconv.Atoi(number.Value.String())
+			if err != nil {
+				return nil, fmt.Errorf("invalid position in order by: %v", number.Value)
+			}
+			switch what := what.(type) {
+			case sql.Star:
+				if position < 1 || position > len(schema.Columns) {
+					return nil, fmt.Errorf("position in order by out of range: %d", position)
+				}
+				expression = query.NewColumnReference(position-1, schema.Columns[position-1].Type)
+			case sql.ExpressionList:
+				if position < 1 || position > len(what.Expressions) {
+					return nil, fmt.Errorf("position in order by out of range: %d", position)
+				}
+				expression, _, err = ConvertExpression(what.Expressions[position-1], schema)
+				if err != nil {
+					return nil, err
+				}
+			}
+		} else {
+			var err error
+			expression, _, err = ConvertExpression(k.Expression, schema)
+			if err != nil {
+				return nil, err
+			}
+			if distinct {
+				err = checkInSelectList(expression, what, schema)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		nullsFirst := k.Descending
+		switch k.Nulls {
+		case sql.NullsFirst:
+			nullsFirst = true
+		case sql.NullsLast:
+			nullsFirst = false
+		}
+
+		result[i] = query.SortKey{
+			Expression: expression,
+			Descending: k.Descending,
+			NullsFirst: nullsFirst,
+		}
+	}
+	sort, err := query.NewSort(plan, result)
+	if err != nil {
+		return nil, err
+	}
+	sort.SpillThreshold = options.SortSpillThreshold
+	return sort, nil
+}
+
+// checkInSelectList returns an error if the expression doesn't appear in the select list.
+func checkInSelectList(expression query.Expression, what sql.SelectList, schema types.TableSchema) error {
+	switch what := what.(type) {
+	case sql.Star:
+		if _, ok := expression.(*query.ColumnReference); ok {
+			return nil
+		}
+	case sql.ExpressionList:
+		for _, e := range what.Expressions {
+			converted, _, err := ConvertExpression(e, schema)
+			if err != nil {
+				return err
+			}
+			if reflect.DeepEqual(converted, expression) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("for select distinct, order by expressions must appear in select list")
+}
+
+// checkTableNames returns an error if two tables in ref are referred to by the same name, e.g.
+// because a table is joined to itself without an alias.
+func checkTableNames(ref sql.TableReference, seen map[string]bool) error {
+	switch f := ref.(type) {
+	case sql.TableName:
+		name := f.Reference()
+		if seen[name] {
+			return fmt.Errorf("table name specified more than once: %s", name)
+		}
+		seen[name] = true
+		return nil
+	case *sql.Join:
+		if err := checkTableNames(f.Left, seen); err != nil {
+			return err
+		}
+		return checkTableNames(f.Right, seen)
+	}
+	panic(fmt.Sprintf("unexpected TableReference: %T", ref))
+}
+
+func convertTableReference(ref sql.TableReference, db *storage.Database, options Options) (query.Plan, error) {
 	switch f := ref.(type) {
 	case sql.TableName:
-		table, err := db.Table(f.Name)
+		schema, err := db.Schema(f.Name)
 		if err != nil {
 			return nil, err
 		}
-		return query.NewLoad(f.Name, table.Schema), nil
+		return query.NewLoadAs(f.Name, f.Reference(), schema), nil
 	case *sql.Join:
 		joinType := convertJoinType(f.Type)
-		left, err := convertTableReference(f.Left, db)
+		left, err := convertTableReference(f.Left, db, options)
 		if err != nil {
 			return nil, err
 		}
-		right, err := convertTableReference(f.Right, db)
+		right, err := convertTableReference(f.Right, db, options)
 		if err != nil {
 			return nil, err
 		}
+		if f.Natural || len(f.Using) > 0 {
+			return convertUsingJoin(f, joinType, left, right, options)
+		}
+		if f.Condition == nil {
+			// a cross join is an inner join that matches all pairs of rows
+			return query.NewJoin(joinType, left, right, query.NewConstant(types.Boo(true)))
+		}
 		schema := query.CombineSchemas(left.Schema(), right.Schema(), joinType)
 		condition, _, err := ConvertExpression(f.Condition, schema)
 		if err != nil {
 			return nil, err
 		}
-		return query.NewJoin(joinType, left, right, condition)
+		return newJoin(joinType, left, right, condition, options)
 	}
 	panic(fmt.Sprintf("unexpected TableReference: %T", ref))
 }
 
 func convertJoinType(input sql.JoinType) query.JoinType {
 	switch input {
-	case sql.JoinTypeInner:
+	case sql.JoinTypeInner, sql.JoinTypeCross:
 		return query.JoinTypeInner
 	case sql.JoinTypeLeftOuter:
 		return query.JoinTypeLeftOuter
 	case sql.JoinTypeRightOuter:
 		return query.JoinTypeRightOuter
+	case sql.JoinTypeFullOuter:
+		return query.JoinTypeFullOuter
 	}
 	panic(fmt.Sprintf("unexpected JoinType: %d", input))
 }
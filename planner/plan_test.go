@@ -1,6 +1,8 @@
 package planner
 
 import (
+	"context"
+	"fmt"
 	"reflect"
 	"testing"
 
@@ -21,13 +23,23 @@ func parse(t *testing.T, input string) *sql.SelectStatement {
 func TestPlanValid(t *testing.T) {
 	sampleData := storage.GetSampleData()
 
-	join, err := query.NewJoin(
+	join, err := query.NewHashJoin(
 		query.JoinTypeInner,
 		query.NewLoad("films", sampleData.Films.Schema),
 		query.NewLoad("people", sampleData.People.Schema),
+		[]query.Expression{&query.ColumnReference{3, types.TypeDecimal}},
+		[]query.Expression{&query.ColumnReference{0, types.TypeDecimal}},
+	)
+	if err != nil {
+		t.Fatalf("query.NewHashJoin returned error: %v", err)
+	}
+	nestedLoopJoin, err := query.NewJoin(
+		query.JoinTypeLeftOuter,
+		query.NewLoad("films", sampleData.Films.Schema),
+		query.NewLoad("people", sampleData.People.Schema),
 		&query.BinaryOperation{
 			&query.ColumnReference{3, types.TypeDecimal},
-			query.BinaryOperatorEq,
+			query.BinaryOperatorLt,
 			&query.ColumnReference{4, types.TypeDecimal},
 		},
 	)
@@ -43,6 +55,10 @@ func TestPlanValid(t *testing.T) {
 			"select * from films",
 			query.NewLoad("films", sampleData.Films.Schema),
 		},
+		{
+			"select * from films as f",
+			query.NewLoadAs("films", "f", sampleData.Films.Schema),
+		},
 		{
 			"select id, name, release_date, director from films",
 			&query.Project{
@@ -81,6 +97,95 @@ func TestPlanValid(t *testing.T) {
 				},
 			},
 		},
+		{
+			"select * from films left join people on films.director < people.id",
+			nestedLoopJoin,
+		},
+		{
+			"select name from films order by 1 desc, release_date",
+			&query.Project{
+				From: &query.Sort{
+					From: query.NewLoad("films", sampleData.Films.Schema),
+					Keys: []query.SortKey{
+						{&query.ColumnReference{1, types.TypeText}, true, true},
+						{&query.ColumnReference{2, types.TypeDate}, false, false},
+					},
+				},
+				Columns: []query.OutputColumn{
+					query.OutputColumn{"films.name", &query.ColumnReference{1, types.TypeText}},
+				},
+			},
+		},
+		{
+			"select * from films order by 4 nulls first",
+			&query.Sort{
+				From: query.NewLoad("films", sampleData.Films.Schema),
+				Keys: []query.SortKey{
+					{&query.ColumnReference{3, types.TypeDecimal}, false, true},
+				},
+			},
+		},
+		{
+			"select * from films limit 2 offset 1",
+			&query.Limit{
+				From:   query.NewLoad("films", sampleData.Films.Schema),
+				Offset: 1,
+				Count:  2,
+			},
+		},
+		{
+			"select director, count(*), max(id) + 1 from films group by director order by count(*)",
+			&query.Project{
+				From: &query.Sort{
+					From: &query.Aggregate{
+						From: query.NewLoad("films", sampleData.Films.Schema),
+						GroupBy: []query.OutputColumn{
+							query.OutputColumn{"films.director", &query.ColumnReference{3, types.TypeDecimal}},
+						},
+						Aggregates: []query.AggregateColumn{
+							{"count", query.AggregateFunctionCount, nil, false},
+							{"max", query.AggregateFunctionMax, &query.ColumnReference{0, types.TypeDecimal}, false},
+						},
+					},
+					Keys: []query.SortKey{
+						{&query.ColumnReference{1, types.TypeDecimal}, false, false},
+					},
+				},
+				Columns: []query.OutputColumn{
+					query.OutputColumn{"films.director", &query.ColumnReference{0, types.TypeDecimal}},
+					query.OutputColumn{"count", &query.ColumnReference{1, types.TypeDecimal}},
+					query.OutputColumn{"?column?", &query.ArithmeticOperation{
+						&query.ColumnReference{2, types.TypeDecimal},
+						query.ArithmeticOperatorAdd,
+						query.NewConstant(types.Dec("1")),
+					}},
+				},
+			},
+		},
+		{
+			"select director from films group by director having count(*) > 1",
+			&query.Project{
+				From: &query.Select{
+					From: &query.Aggregate{
+						From: query.NewLoad("films", sampleData.Films.Schema),
+						GroupBy: []query.OutputColumn{
+							query.OutputColumn{"films.director", &query.ColumnReference{3, types.TypeDecimal}},
+						},
+						Aggregates: []query.AggregateColumn{
+							{"count", query.AggregateFunctionCount, nil, false},
+						},
+					},
+					Condition: &query.BinaryOperation{
+						&query.ColumnReference{1, types.TypeDecimal},
+						query.BinaryOperatorGt,
+						query.NewConstant(types.Dec("1")),
+					},
+				},
+				Columns: []query.OutputColumn{
+					query.OutputColumn{"films.director", &query.ColumnReference{0, types.TypeDecimal}},
+				},
+			},
+		},
 	}
 
 	for _, c := range cases {
@@ -102,6 +207,44 @@ func TestPlanInvalid(t *testing.T) {
 		"select * from foo",
 		"select foo from films",
 		"select id from films where foo = 123",
+		"select id from films where not name",
+		"select id from films where id = 1 or name",
+		"select id from films order by foo",
+		"select id from films order by 0",
+		"select id from films order by 2",
+		"select id from films order by 1.5",
+		"select * from films order by 5",
+		"select * from films limit -1",
+		"select * from films limit 1.5",
+		"select * from films limit 'a'",
+		"select * from films limit id",
+		"select * from films offset 1 = 1",
+		"select name, count(*) from films group by director",
+		"select * from films group by id",
+		"select id from films where count(*) > 1",
+		"select sum(name) from films",
+		"select foo(id) from films",
+		"select count(count(*)) from films",
+		"select count(id, name) from films",
+		"select sum(*) from films",
+		"select director from films order by count(*), name",
+		"select director from films group by director having name = 'The Kid'",
+		"select director from films group by director having count(*)",
+		"select count(*) from films having id > 1",
+		"select distinct director from films order by name",
+		"select distinct director from films order by director + 1",
+		"select id as x, name as x from films",
+		"select id as x from films order by x + 1",
+		"select id as x from films where x = 1",
+		"select * from films join films on films.id = films.director",
+		"select * from films f join people f on f.id = f.director",
+		"select films.id from films f",
+		"select id from films a join films b on a.id = b.director",
+		"select * from films join people using (director)",
+		"select * from films join people using (id, id)",
+		"select * from films join people using (release_date)",
+		"select * from films a join films b using (name) join people using (id)",
+		"select * from films a join films b using (name) natural join people",
 	}
 	for _, c := range cases {
 		stmt := parse(t, c)
@@ -111,3 +254,288 @@ func TestPlanInvalid(t *testing.T) {
 		}
 	}
 }
+
+func TestPlanJoinStrategy(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	cases := []struct {
+		stmt     string
+		strategy JoinStrategy
+		want     string
+	}{
+		{"select * from films join people on films.director = people.id", JoinStrategyAuto, "*query.HashJoin"},
+		{"select * from films join people on films.director = people.id", JoinStrategyNestedLoop, "*query.Join"},
+		{"select * from films join people on films.director = people.id", JoinStrategyHash, "*query.HashJoin"},
+		{"select * from films join people on people.id = films.director", JoinStrategyMerge, "*query.MergeJoin"},
+		{"select * from films join people on films.director < people.id", JoinStrategyAuto, "*query.Join"},
+		{"select * from films join people on films.director < people.id", JoinStrategyHash, "*query.Join"},
+		{"select * from films join people on films.director < people.id", JoinStrategyMerge, "*query.Join"},
+		{
+			"select * from films join people on films.director = people.id and people.name <> 'x'",
+			JoinStrategyHash,
+			"*query.Join",
+		},
+		{"select * from films f join films g on f.id = f.director", JoinStrategyHash, "*query.Join"},
+	}
+	for _, c := range cases {
+		plan, err := PlanWithOptions(parse(t, c.stmt), sampleData.Database, Options{JoinStrategy: c.strategy})
+		if err != nil {
+			t.Errorf("PlanWithOptions returned error for %q: %v", c.stmt, err)
+			continue
+		}
+		got := fmt.Sprintf("%T", plan)
+		if got != c.want {
+			t.Errorf("PlanWithOptions for %q with %s strategy returned %s, want %s", c.stmt, c.strategy, got, c.want)
+		}
+	}
+}
+
+func TestPlanParallelism(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	cases := []struct {
+		stmt string
+		want string
+	}{
+		{"select * from films", "*query.Gather"},
+		{"select name from films where id > 1", "*query.Gather"},
+		{"select * from films join people on films.director = people.id", "*query.Gather"},
+		{"select * from films full join people on films.director = people.id", "*query.HashJoin"},
+		{"select distinct director from films", "*query.Distinct"},
+		{"select name from films order by name", "*query.Project"},
+		{"select director, count(*) from films group by director", "*query.Project"},
+	}
+	for _, c := range cases {
+		plan, err := PlanWithOptions(parse(t, c.stmt), sampleData.Database, Options{Parallelism: 4})
+		if err != nil {
+			t.Errorf("PlanWithOptions returned error for %q: %v", c.stmt, err)
+			continue
+		}
+		got := fmt.Sprintf("%T", plan)
+		if got != c.want {
+			t.Errorf("PlanWithOptions for %q with parallelism returned %s, want %s", c.stmt, got, c.want)
+		}
+	}
+
+	// without parallelism, there's no Gather step
+	plan, err := PlanWithOptions(parse(t, "select * from films"), sampleData.Database, Options{Parallelism: 1})
+	if err != nil {
+		t.Fatalf("PlanWithOptions returned error: %v", err)
+	}
+	if _, ok := plan.(*query.Gather); ok {
+		t.Errorf("PlanWithOptions with parallelism 1 returned Gather step")
+	}
+}
+
+func TestPlanIndex(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	db := sampleData.Database
+	for _, ix := range []struct {
+		name    string
+		columns []string
+	}{
+		{"films_director", []string{"director"}},
+		{"films_director_date", []string{"director", "release_date"}},
+		{"films_name", []string{"name"}},
+	} {
+		if err := db.CreateIndex(ix.name, "films", ix.columns); err != nil {
+			t.Fatalf("CreateIndex returned error: %v", err)
+		}
+	}
+
+	cases := []struct {
+		stmt        string
+		index, want string
+	}{
+		{"select * from films where name = 'The Kid'", "films_name", `[("The Kid"), ("The Kid")]`},
+		{"select * from films where 'The Kid' = name", "films_name", `[("The Kid"), ("The Kid")]`},
+		{"select * from films f where f.name > 'S'", "films_name", `(("S"), +inf)`},
+		{"select * from films where 'S' >= name and id > 1", "films_name", `(-inf, ("S")]`},
+		{"select * from films where director > 0 and director <= 5 and director >= 1", "films_director", `[(1), (5)]`},
+		{"select * from films where director = 1", "films_director", `[(1), (1)]`},
+		{
+			"select * from films where director = 1 and release_date < date '1925-01-01'",
+			"films_director_date",
+			`[(1), (1, 1925-01-01))`,
+		},
+		{"select * from films where release_date < date '1925-01-01'", "", ""},
+		{"select * from films where name <> 'The Kid'", "", ""},
+		{"select * from films where name = 'The Kid' or director = 1", "", ""},
+		{"select * from films where director = id", "", ""},
+	}
+	for _, c := range cases {
+		plan, err := Plan(parse(t, c.stmt), db)
+		if err != nil {
+			t.Errorf("Plan returned error for %q: %v", c.stmt, err)
+			continue
+		}
+		s, ok := plan.(*query.Select)
+		if !ok {
+			t.Errorf("Plan for %q returned %T, want *query.Select", c.stmt, plan)
+			continue
+		}
+		scan, ok := s.From.(*query.IndexScan)
+		if c.index == "" {
+			if ok {
+				t.Errorf("Plan for %q used index %s", c.stmt, scan.IndexName)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("Plan for %q returned Select from %T, want *query.IndexScan", c.stmt, s.From)
+			continue
+		}
+		if scan.IndexName != c.index || scan.Range.String() != c.want {
+			t.Errorf("Plan for %q scans %s for %s, want %s for %s",
+				c.stmt, scan.IndexName, scan.Range, c.index, c.want)
+		}
+	}
+}
+
+func TestPlanHashIndex(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	db := sampleData.Database
+	for _, ix := range []struct {
+		name    string
+		columns []string
+		method  storage.IndexMethod
+	}{
+		{"films_id", []string{"id"}, storage.IndexMethodHash},
+		{"films_director", []string{"director"}, storage.IndexMethodBtree},
+		{"films_director_name", []string{"director", "name"}, storage.IndexMethodHash},
+	} {
+		if err := db.CreateIndexUsing(ix.name, "films", ix.columns, ix.method); err != nil {
+			t.Fatalf("CreateIndexUsing returned error: %v", err)
+		}
+	}
+
+	cases := []struct {
+		stmt        string
+		index, want string
+	}{
+		{"select * from films where id = 2", "films_id", "[[2]]"},
+		{"select * from films f where f.id in (3, 1)", "films_id", "[[3] [1]]"},
+		{"select * from films where 2 = id and director = 1", "films_id", "[[2]]"},
+		{
+			"select * from films where director = 1 and name in ('The Kid', 'The General')",
+			"films_director_name",
+			`[[1 "The Kid"] [1 "The General"]]`,
+		},
+		{
+			"select * from films where director in (1, 2) and name in ('a', 'b')",
+			"films_director_name",
+			`[[1 "a"] [1 "b"] [2 "a"] [2 "b"]]`,
+		},
+		{"select * from films where director = 1", "films_director", ""},
+		{"select * from films where id > 1", "", ""},
+		{"select * from films where id in (1, director)", "", ""},
+		{"select * from films where id not in (1)", "", ""},
+		{"select * from films where id = 1 or id = 2", "", ""},
+	}
+	for _, c := range cases {
+		plan, err := Plan(parse(t, c.stmt), db)
+		if err != nil {
+			t.Errorf("Plan returned error for %q: %v", c.stmt, err)
+			continue
+		}
+		s, ok := plan.(*query.Select)
+		if !ok {
+			t.Errorf("Plan for %q returned %T, want *query.Select", c.stmt, plan)
+			continue
+		}
+		switch from := s.From.(type) {
+		case *query.HashIndexScan:
+			if got := fmt.Sprint(from.Keys); from.IndexName != c.index || got != c.want {
+				t.Errorf("Plan for %q looks up %s in %s, want %s in %s",
+					c.stmt, got, from.IndexName, c.want, c.index)
+			}
+		case *query.IndexScan:
+			if from.IndexName != c.index || c.want != "" {
+				t.Errorf("Plan for %q scans %s, want %s", c.stmt, from.IndexName, c.index)
+			}
+		default:
+			if c.index != "" {
+				t.Errorf("Plan for %q returned Select from %T, want index %s", c.stmt, s.From, c.index)
+			}
+		}
+	}
+}
+
+func TestPlanIndexJoin(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	db := sampleData.Database
+	if err := db.CreateIndex("films_id", "films", []string{"id"}); err != nil {
+		t.Fatalf("CreateIndex returned error: %v", err)
+	}
+	if err := db.CreateIndexUsing("people_id", "people", []string{"id"}, storage.IndexMethodHash); err != nil {
+		t.Fatalf("CreateIndexUsing returned error: %v", err)
+	}
+	noIndexes := storage.GetSampleData().Database
+
+	cases := []struct {
+		stmt    string
+		indexes []string
+	}{
+		{"select * from films f join people p on f.director = p.id where f.id = 2", []string{"films_id"}},
+		{"select * from films f join people p on f.director = p.id where p.id = 1", []string{"people_id"}},
+		{
+			"select * from films f join people p on f.director = p.id where f.id < 3 and p.id in (1, 2)",
+			[]string{"films_id", "people_id"},
+		},
+		{"select * from films f left join people p on f.director = p.id where p.id = 1", []string{"people_id"}},
+		{"select * from people p right join films f on f.director = p.id where f.id >= 2", []string{"films_id"}},
+		{"select * from films f cross join people p where f.id = 1 and p.id = 1", []string{"films_id", "people_id"}},
+		{"select * from films join people using (id) where id = 1", []string{"films_id"}},
+		{"select * from films f join people p on f.director = p.id where f.id = 2 or p.id = 1", nil},
+		{"select * from films f join people p on f.director = p.id where f.id = p.id", nil},
+	}
+	for _, c := range cases {
+		plan, err := Plan(parse(t, c.stmt), db)
+		if err != nil {
+			t.Errorf("Plan returned error for %q: %v", c.stmt, err)
+			continue
+		}
+		if got := indexNames(plan); !reflect.DeepEqual(got, c.indexes) {
+			t.Errorf("Plan for %q uses indexes %v, want %v", c.stmt, got, c.indexes)
+		}
+
+		got, err := plan.Run(context.Background(), db)
+		if err != nil {
+			t.Errorf("Run returned error for %q: %v", c.stmt, err)
+			continue
+		}
+		want, err := Plan(parse(t, c.stmt), noIndexes)
+		if err != nil {
+			t.Fatalf("Plan returned error for %q: %v", c.stmt, err)
+		}
+		wantRelation, err := want.Run(context.Background(), noIndexes)
+		if err != nil {
+			t.Fatalf("Run returned error for %q: %v", c.stmt, err)
+		}
+		if len(wantRelation.Rows) == 0 {
+			t.Errorf("query %q returned no rows", c.stmt)
+		}
+		if !reflect.DeepEqual(got.Rows, wantRelation.Rows) {
+			t.Errorf("with indexes, %q returned\n%v\nwant\n%v", c.stmt, got.Rows, wantRelation.Rows)
+		}
+	}
+}
+
+// indexNames returns the names of the indexes scanned by a plan, from left to right.
+func indexNames(plan query.Plan) []string {
+	switch p := plan.(type) {
+	case *query.IndexScan:
+		return []string{p.IndexName}
+	case *query.HashIndexScan:
+		return []string{p.IndexName}
+	case *query.Select:
+		return indexNames(p.From)
+	case *query.Project:
+		return indexNames(p.From)
+	case *query.Join:
+		return append(indexNames(p.Left), indexNames(p.Right)...)
+	case *query.HashJoin:
+		return append(indexNames(p.Left), indexNames(p.Right)...)
+	case *query.MergeJoin:
+		return append(indexNames(p.Left), indexNames(p.Right)...)
+	}
+	return nil
+}
@@ -0,0 +1,350 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"unsafe"
+
+	"github.com/lfritz/toydb/storage"
+	"github.com/lfritz/toydb/types"
+)
+
+// An AggregateFunction computes a single value from the rows of a group.
+type AggregateFunction int
+
+const (
+	AggregateFunctionCount AggregateFunction = iota
+	AggregateFunctionSum
+	AggregateFunctionAvg
+	AggregateFunctionMin
+	AggregateFunctionMax
+)
+
+func (f AggregateFunction) String() string {
+	switch f {
+	case AggregateFunctionCount:
+		return "count"
+	case AggregateFunctionSum:
+		return "sum"
+	case AggregateFunctionAvg:
+		return "avg"
+	case AggregateFunctionMin:
+		return "min"
+	case AggregateFunctionMax:
+		return "max"
+	}
+	panic(fmt.Sprintf("unexpected AggregateFunction: %d", f))
+}
+
+// An AggregateColumn is a column computed by an aggregate function. The Argument is evaluated for
+// each input row; it's nil for "count(*)", which counts rows. If Distinct is set, duplicate values
+// of the argument are only counted once.
+type AggregateColumn struct {
+	Name     string
+	Function AggregateFunction
+	Argument Expression
+	Distinct bool
+}
+
+func (c AggregateColumn) Type() types.Type {
+	switch c.Function {
+	case AggregateFunctionMin, AggregateFunctionMax:
+		return c.Argument.Type()
+	}
+	return types.TypeDecimal
+}
+
+// Schema returns the schema of the column. Only count is never null; the other functions return
+// null for groups without any non-null values.
+func (c AggregateColumn) Schema() types.ColumnSchema {
+	return types.ColumnSchema{
+		Name: c.Name,
+		Type: c.Type(),
+		Null: c.Function != AggregateFunctionCount,
+	}
+}
+
+func (c AggregateColumn) check(schema types.TableSchema) error {
+	if c.Argument == nil {
+		if c.Function != AggregateFunctionCount {
+			return fmt.Errorf("missing argument for %s", c.Function)
+		}
+		if c.Distinct {
+			return fmt.Errorf("missing argument for %s(distinct)", c.Function)
+		}
+		return nil
+	}
+	if err := c.Argument.Check(schema); err != nil {
+		return err
+	}
+	switch c.Function {
+	case AggregateFunctionSum, AggregateFunctionAvg:
+		if c.Argument.Type() != types.TypeDecimal {
+			return fmt.Errorf("invalid type for %s: %v", c.Function, c.Argument.Type())
+		}
+	}
+	return nil
+}
+
+func (c AggregateColumn) String() string {
+	argument := "*"
+	if c.Argument != nil {
+		argument = c.Argument.String()
+	}
+	if c.Distinct {
+		argument = "distinct " + argument
+	}
+	return fmt.Sprintf("{%s %s(%s)}", c.Name, c.Function, argument)
+}
+
+// An Aggregate step groups rows by the values of the GroupBy columns and computes the aggregate
+// columns for each group. Its output has the GroupBy columns followed by the aggregate columns.
+//
+// Without GroupBy columns, all rows form a single group, so the output has exactly one row even if
+// the input is empty.
+type Aggregate struct {
+	From       Plan
+	GroupBy    []OutputColumn
+	Aggregates []AggregateColumn
+}
+
+func NewAggregate(from Plan, groupBy []OutputColumn, aggregates []AggregateColumn) (*Aggregate, error) {
+	schema := from.Schema()
+	for _, c := range groupBy {
+		if err := c.Expression.Check(schema); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range aggregates {
+		if err := c.check(schema); err != nil {
+			return nil, err
+		}
+	}
+	return &Aggregate{
+		From:       from,
+		GroupBy:    groupBy,
+		Aggregates: aggregates,
+	}, nil
+}
+
+func (a *Aggregate) Schema() types.TableSchema {
+	from := a.From.Schema()
+	var columns []types.ColumnSchema
+	for _, c := range a.GroupBy {
+		columns = append(columns, c.Schema(from))
+	}
+	for _, c := range a.Aggregates {
+		columns = append(columns, c.Schema())
+	}
+	return types.TableSchema{
+		Columns: columns,
+	}
+}
+
+// group holds the values of the GroupBy columns and the state of the aggregate functions for one
+// group.
+type group struct {
+	values       []types.Value
+	accumulators []accumulator
+}
+
+// newGroup returns a group with empty accumulators. Accumulators for distinct aggregates reserve
+// memory for the keys of the values they've seen.
+func (a *Aggregate) newGroup(values []types.Value, memory *reservation) *group {
+	accumulators := make([]accumulator, len(a.Aggregates))
+	for i, c := range a.Aggregates {
+		accumulators[i].function = c.Function
+		if c.Distinct {
+			accumulators[i].seen = make(map[string]bool)
+			accumulators[i].memory = memory
+		}
+	}
+	return &group{
+		values:       values,
+		accumulators: accumulators,
+	}
+}
+
+func (a *Aggregate) Run(ctx context.Context, db *storage.Database) (*types.Relation, error) {
+	return run(ctx, a, db)
+}
+
+// Open reads all rows of the input before returning the first group.
+func (a *Aggregate) Open(ctx context.Context, db *storage.Database) (Iterator, error) {
+	memory := newReservation(ctx, "Aggregate")
+	result, err := a.aggregate(ctx, db, memory)
+	if err != nil {
+		memory.release()
+		return nil, err
+	}
+	return newSliceIterator(ctx, result, memory), nil
+}
+
+func (a *Aggregate) aggregate(ctx context.Context, db *storage.Database, memory *reservation) (*types.Relation, error) {
+	from, err := a.From.Open(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	defer from.Close()
+
+	// groups are output in the order in which they first appear in the input
+	groups := make(map[string]*group)
+	var order []*group
+	if len(a.GroupBy) == 0 {
+		g := a.newGroup(nil, memory)
+		groups[""] = g
+		order = append(order, g)
+	}
+
+	canceler := newCanceler(ctx)
+	for {
+		fromValues, ok, err := from.Next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		if err := canceler.check(); err != nil {
+			return nil, err
+		}
+		row := &types.Row{
+			Schema: from.Schema(),
+			Values: fromValues,
+		}
+		values := make([]types.Value, len(a.GroupBy))
+		for j, c := range a.GroupBy {
+			values[j], err = c.Expression.Evaluate(row)
+			if err != nil {
+				return nil, err
+			}
+		}
+		key := types.RowKey(values)
+		g, ok := groups[key]
+		if !ok {
+			size := len(key) + types.RowSize(values) + len(a.Aggregates)*int(unsafe.Sizeof(accumulator{}))
+			if err := memory.add(size); err != nil {
+				return nil, err
+			}
+			g = a.newGroup(values, memory)
+			groups[key] = g
+			order = append(order, g)
+		}
+		for j, c := range a.Aggregates {
+			if c.Argument == nil {
+				g.accumulators[j].addRow()
+				continue
+			}
+			value, err := c.Argument.Evaluate(row)
+			if err != nil {
+				return nil, err
+			}
+			if err := g.accumulators[j].add(value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	rows := make([][]types.Value, len(order))
+	for i, g := range order {
+		row := make([]types.Value, 0, len(a.GroupBy)+len(a.Aggregates))
+		row = append(row, g.values...)
+		for j, c := range a.Aggregates {
+			row = append(row, g.accumulators[j].result(c.Type()))
+		}
+		rows[i] = row
+	}
+	return &types.Relation{
+		Schema: a.Schema(),
+		Rows:   rows,
+	}, nil
+}
+
+func (a *Aggregate) Print(printer *Printer) {
+	printer.Println("Aggregate {")
+	printer.Indent()
+	printer.Print("From: ")
+	a.From.Print(printer)
+	printer.Println("GroupBy:")
+	printer.Indent()
+	for i, c := range a.GroupBy {
+		printer.Println("(%d) %s", i, c)
+	}
+	printer.Unindent()
+	printer.Println("Aggregates:")
+	printer.Indent()
+	for i, c := range a.Aggregates {
+		printer.Println("(%d) %s", i, c)
+	}
+	printer.Unindent()
+	printer.Unindent()
+	printer.Println("}")
+}
+
+// An accumulator computes an aggregate function over a sequence of values. Null values are ignored.
+// If seen is not nil, values that were added before are ignored as well, and memory is reserved for
+// their keys.
+type accumulator struct {
+	function AggregateFunction
+	count    int
+	sum      types.Decimal
+	value    types.Value // current minimum or maximum
+	seen     map[string]bool
+	memory   *reservation
+}
+
+func (a *accumulator) addRow() {
+	a.count++
+}
+
+func (a *accumulator) add(v types.Value) error {
+	if v.Null() {
+		return nil
+	}
+	if a.seen != nil {
+		key := v.Key()
+		if a.seen[key] {
+			return nil
+		}
+		if err := a.memory.add(len(key)); err != nil {
+			return err
+		}
+		a.seen[key] = true
+	}
+	switch a.function {
+	case AggregateFunctionSum, AggregateFunctionAvg:
+		a.sum = a.sum.Add(v.Value().(types.Decimal))
+	case AggregateFunctionMin:
+		if a.count == 0 || v.Compare(a.value) == types.ComparedLt {
+			a.value = v
+		}
+	case AggregateFunctionMax:
+		if a.count == 0 || v.Compare(a.value) == types.ComparedGt {
+			a.value = v
+		}
+	}
+	a.count++
+	return nil
+}
+
+func (a *accumulator) result(t types.Type) types.Value {
+	if a.function == AggregateFunctionCount {
+		return types.NewValue(types.NewDecimal(strconv.Itoa(a.count)))
+	}
+	if a.count == 0 {
+		return types.NewNull(t)
+	}
+	switch a.function {
+	case AggregateFunctionSum:
+		return types.NewValue(a.sum)
+	case AggregateFunctionAvg:
+		count := types.NewDecimal(strconv.Itoa(a.count))
+		avg, err := a.sum.Div(count)
+		if err != nil {
+			panic(fmt.Sprintf("error computing average: %v", err))
+		}
+		return types.NewValue(avg)
+	}
+	return a.value
+}
@@ -0,0 +1,200 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lfritz/toydb/storage"
+	"github.com/lfritz/toydb/types"
+)
+
+func TestAggregate(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	id := NewColumnReference(0, types.TypeDecimal)
+	name := NewColumnReference(1, types.TypeText)
+	releaseDate := NewColumnReference(2, types.TypeDate)
+
+	a, err := NewAggregate(
+		NewLoad("films", sampleData.Films.Schema),
+		[]OutputColumn{SimpleColumn("films.director", 3, types.TypeDecimal)},
+		[]AggregateColumn{
+			{"count", AggregateFunctionCount, nil, false},
+			{"sum", AggregateFunctionSum, id, false},
+			{"avg", AggregateFunctionAvg, id, false},
+			{"min", AggregateFunctionMin, name, false},
+			{"max", AggregateFunctionMax, releaseDate, false},
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewAggregate returned error: %v", err)
+	}
+
+	want := &types.Relation{
+		Schema: types.TableSchema{
+			Columns: []types.ColumnSchema{
+				types.ColumnSchema{"films.director", types.TypeDecimal, false},
+				types.ColumnSchema{"count", types.TypeDecimal, false},
+				types.ColumnSchema{"sum", types.TypeDecimal, true},
+				types.ColumnSchema{"avg", types.TypeDecimal, true},
+				types.ColumnSchema{"min", types.TypeText, true},
+				types.ColumnSchema{"max", types.TypeDate, true},
+			},
+		},
+		Rows: [][]types.Value{
+			{types.Dec("1"), types.Dec("2"), types.Dec("4"), types.Dec("2"), types.Txt("Sherlock Jr."), types.Dat(1926, 12, 31)},
+			{types.Dec("2"), types.Dec("1"), types.Dec("2"), types.Dec("2"), types.Txt("The Kid"), types.Dat(1921, 1, 21)},
+		},
+	}
+	got := mustRun(t, a, sampleData.Database)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Run returned %v, want %v", got, want)
+	}
+}
+
+func TestAggregateEmptyInput(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	empty, err := NewSelect(NewLoad("films", sampleData.Films.Schema), NewConstant(types.Boo(false)))
+	if err != nil {
+		t.Fatalf("NewSelect returned error: %v", err)
+	}
+	id := NewColumnReference(0, types.TypeDecimal)
+	aggregates := []AggregateColumn{
+		{"count", AggregateFunctionCount, nil, false},
+		{"sum", AggregateFunctionSum, id, false},
+		{"avg", AggregateFunctionAvg, id, false},
+		{"max", AggregateFunctionMax, id, false},
+	}
+
+	// without group by, there's always one row
+	a, err := NewAggregate(empty, nil, aggregates)
+	if err != nil {
+		t.Fatalf("NewAggregate returned error: %v", err)
+	}
+	got := mustRun(t, a, sampleData.Database).Rows
+	null := types.NewNull(types.TypeDecimal)
+	want := [][]types.Value{{types.Dec("0"), null, null, null}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Run returned %v, want %v", got, want)
+	}
+
+	// with group by, there are no groups
+	a, err = NewAggregate(empty, []OutputColumn{SimpleColumn("films.id", 0, types.TypeDecimal)}, aggregates)
+	if err != nil {
+		t.Fatalf("NewAggregate returned error: %v", err)
+	}
+	got = mustRun(t, a, sampleData.Database).Rows
+	if len(got) != 0 {
+		t.Errorf("Run returned %v, want no rows", got)
+	}
+}
+
+func TestAggregateDistinct(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	director := NewColumnReference(3, types.TypeDecimal)
+
+	a, err := NewAggregate(
+		NewLoad("films", sampleData.Films.Schema),
+		nil,
+		[]AggregateColumn{
+			{"count", AggregateFunctionCount, director, false},
+			{"count", AggregateFunctionCount, director, true},
+			{"sum", AggregateFunctionSum, director, false},
+			{"sum", AggregateFunctionSum, director, true},
+			{"avg", AggregateFunctionAvg, director, true},
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewAggregate returned error: %v", err)
+	}
+	got := mustRun(t, a, sampleData.Database).Rows
+	want := [][]types.Value{
+		{types.Dec("3"), types.Dec("2"), types.Dec("4"), types.Dec("3"), types.Dec("1.5")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Run returned %v, want %v", got, want)
+	}
+}
+
+func TestAggregateNulls(t *testing.T) {
+	sampleData := storage.GetSampleData()
+
+	// people left outer join films on people.id = films.director
+	condition, err := NewBinaryOperation(
+		NewColumnReference(0, types.TypeDecimal),
+		BinaryOperatorEq,
+		NewColumnReference(5, types.TypeDecimal),
+	)
+	if err != nil {
+		t.Fatalf("NewBinaryOperation returned error: %v", err)
+	}
+	join, err := NewJoin(
+		JoinTypeLeftOuter,
+		NewLoad("people", sampleData.People.Schema),
+		NewLoad("films", sampleData.Films.Schema),
+		condition,
+	)
+	if err != nil {
+		t.Fatalf("NewJoin returned error: %v", err)
+	}
+	filmID := NewColumnReference(2, types.TypeDecimal)
+
+	a, err := NewAggregate(
+		join,
+		[]OutputColumn{SimpleColumn("people.name", 1, types.TypeText)},
+		[]AggregateColumn{
+			{"count", AggregateFunctionCount, nil, false},
+			{"count", AggregateFunctionCount, filmID, false},
+			{"sum", AggregateFunctionSum, filmID, false},
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewAggregate returned error: %v", err)
+	}
+	got := mustRun(t, a, sampleData.Database).Rows
+	want := [][]types.Value{
+		{types.Txt("Buster Keaton"), types.Dec("2"), types.Dec("2"), types.Dec("4")},
+		{types.Txt("Charlie Chaplin"), types.Dec("1"), types.Dec("1"), types.Dec("2")},
+		{types.Txt("Harold Lloyd"), types.Dec("1"), types.Dec("0"), types.NewNull(types.TypeDecimal)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Run returned %v, want %v", got, want)
+	}
+
+	// nulls form a single group
+	a, err = NewAggregate(
+		join,
+		[]OutputColumn{SimpleColumn("films.director", 5, types.TypeDecimal)},
+		[]AggregateColumn{{"count", AggregateFunctionCount, nil, false}},
+	)
+	if err != nil {
+		t.Fatalf("NewAggregate returned error: %v", err)
+	}
+	got = mustRun(t, a, sampleData.Database).Rows
+	want = [][]types.Value{
+		{types.Dec("1"), types.Dec("2")},
+		{types.Dec("2"), types.Dec("1")},
+		{types.NewNull(types.TypeDecimal), types.Dec("1")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Run returned %v, want %v", got, want)
+	}
+}
+
+func TestNewAggregateInvalid(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	l := NewLoad("films", sampleData.Films.Schema)
+	name := NewColumnReference(1, types.TypeText)
+	cases := []AggregateColumn{
+		{"sum", AggregateFunctionSum, name, false},
+		{"avg", AggregateFunctionAvg, name, false},
+		{"max", AggregateFunctionMax, nil, false},
+		{"count", AggregateFunctionCount, nil, true},
+		{"min", AggregateFunctionMin, NewColumnReference(7, types.TypeText), false},
+	}
+	for _, c := range cases {
+		_, err := NewAggregate(l, nil, []AggregateColumn{c})
+		if err == nil {
+			t.Errorf("NewAggregate did not return error for %v", c)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package query
+
+import "context"
+
+// checkInterval is the number of rows a plan step processes between checks whether the query was
+// canceled.
+const checkInterval = 256
+
+// A canceler checks periodically whether a query's context is done, so long-running loops can stop
+// without paying for a check on every row.
+type canceler struct {
+	ctx   context.Context
+	count int
+}
+
+func newCanceler(ctx context.Context) *canceler {
+	return &canceler{ctx: ctx}
+}
+
+// check is called once for each row processed. It returns context.Canceled or
+// context.DeadlineExceeded if the context is done, checking on the first call and then every
+// checkInterval calls.
+func (c *canceler) check() error {
+	c.count++
+	if c.count%checkInterval != 1 {
+		return nil
+	}
+	return c.ctx.Err()
+}
@@ -0,0 +1,136 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lfritz/toydb/storage"
+	"github.com/lfritz/toydb/types"
+)
+
+// numbersTable creates a table "numbers" with a single decimal column "n" and the values 0 to n-1.
+// It returns the database and the table's schema.
+func numbersTable(t *testing.T, n int) (*storage.Database, types.TableSchema) {
+	t.Helper()
+	db := storage.NewDatabase()
+	schema := types.TableSchema{
+		Columns: []types.ColumnSchema{
+			{"n", types.TypeDecimal, false},
+		},
+	}
+	table, err := db.CreateTable("numbers", schema)
+	if err != nil {
+		t.Fatalf("CreateTable returned error: %v", err)
+	}
+	for i := 0; i < n; i++ {
+		table.Rows = append(table.Rows, []types.Value{types.Dec(strconv.Itoa(i))})
+	}
+	return db, schema
+}
+
+func TestCanceled(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	films := NewLoad("films", sampleData.Films.Schema)
+	people := NewLoad("people", sampleData.People.Schema)
+	director := NewColumnReference(3, types.TypeDecimal)
+	id := NewColumnReference(0, types.TypeDecimal)
+	condition, err := NewBinaryOperation(director, BinaryOperatorEq, NewColumnReference(4, types.TypeDecimal))
+	if err != nil {
+		t.Fatalf("NewBinaryOperation returned error: %v", err)
+	}
+	selectCondition, err := NewBinaryOperation(director, BinaryOperatorEq, NewConstant(types.Dec("1")))
+	if err != nil {
+		t.Fatalf("NewBinaryOperation returned error: %v", err)
+	}
+
+	var plans []Plan
+	add := func(p Plan, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("creating plan returned error: %v", err)
+		}
+		plans = append(plans, p)
+	}
+	add(films, nil)
+	add(NewSelect(films, selectCondition))
+	add(NewProject(films, []OutputColumn{{"id", id}}))
+	add(NewSort(films, []SortKey{{director, false, false}}))
+	add(NewAggregate(films, nil, []AggregateColumn{{"count", AggregateFunctionCount, nil, false}}))
+	add(NewJoin(JoinTypeInner, films, people, condition))
+	add(NewHashJoin(JoinTypeInner, films, people, []Expression{director}, []Expression{id}))
+	add(NewMergeJoin(JoinTypeInner, films, people, []Expression{director}, []Expression{id}))
+	add(NewLimit(films, 1, 1))
+	plans = append(plans, NewDistinct(films))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, p := range plans {
+		_, err := p.Run(ctx, sampleData.Database)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Run for\n%sreturned %v, want %v", Print(p), err, context.Canceled)
+		}
+	}
+}
+
+func TestCancelWhileRunning(t *testing.T) {
+	db, schema := numbersTable(t, 2*checkInterval)
+	numbers := NewLoad("numbers", schema)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	it, err := numbers.Open(ctx, db)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer it.Close()
+	if _, ok := mustNext(t, it); !ok {
+		t.Fatalf("Next returned no row")
+	}
+
+	cancel()
+	for i := 0; i < checkInterval; i++ {
+		_, ok, err := it.Next()
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		if err != nil || !ok {
+			t.Fatalf("Next returned %v, %v; want %v", ok, err, context.Canceled)
+		}
+	}
+	t.Errorf("Next did not return %v within %d rows", context.Canceled, checkInterval)
+}
+
+func TestJoinTimeout(t *testing.T) {
+	// a nested-loop join that compares 10^8 pairs of rows and never finds a match
+	db, schema := numbersTable(t, 10000)
+	condition, err := NewBinaryOperation(
+		NewColumnReference(0, types.TypeDecimal),
+		BinaryOperatorLt,
+		NewConstant(types.Dec("0")),
+	)
+	if err != nil {
+		t.Fatalf("NewBinaryOperation returned error: %v", err)
+	}
+	join, err := NewJoin(
+		JoinTypeInner,
+		NewLoad("numbers", schema),
+		NewLoadAs("numbers", "other", schema),
+		condition,
+	)
+	if err != nil {
+		t.Fatalf("NewJoin returned error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = join.Run(ctx, db)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Run returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Run took %v, want it to stop soon after the deadline", elapsed)
+	}
+}
@@ -0,0 +1,83 @@
+package query
+
+import (
+	"context"
+
+	"github.com/lfritz/toydb/storage"
+	"github.com/lfritz/toydb/types"
+)
+
+// A Distinct step removes duplicate rows. Two rows are duplicates if their values are pairwise
+// identical, so null values are not distinct from each other. Rows are returned in the order in
+// which they first appear in the input.
+type Distinct struct {
+	From Plan
+}
+
+func NewDistinct(from Plan) *Distinct {
+	return &Distinct{
+		From: from,
+	}
+}
+
+func (d *Distinct) Schema() types.TableSchema {
+	return d.From.Schema()
+}
+
+func (d *Distinct) Run(ctx context.Context, db *storage.Database) (*types.Relation, error) {
+	return run(ctx, d, db)
+}
+
+func (d *Distinct) Open(ctx context.Context, db *storage.Database) (Iterator, error) {
+	from, err := d.From.Open(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	return &distinctIterator{
+		from:   from,
+		seen:   make(map[string]bool),
+		memory: newReservation(ctx, "Distinct"),
+	}, nil
+}
+
+// A distinctIterator remembers the rows it has returned, reserving memory for their keys.
+type distinctIterator struct {
+	from   Iterator
+	seen   map[string]bool
+	memory *reservation
+}
+
+func (it *distinctIterator) Schema() types.TableSchema {
+	return it.from.Schema()
+}
+
+func (it *distinctIterator) Next() ([]types.Value, bool, error) {
+	for {
+		row, ok, err := it.from.Next()
+		if !ok || err != nil {
+			return nil, false, err
+		}
+		key := types.RowKey(row)
+		if !it.seen[key] {
+			if err := it.memory.add(len(key)); err != nil {
+				return nil, false, err
+			}
+			it.seen[key] = true
+			return row, true, nil
+		}
+	}
+}
+
+func (it *distinctIterator) Close() {
+	it.from.Close()
+	it.memory.release()
+}
+
+func (d *Distinct) Print(printer *Printer) {
+	printer.Println("Distinct {")
+	printer.Indent()
+	printer.Print("From: ")
+	d.From.Print(printer)
+	printer.Unindent()
+	printer.Println("}")
+}
@@ -0,0 +1,60 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lfritz/toydb/storage"
+	"github.com/lfritz/toydb/types"
+)
+
+func TestDistinct(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	condition, err := NewBinaryOperation(
+		NewColumnReference(0, types.TypeDecimal),
+		BinaryOperatorEq,
+		NewColumnReference(5, types.TypeDecimal),
+	)
+	if err != nil {
+		t.Fatalf("NewBinaryOperation returned error: %v", err)
+	}
+	join, err := NewJoin(
+		JoinTypeLeftOuter,
+		NewLoad("people", sampleData.People.Schema),
+		NewLoad("films", sampleData.Films.Schema),
+		condition,
+	)
+	if err != nil {
+		t.Fatalf("NewJoin returned error: %v", err)
+	}
+	project, err := NewProject(join, []OutputColumn{
+		SimpleColumn("films.director", 5, types.TypeDecimal),
+		ComputedColumn("null", NewConstant(types.NewNull(types.TypeText))),
+	})
+	if err != nil {
+		t.Fatalf("NewProject returned error: %v", err)
+	}
+
+	d := NewDistinct(project)
+	got := mustRun(t, d, sampleData.Database)
+	want := &types.Relation{
+		Schema: project.Schema(),
+		Rows: [][]types.Value{
+			{types.Dec("1"), types.NewNull(types.TypeText)},
+			{types.Dec("2"), types.NewNull(types.TypeText)},
+			{types.NewNull(types.TypeDecimal), types.NewNull(types.TypeText)},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Run returned %v, want %v", got, want)
+	}
+
+	l, err := NewLimit(d, 0, 2)
+	if err != nil {
+		t.Fatalf("NewLimit returned error: %v", err)
+	}
+	gotRows := mustRun(t, l, sampleData.Database).Rows
+	if !reflect.DeepEqual(gotRows, want.Rows[:2]) {
+		t.Errorf("Run with limit returned %v, want %v", gotRows, want.Rows[:2])
+	}
+}
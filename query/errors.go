@@ -0,0 +1,32 @@
+package query
+
+import "fmt"
+
+// A RuntimeError is the error returned when running a plan fails. If the error happened while
+// evaluating an expression, Expression is the innermost expression that failed; otherwise it's nil.
+type RuntimeError struct {
+	Expression Expression
+	Err        error
+}
+
+func (e RuntimeError) Error() string {
+	if e.Expression == nil {
+		return e.Err.Error()
+	}
+	return "error evaluating " + e.Expression.String() + ": " + e.Err.Error()
+}
+
+func (e RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// A MemoryLimitError is the error returned when a step of a plan would need more memory than the
+// query's limit allows. Node is the name of the step, such as "Sort" or "HashJoin".
+type MemoryLimitError struct {
+	Node  string
+	Limit int
+}
+
+func (e MemoryLimitError) Error() string {
+	return fmt.Sprintf("memory limit exceeded in %s step: limit is %d bytes", e.Node, e.Limit)
+}
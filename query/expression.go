@@ -2,16 +2,19 @@ package query
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/lfritz/toydb/types"
 )
 
 // An Expression is an expression composed of column references, constants, and operations on them.
-// Each expression has a static type.
+// Each expression has a static type. Nullable reports whether the expression can evaluate to null
+// for rows with the given schema. If Evaluate fails, it returns a RuntimeError.
 type Expression interface {
 	Type() types.Type
 	Check(schema types.TableSchema) error
-	Evaluate(r *types.Row) types.Value
+	Nullable(schema types.TableSchema) bool
+	Evaluate(r *types.Row) (types.Value, error)
 	String() string
 }
 
@@ -31,8 +34,12 @@ func (c Constant) Check(schema types.TableSchema) error {
 	return nil
 }
 
-func (c Constant) Evaluate(r *types.Row) types.Value {
-	return c.value
+func (c Constant) Nullable(schema types.TableSchema) bool {
+	return c.value.Null()
+}
+
+func (c Constant) Evaluate(r *types.Row) (types.Value, error) {
+	return c.value, nil
 }
 
 func (c Constant) String() string {
@@ -67,8 +74,12 @@ func (c ColumnReference) Check(schema types.TableSchema) error {
 	return nil
 }
 
-func (c *ColumnReference) Evaluate(r *types.Row) types.Value {
-	return r.Values[c.Index]
+func (c *ColumnReference) Nullable(schema types.TableSchema) bool {
+	return schema.Columns[c.Index].Null
+}
+
+func (c *ColumnReference) Evaluate(r *types.Row) (types.Value, error) {
+	return r.Values[c.Index], nil
 }
 
 func (c *ColumnReference) String() string {
@@ -106,21 +117,46 @@ func (o BinaryOperation) Check(schema types.TableSchema) error {
 	return nil
 }
 
-func (o *BinaryOperation) Evaluate(r *types.Row) types.Value {
-	left := o.Left.Evaluate(r)
-	right := o.Right.Evaluate(r)
+func (o *BinaryOperation) Nullable(schema types.TableSchema) bool {
+	return o.Left.Nullable(schema) || o.Right.Nullable(schema)
+}
+
+// Evaluate compares the two operands. If either of them is null, the result is null.
+func (o *BinaryOperation) Evaluate(r *types.Row) (types.Value, error) {
+	left, right, err := evaluateOperands(r, o.Left, o.Right)
+	if err != nil {
+		return types.Value{}, err
+	}
 	var result bool
 	switch left.Compare(right) {
 	case types.ComparedLt:
-		result = o.Operator == BinaryOperatorLt || o.Operator == BinaryOperatorLe
+		result = o.Operator == BinaryOperatorLt || o.Operator == BinaryOperatorLe || o.Operator == BinaryOperatorNe
 	case types.ComparedEq:
 		result = o.Operator == BinaryOperatorLe || o.Operator == BinaryOperatorEq || o.Operator == BinaryOperatorGe
 	case types.ComparedGt:
-		result = o.Operator == BinaryOperatorGt || o.Operator == BinaryOperatorGe
+		result = o.Operator == BinaryOperatorGt || o.Operator == BinaryOperatorGe || o.Operator == BinaryOperatorNe
+	case types.ComparedNull:
+		return types.NewNull(types.TypeBoolean), nil
 	default: // ComparedInvalid
-		panic("comparison returned ComparedInvalid")
+		return types.Value{}, RuntimeError{
+			Expression: o,
+			Err:        fmt.Errorf("cannot compare %v and %v", left.Type(), right.Type()),
+		}
+	}
+	return types.NewValue(types.NewBoolean(result)), nil
+}
+
+// evaluateOperands evaluates the two operands of a binary expression.
+func evaluateOperands(r *types.Row, left, right Expression) (types.Value, types.Value, error) {
+	a, err := left.Evaluate(r)
+	if err != nil {
+		return types.Value{}, types.Value{}, err
 	}
-	return types.NewValue(types.NewBoolean(result))
+	b, err := right.Evaluate(r)
+	if err != nil {
+		return types.Value{}, types.Value{}, err
+	}
+	return a, b, nil
 }
 
 func (o *BinaryOperation) String() string {
@@ -157,6 +193,197 @@ func (o BinaryOperator) String() string {
 	panic(fmt.Sprintf("unexpected BinaryOperator: %d", o))
 }
 
+// An ArithmeticOperation is an arithmetic operation on two decimal numbers. If either operand is
+// null, the result is null.
+type ArithmeticOperation struct {
+	Left     Expression
+	Operator ArithmeticOperator
+	Right    Expression
+}
+
+func NewArithmeticOperation(left Expression, op ArithmeticOperator, right Expression) (*ArithmeticOperation, error) {
+	if left.Type() != types.TypeDecimal || right.Type() != types.TypeDecimal {
+		return nil, fmt.Errorf("invalid types for %s: %v, %v", op, left.Type(), right.Type())
+	}
+	return &ArithmeticOperation{
+		Left:     left,
+		Operator: op,
+		Right:    right,
+	}, nil
+}
+
+func (o *ArithmeticOperation) Type() types.Type {
+	return types.TypeDecimal
+}
+
+func (o ArithmeticOperation) Check(schema types.TableSchema) error {
+	if err := o.Left.Check(schema); err != nil {
+		return err
+	}
+	if err := o.Right.Check(schema); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (o *ArithmeticOperation) Nullable(schema types.TableSchema) bool {
+	return o.Left.Nullable(schema) || o.Right.Nullable(schema)
+}
+
+func (o *ArithmeticOperation) Evaluate(r *types.Row) (types.Value, error) {
+	left, right, err := evaluateOperands(r, o.Left, o.Right)
+	if err != nil {
+		return types.Value{}, err
+	}
+	if left.Null() || right.Null() {
+		return types.NewNull(types.TypeDecimal), nil
+	}
+
+	a := left.Value().(types.Decimal)
+	b := right.Value().(types.Decimal)
+	var result types.Decimal
+	switch o.Operator {
+	case ArithmeticOperatorAdd:
+		result = a.Add(b)
+	case ArithmeticOperatorSub:
+		result = a.Sub(b)
+	case ArithmeticOperatorMul:
+		result = a.Mul(b)
+	case ArithmeticOperatorDiv:
+		result, err = a.Div(b)
+	case ArithmeticOperatorMod:
+		result, err = a.Mod(b)
+	default:
+		panic(fmt.Sprintf("unexpected ArithmeticOperator: %d", o.Operator))
+	}
+	if err != nil {
+		return types.Value{}, RuntimeError{Expression: o, Err: err}
+	}
+	return types.NewValue(result), nil
+}
+
+func (o *ArithmeticOperation) String() string {
+	return fmt.Sprintf("ArithmeticOperation(%s %s %s)", o.Left, o.Operator, o.Right)
+}
+
+type ArithmeticOperator int
+
+const (
+	ArithmeticOperatorAdd ArithmeticOperator = iota
+	ArithmeticOperatorSub
+	ArithmeticOperatorMul
+	ArithmeticOperatorDiv
+	ArithmeticOperatorMod
+)
+
+func (o ArithmeticOperator) String() string {
+	switch o {
+	case ArithmeticOperatorAdd:
+		return "add"
+	case ArithmeticOperatorSub:
+		return "sub"
+	case ArithmeticOperatorMul:
+		return "mul"
+	case ArithmeticOperatorDiv:
+		return "div"
+	case ArithmeticOperatorMod:
+		return "mod"
+	}
+	panic(fmt.Sprintf("unexpected ArithmeticOperator: %d", o))
+}
+
+// A LogicalOperation combines two boolean expressions with "and" or "or". It follows SQL's
+// three-valued logic, so "null and false" is false and "null or true" is true.
+type LogicalOperation struct {
+	Left     Expression
+	Operator LogicalOperator
+	Right    Expression
+}
+
+func NewLogicalOperation(left Expression, op LogicalOperator, right Expression) (*LogicalOperation, error) {
+	if left.Type() != types.TypeBoolean || right.Type() != types.TypeBoolean {
+		return nil, fmt.Errorf("invalid types for %s: %v, %v", op, left.Type(), right.Type())
+	}
+	return &LogicalOperation{
+		Left:     left,
+		Operator: op,
+		Right:    right,
+	}, nil
+}
+
+func (o *LogicalOperation) Type() types.Type {
+	return types.TypeBoolean
+}
+
+func (o LogicalOperation) Check(schema types.TableSchema) error {
+	if err := o.Left.Check(schema); err != nil {
+		return err
+	}
+	if err := o.Right.Check(schema); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (o *LogicalOperation) Nullable(schema types.TableSchema) bool {
+	return o.Left.Nullable(schema) || o.Right.Nullable(schema)
+}
+
+func (o *LogicalOperation) Evaluate(r *types.Row) (types.Value, error) {
+	left, right, err := evaluateOperands(r, o.Left, o.Right)
+	if err != nil {
+		return types.Value{}, err
+	}
+
+	// the value that decides the result regardless of the other operand
+	var dominant bool
+	switch o.Operator {
+	case LogicalOperatorAnd:
+		dominant = false
+	case LogicalOperatorOr:
+		dominant = true
+	default:
+		panic(fmt.Sprintf("unexpected LogicalOperator: %d", o.Operator))
+	}
+
+	switch {
+	case isBoolean(left, dominant) || isBoolean(right, dominant):
+		return types.NewValue(types.NewBoolean(dominant)), nil
+	case left.Null() || right.Null():
+		return types.NewNull(types.TypeBoolean), nil
+	}
+	return types.NewValue(types.NewBoolean(!dominant)), nil
+}
+
+func (o *LogicalOperation) String() string {
+	return fmt.Sprintf("LogicalOperation(%s %s %s)", o.Left, o.Operator, o.Right)
+}
+
+// isBoolean returns true if v is the non-null boolean b.
+func isBoolean(v types.Value, b bool) bool {
+	if v.Null() {
+		return false
+	}
+	return v.Value().(types.Boolean).Bool() == b
+}
+
+type LogicalOperator int
+
+const (
+	LogicalOperatorAnd LogicalOperator = iota
+	LogicalOperatorOr
+)
+
+func (o LogicalOperator) String() string {
+	switch o {
+	case LogicalOperatorAnd:
+		return "and"
+	case LogicalOperatorOr:
+		return "or"
+	}
+	panic(fmt.Sprintf("unexpected LogicalOperator: %d", o))
+}
+
 type UnaryOperation struct {
 	Operand  Expression
 	Operator UnaryOperator
@@ -170,6 +397,9 @@ func NewUnaryOperation(operand Expression, operator UnaryOperator) *UnaryOperati
 }
 
 func (o *UnaryOperation) Type() types.Type {
+	if o.Operator == UnaryOperatorMinus {
+		return types.TypeDecimal
+	}
 	return types.TypeBoolean
 }
 
@@ -177,21 +407,47 @@ func (o UnaryOperation) Check(schema types.TableSchema) error {
 	if err := o.Operand.Check(schema); err != nil {
 		return err
 	}
+	switch {
+	case o.Operator == UnaryOperatorNot && o.Operand.Type() != types.TypeBoolean,
+		o.Operator == UnaryOperatorMinus && o.Operand.Type() != types.TypeDecimal:
+		return fmt.Errorf("invalid type for %s: %v", o.Operator, o.Operand.Type())
+	}
 	return nil
 }
 
-func (o *UnaryOperation) Evaluate(r *types.Row) types.Value {
-	value := o.Operand.Evaluate(r)
+func (o *UnaryOperation) Nullable(schema types.TableSchema) bool {
+	switch o.Operator {
+	case UnaryOperatorIsNull, UnaryOperatorIsNotNull:
+		return false
+	}
+	return o.Operand.Nullable(schema)
+}
+
+func (o *UnaryOperation) Evaluate(r *types.Row) (types.Value, error) {
+	value, err := o.Operand.Evaluate(r)
+	if err != nil {
+		return types.Value{}, err
+	}
 	var result bool
 	switch o.Operator {
 	case UnaryOperatorIsNull:
 		result = value.Null()
 	case UnaryOperatorIsNotNull:
 		result = !value.Null()
+	case UnaryOperatorNot:
+		if value.Null() {
+			return value, nil
+		}
+		result = !value.IsTrue()
+	case UnaryOperatorMinus:
+		if value.Null() {
+			return value, nil
+		}
+		return types.NewValue(value.Value().(types.Decimal).Neg()), nil
 	default:
 		panic(fmt.Sprintf("unexpected UnaryOperator: %d", o.Operator))
 	}
-	return types.NewValue(types.NewBoolean(result))
+	return types.NewValue(types.NewBoolean(result)), nil
 }
 
 func (o *UnaryOperation) String() string {
@@ -204,6 +460,12 @@ const (
 	// comparison with null
 	UnaryOperatorIsNull UnaryOperator = iota
 	UnaryOperatorIsNotNull
+
+	// logical negation
+	UnaryOperatorNot
+
+	// arithmetic negation
+	UnaryOperatorMinus
 )
 
 func (o UnaryOperator) String() string {
@@ -212,6 +474,162 @@ func (o UnaryOperator) String() string {
 		return "isNull"
 	case UnaryOperatorIsNotNull:
 		return "isNotNull"
+	case UnaryOperatorNot:
+		return "not"
+	case UnaryOperatorMinus:
+		return "minus"
 	}
 	panic(fmt.Sprintf("unexpected UnaryOperator: %d", o))
 }
+
+// A Coalesce expression returns the first of its arguments that's not null, or null if they're all
+// null. All arguments must have the same type.
+type Coalesce struct {
+	Arguments []Expression
+}
+
+func NewCoalesce(arguments ...Expression) (*Coalesce, error) {
+	if len(arguments) == 0 {
+		return nil, fmt.Errorf("missing arguments for coalesce")
+	}
+	t := arguments[0].Type()
+	for _, a := range arguments[1:] {
+		if a.Type() != t {
+			return nil, fmt.Errorf("mismatched types for coalesce: %v and %v", t, a.Type())
+		}
+	}
+	return &Coalesce{Arguments: arguments}, nil
+}
+
+func (c *Coalesce) Type() types.Type {
+	return c.Arguments[0].Type()
+}
+
+func (c *Coalesce) Check(schema types.TableSchema) error {
+	for _, a := range c.Arguments {
+		if err := a.Check(schema); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Coalesce) Nullable(schema types.TableSchema) bool {
+	for _, a := range c.Arguments {
+		if !a.Nullable(schema) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Coalesce) Evaluate(r *types.Row) (types.Value, error) {
+	var value types.Value
+	for _, a := range c.Arguments {
+		var err error
+		value, err = a.Evaluate(r)
+		if err != nil {
+			return types.Value{}, err
+		}
+		if !value.Null() {
+			break
+		}
+	}
+	return value, nil
+}
+
+func (c *Coalesce) String() string {
+	list := make([]string, len(c.Arguments))
+	for i, a := range c.Arguments {
+		list[i] = a.String()
+	}
+	return fmt.Sprintf("Coalesce(%s)", strings.Join(list, ", "))
+}
+
+// An In expression checks if its operand is equal to any of the expressions in a list, which must
+// have the same type as the operand. Like a chain of comparisons joined by "or", it's null if the
+// operand is null, or if it isn't equal to any of the values in the list and one of them is null.
+type In struct {
+	Operand Expression
+	List    []Expression
+}
+
+func NewIn(operand Expression, list []Expression) (*In, error) {
+	if len(list) == 0 {
+		return nil, fmt.Errorf("empty list for in")
+	}
+	for _, e := range list {
+		if e.Type() != operand.Type() {
+			return nil, fmt.Errorf("incompatible types: %v, %v", operand.Type(), e.Type())
+		}
+	}
+	return &In{
+		Operand: operand,
+		List:    list,
+	}, nil
+}
+
+func (e *In) Type() types.Type {
+	return types.TypeBoolean
+}
+
+func (e *In) Check(schema types.TableSchema) error {
+	if err := e.Operand.Check(schema); err != nil {
+		return err
+	}
+	for _, x := range e.List {
+		if err := x.Check(schema); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *In) Nullable(schema types.TableSchema) bool {
+	if e.Operand.Nullable(schema) {
+		return true
+	}
+	for _, x := range e.List {
+		if x.Nullable(schema) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *In) Evaluate(r *types.Row) (types.Value, error) {
+	value, err := e.Operand.Evaluate(r)
+	if err != nil {
+		return types.Value{}, err
+	}
+	null := false
+	for _, x := range e.List {
+		y, err := x.Evaluate(r)
+		if err != nil {
+			return types.Value{}, err
+		}
+		switch value.Compare(y) {
+		case types.ComparedEq:
+			return types.NewValue(types.NewBoolean(true)), nil
+		case types.ComparedNull:
+			null = true
+		case types.ComparedInvalid:
+			return types.Value{}, RuntimeError{
+				Expression: e,
+				Err:        fmt.Errorf("cannot compare %v and %v", value.Type(), y.Type()),
+			}
+		}
+	}
+	if null {
+		return types.NewNull(types.TypeBoolean), nil
+	}
+	return types.NewValue(types.NewBoolean(false)), nil
+}
+
+func (e *In) String() string {
+	list := make([]string, len(e.List))
+	for i, x := range e.List {
+		list[i] = x.String()
+	}
+	return fmt.Sprintf("In(%s, %s)", e.Operand, strings.Join(list, ", "))
+}
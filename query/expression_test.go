@@ -1,6 +1,7 @@
 package query
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/lfritz/toydb/types"
@@ -21,6 +22,16 @@ func sampleSchema() types.TableSchema {
 	}
 }
 
+// mustEvaluate evaluates an expression and fails the test if it returns an error.
+func mustEvaluate(t *testing.T, e Expression, r *types.Row) types.Value {
+	t.Helper()
+	value, err := e.Evaluate(r)
+	if err != nil {
+		t.Fatalf("Evaluate returned error for %v: %v", e, err)
+	}
+	return value
+}
+
 func sampleRow() *types.Row {
 	return &types.Row{
 		Schema: sampleSchema(),
@@ -39,7 +50,7 @@ func TestConstantType(t *testing.T) {
 
 func TestConstantEvaluate(t *testing.T) {
 	value := types.Dec("123")
-	got := NewConstant(value).Evaluate(sampleRow())
+	got := mustEvaluate(t, NewConstant(value), sampleRow())
 	if got.Compare(value) != types.ComparedEq {
 		t.Errorf("Evaluate returned %v, want %v", got, value)
 	}
@@ -78,7 +89,7 @@ func TestColumnReferenceCheck(t *testing.T) {
 
 func TestColumnReferenceEvaluate(t *testing.T) {
 	c := NewColumnReference(1, types.TypeText)
-	got := c.Evaluate(sampleRow())
+	got := mustEvaluate(t, c, sampleRow())
 	want := types.Txt("hello")
 	if got.Compare(want) != types.ComparedEq {
 		t.Errorf("Evaluate returned %v, want %v", got, want)
@@ -116,13 +127,16 @@ func TestBinaryOperationEvaluate(t *testing.T) {
 		{"123", "456", BinaryOperatorLt, true},
 		{"123", "456", BinaryOperatorLe, true},
 		{"123", "456", BinaryOperatorGe, false},
+		{"123", "456", BinaryOperatorNe, true},
+		{"456", "123", BinaryOperatorNe, true},
+		{"123", "123", BinaryOperatorNe, false},
 	}
 
 	row := sampleRow()
 	for _, c := range cases {
 		expression := binaryOperation(t, c.left, c.right, c.op)
 		want := types.Boo(c.want)
-		got := expression.Evaluate(row)
+		got := mustEvaluate(t, expression, row)
 		if got.Compare(want) != types.ComparedEq {
 			t.Errorf("Evaluate returned %v, want %v", got, c.want)
 		}
@@ -137,6 +151,72 @@ func TestBinaryOperationEvaluate(t *testing.T) {
 	}
 }
 
+func TestBinaryOperationEvaluateNull(t *testing.T) {
+	null := NewConstant(types.NewNull(types.TypeDecimal))
+	value := NewConstant(types.Dec("123"))
+	operators := []BinaryOperator{
+		BinaryOperatorEq, BinaryOperatorNe,
+		BinaryOperatorLt, BinaryOperatorGt,
+		BinaryOperatorLe, BinaryOperatorGe,
+	}
+	operands := [][2]Expression{
+		{null, value},
+		{value, null},
+		{null, null},
+	}
+
+	row := sampleRow()
+	want := types.NewNull(types.TypeBoolean)
+	for _, op := range operators {
+		for _, o := range operands {
+			expression, err := NewBinaryOperation(o[0], op, o[1])
+			if err != nil {
+				t.Fatalf("NewBinaryOperation returned error: %v", err)
+			}
+			got := mustEvaluate(t, expression, row)
+			if got != want {
+				t.Errorf("%v returned %v, want %v", expression, got, want)
+			}
+		}
+	}
+}
+
+func TestNullable(t *testing.T) {
+	schema := types.TableSchema{
+		Columns: []types.ColumnSchema{
+			types.ColumnSchema{Name: "a", Type: types.TypeDecimal, Null: false},
+			types.ColumnSchema{Name: "b", Type: types.TypeDecimal, Null: true},
+		},
+	}
+	a := NewColumnReference(0, types.TypeDecimal)
+	b := NewColumnReference(1, types.TypeDecimal)
+	aEqA := &BinaryOperation{a, BinaryOperatorEq, a}
+	aEqB := &BinaryOperation{a, BinaryOperatorEq, b}
+
+	cases := []struct {
+		e    Expression
+		want bool
+	}{
+		{NewConstant(types.Dec("1")), false},
+		{NewConstant(types.NewNull(types.TypeDecimal)), true},
+		{a, false},
+		{b, true},
+		{aEqA, false},
+		{aEqB, true},
+		{&LogicalOperation{aEqA, LogicalOperatorOr, aEqB}, true},
+		{NewUnaryOperation(aEqB, UnaryOperatorNot), true},
+		{NewUnaryOperation(b, UnaryOperatorIsNull), false},
+		{&Coalesce{[]Expression{b, a}}, false},
+		{&Coalesce{[]Expression{b, b}}, true},
+	}
+	for _, c := range cases {
+		got := c.e.Nullable(schema)
+		if got != c.want {
+			t.Errorf("%v.Nullable returned %v, want %v", c.e, got, c.want)
+		}
+	}
+}
+
 func TestUnaryOperationType(t *testing.T) {
 	expression := NewUnaryOperation(NewConstant(types.Dec("123")), UnaryOperatorIsNull)
 	want := types.TypeBoolean
@@ -162,13 +242,222 @@ func TestUnaryOperationEvaluate(t *testing.T) {
 	for _, c := range cases {
 		expression := NewUnaryOperation(c.operand, c.operator)
 		want := types.Boo(c.want)
-		got := expression.Evaluate(row)
+		got := mustEvaluate(t, expression, row)
 		if got.Compare(want) != types.ComparedEq {
 			t.Errorf("Evaluate returned %v, want %v", got, c.want)
 		}
 	}
 }
 
+func TestArithmeticOperationEvaluate(t *testing.T) {
+	null := types.NewNull(types.TypeDecimal)
+	cases := []struct {
+		left  types.Value
+		op    ArithmeticOperator
+		right types.Value
+		want  types.Value
+	}{
+		{types.Dec("1.5"), ArithmeticOperatorAdd, types.Dec("2"), types.Dec("3.5")},
+		{types.Dec("1.5"), ArithmeticOperatorSub, types.Dec("2"), types.Dec("-0.5")},
+		{types.Dec("1.5"), ArithmeticOperatorMul, types.Dec("2"), types.Dec("3")},
+		{types.Dec("1.5"), ArithmeticOperatorDiv, types.Dec("2"), types.Dec("0.75")},
+		{types.Dec("1.5"), ArithmeticOperatorMod, types.Dec("2"), types.Dec("1.5")},
+		{null, ArithmeticOperatorAdd, types.Dec("2"), null},
+		{types.Dec("1.5"), ArithmeticOperatorDiv, null, null},
+	}
+
+	row := sampleRow()
+	for _, c := range cases {
+		expression, err := NewArithmeticOperation(NewConstant(c.left), c.op, NewConstant(c.right))
+		if err != nil {
+			t.Fatalf("NewArithmeticOperation returned error: %v", err)
+		}
+		if expression.Type() != types.TypeDecimal {
+			t.Errorf("expression.Type() == %v, want %v", expression.Type(), types.TypeDecimal)
+		}
+		got := mustEvaluate(t, expression, row)
+		if got.Compare(c.want) != types.ComparedEq && !(got.Null() && c.want.Null()) {
+			t.Errorf("%v %v %v returned %v, want %v", c.left, c.op, c.right, got, c.want)
+		}
+	}
+
+	left := NewConstant(types.Dec("133"))
+	right := NewConstant(types.Txt("hello"))
+	op := ArithmeticOperatorAdd
+	_, err := NewArithmeticOperation(left, op, right)
+	if err == nil {
+		t.Errorf("NewArithmeticOperation(%v, %v, %v) did not return error", left, op, right)
+	}
+}
+
+func TestUnaryOperationMinus(t *testing.T) {
+	expression := NewUnaryOperation(NewConstant(types.Dec("1.5")), UnaryOperatorMinus)
+	if expression.Type() != types.TypeDecimal {
+		t.Errorf("expression.Type() == %v, want %v", expression.Type(), types.TypeDecimal)
+	}
+	got := mustEvaluate(t, expression, sampleRow())
+	want := types.Dec("-1.5")
+	if got.Compare(want) != types.ComparedEq {
+		t.Errorf("Evaluate returned %v, want %v", got, want)
+	}
+
+	expression = NewUnaryOperation(NewColumnReference(1, types.TypeText), UnaryOperatorMinus)
+	if err := expression.Check(sampleSchema()); err == nil {
+		t.Errorf("Check did not return error for %v", expression)
+	}
+}
+
+func TestLogicalOperationEvaluate(t *testing.T) {
+	null := types.NewNull(types.TypeBoolean)
+	f := types.Boo(false)
+	tr := types.Boo(true)
+	cases := []struct {
+		left  types.Value
+		op    LogicalOperator
+		right types.Value
+		want  types.Value
+	}{
+		{tr, LogicalOperatorAnd, tr, tr},
+		{tr, LogicalOperatorAnd, f, f},
+		{f, LogicalOperatorAnd, tr, f},
+		{f, LogicalOperatorAnd, f, f},
+		{null, LogicalOperatorAnd, tr, null},
+		{null, LogicalOperatorAnd, f, f},
+		{f, LogicalOperatorAnd, null, f},
+		{null, LogicalOperatorAnd, null, null},
+
+		{tr, LogicalOperatorOr, tr, tr},
+		{tr, LogicalOperatorOr, f, tr},
+		{f, LogicalOperatorOr, tr, tr},
+		{f, LogicalOperatorOr, f, f},
+		{null, LogicalOperatorOr, tr, tr},
+		{tr, LogicalOperatorOr, null, tr},
+		{null, LogicalOperatorOr, f, null},
+		{null, LogicalOperatorOr, null, null},
+	}
+
+	row := sampleRow()
+	for _, c := range cases {
+		expression, err := NewLogicalOperation(NewConstant(c.left), c.op, NewConstant(c.right))
+		if err != nil {
+			t.Fatalf("NewLogicalOperation returned error: %v", err)
+		}
+		got := mustEvaluate(t, expression, row)
+		if got != c.want {
+			t.Errorf("%v %v %v returned %v, want %v", c.left, c.op, c.right, got, c.want)
+		}
+	}
+
+	left := NewConstant(types.Dec("133"))
+	right := NewConstant(types.Boo(false))
+	op := LogicalOperatorAnd
+	_, err := NewLogicalOperation(left, op, right)
+	if err == nil {
+		t.Errorf("NewLogicalOperation(%v, %v, %v) did not return error", left, op, right)
+	}
+}
+
+func TestUnaryOperationNot(t *testing.T) {
+	cases := []struct {
+		operand, want types.Value
+	}{
+		{types.Boo(true), types.Boo(false)},
+		{types.Boo(false), types.Boo(true)},
+		{types.NewNull(types.TypeBoolean), types.NewNull(types.TypeBoolean)},
+	}
+
+	row := sampleRow()
+	for _, c := range cases {
+		got := mustEvaluate(t, NewUnaryOperation(NewConstant(c.operand), UnaryOperatorNot), row)
+		if got != c.want {
+			t.Errorf("not %v returned %v, want %v", c.operand, got, c.want)
+		}
+	}
+
+	expression := NewUnaryOperation(NewColumnReference(1, types.TypeText), UnaryOperatorNot)
+	if err := expression.Check(sampleSchema()); err == nil {
+		t.Errorf("Check did not return error for %v", expression)
+	}
+}
+
+func TestCoalesceEvaluate(t *testing.T) {
+	schema := types.TableSchema{
+		Columns: []types.ColumnSchema{
+			types.ColumnSchema{Name: "a", Type: types.TypeText, Null: true},
+			types.ColumnSchema{Name: "b", Type: types.TypeText, Null: true},
+		},
+	}
+	a := NewColumnReference(0, types.TypeText)
+	b := NewColumnReference(1, types.TypeText)
+	null := types.NewNull(types.TypeText)
+	c, err := NewCoalesce(a, b)
+	if err != nil {
+		t.Fatalf("NewCoalesce returned error: %v", err)
+	}
+
+	cases := []struct {
+		values []types.Value
+		want   types.Value
+	}{
+		{[]types.Value{types.Txt("x"), types.Txt("y")}, types.Txt("x")},
+		{[]types.Value{null, types.Txt("y")}, types.Txt("y")},
+		{[]types.Value{types.Txt("x"), null}, types.Txt("x")},
+		{[]types.Value{null, null}, null},
+	}
+	for _, tc := range cases {
+		got := mustEvaluate(t, c, &types.Row{Schema: schema, Values: tc.values})
+		if got != tc.want {
+			t.Errorf("%v.Evaluate(%v) returned %v, want %v", c, tc.values, got, tc.want)
+		}
+	}
+
+	_, err = NewCoalesce(a, NewConstant(types.Dec("1")))
+	if err == nil {
+		t.Errorf("NewCoalesce did not return error for mismatched types")
+	}
+}
+
+func TestInEvaluate(t *testing.T) {
+	schema := types.TableSchema{
+		Columns: []types.ColumnSchema{
+			types.ColumnSchema{Name: "a", Type: types.TypeDecimal, Null: true},
+			types.ColumnSchema{Name: "b", Type: types.TypeDecimal, Null: true},
+		},
+	}
+	a := NewColumnReference(0, types.TypeDecimal)
+	b := NewColumnReference(1, types.TypeDecimal)
+	null := types.NewNull(types.TypeDecimal)
+	in, err := NewIn(a, []Expression{NewConstant(types.Dec("1")), b})
+	if err != nil {
+		t.Fatalf("NewIn returned error: %v", err)
+	}
+
+	cases := []struct {
+		values []types.Value
+		want   types.Value
+	}{
+		{[]types.Value{types.Dec("1"), types.Dec("2")}, types.Boo(true)},
+		{[]types.Value{types.Dec("2.0"), types.Dec("2")}, types.Boo(true)},
+		{[]types.Value{types.Dec("3"), types.Dec("2")}, types.Boo(false)},
+		{[]types.Value{types.Dec("1"), null}, types.Boo(true)},
+		{[]types.Value{types.Dec("3"), null}, types.NewNull(types.TypeBoolean)},
+		{[]types.Value{null, types.Dec("2")}, types.NewNull(types.TypeBoolean)},
+	}
+	for _, tc := range cases {
+		got := mustEvaluate(t, in, &types.Row{Schema: schema, Values: tc.values})
+		if got != tc.want {
+			t.Errorf("%v.Evaluate(%v) returned %v, want %v", in, tc.values, got, tc.want)
+		}
+	}
+
+	if _, err := NewIn(a, []Expression{NewConstant(types.Txt("1"))}); err == nil {
+		t.Errorf("NewIn did not return error for mismatched types")
+	}
+	if _, err := NewIn(a, nil); err == nil {
+		t.Errorf("NewIn did not return error for empty list")
+	}
+}
+
 func TestExpressionString(t *testing.T) {
 	constant := NewConstant(types.Dec("123"))
 	columnReference := NewColumnReference(1, types.TypeDecimal)
@@ -191,3 +480,50 @@ func TestExpressionString(t *testing.T) {
 		}
 	}
 }
+
+func TestEvaluateErrors(t *testing.T) {
+	divide, err := NewArithmeticOperation(
+		NewConstant(types.Dec("1")),
+		ArithmeticOperatorDiv,
+		NewConstant(types.Dec("0")),
+	)
+	if err != nil {
+		t.Fatalf("NewArithmeticOperation returned error: %v", err)
+	}
+	compare, err := NewBinaryOperation(divide, BinaryOperatorEq, NewConstant(types.Dec("1")))
+	if err != nil {
+		t.Fatalf("NewBinaryOperation returned error: %v", err)
+	}
+	invalid := &BinaryOperation{
+		Left:     NewConstant(types.Dec("1")),
+		Operator: BinaryOperatorLt,
+		Right:    NewConstant(types.Txt("a")),
+	}
+
+	cases := []struct {
+		expression Expression
+		failing    Expression
+	}{
+		{divide, divide},
+		{compare, divide},
+		{NewUnaryOperation(divide, UnaryOperatorMinus), divide},
+		{invalid, invalid},
+	}
+	for _, c := range cases {
+		_, err := c.expression.Evaluate(sampleRow())
+		var runtimeError RuntimeError
+		if !errors.As(err, &runtimeError) {
+			t.Errorf("Evaluate for %v returned %v, want RuntimeError", c.expression, err)
+			continue
+		}
+		if runtimeError.Expression != c.failing {
+			t.Errorf("Evaluate for %v returned error for %v, want %v",
+				c.expression, runtimeError.Expression, c.failing)
+		}
+	}
+
+	_, err = divide.Evaluate(sampleRow())
+	if !errors.Is(err, types.ErrDivisionByZero) {
+		t.Errorf("Evaluate returned %v, want %v", err, types.ErrDivisionByZero)
+	}
+}
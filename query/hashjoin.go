@@ -0,0 +1,289 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"github.com/lfritz/toydb/storage"
+	"github.com/lfritz/toydb/types"
+)
+
+// A HashJoin step joins two inputs on equality of key expressions. The LeftKeys are evaluated on
+// rows of the left input, the RightKeys on rows of the right input, and two rows match if all their
+// keys are equal. As with the "=" operator, a null key doesn't match anything.
+//
+// It builds a hash table on one input and probes it with the other. The output has the same rows in
+// the same order as a Join with the equivalent condition.
+type HashJoin struct {
+	Type                JoinType
+	Left, Right         Plan
+	LeftKeys, RightKeys []Expression
+	combinedSchema      types.TableSchema
+}
+
+func NewHashJoin(t JoinType, left, right Plan, leftKeys, rightKeys []Expression) (*HashJoin, error) {
+	if err := checkJoinKeys(left, right, leftKeys, rightKeys); err != nil {
+		return nil, err
+	}
+	return &HashJoin{
+		Type:           t,
+		Left:           left,
+		Right:          right,
+		LeftKeys:       leftKeys,
+		RightKeys:      rightKeys,
+		combinedSchema: CombineSchemas(left.Schema(), right.Schema(), t),
+	}, nil
+}
+
+func (j *HashJoin) Schema() types.TableSchema {
+	return j.combinedSchema
+}
+
+func (j *HashJoin) Run(ctx context.Context, db *storage.Database) (*types.Relation, error) {
+	return run(ctx, j, db)
+}
+
+// Open builds a hash table on the right input and probes it with the rows of the left input as
+// they're read. For right outer joins, it builds on the left input and probes with the right input
+// instead, so the output is in the order of the right input.
+//
+// Workers of a Gather step share the hash table and build it together: each of them reads a
+// partition of the build input, if it's Parallelizable, and builds a part of the table from it.
+func (j *HashJoin) Open(ctx context.Context, db *storage.Database) (Iterator, error) {
+	build, buildKeys := j.Right, j.RightKeys
+	probeSchema, probeKeys := j.Left.Schema(), j.LeftKeys
+	if j.Type == JoinTypeRightOuter {
+		build, buildKeys = j.Left, j.LeftKeys
+		probeSchema, probeKeys = j.Right.Schema(), j.RightKeys
+	}
+	memory := newReservation(ctx, "HashJoin")
+	_, workers := partition(ctx)
+	result, err := shared(ctx, j, memory, func(ctx context.Context, memory *reservation) (interface{}, error) {
+		return buildHashTable(ctx, db, build, buildKeys, memory, workers)
+	})
+	if err != nil {
+		memory.release()
+		return nil, err
+	}
+	table := result.(*hashTable)
+	candidates := func(values []types.Value) ([]int, error) {
+		key, err := joinKey(probeSchema, values, probeKeys)
+		if err != nil {
+			return nil, err
+		}
+		return table.lookup(key), nil
+	}
+	return newJoinIterator(ctx, db, memory, j.Type, j.Left, j.Right, j.Schema(), nil, table.rows, candidates)
+}
+
+// checkJoinKeys checks that the keys for an equi-join are valid for the schemas of the inputs and
+// that each pair of keys has the same type.
+func checkJoinKeys(left, right Plan, leftKeys, rightKeys []Expression) error {
+	if len(leftKeys) == 0 || len(leftKeys) != len(rightKeys) {
+		return fmt.Errorf("invalid join keys: %d left, %d right", len(leftKeys), len(rightKeys))
+	}
+	for i := range leftKeys {
+		if err := leftKeys[i].Check(left.Schema()); err != nil {
+			return err
+		}
+		if err := rightKeys[i].Check(right.Schema()); err != nil {
+			return err
+		}
+		if leftKeys[i].Type() != rightKeys[i].Type() {
+			return fmt.Errorf("mismatched types for join key %d: %v and %v",
+				i, leftKeys[i].Type(), rightKeys[i].Type())
+		}
+	}
+	return nil
+}
+
+// A hashTable holds the rows of a hash join's build input and maps join keys to the indexes of the
+// rows with that key. It's split into parts, each built from a range of the rows: part i maps keys
+// to indexes relative to offsets[i]. Rows with a null key are left out because they can't match
+// anything.
+type hashTable struct {
+	rows    [][]types.Value
+	parts   []map[string][]int
+	offsets []int
+}
+
+// lookup returns the indexes of the rows with the given key, in order.
+func (t *hashTable) lookup(key string) []int {
+	if len(t.parts) == 1 {
+		return t.parts[0][key]
+	}
+	var result []int
+	for i, part := range t.parts {
+		for _, index := range part[key] {
+			result = append(result, t.offsets[i]+index)
+		}
+	}
+	return result
+}
+
+// buildHashTable reads the build input and builds a hash table on it with the given number of
+// goroutines. If the input is Parallelizable, each goroutine reads a partition of it, as a worker of
+// a Gather step would, and builds a part of the table from those rows; otherwise, the input is read
+// first and each goroutine builds a part of the table from a range of its rows. The memory for the
+// rows and the table is reserved in memory.
+func buildHashTable(
+	ctx context.Context,
+	db *storage.Database,
+	build Plan,
+	keys []Expression,
+	memory *reservation,
+	workers int,
+) (*hashTable, error) {
+	var relation *types.Relation
+	if workers == 1 || !Parallelizable(build) {
+		var err error
+		relation, err = materialize(ctx, build, db, memory)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	state := &sharedState{
+		results: make(map[interface{}]*sharedResult),
+	}
+	defer state.release()
+	rows := make([][][]types.Value, workers)
+	parts := make([]map[string][]int, workers)
+	reservations := make([]*reservation, workers)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		reservations[w] = newReservation(ctx, memory.node)
+		go func(w int) {
+			defer wg.Done()
+			if relation != nil {
+				start, end := len(relation.Rows)*w/workers, len(relation.Rows)*(w+1)/workers
+				rows[w] = relation.Rows[start:end]
+			} else {
+				worker := &worker{index: w, count: workers, shared: state}
+				part, err := materialize(withWorker(ctx, worker), build, db, reservations[w])
+				if err != nil {
+					errs[w] = err
+					cancel()
+					return
+				}
+				rows[w] = part.Rows
+			}
+			parts[w], errs[w] = buildHashTablePart(ctx, build.Schema(), rows[w], keys, reservations[w])
+			if errs[w] != nil {
+				cancel()
+			}
+		}(w)
+	}
+	wg.Wait()
+	for _, r := range reservations {
+		memory.take(r)
+	}
+	for _, err := range errs {
+		if err != nil && err != context.Canceled {
+			return nil, err
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	table := &hashTable{
+		parts:   parts,
+		offsets: make([]int, workers),
+	}
+	offset := 0
+	for w := range rows {
+		table.offsets[w] = offset
+		offset += len(rows[w])
+	}
+	if relation != nil {
+		table.rows = relation.Rows
+	} else {
+		table.rows = make([][]types.Value, 0, offset)
+		for _, part := range rows {
+			table.rows = append(table.rows, part...)
+		}
+	}
+	return table, nil
+}
+
+// buildHashTablePart returns a map from join keys to the indexes of the rows with that key, in
+// order, reserving the memory for the keys and indexes in memory.
+func buildHashTablePart(
+	ctx context.Context,
+	schema types.TableSchema,
+	rows [][]types.Value,
+	keys []Expression,
+	memory *reservation,
+) (map[string][]int, error) {
+	canceler := newCanceler(ctx)
+	table := make(map[string][]int)
+	for i, values := range rows {
+		if err := canceler.check(); err != nil {
+			return nil, err
+		}
+		key, err := joinKey(schema, values, keys)
+		if err != nil {
+			return nil, err
+		}
+		if key == "" {
+			continue
+		}
+		size := int(unsafe.Sizeof(i))
+		if _, ok := table[key]; !ok {
+			size += len(key)
+		}
+		if err := memory.add(size); err != nil {
+			return nil, err
+		}
+		table[key] = append(table[key], i)
+	}
+	return table, nil
+}
+
+// joinKey evaluates the key expressions for a row and returns a string for use as a map key. It
+// returns the empty string if any of the keys is null.
+func joinKey(schema types.TableSchema, values []types.Value, keys []Expression) (string, error) {
+	row := &types.Row{
+		Schema: schema,
+		Values: values,
+	}
+	keyValues := make([]types.Value, len(keys))
+	for i, k := range keys {
+		var err error
+		keyValues[i], err = k.Evaluate(row)
+		if err != nil {
+			return "", err
+		}
+		if keyValues[i].Null() {
+			return "", nil
+		}
+	}
+	return types.RowKey(keyValues), nil
+}
+
+func (j *HashJoin) Print(printer *Printer) {
+	printer.Println("HashJoin {")
+	printer.Indent()
+	printer.Println("Type: %s", j.Type)
+	printer.Print("Left: ")
+	j.Left.Print(printer)
+	printer.Print("Right: ")
+	j.Right.Print(printer)
+	printer.Println("Keys:")
+	printer.Indent()
+	for i := range j.LeftKeys {
+		printer.Println("(%d) %s = %s", i, j.LeftKeys[i], j.RightKeys[i])
+	}
+	printer.Unindent()
+	printer.Unindent()
+	printer.Println("}")
+}
@@ -0,0 +1,222 @@
+package query
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/lfritz/toydb/storage"
+	"github.com/lfritz/toydb/types"
+)
+
+// joinTestData creates a database with two tables "a" and "b", each with a nullable key column "k",
+// a second key column "l", and a text column. The keys have duplicates and nulls on both sides and
+// some keys only appear on one side.
+func joinTestData(t *testing.T) *storage.Database {
+	t.Helper()
+	db := storage.NewDatabase()
+	for _, name := range []string{"a", "b"} {
+		table, err := db.CreateTable(name, types.TableSchema{
+			Columns: []types.ColumnSchema{
+				{"k", types.TypeDecimal, true},
+				{"l", types.TypeDecimal, false},
+				{"v", types.TypeText, false},
+			},
+		})
+		if err != nil {
+			t.Fatalf("CreateTable returned error: %v", err)
+		}
+		offset := 0
+		if name == "b" {
+			offset = 3
+		}
+		for i := 0; i < 20; i++ {
+			k := types.Dec(strconv.Itoa((i*7 + offset) % 9))
+			if i%6 == 0 {
+				k = types.NewNull(types.TypeDecimal)
+			}
+			l := types.Dec(strconv.Itoa(i % 2))
+			v := types.Txt(name + strconv.Itoa(i))
+			table.Rows = append(table.Rows, []types.Value{k, l, v})
+		}
+	}
+	return db
+}
+
+// checkJoinEquivalent checks that plan returns the same rows as the nested-loop join when reading at
+// most limit rows.
+func checkJoinEquivalent(t *testing.T, db *storage.Database, plan, nestedLoop Plan, limit int) {
+	t.Helper()
+	if got, want := plan.Schema(), nestedLoop.Schema(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Schema returned %v, want %v", got, want)
+	}
+	limitRows := func(p Plan) *types.Relation {
+		l, err := NewLimit(p, 0, limit)
+		if err != nil {
+			t.Fatalf("NewLimit returned error: %v", err)
+		}
+		return mustRun(t, l, db)
+	}
+	got := limitRows(plan)
+	want := limitRows(nestedLoop)
+	if len(want.Rows) == 0 {
+		t.Fatalf("nested-loop join returned no rows")
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Run returned\n%v\nwant\n%v", got, want)
+	}
+}
+
+// equiJoinCondition returns the condition "a.k = b.k" or "a.k = b.k and a.l = b.l".
+func equiJoinCondition(t *testing.T, keys int) Expression {
+	t.Helper()
+	var condition Expression
+	for i := 0; i < keys; i++ {
+		eq, err := NewBinaryOperation(
+			NewColumnReference(i, types.TypeDecimal),
+			BinaryOperatorEq,
+			NewColumnReference(3+i, types.TypeDecimal),
+		)
+		if err != nil {
+			t.Fatalf("NewBinaryOperation returned error: %v", err)
+		}
+		if condition == nil {
+			condition = eq
+			continue
+		}
+		condition, err = NewLogicalOperation(condition, LogicalOperatorAnd, eq)
+		if err != nil {
+			t.Fatalf("NewLogicalOperation returned error: %v", err)
+		}
+	}
+	return condition
+}
+
+func TestHashJoin(t *testing.T) {
+	db := joinTestData(t)
+	a, err := db.Table("a")
+	if err != nil {
+		t.Fatalf("Table returned error: %v", err)
+	}
+	b, err := db.Table("b")
+	if err != nil {
+		t.Fatalf("Table returned error: %v", err)
+	}
+
+	joinTypes := []JoinType{JoinTypeInner, JoinTypeLeftOuter, JoinTypeRightOuter, JoinTypeFullOuter}
+	for _, joinType := range joinTypes {
+		for keys := 1; keys <= 2; keys++ {
+			for _, limit := range []int{noLimit, 5} {
+				left := NewLoad("a", a.Schema)
+				right := NewLoad("b", b.Schema)
+				nestedLoop, err := NewJoin(joinType, left, right, equiJoinCondition(t, keys))
+				if err != nil {
+					t.Fatalf("NewJoin returned error: %v", err)
+				}
+				var leftKeys, rightKeys []Expression
+				for i := 0; i < keys; i++ {
+					leftKeys = append(leftKeys, NewColumnReference(i, types.TypeDecimal))
+					rightKeys = append(rightKeys, NewColumnReference(i, types.TypeDecimal))
+				}
+				hashJoin, err := NewHashJoin(joinType, left, right, leftKeys, rightKeys)
+				if err != nil {
+					t.Fatalf("NewHashJoin returned error: %v", err)
+				}
+				checkJoinEquivalent(t, db, hashJoin, nestedLoop, limit)
+			}
+		}
+	}
+}
+
+func TestNewHashJoinInvalid(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	films := NewLoad("films", sampleData.Films.Schema)
+	people := NewLoad("people", sampleData.People.Schema)
+	id := NewColumnReference(0, types.TypeDecimal)
+	name := NewColumnReference(1, types.TypeText)
+	director := NewColumnReference(3, types.TypeDecimal)
+
+	cases := []struct {
+		leftKeys, rightKeys []Expression
+	}{
+		{nil, nil},
+		{[]Expression{director}, nil},
+		{[]Expression{director}, []Expression{name}},
+		{[]Expression{director}, []Expression{director}},
+	}
+	for _, c := range cases {
+		_, err := NewHashJoin(JoinTypeInner, films, people, c.leftKeys, c.rightKeys)
+		if err == nil {
+			t.Errorf("NewHashJoin did not return error for keys %v and %v", c.leftKeys, c.rightKeys)
+		}
+	}
+
+	_, err := NewHashJoin(JoinTypeInner, films, people, []Expression{director}, []Expression{id})
+	if err != nil {
+		t.Errorf("NewHashJoin returned error: %v", err)
+	}
+}
+
+func TestBuildHashTable(t *testing.T) {
+	db, schema := numbersTable(t, 1000)
+	n := NewColumnReference(0, types.TypeDecimal)
+	mod10, err := NewArithmeticOperation(n, ArithmeticOperatorMod, NewConstant(types.Dec("10")))
+	if err != nil {
+		t.Fatalf("NewArithmeticOperation returned error: %v", err)
+	}
+	keys := []Expression{mod10}
+	load := NewLoad("numbers", schema)
+	sorted, err := NewSort(load, []SortKey{{Expression: n, Descending: true}})
+	if err != nil {
+		t.Fatalf("NewSort returned error: %v", err)
+	}
+
+	for _, build := range []Plan{load, sorted} {
+		want := mustRun(t, build, db)
+		for _, workers := range []int{1, 3, 8} {
+			accountant := NewMemoryAccountant(0)
+			ctx := WithMemoryAccountant(context.Background(), accountant)
+			memory := newReservation(ctx, "HashJoin")
+			table, err := buildHashTable(ctx, db, build, keys, memory, workers)
+			if err != nil {
+				t.Fatalf("buildHashTable returned error: %v", err)
+			}
+			if !reflect.DeepEqual(table.rows, want.Rows) {
+				t.Errorf("buildHashTable on %s step with %d workers returned rows in the wrong order",
+					nodeName(build), workers)
+			}
+			if len(table.parts) != workers {
+				t.Errorf("buildHashTable with %d workers returned %d parts", workers, len(table.parts))
+			}
+			for i, part := range table.parts {
+				if len(part) == 0 {
+					t.Errorf("buildHashTable with %d workers returned empty part %d", workers, i)
+				}
+			}
+
+			key, err := joinKey(schema, []types.Value{types.Dec("3")}, keys)
+			if err != nil {
+				t.Fatalf("joinKey returned error: %v", err)
+			}
+			var wantIndexes []int
+			for i, row := range want.Rows {
+				if k, _ := joinKey(schema, row, keys); k == key {
+					wantIndexes = append(wantIndexes, i)
+				}
+			}
+			if got := table.lookup(key); !reflect.DeepEqual(got, wantIndexes) {
+				t.Errorf("lookup on %s step with %d workers returned %v, want %v",
+					nodeName(build), workers, got, wantIndexes)
+			}
+
+			if memory.bytes == 0 || accountant.Used() != memory.bytes {
+				t.Errorf("buildHashTable reserved %d bytes, accountant has %d", memory.bytes, accountant.Used())
+			}
+			memory.release()
+			if got := accountant.Used(); got != 0 {
+				t.Errorf("after release, accountant has %d bytes", got)
+			}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/lfritz/toydb/storage"
+	"github.com/lfritz/toydb/types"
+)
+
+// An IndexScan step loads the rows of a table whose keys in an index are in a range, in the order of
+// the index. Like for Load, the columns in its schema are prefixed with the alias.
+type IndexScan struct {
+	TableName   string
+	Alias       string
+	IndexName   string
+	Range       storage.KeyRange
+	TableSchema types.TableSchema
+}
+
+// NewIndexScan creates an IndexScan step that reads the rows in a range of an index instead of all
+// rows of the table loaded by a Load step.
+func NewIndexScan(load *Load, index string, r storage.KeyRange) *IndexScan {
+	return &IndexScan{
+		TableName:   load.TableName,
+		Alias:       load.Alias,
+		IndexName:   index,
+		Range:       r,
+		TableSchema: load.TableSchema,
+	}
+}
+
+func (s *IndexScan) Schema() types.TableSchema {
+	return s.TableSchema
+}
+
+func (s *IndexScan) Run(ctx context.Context, db *storage.Database) (*types.Relation, error) {
+	return run(ctx, s, db)
+}
+
+func (s *IndexScan) Open(ctx context.Context, db *storage.Database) (Iterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	scan, err := db.IndexScan(s.IndexName, s.Range)
+	if err != nil {
+		return nil, RuntimeError{Err: fmt.Errorf("error loading table %s: %w", s.TableName, err)}
+	}
+	return &scanIterator{
+		scan:      scan,
+		tableName: s.TableName,
+		canceler:  newCanceler(ctx),
+	}, nil
+}
+
+func (s *IndexScan) Print(printer *Printer) {
+	printer.Println("IndexScan {")
+	printer.Indent()
+	printer.Println("Table: %q", s.TableName)
+	if s.Alias != s.TableName {
+		printer.Println("Alias: %q", s.Alias)
+	}
+	printer.Println("Index: %q", s.IndexName)
+	printer.Println("Range: %s", s.Range)
+	printer.Println("Schema: %s", s.TableSchema)
+	printer.Unindent()
+	printer.Println("}")
+}
+
+// A HashIndexScan step loads the rows of a table whose keys in a hash index are any of a list of
+// keys. Like for Load, the columns in its schema are prefixed with the alias.
+type HashIndexScan struct {
+	TableName   string
+	Alias       string
+	IndexName   string
+	Keys        [][]types.Value
+	TableSchema types.TableSchema
+}
+
+// NewHashIndexScan creates a HashIndexScan step that looks up keys in a hash index instead of
+// reading all rows of the table loaded by a Load step.
+func NewHashIndexScan(load *Load, index string, keys [][]types.Value) *HashIndexScan {
+	return &HashIndexScan{
+		TableName:   load.TableName,
+		Alias:       load.Alias,
+		IndexName:   index,
+		Keys:        keys,
+		TableSchema: load.TableSchema,
+	}
+}
+
+func (s *HashIndexScan) Schema() types.TableSchema {
+	return s.TableSchema
+}
+
+func (s *HashIndexScan) Run(ctx context.Context, db *storage.Database) (*types.Relation, error) {
+	return run(ctx, s, db)
+}
+
+func (s *HashIndexScan) Open(ctx context.Context, db *storage.Database) (Iterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	scan, err := db.HashIndexScan(s.IndexName, s.Keys)
+	if err != nil {
+		return nil, RuntimeError{Err: fmt.Errorf("error loading table %s: %w", s.TableName, err)}
+	}
+	return &scanIterator{
+		scan:      scan,
+		tableName: s.TableName,
+		canceler:  newCanceler(ctx),
+	}, nil
+}
+
+func (s *HashIndexScan) Print(printer *Printer) {
+	keys := make([]string, len(s.Keys))
+	for i, key := range s.Keys {
+		values := make([]string, len(key))
+		for j, v := range key {
+			values[j] = v.String()
+		}
+		keys[i] = "(" + strings.Join(values, ", ") + ")"
+	}
+	printer.Println("HashIndexScan {")
+	printer.Indent()
+	printer.Println("Table: %q", s.TableName)
+	if s.Alias != s.TableName {
+		printer.Println("Alias: %q", s.Alias)
+	}
+	printer.Println("Index: %q", s.IndexName)
+	printer.Println("Keys: %s", strings.Join(keys, ", "))
+	printer.Println("Schema: %s", s.TableSchema)
+	printer.Unindent()
+	printer.Println("}")
+}
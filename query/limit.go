@@ -0,0 +1,101 @@
+package query
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lfritz/toydb/storage"
+	"github.com/lfritz/toydb/types"
+)
+
+// noLimit is the Count of a Limit step that returns all remaining rows.
+const noLimit = -1
+
+// A Limit step skips the first Offset rows and returns at most Count of the remaining rows. If Count
+// is negative, it returns all remaining rows.
+type Limit struct {
+	From   Plan
+	Offset int
+	Count  int
+}
+
+func NewLimit(from Plan, offset, count int) (*Limit, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("invalid offset: %d", offset)
+	}
+	if count < 0 {
+		count = noLimit
+	}
+	return &Limit{
+		From:   from,
+		Offset: offset,
+		Count:  count,
+	}, nil
+}
+
+func (l *Limit) Schema() types.TableSchema {
+	return l.From.Schema()
+}
+
+func (l *Limit) Run(ctx context.Context, db *storage.Database) (*types.Relation, error) {
+	return run(ctx, l, db)
+}
+
+// Open returns an iterator that stops reading its input once it has returned Count rows, so rows
+// that would be discarded anyway are never computed.
+func (l *Limit) Open(ctx context.Context, db *storage.Database) (Iterator, error) {
+	from, err := l.From.Open(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	return &limitIterator{
+		from:   from,
+		offset: l.Offset,
+		count:  l.Count,
+	}, nil
+}
+
+type limitIterator struct {
+	from     Iterator
+	offset   int
+	count    int
+	returned int
+}
+
+func (it *limitIterator) Schema() types.TableSchema {
+	return it.from.Schema()
+}
+
+func (it *limitIterator) Next() ([]types.Value, bool, error) {
+	if it.count != noLimit && it.returned >= it.count {
+		return nil, false, nil
+	}
+	for ; it.offset > 0; it.offset-- {
+		if _, ok, err := it.from.Next(); !ok || err != nil {
+			return nil, false, err
+		}
+	}
+	row, ok, err := it.from.Next()
+	if !ok || err != nil {
+		return nil, false, err
+	}
+	it.returned++
+	return row, true, nil
+}
+
+func (it *limitIterator) Close() {
+	it.from.Close()
+}
+
+func (l *Limit) Print(printer *Printer) {
+	printer.Println("Limit {")
+	printer.Indent()
+	printer.Print("From: ")
+	l.From.Print(printer)
+	printer.Println("Offset: %d", l.Offset)
+	if l.Count != noLimit {
+		printer.Println("Count: %d", l.Count)
+	}
+	printer.Unindent()
+	printer.Println("}")
+}
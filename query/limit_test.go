@@ -0,0 +1,113 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lfritz/toydb/storage"
+	"github.com/lfritz/toydb/types"
+)
+
+// countingExpression wraps an expression and counts how often it's evaluated.
+type countingExpression struct {
+	Expression
+	count int
+}
+
+func (e *countingExpression) Evaluate(r *types.Row) (types.Value, error) {
+	e.count++
+	return e.Expression.Evaluate(r)
+}
+
+func TestLimit(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	films := sampleData.Films.Rows
+
+	cases := []struct {
+		offset, count int
+		want          [][]types.Value
+	}{
+		{0, -1, films},
+		{0, 0, nil},
+		{0, 2, films[:2]},
+		{1, 1, films[1:2]},
+		{1, -1, films[1:]},
+		{2, 5, films[2:]},
+		{3, 1, nil},
+		{5, -1, nil},
+	}
+	for _, c := range cases {
+		l, err := NewLimit(NewLoad("films", sampleData.Films.Schema), c.offset, c.count)
+		if err != nil {
+			t.Fatalf("NewLimit returned error: %v", err)
+		}
+		got := mustRun(t, l, sampleData.Database)
+		if len(got.Rows) != len(c.want) || (len(c.want) > 0 && !reflect.DeepEqual(got.Rows, c.want)) {
+			t.Errorf("Limit(offset %d, count %d) returned %v, want %v", c.offset, c.count, got.Rows, c.want)
+		}
+	}
+
+	_, err := NewLimit(NewLoad("films", sampleData.Films.Schema), -1, 1)
+	if err == nil {
+		t.Errorf("NewLimit did not return error for negative offset")
+	}
+}
+
+func TestLimitStopsEarly(t *testing.T) {
+	sampleData := storage.GetSampleData()
+
+	condition := &countingExpression{Expression: NewConstant(types.Boo(true))}
+	s, err := NewSelect(NewLoad("films", sampleData.Films.Schema), condition)
+	if err != nil {
+		t.Fatalf("NewSelect returned error: %v", err)
+	}
+	p, err := NewProject(s, []OutputColumn{SimpleColumn("films.name", 1, types.TypeText)})
+	if err != nil {
+		t.Fatalf("NewProject returned error: %v", err)
+	}
+	l, err := NewLimit(p, 1, 1)
+	if err != nil {
+		t.Fatalf("NewLimit returned error: %v", err)
+	}
+
+	got := mustRun(t, l, sampleData.Database)
+	want := [][]types.Value{{types.Txt("The Kid")}}
+	if !reflect.DeepEqual(got.Rows, want) {
+		t.Errorf("Run returned %v, want %v", got.Rows, want)
+	}
+	if condition.count != 2 {
+		t.Errorf("condition was evaluated %d times, want 2", condition.count)
+	}
+
+	comparison, err := NewBinaryOperation(
+		NewColumnReference(3, types.TypeDecimal),
+		BinaryOperatorEq,
+		NewColumnReference(4, types.TypeDecimal),
+	)
+	if err != nil {
+		t.Fatalf("NewBinaryOperation returned error: %v", err)
+	}
+	condition = &countingExpression{Expression: comparison}
+	join, err := NewJoin(
+		JoinTypeInner,
+		NewLoad("films", sampleData.Films.Schema),
+		NewLoad("people", sampleData.People.Schema),
+		condition,
+	)
+	if err != nil {
+		t.Fatalf("NewJoin returned error: %v", err)
+	}
+	l, err = NewLimit(join, 0, 1)
+	if err != nil {
+		t.Fatalf("NewLimit returned error: %v", err)
+	}
+
+	got = mustRun(t, l, sampleData.Database)
+	if len(got.Rows) != 1 {
+		t.Errorf("Run returned %d rows, want 1", len(got.Rows))
+	}
+	// the first film matches the first person, so no other pairs need to be checked
+	if condition.count != 1 {
+		t.Errorf("join condition was evaluated %d times, want 1", condition.count)
+	}
+}
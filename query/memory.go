@@ -0,0 +1,94 @@
+package query
+
+import (
+	"context"
+	"sync"
+
+	"github.com/lfritz/toydb/types"
+)
+
+// A MemoryAccountant tracks an estimate of the memory used by the rows that the steps of a query
+// hold, such as the input of a Sort or the inner input of a Join, and enforces a limit on it. Rows
+// that merely stream through a step aren't counted. It's safe for concurrent use by the workers of a
+// Gather step.
+type MemoryAccountant struct {
+	mu    sync.Mutex
+	limit int
+	used  int
+}
+
+// NewMemoryAccountant returns an accountant with a limit in bytes. If the limit is zero or
+// negative, memory use is tracked but not limited.
+func NewMemoryAccountant(limit int) *MemoryAccountant {
+	return &MemoryAccountant{limit: limit}
+}
+
+// Used returns the number of bytes currently held by the steps of the query.
+func (a *MemoryAccountant) Used() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.used
+}
+
+type memoryAccountantKey struct{}
+
+// WithMemoryAccountant returns a context that makes queries run with it account for their memory
+// use with a.
+func WithMemoryAccountant(ctx context.Context, a *MemoryAccountant) context.Context {
+	return context.WithValue(ctx, memoryAccountantKey{}, a)
+}
+
+// A reservation is the memory held by one step of a query. Without a memory accountant, it does
+// nothing.
+type reservation struct {
+	accountant *MemoryAccountant
+	node       string
+	bytes      int
+}
+
+// newReservation returns an empty reservation for the step called node.
+func newReservation(ctx context.Context, node string) *reservation {
+	a, _ := ctx.Value(memoryAccountantKey{}).(*MemoryAccountant)
+	return &reservation{
+		accountant: a,
+		node:       node,
+	}
+}
+
+// add reserves memory, returning a MemoryLimitError if that would exceed the limit.
+func (r *reservation) add(bytes int) error {
+	a := r.accountant
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.limit > 0 && a.used+bytes > a.limit {
+		return MemoryLimitError{Node: r.node, Limit: a.limit}
+	}
+	a.used += bytes
+	r.bytes += bytes
+	return nil
+}
+
+// addRow reserves memory for a row.
+func (r *reservation) addRow(row []types.Value) error {
+	return r.add(types.RowSize(row))
+}
+
+// take moves the memory reserved in other to r, leaving other empty. Both have to be for the same
+// query.
+func (r *reservation) take(other *reservation) {
+	r.bytes += other.bytes
+	other.bytes = 0
+}
+
+// release frees all memory reserved so far.
+func (r *reservation) release() {
+	if a := r.accountant; a != nil {
+		a.mu.Lock()
+		a.used -= r.bytes
+		a.mu.Unlock()
+	}
+	r.bytes = 0
+}
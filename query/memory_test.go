@@ -0,0 +1,108 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/lfritz/toydb/types"
+)
+
+func TestMemoryLimit(t *testing.T) {
+	db, schema := numbersTable(t, 100)
+	numbers := NewLoad("numbers", schema)
+	other := NewLoadAs("numbers", "other", schema)
+	n := NewColumnReference(0, types.TypeDecimal)
+	crossJoin, err := NewJoin(JoinTypeInner, numbers, other, NewConstant(types.Boo(true)))
+	if err != nil {
+		t.Fatalf("NewJoin returned error: %v", err)
+	}
+
+	var plans []Plan
+	add := func(p Plan, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("creating plan returned error: %v", err)
+		}
+		plans = append(plans, p)
+	}
+	add(NewSort(numbers, []SortKey{{n, true, false}}))
+	add(NewAggregate(numbers, []OutputColumn{{"n", n}}, nil))
+	add(NewHashJoin(JoinTypeInner, numbers, other, []Expression{n}, []Expression{n}))
+	add(NewMergeJoin(JoinTypeInner, numbers, other, []Expression{n}, []Expression{n}))
+	plans = append(plans, NewDistinct(numbers), crossJoin)
+
+	for _, p := range plans {
+		// without a limit, the plan runs and releases all memory afterwards
+		accountant := NewMemoryAccountant(0)
+		ctx := WithMemoryAccountant(context.Background(), accountant)
+		if _, err := p.Run(ctx, db); err != nil {
+			t.Errorf("Run for\n%sreturned error: %v", Print(p), err)
+		}
+		if accountant.Used() != 0 {
+			t.Errorf("Run for\n%sdid not release %d bytes", Print(p), accountant.Used())
+		}
+
+		// with a limit that's too small for half of the table
+		limit := 50 * types.RowSize([]types.Value{types.Dec("10")})
+		accountant = NewMemoryAccountant(limit)
+		ctx = WithMemoryAccountant(context.Background(), accountant)
+		_, err := p.Run(ctx, db)
+		var memoryLimitError MemoryLimitError
+		if !errors.As(err, &memoryLimitError) {
+			t.Errorf("Run for\n%sreturned %v, want MemoryLimitError", Print(p), err)
+			continue
+		}
+		if memoryLimitError.Node != nodeName(p) || memoryLimitError.Limit != limit {
+			t.Errorf("Run for\n%sreturned %v, want error for %s step with limit %d",
+				Print(p), err, nodeName(p), limit)
+		}
+		if accountant.Used() != 0 {
+			t.Errorf("Run for\n%sdid not release %d bytes after error", Print(p), accountant.Used())
+		}
+	}
+}
+
+func TestMemoryLimitNode(t *testing.T) {
+	db, schema := numbersTable(t, 100)
+	n := NewColumnReference(0, types.TypeDecimal)
+	sort, err := NewSort(NewLoad("numbers", schema), []SortKey{{n, true, false}})
+	if err != nil {
+		t.Fatalf("NewSort returned error: %v", err)
+	}
+	project, err := NewProject(sort, []OutputColumn{{"n", n}})
+	if err != nil {
+		t.Fatalf("NewProject returned error: %v", err)
+	}
+
+	// the Sort step runs out of memory before the Project step returns any rows
+	ctx := WithMemoryAccountant(context.Background(), NewMemoryAccountant(1000))
+	_, err = project.Run(ctx, db)
+	want := "memory limit exceeded in Sort step: limit is 1000 bytes"
+	if err == nil || err.Error() != want {
+		t.Errorf("Run returned %v, want %q", err, want)
+	}
+}
+
+func TestMemoryLimitDistinctAggregate(t *testing.T) {
+	db, schema := numbersTable(t, 100)
+	n := NewColumnReference(0, types.TypeDecimal)
+	p, err := NewAggregate(NewLoad("numbers", schema), nil, []AggregateColumn{
+		{"count", AggregateFunctionCount, n, true},
+	})
+	if err != nil {
+		t.Fatalf("NewAggregate returned error: %v", err)
+	}
+
+	// the single group is small, but the values seen by count(distinct n) are not
+	accountant := NewMemoryAccountant(100)
+	ctx := WithMemoryAccountant(context.Background(), accountant)
+	_, err = p.Run(ctx, db)
+	want := "memory limit exceeded in Aggregate step: limit is 100 bytes"
+	if err == nil || err.Error() != want {
+		t.Errorf("Run returned %v, want %q", err, want)
+	}
+	if accountant.Used() != 0 {
+		t.Errorf("Run did not release %d bytes after error", accountant.Used())
+	}
+}
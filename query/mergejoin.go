@@ -0,0 +1,311 @@
+package query
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/lfritz/toydb/storage"
+	"github.com/lfritz/toydb/types"
+)
+
+// A MergeJoin step joins two inputs on equality of key expressions, like a HashJoin. It reads both
+// inputs in ascending order of their keys and merges them, so the output is ordered by the keys. An
+// input that's already in that order, such as a Sort by the keys or an IndexScan of an index on the
+// key columns, is read as it is; other inputs are sorted first. Rows padded with nulls for outer
+// joins come in key order as well, except that rows with a null key, which don't match anything,
+// come where their input has them.
+type MergeJoin struct {
+	Type                JoinType
+	Left, Right         Plan
+	LeftKeys, RightKeys []Expression
+	combinedSchema      types.TableSchema
+}
+
+func NewMergeJoin(t JoinType, left, right Plan, leftKeys, rightKeys []Expression) (*MergeJoin, error) {
+	if err := checkJoinKeys(left, right, leftKeys, rightKeys); err != nil {
+		return nil, err
+	}
+	return &MergeJoin{
+		Type:           t,
+		Left:           left,
+		Right:          right,
+		LeftKeys:       leftKeys,
+		RightKeys:      rightKeys,
+		combinedSchema: CombineSchemas(left.Schema(), right.Schema(), t),
+	}, nil
+}
+
+func (j *MergeJoin) Schema() types.TableSchema {
+	return j.combinedSchema
+}
+
+func (j *MergeJoin) Run(ctx context.Context, db *storage.Database) (*types.Relation, error) {
+	return run(ctx, j, db)
+}
+
+// Open opens both inputs, sorting those that aren't ordered by their keys. The merge reads the
+// inputs as rows are requested, holding only the right input's rows for the current key in memory.
+func (j *MergeJoin) Open(ctx context.Context, db *storage.Database) (Iterator, error) {
+	left, err := j.openOrdered(ctx, db, j.Left, j.LeftKeys)
+	if err != nil {
+		return nil, err
+	}
+	right, err := j.openOrdered(ctx, db, j.Right, j.RightKeys)
+	if err != nil {
+		left.Close()
+		return nil, err
+	}
+	it := &mergeJoinIterator{
+		joinType: j.Type,
+		schema:   j.Schema(),
+		left:     &mergeInput{it: left, keys: j.LeftKeys},
+		right:    &mergeInput{it: right, keys: j.RightKeys},
+		canceler: newCanceler(ctx),
+		memory:   newReservation(ctx, "MergeJoin"),
+	}
+	if err := it.left.advance(); err != nil {
+		it.Close()
+		return nil, err
+	}
+	if err := it.right.advance(); err != nil {
+		it.Close()
+		return nil, err
+	}
+	return it, nil
+}
+
+// openOrdered opens an input and returns an iterator over its rows in ascending order of the keys,
+// with rows with the same keys in input order.
+func (j *MergeJoin) openOrdered(ctx context.Context, db *storage.Database, p Plan, keys []Expression) (Iterator, error) {
+	it, err := p.Open(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	if orderedBy(p, keys, db) {
+		return it, nil
+	}
+	defer it.Close()
+	s := &Sort{From: p}
+	for _, k := range keys {
+		s.Keys = append(s.Keys, SortKey{Expression: k})
+	}
+	memory := newReservation(ctx, "MergeJoin")
+	sorted, err := s.sort(ctx, it, memory)
+	if err != nil {
+		memory.release()
+		return nil, err
+	}
+	return sorted, nil
+}
+
+// orderedBy checks if the rows of a plan come in ascending order of a list of keys, ignoring rows
+// where a key is null. That's the case for a Sort step whose first keys are the given ones, in
+// ascending order, and for an IndexScan of a B+tree index whose first columns are the keys. A
+// Select step keeps the order of its input.
+func orderedBy(p Plan, keys []Expression, db *storage.Database) bool {
+	switch p := p.(type) {
+	case *Select:
+		return orderedBy(p.From, keys, db)
+	case *Sort:
+		if len(p.Keys) < len(keys) {
+			return false
+		}
+		for i, k := range keys {
+			if p.Keys[i].Descending || !reflect.DeepEqual(p.Keys[i].Expression, k) {
+				return false
+			}
+		}
+		return true
+	case *IndexScan:
+		indexes, err := db.Indexes(p.TableName)
+		if err != nil {
+			return false
+		}
+		schema, err := db.Schema(p.TableName)
+		if err != nil {
+			return false
+		}
+		for _, ix := range indexes {
+			if ix.Name != p.IndexName {
+				continue
+			}
+			if ix.Method != storage.IndexMethodBtree || len(ix.Columns) < len(keys) {
+				return false
+			}
+			for i, k := range keys {
+				c, ok := k.(*ColumnReference)
+				column, _, _ := schema.Column(ix.Columns[i])
+				if !ok || c.Index != column {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// A mergeInput is one of the inputs of a MergeJoin, positioned at a row.
+type mergeInput struct {
+	it   Iterator
+	keys []Expression
+
+	// current row and its keys, which are nil if any of them is null
+	row    []types.Value
+	values []types.Value
+	done   bool
+}
+
+// advance reads the next row and evaluates its keys.
+func (in *mergeInput) advance() error {
+	row, ok, err := in.it.Next()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		in.row, in.values, in.done = nil, nil, true
+		return nil
+	}
+	in.row, in.values = row, nil
+	r := &types.Row{Schema: in.it.Schema(), Values: row}
+	values := make([]types.Value, len(in.keys))
+	for i, k := range in.keys {
+		values[i], err = k.Evaluate(r)
+		if err != nil {
+			return err
+		}
+		if values[i].Null() {
+			return nil
+		}
+	}
+	in.values = values
+	return nil
+}
+
+// matches checks if the input is positioned at a row with the given keys.
+func (in *mergeInput) matches(values []types.Value) bool {
+	return !in.done && in.values != nil && compareJoinKeys(in.values, values) == 0
+}
+
+// A mergeJoinIterator merges the ordered inputs of a MergeJoin. When the keys of the current rows
+// match, it reads the group of right rows with that key and combines it with each left row with the
+// key.
+type mergeJoinIterator struct {
+	joinType    JoinType
+	schema      types.TableSchema
+	left, right *mergeInput
+	canceler    *canceler
+	memory      *reservation
+
+	group   [][]types.Value // right rows with the current key
+	key     []types.Value
+	pending [][]types.Value // rows to return before reading on
+}
+
+func (it *mergeJoinIterator) Schema() types.TableSchema {
+	return it.schema
+}
+
+func (it *mergeJoinIterator) Next() ([]types.Value, bool, error) {
+	for len(it.pending) == 0 {
+		ok, err := it.step()
+		if err != nil || !ok {
+			return nil, false, err
+		}
+	}
+	row := it.pending[0]
+	it.pending = it.pending[1:]
+	return row, true, nil
+}
+
+// step advances one of the inputs, adding the rows that produces to pending. It returns false when
+// both inputs are done.
+func (it *mergeJoinIterator) step() (bool, error) {
+	if err := it.canceler.check(); err != nil {
+		return false, err
+	}
+	left, right := it.left, it.right
+	if it.group != nil {
+		if left.matches(it.key) {
+			for _, row := range it.group {
+				it.pending = append(it.pending, combineRow(left.row, row))
+			}
+			return true, left.advance()
+		}
+		it.group, it.key = nil, nil
+		it.memory.release()
+	}
+
+	switch {
+	case left.done && right.done:
+		return false, nil
+	case !left.done && (left.values == nil || right.done ||
+		right.values != nil && compareJoinKeys(left.values, right.values) < 0):
+		// the left row has no match
+		if it.joinType == JoinTypeLeftOuter || it.joinType == JoinTypeFullOuter {
+			it.pending = append(it.pending, combineRow(left.row, nullRow(right.it.Schema())))
+		}
+		return true, left.advance()
+	case !right.done && (right.values == nil || left.done ||
+		compareJoinKeys(left.values, right.values) > 0):
+		// the right row has no match
+		if it.joinType == JoinTypeRightOuter || it.joinType == JoinTypeFullOuter {
+			it.pending = append(it.pending, combineRow(nullRow(left.it.Schema()), right.row))
+		}
+		return true, right.advance()
+	}
+
+	// the keys are equal: read the group of right rows with this key
+	it.key = right.values
+	for right.matches(it.key) {
+		if err := it.canceler.check(); err != nil {
+			return false, err
+		}
+		if err := it.memory.addRow(right.row); err != nil {
+			return false, err
+		}
+		it.group = append(it.group, right.row)
+		if err := right.advance(); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (it *mergeJoinIterator) Close() {
+	it.left.it.Close()
+	it.right.it.Close()
+	it.memory.release()
+}
+
+// compareJoinKeys compares two lists of non-null keys and returns a negative number, zero or a
+// positive number if a is less than, equal to or greater than b.
+func compareJoinKeys(a, b []types.Value) int {
+	for i := range a {
+		switch a[i].Compare(b[i]) {
+		case types.ComparedLt:
+			return -1
+		case types.ComparedGt:
+			return 1
+		}
+	}
+	return 0
+}
+
+func (j *MergeJoin) Print(printer *Printer) {
+	printer.Println("MergeJoin {")
+	printer.Indent()
+	printer.Println("Type: %s", j.Type)
+	printer.Print("Left: ")
+	j.Left.Print(printer)
+	printer.Print("Right: ")
+	j.Right.Print(printer)
+	printer.Println("Keys:")
+	printer.Indent()
+	for i := range j.LeftKeys {
+		printer.Println("(%d) %s = %s", i, j.LeftKeys[i], j.RightKeys[i])
+	}
+	printer.Unindent()
+	printer.Unindent()
+	printer.Println("}")
+}
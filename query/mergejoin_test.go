@@ -0,0 +1,209 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/lfritz/toydb/storage"
+	"github.com/lfritz/toydb/types"
+)
+
+// sortedRows returns the rows in a canonical order, for comparing results regardless of order.
+func sortedRows(rows [][]types.Value) []string {
+	keys := make([]string, len(rows))
+	for i, row := range rows {
+		keys[i] = types.RowKey(row)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestMergeJoin(t *testing.T) {
+	db := joinTestData(t)
+	a, err := db.Table("a")
+	if err != nil {
+		t.Fatalf("Table returned error: %v", err)
+	}
+	b, err := db.Table("b")
+	if err != nil {
+		t.Fatalf("Table returned error: %v", err)
+	}
+
+	joinTypes := []JoinType{JoinTypeInner, JoinTypeLeftOuter, JoinTypeRightOuter, JoinTypeFullOuter}
+	for _, joinType := range joinTypes {
+		for keys := 1; keys <= 2; keys++ {
+			left := NewLoad("a", a.Schema)
+			right := NewLoad("b", b.Schema)
+			nestedLoop, err := NewJoin(joinType, left, right, equiJoinCondition(t, keys))
+			if err != nil {
+				t.Fatalf("NewJoin returned error: %v", err)
+			}
+			var leftKeys, rightKeys []Expression
+			for i := 0; i < keys; i++ {
+				leftKeys = append(leftKeys, NewColumnReference(i, types.TypeDecimal))
+				rightKeys = append(rightKeys, NewColumnReference(i, types.TypeDecimal))
+			}
+			mergeJoin, err := NewMergeJoin(joinType, left, right, leftKeys, rightKeys)
+			if err != nil {
+				t.Fatalf("NewMergeJoin returned error: %v", err)
+			}
+
+			if got, want := mergeJoin.Schema(), nestedLoop.Schema(); !reflect.DeepEqual(got, want) {
+				t.Errorf("Schema returned %v, want %v", got, want)
+			}
+			got := mustRun(t, mergeJoin, db)
+			want := mustRun(t, nestedLoop, db)
+			if len(want.Rows) == 0 {
+				t.Fatalf("nested-loop join returned no rows")
+			}
+			if !reflect.DeepEqual(sortedRows(got.Rows), sortedRows(want.Rows)) {
+				t.Errorf("%s merge join on %d keys returned\n%v\nwant\n%v", joinType, keys, got.Rows, want.Rows)
+			}
+
+			limit, err := NewLimit(mergeJoin, 0, 5)
+			if err != nil {
+				t.Fatalf("NewLimit returned error: %v", err)
+			}
+			limited := mustRun(t, limit, db)
+			if !reflect.DeepEqual(limited.Rows, got.Rows[:5]) {
+				t.Errorf("Limit returned %v, want %v", limited.Rows, got.Rows[:5])
+			}
+		}
+	}
+}
+
+func TestMergeJoinOrder(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	join, err := NewMergeJoin(
+		JoinTypeLeftOuter,
+		NewLoad("people", sampleData.People.Schema),
+		NewLoad("films", sampleData.Films.Schema),
+		[]Expression{NewColumnReference(0, types.TypeDecimal)},
+		[]Expression{NewColumnReference(3, types.TypeDecimal)},
+	)
+	if err != nil {
+		t.Fatalf("NewMergeJoin returned error: %v", err)
+	}
+
+	// rows are ordered by key, with duplicates in input order, and padded rows in key order too
+	got := mustRun(t, join, sampleData.Database).Rows
+	want := [][]types.Value{
+		{types.Dec("1"), types.Txt("Buster Keaton"), types.Dec("1"), types.Txt("The General"), types.Dat(1926, 12, 31), types.Dec("1")},
+		{types.Dec("1"), types.Txt("Buster Keaton"), types.Dec("3"), types.Txt("Sherlock Jr."), types.Dat(1924, 4, 21), types.Dec("1")},
+		{types.Dec("2"), types.Txt("Charlie Chaplin"), types.Dec("2"), types.Txt("The Kid"), types.Dat(1921, 1, 21), types.Dec("2")},
+		{types.Dec("3"), types.Txt("Harold Lloyd"), types.NewNull(types.TypeDecimal), types.NewNull(types.TypeText), types.NewNull(types.TypeDate), types.NewNull(types.TypeDecimal)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Run returned %v, want %v", got, want)
+	}
+}
+
+func TestMergeJoinOrderedInputs(t *testing.T) {
+	db, schema := numbersTable(t, 1000)
+	if err := db.CreateIndex("numbers_n", "numbers", []string{"n"}); err != nil {
+		t.Fatalf("CreateIndex returned error: %v", err)
+	}
+	n := NewColumnReference(0, types.TypeDecimal)
+	keys := []Expression{n}
+	numbers := NewLoad("numbers", schema)
+	other := NewLoadAs("numbers", "other", schema)
+	sorted, err := NewSort(numbers, []SortKey{{n, false, true}})
+	if err != nil {
+		t.Fatalf("NewSort returned error: %v", err)
+	}
+	descending, err := NewSort(numbers, []SortKey{{n, true, false}})
+	if err != nil {
+		t.Fatalf("NewSort returned error: %v", err)
+	}
+	indexScan := NewIndexScan(other, "numbers_n", storage.KeyRange{})
+
+	cases := []struct {
+		plan Plan
+		want bool
+	}{
+		{numbers, false},
+		{sorted, true},
+		{descending, false},
+		{indexScan, true},
+		{&Select{From: indexScan, Condition: NewConstant(types.Boo(true))}, true},
+	}
+	for _, c := range cases {
+		if got := orderedBy(c.plan, keys, db); got != c.want {
+			t.Errorf("orderedBy for\n%sreturned %v, want %v", Print(c.plan), got, c.want)
+		}
+	}
+
+	// joining two index scans doesn't sort them, so it only needs memory for one row at a time ...
+	limit := 10 * types.RowSize([]types.Value{types.Dec("1000")})
+	join, err := NewMergeJoin(JoinTypeInner, NewIndexScan(numbers, "numbers_n", storage.KeyRange{}), indexScan, keys, keys)
+	if err != nil {
+		t.Fatalf("NewMergeJoin returned error: %v", err)
+	}
+	ctx := WithMemoryAccountant(context.Background(), NewMemoryAccountant(limit))
+	it, err := join.Open(ctx, db)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	var count int
+	for ; ; count++ {
+		row, ok := mustNext(t, it)
+		if !ok {
+			break
+		}
+		if want := types.Dec(strconv.Itoa(count)); !reflect.DeepEqual(row, []types.Value{want, want}) {
+			t.Fatalf("row %d is %v", count, row)
+		}
+	}
+	it.Close()
+	if count != 1000 {
+		t.Errorf("MergeJoin returned %d rows, want 1000", count)
+	}
+
+	// ... while joining the tables has to sort them
+	join, err = NewMergeJoin(JoinTypeInner, numbers, other, keys, keys)
+	if err != nil {
+		t.Fatalf("NewMergeJoin returned error: %v", err)
+	}
+	var memoryLimitError MemoryLimitError
+	if _, err := join.Open(ctx, db); !errors.As(err, &memoryLimitError) {
+		t.Errorf("Open returned %v, want MemoryLimitError", err)
+	}
+}
+
+func TestMergeJoinCancelWithoutMatches(t *testing.T) {
+	// inputs that ignore cancellation and whose keys never match
+	numbers := func(offset int) *mergeInput {
+		relation := &types.Relation{
+			Schema: types.TableSchema{Columns: []types.ColumnSchema{{"n", types.TypeDecimal, false}}},
+		}
+		for i := 0; i < 2*checkInterval; i++ {
+			relation.Rows = append(relation.Rows, []types.Value{types.Dec(strconv.Itoa(offset + i))})
+		}
+		return &mergeInput{
+			it:   newSliceIterator(context.Background(), relation, nil),
+			keys: []Expression{NewColumnReference(0, types.TypeDecimal)},
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	it := &mergeJoinIterator{
+		joinType: JoinTypeInner,
+		left:     numbers(0),
+		right:    numbers(2 * checkInterval),
+		canceler: newCanceler(ctx),
+		memory:   newReservation(ctx, "MergeJoin"),
+	}
+	defer it.Close()
+	for _, in := range []*mergeInput{it.left, it.right} {
+		if err := in.advance(); err != nil {
+			t.Fatalf("advance returned error: %v", err)
+		}
+	}
+	if _, _, err := it.Next(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Next returned %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,294 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/lfritz/toydb/storage"
+	"github.com/lfritz/toydb/types"
+)
+
+// gatherBatchSize is the number of rows a worker of a Gather step sends at a time.
+const gatherBatchSize = 64
+
+// A Gather step runs copies of its input in parallel, one for each worker, and merges the rows they
+// produce. Each worker scans a different partition of the tables that are loaded on the input's
+// streaming side, so together they produce the same rows as the input, but in no particular order.
+//
+// The input has to be Parallelizable. Inputs that have to be read in full, like the inner input of
+// a join, are computed once and shared between the workers.
+type Gather struct {
+	From    Plan
+	Workers int
+}
+
+func NewGather(from Plan, workers int) (*Gather, error) {
+	if workers < 1 {
+		return nil, fmt.Errorf("invalid number of workers for gather step: %d", workers)
+	}
+	if !Parallelizable(from) {
+		return nil, fmt.Errorf("cannot run %s step in parallel", nodeName(from))
+	}
+	return &Gather{
+		From:    from,
+		Workers: workers,
+	}, nil
+}
+
+// Parallelizable returns true if a plan can be the input of a Gather step. That's the case if each
+// row it produces depends on a single row of the tables loaded on its streaming side: Load, Select
+// and Project steps, and inner, left outer and right outer joins whose outer input is
+// parallelizable. Steps that look at all rows, such as Sort or Aggregate, are not.
+func Parallelizable(p Plan) bool {
+	switch p := p.(type) {
+	case *Load:
+		return true
+	case *Select:
+		return Parallelizable(p.From)
+	case *Project:
+		return Parallelizable(p.From)
+	case *Join:
+		return parallelizableJoin(p.Type, p.Left, p.Right)
+	case *HashJoin:
+		return parallelizableJoin(p.Type, p.Left, p.Right)
+	}
+	return false
+}
+
+func parallelizableJoin(t JoinType, left, right Plan) bool {
+	switch t {
+	case JoinTypeInner, JoinTypeLeftOuter:
+		return Parallelizable(left)
+	case JoinTypeRightOuter:
+		return Parallelizable(right)
+	}
+	return false
+}
+
+func (g *Gather) Schema() types.TableSchema {
+	return g.From.Schema()
+}
+
+func (g *Gather) Run(ctx context.Context, db *storage.Database) (*types.Relation, error) {
+	return run(ctx, g, db)
+}
+
+// Open opens the input once for each worker and starts a goroutine for each of them.
+func (g *Gather) Open(ctx context.Context, db *storage.Database) (Iterator, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	shared := &sharedState{
+		results: make(map[interface{}]*sharedResult),
+	}
+	workers := make([]Iterator, g.Workers)
+	for i := range workers {
+		w := &worker{index: i, count: g.Workers, shared: shared}
+		it, err := g.From.Open(withWorker(ctx, w), db)
+		if err != nil {
+			for _, it := range workers[:i] {
+				it.Close()
+			}
+			cancel()
+			shared.release()
+			return nil, err
+		}
+		workers[i] = it
+	}
+
+	it := &gatherIterator{
+		ctx:     ctx,
+		cancel:  cancel,
+		schema:  workers[0].Schema(),
+		batches: make(chan gatherBatch, g.Workers),
+		shared:  shared,
+	}
+	it.wg.Add(len(workers))
+	for _, w := range workers {
+		go it.work(w)
+	}
+	go func() {
+		it.wg.Wait()
+		close(it.batches)
+	}()
+	return it, nil
+}
+
+func (g *Gather) Print(printer *Printer) {
+	printer.Println("Gather {")
+	printer.Indent()
+	printer.Print("From: ")
+	g.From.Print(printer)
+	printer.Println("Workers: %d", g.Workers)
+	printer.Unindent()
+	printer.Println("}")
+}
+
+// A gatherBatch is a group of rows sent by a worker, or the error that stopped it.
+type gatherBatch struct {
+	rows [][]types.Value
+	err  error
+}
+
+// A gatherIterator returns the rows produced by the workers of a Gather step in the order they
+// arrive. The first error returned by any worker stops all of them.
+type gatherIterator struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	schema  types.TableSchema
+	batches chan gatherBatch
+	wg      sync.WaitGroup
+	shared  *sharedState
+	current [][]types.Value
+	err     error
+}
+
+// work reads all rows from a worker's iterator and sends them to the batches channel. It stops
+// early when the context is done.
+func (it *gatherIterator) work(from Iterator) {
+	defer it.wg.Done()
+	defer from.Close()
+	send := func(batch gatherBatch) bool {
+		select {
+		case it.batches <- batch:
+			return true
+		case <-it.ctx.Done():
+			return false
+		}
+	}
+	var rows [][]types.Value
+	for {
+		row, ok, err := from.Next()
+		if err != nil {
+			send(gatherBatch{err: err})
+			return
+		}
+		if !ok {
+			if len(rows) > 0 {
+				send(gatherBatch{rows: rows})
+			}
+			return
+		}
+		rows = append(rows, row)
+		if len(rows) == gatherBatchSize {
+			if !send(gatherBatch{rows: rows}) {
+				return
+			}
+			rows = nil
+		}
+	}
+}
+
+func (it *gatherIterator) Schema() types.TableSchema {
+	return it.schema
+}
+
+func (it *gatherIterator) Next() ([]types.Value, bool, error) {
+	for len(it.current) == 0 {
+		if it.err != nil {
+			return nil, false, it.err
+		}
+		batch, ok := <-it.batches
+		if !ok {
+			// workers may have stopped without reporting an error because the query's context is
+			// done
+			it.err = it.ctx.Err()
+			return nil, false, it.err
+		}
+		if batch.err != nil {
+			it.err = batch.err
+			it.cancel()
+			return nil, false, it.err
+		}
+		it.current = batch.rows
+	}
+	row := it.current[0]
+	it.current = it.current[1:]
+	return row, true, nil
+}
+
+// Close stops the workers and waits for them to finish.
+func (it *gatherIterator) Close() {
+	it.cancel()
+	for range it.batches {
+	}
+	it.shared.release()
+}
+
+// A worker is one of the copies of its input that a Gather step runs.
+type worker struct {
+	index, count int
+	shared       *sharedState
+}
+
+type workerKey struct{}
+
+// withWorker returns a context that makes plans opened with it run as a worker of a Gather step. If
+// w is nil, they run in full, as they would outside a Gather step.
+func withWorker(ctx context.Context, w *worker) context.Context {
+	return context.WithValue(ctx, workerKey{}, w)
+}
+
+// workerFrom returns the worker a plan is run as, or nil if it's run in full.
+func workerFrom(ctx context.Context) *worker {
+	w, _ := ctx.Value(workerKey{}).(*worker)
+	return w
+}
+
+// partition returns the index of the part of a table's rows that a plan opened with ctx should
+// scan and the number of parts.
+func partition(ctx context.Context) (part, parts int) {
+	if w := workerFrom(ctx); w != nil {
+		return w.index, w.count
+	}
+	return 0, 1
+}
+
+// sharedState holds the results that the workers of a Gather step compute once and share.
+type sharedState struct {
+	mu      sync.Mutex
+	results map[interface{}]*sharedResult
+}
+
+type sharedResult struct {
+	once   sync.Once
+	value  interface{}
+	err    error
+	memory *reservation
+}
+
+// shared calls compute and returns its result. Workers of a Gather step call compute only once for
+// each key and share the result, with its memory reserved until the Gather step is closed;
+// otherwise, the memory is reserved in memory. Compute has to read its inputs in full, so it gets a
+// context without the worker.
+func shared(
+	ctx context.Context,
+	key interface{},
+	memory *reservation,
+	compute func(ctx context.Context, memory *reservation) (interface{}, error),
+) (interface{}, error) {
+	w := workerFrom(ctx)
+	if w == nil {
+		return compute(ctx, memory)
+	}
+	s := w.shared
+	s.mu.Lock()
+	result, ok := s.results[key]
+	if !ok {
+		result = &sharedResult{memory: newReservation(ctx, memory.node)}
+		s.results[key] = result
+	}
+	s.mu.Unlock()
+	result.once.Do(func() {
+		result.value, result.err = compute(withWorker(ctx, nil), result.memory)
+	})
+	return result.value, result.err
+}
+
+// release frees the memory reserved for shared results.
+func (s *sharedState) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, result := range s.results {
+		result.memory.release()
+	}
+}
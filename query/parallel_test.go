@@ -0,0 +1,187 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/lfritz/toydb/types"
+)
+
+// parallelTestPlans returns plans over two copies of a table of numbers, called "a" and "b", that
+// can run in parallel.
+func parallelTestPlans(t *testing.T, schema types.TableSchema) []Plan {
+	t.Helper()
+	must := func(p Plan, err error) Plan {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("creating plan returned error: %v", err)
+		}
+		return p
+	}
+	mustExpression := func(e Expression, err error) Expression {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("creating expression returned error: %v", err)
+		}
+		return e
+	}
+	n := NewColumnReference(0, types.TypeDecimal)
+	m := NewColumnReference(1, types.TypeDecimal)
+	constant := func(s string) Expression {
+		return NewConstant(types.Dec(s))
+	}
+
+	a := NewLoadAs("numbers", "a", schema)
+	b := NewLoadAs("numbers", "b", schema)
+	mod3 := mustExpression(NewArithmeticOperation(n, ArithmeticOperatorMod, constant("3")))
+	small := must(NewSelect(b, mustExpression(NewBinaryOperation(n, BinaryOperatorLt, constant("100")))))
+	mod100 := mustExpression(NewArithmeticOperation(n, ArithmeticOperatorMod, constant("100")))
+	equal := mustExpression(NewBinaryOperation(n, BinaryOperatorEq, m))
+	sorted := must(NewSort(small, []SortKey{{Expression: n, Descending: true}}))
+
+	return []Plan{
+		a,
+		must(NewSelect(a, mustExpression(NewBinaryOperation(mod3, BinaryOperatorEq, constant("0"))))),
+		must(NewProject(a, []OutputColumn{
+			{"n", n},
+			{"double", mustExpression(NewArithmeticOperation(n, ArithmeticOperatorMul, constant("2")))},
+		})),
+		must(NewHashJoin(JoinTypeInner, a, small, []Expression{mod100}, []Expression{n})),
+		must(NewHashJoin(JoinTypeLeftOuter, a, small, []Expression{n}, []Expression{n})),
+		must(NewHashJoin(JoinTypeRightOuter, small, a, []Expression{n}, []Expression{n})),
+		must(NewHashJoin(JoinTypeInner, a, sorted, []Expression{mod100}, []Expression{n})),
+		must(NewJoin(JoinTypeInner, a, small, equal)),
+	}
+}
+
+func TestGather(t *testing.T) {
+	db, schema := numbersTable(t, 5000)
+	for _, p := range parallelTestPlans(t, schema) {
+		want := mustRun(t, p, db)
+		for _, workers := range []int{1, 2, 3, 8} {
+			g, err := NewGather(p, workers)
+			if err != nil {
+				t.Fatalf("NewGather returned error: %v", err)
+			}
+			got := mustRun(t, g, db)
+			if !reflect.DeepEqual(got.Schema, want.Schema) {
+				t.Errorf("Gather with %d workers returned schema %v, want %v", workers, got.Schema, want.Schema)
+			}
+			if !reflect.DeepEqual(sortedRows(got.Rows), sortedRows(want.Rows)) {
+				t.Errorf("Gather with %d workers returned %d rows, want the same %d rows as %s step",
+					workers, len(got.Rows), len(want.Rows), nodeName(p))
+			}
+		}
+	}
+}
+
+func TestNewGatherInvalid(t *testing.T) {
+	_, schema := numbersTable(t, 10)
+	a := NewLoadAs("numbers", "a", schema)
+	b := NewLoadAs("numbers", "b", schema)
+	n := NewColumnReference(0, types.TypeDecimal)
+	sort, err := NewSort(a, []SortKey{{n, false, false}})
+	if err != nil {
+		t.Fatalf("NewSort returned error: %v", err)
+	}
+	fullJoin, err := NewHashJoin(JoinTypeFullOuter, a, b, []Expression{n}, []Expression{n})
+	if err != nil {
+		t.Fatalf("NewHashJoin returned error: %v", err)
+	}
+	// the streaming side of a right outer join is the right input
+	rightJoin, err := NewHashJoin(JoinTypeRightOuter, a, sort, []Expression{n}, []Expression{n})
+	if err != nil {
+		t.Fatalf("NewHashJoin returned error: %v", err)
+	}
+
+	if _, err := NewGather(a, 0); err == nil {
+		t.Errorf("NewGather did not return error for zero workers")
+	}
+	for _, p := range []Plan{sort, fullJoin, rightJoin, NewDistinct(a)} {
+		if _, err := NewGather(p, 4); err == nil {
+			t.Errorf("NewGather did not return error for %s step", nodeName(p))
+		}
+	}
+}
+
+func TestGatherError(t *testing.T) {
+	db, schema := numbersTable(t, 5000)
+	n := NewColumnReference(0, types.TypeDecimal)
+
+	// n / (n - 4000) fails for one row near the end of the table
+	difference, err := NewArithmeticOperation(n, ArithmeticOperatorSub, NewConstant(types.Dec("4000")))
+	if err != nil {
+		t.Fatalf("NewArithmeticOperation returned error: %v", err)
+	}
+	quotient, err := NewArithmeticOperation(n, ArithmeticOperatorDiv, difference)
+	if err != nil {
+		t.Fatalf("NewArithmeticOperation returned error: %v", err)
+	}
+	project, err := NewProject(NewLoad("numbers", schema), []OutputColumn{{"q", quotient}})
+	if err != nil {
+		t.Fatalf("NewProject returned error: %v", err)
+	}
+	g, err := NewGather(project, 4)
+	if err != nil {
+		t.Fatalf("NewGather returned error: %v", err)
+	}
+	_, err = g.Run(context.Background(), db)
+	if !errors.Is(err, types.ErrDivisionByZero) {
+		t.Errorf("Run returned %v, want %v", err, types.ErrDivisionByZero)
+	}
+}
+
+func TestGatherClose(t *testing.T) {
+	db, schema := numbersTable(t, 5000)
+	accountant := NewMemoryAccountant(0)
+	ctx := WithMemoryAccountant(context.Background(), accountant)
+	for _, p := range parallelTestPlans(t, schema) {
+		g, err := NewGather(p, 4)
+		if err != nil {
+			t.Fatalf("NewGather returned error: %v", err)
+		}
+		it, err := g.Open(ctx, db)
+		if err != nil {
+			t.Fatalf("Open returned error: %v", err)
+		}
+		if _, ok := mustNext(t, it); !ok {
+			t.Fatalf("Next returned no row for %s step", nodeName(p))
+		}
+		it.Close()
+		if used := accountant.Used(); used != 0 {
+			t.Errorf("%d bytes still reserved after closing Gather over %s step", used, nodeName(p))
+		}
+	}
+}
+
+func TestGatherCanceled(t *testing.T) {
+	db, schema := numbersTable(t, 5000)
+	g, err := NewGather(NewLoad("numbers", schema), 4)
+	if err != nil {
+		t.Fatalf("NewGather returned error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	it, err := g.Open(ctx, db)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer it.Close()
+	if _, ok := mustNext(t, it); !ok {
+		t.Fatalf("Next returned no row")
+	}
+	cancel()
+	for {
+		_, ok, err := it.Next()
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("Next returned %v, want %v", err, context.Canceled)
+			}
+			return
+		}
+		if !ok {
+			t.Fatalf("Next returned all rows after the context was canceled")
+		}
+	}
+}
@@ -1,29 +1,145 @@
 package query
 
 import (
+	"context"
 	"fmt"
+	"strings"
 
 	"github.com/lfritz/toydb/storage"
 	"github.com/lfritz/toydb/types"
 )
 
-// A Plan implements the steps to run a query on a database.
+// A Plan implements the steps to run a query on a database. Open starts running the plan and
+// returns an iterator over the resulting rows; Run is a convenience method that reads all rows.
+// Errors that happen while running a plan are returned as a RuntimeError, except that a step running
+// out of memory returns a MemoryLimitError. If the context is canceled or its deadline passes,
+// running the plan stops with context.Canceled or context.DeadlineExceeded.
 type Plan interface {
 	Schema() types.TableSchema
-	Run(db *storage.Database) *types.Relation
+	Open(ctx context.Context, db *storage.Database) (Iterator, error)
+	Run(ctx context.Context, db *storage.Database) (*types.Relation, error)
 	Print(printer *Printer)
 }
 
-// A Load step loads a table from the database.
+// An Iterator returns the rows produced by a plan one at a time, so rows can stream through the
+// steps of a plan without materializing intermediate results. Schema returns the schema of the
+// rows. Next returns the next row, or false when there are no more rows or if there was an error.
+// Close releases the iterator and those of its inputs; it must be called even if not all rows were
+// read.
+type Iterator interface {
+	Schema() types.TableSchema
+	Next() ([]types.Value, bool, error)
+	Close()
+}
+
+// run opens a plan, reads all rows into a relation and closes it. The memory for the rows counts
+// towards the query's limit while they're read; after that, they belong to the caller.
+func run(ctx context.Context, p Plan, db *storage.Database) (*types.Relation, error) {
+	memory := newReservation(ctx, nodeName(p))
+	defer memory.release()
+	return materialize(ctx, p, db, memory)
+}
+
+// materialize opens a plan, reads all rows into a relation and closes it, reserving memory for the
+// rows.
+func materialize(ctx context.Context, p Plan, db *storage.Database, memory *reservation) (*types.Relation, error) {
+	it, err := p.Open(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	rows, err := drain(it, memory)
+	if err != nil {
+		return nil, err
+	}
+	return &types.Relation{
+		Schema: it.Schema(),
+		Rows:   rows,
+	}, nil
+}
+
+// drain reads all remaining rows from an iterator, reserving memory for them.
+func drain(it Iterator, memory *reservation) ([][]types.Value, error) {
+	var rows [][]types.Value
+	for {
+		row, ok, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return rows, nil
+		}
+		if err := memory.addRow(row); err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+}
+
+// nodeName returns the name of a plan's step for error messages, such as "Sort".
+func nodeName(p Plan) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", p), "*query.")
+}
+
+// A sliceIterator returns rows that have already been computed. If memory is not nil, it's the
+// memory reserved for the rows, which is released when the iterator is closed.
+type sliceIterator struct {
+	schema   types.TableSchema
+	rows     [][]types.Value
+	next     int
+	canceler *canceler
+	memory   *reservation
+}
+
+func newSliceIterator(ctx context.Context, relation *types.Relation, memory *reservation) *sliceIterator {
+	return &sliceIterator{
+		schema:   relation.Schema,
+		rows:     relation.Rows,
+		canceler: newCanceler(ctx),
+		memory:   memory,
+	}
+}
+
+func (it *sliceIterator) Schema() types.TableSchema {
+	return it.schema
+}
+
+func (it *sliceIterator) Next() ([]types.Value, bool, error) {
+	if it.next >= len(it.rows) {
+		return nil, false, nil
+	}
+	if err := it.canceler.check(); err != nil {
+		return nil, false, err
+	}
+	row := it.rows[it.next]
+	it.next++
+	return row, true, nil
+}
+
+func (it *sliceIterator) Close() {
+	if it.memory != nil {
+		it.memory.release()
+	}
+}
+
+// A Load step loads a table from the database. The columns in its schema are prefixed with the
+// alias, which is the same as the table name unless the query gives the table a different name.
 type Load struct {
 	TableName   string
+	Alias       string
 	TableSchema types.TableSchema
 }
 
 func NewLoad(name string, schema types.TableSchema) *Load {
+	return NewLoadAs(name, name, schema)
+}
+
+// NewLoadAs creates a Load step for a table that's referred to by an alias.
+func NewLoadAs(name, alias string, schema types.TableSchema) *Load {
 	return &Load{
 		TableName:   name,
-		TableSchema: schema.Prefix(name),
+		Alias:       alias,
+		TableSchema: schema.Prefix(alias),
 	}
 }
 
@@ -31,18 +147,74 @@ func (l *Load) Schema() types.TableSchema {
 	return l.TableSchema
 }
 
-func (l *Load) Run(db *storage.Database) *types.Relation {
+// Run returns the table itself, without copying it, for a database kept in memory.
+func (l *Load) Run(ctx context.Context, db *storage.Database) (*types.Relation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	t, err := db.Table(l.TableName)
 	if err != nil {
-		panic(fmt.Sprintf("error loading table %s: %v", l.TableName, err))
+		return nil, l.loadError(err)
+	}
+	return t, nil
+}
+
+// Open returns an iterator that scans the table. When run as a worker of a Gather step, it only
+// scans the worker's partition of the table.
+func (l *Load) Open(ctx context.Context, db *storage.Database) (Iterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	part, parts := partition(ctx)
+	scan, err := db.Scan(l.TableName, part, parts)
+	if err != nil {
+		return nil, l.loadError(err)
+	}
+	return &scanIterator{
+		scan:      scan,
+		tableName: l.TableName,
+		canceler:  newCanceler(ctx),
+	}, nil
+}
+
+func (l *Load) loadError(err error) error {
+	return RuntimeError{Err: fmt.Errorf("error loading table %s: %w", l.TableName, err)}
+}
+
+// A scanIterator returns the rows of a table scan.
+type scanIterator struct {
+	scan      *storage.TableScan
+	tableName string
+	canceler  *canceler
+}
+
+func (it *scanIterator) Schema() types.TableSchema {
+	return it.scan.Schema()
+}
+
+func (it *scanIterator) Next() ([]types.Value, bool, error) {
+	values, ok, err := it.scan.Next()
+	if err != nil {
+		return nil, false, RuntimeError{Err: fmt.Errorf("error loading table %s: %w", it.tableName, err)}
+	}
+	if !ok {
+		return nil, false, nil
+	}
+	if err := it.canceler.check(); err != nil {
+		return nil, false, err
 	}
-	return t
+	return values, true, nil
 }
 
+func (it *scanIterator) Close() {}
+
 func (l *Load) Print(printer *Printer) {
 	printer.Println("Load {")
 	printer.Indent()
 	printer.Println("Table: %q", l.TableName)
+	if l.Alias != l.TableName {
+		printer.Println("Alias: %q", l.Alias)
+	}
 	printer.Println("Schema: %s", l.TableSchema)
 	printer.Unindent()
 	printer.Println("}")
@@ -71,24 +243,59 @@ func (s *Select) Schema() types.TableSchema {
 	return s.From.Schema()
 }
 
-func (s *Select) Run(db *storage.Database) *types.Relation {
-	from := s.From.Run(db)
+func (s *Select) Run(ctx context.Context, db *storage.Database) (*types.Relation, error) {
+	return run(ctx, s, db)
+}
 
-	var rows [][]types.Value
-	for i := range from.Rows {
-		row := from.Row(i)
-		got := s.Condition.Evaluate(row)
-		if got.IsTrue() {
-			rows = append(rows, row.Values)
-		}
+func (s *Select) Open(ctx context.Context, db *storage.Database) (Iterator, error) {
+	from, err := s.From.Open(ctx, db)
+	if err != nil {
+		return nil, err
 	}
+	return &selectIterator{
+		from:      from,
+		condition: s.Condition,
+		canceler:  newCanceler(ctx),
+	}, nil
+}
 
-	return &types.Relation{
-		Schema: from.Schema,
-		Rows:   rows,
+type selectIterator struct {
+	from      Iterator
+	condition Expression
+	canceler  *canceler
+}
+
+func (it *selectIterator) Schema() types.TableSchema {
+	return it.from.Schema()
+}
+
+func (it *selectIterator) Next() ([]types.Value, bool, error) {
+	for {
+		values, ok, err := it.from.Next()
+		if !ok || err != nil {
+			return nil, false, err
+		}
+		if err := it.canceler.check(); err != nil {
+			return nil, false, err
+		}
+		row := &types.Row{
+			Schema: it.from.Schema(),
+			Values: values,
+		}
+		match, err := it.condition.Evaluate(row)
+		if err != nil {
+			return nil, false, err
+		}
+		if match.IsTrue() {
+			return values, true, nil
+		}
 	}
 }
 
+func (it *selectIterator) Close() {
+	it.from.Close()
+}
+
 func (s *Select) Print(printer *Printer) {
 	printer.Println("Select {")
 	printer.Indent()
@@ -118,10 +325,12 @@ func ComputedColumn(name string, expression Expression) OutputColumn {
 	}
 }
 
-func (c OutputColumn) Schema() types.ColumnSchema {
+// Schema returns the schema of the column for input rows with the given schema.
+func (c OutputColumn) Schema(from types.TableSchema) types.ColumnSchema {
 	return types.ColumnSchema{
 		Name: c.Name,
 		Type: c.Expression.Type(),
+		Null: c.Expression.Nullable(from),
 	}
 }
 
@@ -156,29 +365,68 @@ func NewProject(from Plan, columns []OutputColumn) (*Project, error) {
 }
 
 func (p *Project) Schema() types.TableSchema {
+	from := p.From.Schema()
 	columns := make([]types.ColumnSchema, len(p.Columns))
 	for i, c := range p.Columns {
-		columns[i] = c.Schema()
+		columns[i] = c.Schema(from)
 	}
 	return types.TableSchema{
 		Columns: columns,
 	}
 }
 
-func (p *Project) Run(db *storage.Database) *types.Relation {
-	from := p.From.Run(db)
-	rows := make([][]types.Value, len(from.Rows))
-	for i := range from.Rows {
-		row := make([]types.Value, len(p.Columns))
-		for j := range p.Columns {
-			row[j] = p.Columns[j].Expression.Evaluate(from.Row(i))
-		}
-		rows[i] = row
+func (p *Project) Run(ctx context.Context, db *storage.Database) (*types.Relation, error) {
+	return run(ctx, p, db)
+}
+
+func (p *Project) Open(ctx context.Context, db *storage.Database) (Iterator, error) {
+	from, err := p.From.Open(ctx, db)
+	if err != nil {
+		return nil, err
 	}
-	return &types.Relation{
-		Schema: p.Schema(),
-		Rows:   rows,
+	return &projectIterator{
+		from:     from,
+		columns:  p.Columns,
+		schema:   p.Schema(),
+		canceler: newCanceler(ctx),
+	}, nil
+}
+
+type projectIterator struct {
+	from     Iterator
+	columns  []OutputColumn
+	schema   types.TableSchema
+	canceler *canceler
+}
+
+func (it *projectIterator) Schema() types.TableSchema {
+	return it.schema
+}
+
+func (it *projectIterator) Next() ([]types.Value, bool, error) {
+	values, ok, err := it.from.Next()
+	if !ok || err != nil {
+		return nil, false, err
+	}
+	if err := it.canceler.check(); err != nil {
+		return nil, false, err
 	}
+	row := &types.Row{
+		Schema: it.from.Schema(),
+		Values: values,
+	}
+	result := make([]types.Value, len(it.columns))
+	for i, c := range it.columns {
+		result[i], err = c.Expression.Evaluate(row)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	return result, true, nil
+}
+
+func (it *projectIterator) Close() {
+	it.from.Close()
 }
 
 func (p *Project) Print(printer *Printer) {
@@ -202,6 +450,7 @@ const (
 	JoinTypeInner JoinType = iota
 	JoinTypeLeftOuter
 	JoinTypeRightOuter
+	JoinTypeFullOuter
 )
 
 func (t JoinType) String() string {
@@ -212,6 +461,8 @@ func (t JoinType) String() string {
 		return "left outer"
 	case JoinTypeRightOuter:
 		return "right outer"
+	case JoinTypeFullOuter:
+		return "full outer"
 	}
 	panic(fmt.Sprintf("unexpected JoinType: %d", t))
 }
@@ -247,86 +498,200 @@ func (j *Join) Schema() types.TableSchema {
 	return j.combinedSchema
 }
 
-func (j *Join) Run(db *storage.Database) *types.Relation {
-	left := j.Left.Run(db)
-	right := j.Right.Run(db)
-	schema := j.Schema()
+func (j *Join) Run(ctx context.Context, db *storage.Database) (*types.Relation, error) {
+	return run(ctx, j, db)
+}
 
-	var rows [][]types.Value
-	switch j.Type {
-	case JoinTypeInner:
-		for _, l := range left.Rows {
-			for _, r := range right.Rows {
-				row := &types.Row{
-					Schema: schema,
-					Values: combineRow(l, r),
-				}
-				got := j.Condition.Evaluate(row)
-				if got.IsTrue() {
-					rows = append(rows, row.Values)
-				}
-			}
+// Open materializes the inner input and streams the outer input. Workers of a Gather step share the
+// inner rows.
+func (j *Join) Open(ctx context.Context, db *storage.Database) (Iterator, error) {
+	memory := newReservation(ctx, "Join")
+	_, inner := joinInputs(j.Type, j.Left, j.Right)
+	rows, err := shared(ctx, inner, memory, func(ctx context.Context, memory *reservation) (interface{}, error) {
+		relation, err := materialize(ctx, inner, db, memory)
+		if err != nil {
+			return nil, err
 		}
-	case JoinTypeLeftOuter:
-		for _, l := range left.Rows {
-			found := false
-			for _, r := range right.Rows {
-				row := &types.Row{
-					Schema: schema,
-					Values: combineRow(l, r),
-				}
-				got := j.Condition.Evaluate(row)
-				if got.IsTrue() {
-					rows = append(rows, row.Values)
-					found = true
-				}
+		return relation.Rows, nil
+	})
+	if err != nil {
+		memory.release()
+		return nil, err
+	}
+	return newJoinIterator(ctx, db, memory, j.Type, j.Left, j.Right, j.Schema(), j.Condition, rows.([][]types.Value), nil)
+}
+
+// joinInputs returns the outer and inner input of a join: the left and right input, except for
+// right outer joins.
+func joinInputs(t JoinType, left, right Plan) (outer, inner Plan) {
+	if t == JoinTypeRightOuter {
+		return right, left
+	}
+	return left, right
+}
+
+// A joinIterator joins the rows of one input, the outer input, with the rows of the other input,
+// the inner input. The outer input is streamed while the inner input is materialized. The outer
+// input is the left one, except for right outer joins, so the output has the same order as a
+// nested loop over the left and right inputs.
+//
+// For each outer row, the inner rows returned by candidates are combined with it and included if
+// they match the condition. With a nil condition, all candidates match; with a nil candidates
+// function, all inner rows are candidates.
+//
+// The inner rows are passed in by the caller, which reserves their memory in memory; it's released
+// when the iterator is closed.
+type joinIterator struct {
+	joinType    JoinType
+	schema      types.TableSchema
+	outer       Iterator
+	inner       [][]types.Value
+	outerSchema types.TableSchema
+	innerSchema types.TableSchema
+	condition   Expression
+	candidates  func(outer []types.Value) ([]int, error)
+
+	current     []types.Value // current outer row, or nil
+	matches     []int         // candidates for the current outer row
+	next        int           // index into matches
+	found       bool          // whether the current outer row matched any inner row
+	innerFound  []bool        // for full outer joins, inner rows that matched any outer row
+	unmatched   int           // for full outer joins, next inner row to check after the outer input
+	allIndexes  []int
+	outerIsLeft bool
+	canceler    *canceler
+	memory      *reservation
+}
+
+func newJoinIterator(
+	ctx context.Context,
+	db *storage.Database,
+	memory *reservation,
+	joinType JoinType,
+	left, right Plan,
+	schema types.TableSchema,
+	condition Expression,
+	innerRows [][]types.Value,
+	candidates func(outer []types.Value) ([]int, error),
+) (*joinIterator, error) {
+	outer, inner := joinInputs(joinType, left, right)
+	outerIterator, err := outer.Open(ctx, db)
+	if err != nil {
+		memory.release()
+		return nil, err
+	}
+	it := &joinIterator{
+		joinType:    joinType,
+		schema:      schema,
+		outer:       outerIterator,
+		inner:       innerRows,
+		outerSchema: outer.Schema(),
+		innerSchema: inner.Schema(),
+		condition:   condition,
+		candidates:  candidates,
+		outerIsLeft: joinType != JoinTypeRightOuter,
+		canceler:    newCanceler(ctx),
+		memory:      memory,
+	}
+	if candidates == nil {
+		it.allIndexes = make([]int, len(it.inner))
+		for i := range it.allIndexes {
+			it.allIndexes[i] = i
+		}
+	}
+	if joinType == JoinTypeFullOuter {
+		it.innerFound = make([]bool, len(it.inner))
+	}
+	return it, nil
+}
+
+func (it *joinIterator) Schema() types.TableSchema {
+	return it.schema
+}
+
+func (it *joinIterator) Next() ([]types.Value, bool, error) {
+	for {
+		if it.current == nil {
+			values, ok, err := it.outer.Next()
+			if err != nil {
+				return nil, false, err
 			}
-			if !found {
-				leftColumns := len(left.Schema.Columns)
-				rightColumns := len(right.Schema.Columns)
-				row := make([]types.Value, leftColumns+rightColumns)
-				for i, value := range l {
-					row[i] = value
-				}
-				for i, columnSchema := range right.Schema.Columns {
-					row[leftColumns+i] = types.NewNull(columnSchema.Type)
+			if !ok {
+				values, ok := it.nextUnmatched()
+				return values, ok, nil
+			}
+			it.current = values
+			it.next = 0
+			it.found = false
+			if it.candidates == nil {
+				it.matches = it.allIndexes
+			} else {
+				it.matches, err = it.candidates(values)
+				if err != nil {
+					return nil, false, err
 				}
-				rows = append(rows, row)
 			}
 		}
-	case JoinTypeRightOuter:
-		for _, r := range right.Rows {
-			found := false
-			for _, l := range left.Rows {
+
+		for it.next < len(it.matches) {
+			if err := it.canceler.check(); err != nil {
+				return nil, false, err
+			}
+			i := it.matches[it.next]
+			it.next++
+			values := it.combine(it.current, it.inner[i])
+			if it.condition != nil {
 				row := &types.Row{
-					Schema: schema,
-					Values: combineRow(l, r),
+					Schema: it.schema,
+					Values: values,
 				}
-				got := j.Condition.Evaluate(row)
-				if got.IsTrue() {
-					rows = append(rows, row.Values)
-					found = true
-				}
-			}
-			if !found {
-				leftColumns := len(left.Schema.Columns)
-				rightColumns := len(right.Schema.Columns)
-				row := make([]types.Value, leftColumns+rightColumns)
-				for i, columnSchema := range left.Schema.Columns {
-					row[i] = types.NewNull(columnSchema.Type)
+				match, err := it.condition.Evaluate(row)
+				if err != nil {
+					return nil, false, err
 				}
-				for i, value := range r {
-					row[leftColumns+i] = value
+				if !match.IsTrue() {
+					continue
 				}
-				rows = append(rows, row)
 			}
+			it.found = true
+			if it.innerFound != nil {
+				it.innerFound[i] = true
+			}
+			return values, true, nil
+		}
+
+		current := it.current
+		it.current = nil
+		if !it.found && it.joinType != JoinTypeInner {
+			return it.combine(current, nullRow(it.innerSchema)), true, nil
 		}
 	}
+}
 
-	return &types.Relation{
-		Schema: schema,
-		Rows:   rows,
+// nextUnmatched returns the next inner row that didn't match any outer row, padded with nulls, for
+// a full outer join.
+func (it *joinIterator) nextUnmatched() ([]types.Value, bool) {
+	for it.innerFound != nil && it.unmatched < len(it.inner) {
+		i := it.unmatched
+		it.unmatched++
+		if !it.innerFound[i] {
+			return it.combine(nullRow(it.outerSchema), it.inner[i]), true
+		}
+	}
+	return nil, false
+}
+
+// combine combines an outer and an inner row into a row with the left columns first.
+func (it *joinIterator) combine(outer, inner []types.Value) []types.Value {
+	if it.outerIsLeft {
+		return combineRow(outer, inner)
 	}
+	return combineRow(inner, outer)
+}
+
+func (it *joinIterator) Close() {
+	it.outer.Close()
+	it.memory.release()
 }
 
 func (j *Join) Print(printer *Printer) {
@@ -344,8 +709,10 @@ func (j *Join) Print(printer *Printer) {
 
 func CombineSchemas(a, b types.TableSchema, joinType JoinType) types.TableSchema {
 	var columns []types.ColumnSchema
-	columns = appendColumns(columns, a.Columns, joinType == JoinTypeRightOuter)
-	columns = appendColumns(columns, b.Columns, joinType == JoinTypeLeftOuter)
+	columns = appendColumns(columns, a.Columns,
+		joinType == JoinTypeRightOuter || joinType == JoinTypeFullOuter)
+	columns = appendColumns(columns, b.Columns,
+		joinType == JoinTypeLeftOuter || joinType == JoinTypeFullOuter)
 	return types.TableSchema{Columns: columns}
 }
 
@@ -361,6 +728,15 @@ func appendColumns(slice, elems []types.ColumnSchema, makeNull bool) []types.Col
 	return slice
 }
 
+// nullRow returns a row of null values for the schema, used to pad rows in outer joins.
+func nullRow(schema types.TableSchema) []types.Value {
+	row := make([]types.Value, len(schema.Columns))
+	for i, columnSchema := range schema.Columns {
+		row[i] = types.NewNull(columnSchema.Type)
+	}
+	return row
+}
+
 func combineRow(a, b []types.Value) []types.Value {
 	var rows []types.Value
 	rows = append(rows, a...)
@@ -1,6 +1,8 @@
 package query
 
 import (
+	"context"
+	"errors"
 	"reflect"
 	"testing"
 
@@ -8,16 +10,118 @@ import (
 	"github.com/lfritz/toydb/types"
 )
 
+// mustRun runs a plan and fails the test if it returns an error.
+func mustRun(t *testing.T, p Plan, db *storage.Database) *types.Relation {
+	t.Helper()
+	result, err := p.Run(context.Background(), db)
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	return result
+}
+
+// mustOpen opens a plan and fails the test if it returns an error.
+func mustOpen(t *testing.T, p Plan, db *storage.Database) Iterator {
+	t.Helper()
+	it, err := p.Open(context.Background(), db)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	return it
+}
+
+// mustNext reads the next row from an iterator and fails the test if it returns an error.
+func mustNext(t *testing.T, it Iterator) ([]types.Value, bool) {
+	t.Helper()
+	row, ok, err := it.Next()
+	if err != nil {
+		t.Fatalf("Next returned error: %v", err)
+	}
+	return row, ok
+}
+
 func TestLoad(t *testing.T) {
 	sampleData := storage.GetSampleData()
 	l := NewLoad("films", sampleData.Films.Schema)
-	got := l.Run(sampleData.Database)
+	got := mustRun(t, l, sampleData.Database)
 	want := sampleData.Films
 	if !reflect.DeepEqual(got, want) {
 		t.Errorf("Run returned %v, want %v", got, want)
 	}
 }
 
+func TestLoadAs(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	l := NewLoadAs("films", "f", sampleData.Films.Schema)
+	want := types.TableSchema{
+		Columns: []types.ColumnSchema{
+			types.ColumnSchema{"f.id", types.TypeDecimal, false},
+			types.ColumnSchema{"f.name", types.TypeText, false},
+			types.ColumnSchema{"f.release_date", types.TypeDate, false},
+			types.ColumnSchema{"f.director", types.TypeDecimal, false},
+		},
+	}
+	if got := l.Schema(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Schema returned %v, want %v", got, want)
+	}
+	got := mustRun(t, l, sampleData.Database)
+	if !reflect.DeepEqual(got.Rows, sampleData.Films.Rows) {
+		t.Errorf("Run returned %v, want %v", got.Rows, sampleData.Films.Rows)
+	}
+}
+
+func TestIndexScan(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	if err := sampleData.Database.CreateIndex("films_director", "films", []string{"director"}); err != nil {
+		t.Fatalf("CreateIndex returned error: %v", err)
+	}
+	l := NewLoadAs("films", "f", sampleData.Films.Schema)
+	r := storage.KeyRange{
+		Lower: &storage.Bound{Key: []types.Value{types.Dec("1")}, Inclusive: true},
+		Upper: &storage.Bound{Key: []types.Value{types.Dec("1")}, Inclusive: true},
+	}
+	s := NewIndexScan(l, "films_director", r)
+	if got, want := s.Schema(), l.Schema(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Schema returned %v, want %v", got, want)
+	}
+	got := mustRun(t, s, sampleData.Database)
+	want := [][]types.Value{sampleData.Films.Rows[0], sampleData.Films.Rows[2]}
+	if !reflect.DeepEqual(got.Rows, want) {
+		t.Errorf("Run returned %v, want %v", got.Rows, want)
+	}
+
+	s = NewIndexScan(l, "missing", r)
+	var runtimeError RuntimeError
+	if _, err := s.Run(context.Background(), sampleData.Database); !errors.As(err, &runtimeError) {
+		t.Errorf("Run returned %v for missing index, want RuntimeError", err)
+	}
+}
+
+func TestHashIndexScan(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	err := sampleData.Database.CreateIndexUsing("films_director", "films", []string{"director"}, storage.IndexMethodHash)
+	if err != nil {
+		t.Fatalf("CreateIndexUsing returned error: %v", err)
+	}
+	l := NewLoad("films", sampleData.Films.Schema)
+	keys := [][]types.Value{{types.Dec("2")}, {types.Dec("3")}, {types.Dec("1")}}
+	s := NewHashIndexScan(l, "films_director", keys)
+	if got, want := s.Schema(), l.Schema(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Schema returned %v, want %v", got, want)
+	}
+	got := mustRun(t, s, sampleData.Database)
+	want := [][]types.Value{sampleData.Films.Rows[1], sampleData.Films.Rows[0], sampleData.Films.Rows[2]}
+	if !reflect.DeepEqual(got.Rows, want) {
+		t.Errorf("Run returned %v, want %v", got.Rows, want)
+	}
+
+	s = NewHashIndexScan(l, "missing", keys)
+	var runtimeError RuntimeError
+	if _, err := s.Run(context.Background(), sampleData.Database); !errors.As(err, &runtimeError) {
+		t.Errorf("Run returned %v for missing index, want RuntimeError", err)
+	}
+}
+
 func TestSelect(t *testing.T) {
 	sampleData := storage.GetSampleData()
 	l := NewLoad("films", sampleData.Films.Schema)
@@ -34,7 +138,7 @@ func TestSelect(t *testing.T) {
 		t.Fatalf("NewSelect returned error: %v", err)
 	}
 
-	got := s.Run(sampleData.Database)
+	got := mustRun(t, s, sampleData.Database)
 	want := &types.Relation{
 		Schema: sampleData.Films.Schema,
 		Rows: [][]types.Value{
@@ -82,7 +186,7 @@ func TestProject(t *testing.T) {
 	if err != nil {
 		t.Fatalf("NewProject returned error: %v", err)
 	}
-	got := p.Run(sampleData.Database)
+	got := mustRun(t, p, sampleData.Database)
 	if !reflect.DeepEqual(got, want) {
 		t.Errorf("Run returned %v, want %v", got, want)
 	}
@@ -131,7 +235,7 @@ func TestInnerJoin(t *testing.T) {
 		t.Errorf("Schema returned %v, want %v", gotSchema, wantSchema)
 	}
 
-	got := join.Run(sampleData.Database)
+	got := mustRun(t, join, sampleData.Database)
 	if !reflect.DeepEqual(got, want) {
 		t.Errorf("Run returned %v, want %v", got, want)
 	}
@@ -181,7 +285,7 @@ func TestLeftOuterJoin(t *testing.T) {
 		t.Errorf("Schema returned %v, want %v", gotSchema, wantSchema)
 	}
 
-	got := join.Run(sampleData.Database)
+	got := mustRun(t, join, sampleData.Database)
 	if !reflect.DeepEqual(got, want) {
 		t.Errorf("Run returned %v, want %v", got, want)
 	}
@@ -232,8 +336,386 @@ func TestRightOuterJoin(t *testing.T) {
 		t.Errorf("Schema returned %v, want %v", gotSchema, wantSchema)
 	}
 
-	got := join.Run(sampleData.Database)
+	got := mustRun(t, join, sampleData.Database)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Run returned %v, want %v", got, want)
+	}
+}
+
+func TestSelectWithNulls(t *testing.T) {
+	sampleData := storage.GetSampleData()
+
+	// people left outer join films on people.id = films.director
+	join := func() Plan {
+		condition, err := NewBinaryOperation(
+			NewColumnReference(0, types.TypeDecimal),
+			BinaryOperatorEq,
+			NewColumnReference(5, types.TypeDecimal),
+		)
+		if err != nil {
+			t.Fatalf("NewBinaryOperation returned error: %v", err)
+		}
+		join, err := NewJoin(
+			JoinTypeLeftOuter,
+			NewLoad("people", sampleData.People.Schema),
+			NewLoad("films", sampleData.Films.Schema),
+			condition,
+		)
+		if err != nil {
+			t.Fatalf("NewJoin returned error: %v", err)
+		}
+		return join
+	}
+	filmID := NewColumnReference(2, types.TypeDecimal)
+	one := NewConstant(types.Dec("1"))
+
+	cases := []struct {
+		condition Expression
+		want      []string
+	}{
+		{
+			// films.id != 1
+			&BinaryOperation{filmID, BinaryOperatorNe, one},
+			[]string{"Buster Keaton", "Charlie Chaplin"},
+		},
+		{
+			// not (films.id = 1)
+			NewUnaryOperation(&BinaryOperation{filmID, BinaryOperatorEq, one}, UnaryOperatorNot),
+			[]string{"Buster Keaton", "Charlie Chaplin"},
+		},
+		{
+			// films.id = 1 or true
+			&LogicalOperation{
+				&BinaryOperation{filmID, BinaryOperatorEq, one},
+				LogicalOperatorOr,
+				NewConstant(types.Boo(true)),
+			},
+			[]string{"Buster Keaton", "Buster Keaton", "Charlie Chaplin", "Harold Lloyd"},
+		},
+		{
+			// films.id is null
+			NewUnaryOperation(filmID, UnaryOperatorIsNull),
+			[]string{"Harold Lloyd"},
+		},
+	}
+
+	for _, c := range cases {
+		s, err := NewSelect(join(), c.condition)
+		if err != nil {
+			t.Fatalf("NewSelect returned error: %v", err)
+		}
+		result := mustRun(t, s, sampleData.Database)
+		var got, want []types.Value
+		for _, row := range result.Rows {
+			got = append(got, row[1])
+		}
+		for _, name := range c.want {
+			want = append(want, types.Txt(name))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Select with %v returned %v, want %v", c.condition, got, want)
+		}
+	}
+}
+
+func TestProjectNullable(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	condition, err := NewBinaryOperation(
+		NewColumnReference(0, types.TypeDecimal),
+		BinaryOperatorEq,
+		NewColumnReference(5, types.TypeDecimal),
+	)
+	if err != nil {
+		t.Fatalf("NewBinaryOperation returned error: %v", err)
+	}
+	join, err := NewJoin(
+		JoinTypeLeftOuter,
+		NewLoad("people", sampleData.People.Schema),
+		NewLoad("films", sampleData.Films.Schema),
+		condition,
+	)
+	if err != nil {
+		t.Fatalf("NewJoin returned error: %v", err)
+	}
+
+	comparison, err := NewBinaryOperation(
+		NewColumnReference(2, types.TypeDecimal),
+		BinaryOperatorEq,
+		NewConstant(types.Dec("1")),
+	)
+	if err != nil {
+		t.Fatalf("NewBinaryOperation returned error: %v", err)
+	}
+	columns := []OutputColumn{
+		SimpleColumn("people.name", 1, types.TypeText),
+		ComputedColumn("is_general", comparison),
+	}
+	p, err := NewProject(join, columns)
+	if err != nil {
+		t.Fatalf("NewProject returned error: %v", err)
+	}
+
+	want := &types.Relation{
+		Schema: types.TableSchema{
+			Columns: []types.ColumnSchema{
+				types.ColumnSchema{"people.name", types.TypeText, false},
+				types.ColumnSchema{"is_general", types.TypeBoolean, true},
+			},
+		},
+		Rows: [][]types.Value{
+			{types.Txt("Buster Keaton"), types.Boo(true)},
+			{types.Txt("Buster Keaton"), types.Boo(false)},
+			{types.Txt("Charlie Chaplin"), types.Boo(false)},
+			{types.Txt("Harold Lloyd"), types.NewNull(types.TypeBoolean)},
+		},
+	}
+	got := mustRun(t, p, sampleData.Database)
 	if !reflect.DeepEqual(got, want) {
 		t.Errorf("Run returned %v, want %v", got, want)
 	}
 }
+
+func TestFullOuterJoin(t *testing.T) {
+	sampleData := storage.GetSampleData()
+
+	wantSchema := types.TableSchema{
+		Columns: []types.ColumnSchema{
+			types.ColumnSchema{"films.id", types.TypeDecimal, true},
+			types.ColumnSchema{"films.name", types.TypeText, true},
+			types.ColumnSchema{"films.release_date", types.TypeDate, true},
+			types.ColumnSchema{"films.director", types.TypeDecimal, true},
+			types.ColumnSchema{"people.id", types.TypeDecimal, true},
+			types.ColumnSchema{"people.name", types.TypeText, true},
+		},
+	}
+	nullFilm := []types.Value{types.NewNull(types.TypeDecimal), types.NewNull(types.TypeText), types.NewNull(types.TypeDate), types.NewNull(types.TypeDecimal)}
+	wantRows := [][]types.Value{
+		{types.Dec("1"), types.Txt("The General"), types.Dat(1926, 12, 31), types.Dec("1"), types.NewNull(types.TypeDecimal), types.NewNull(types.TypeText)},
+		{types.Dec("2"), types.Txt("The Kid"), types.Dat(1921, 1, 21), types.Dec("2"), types.Dec("2"), types.Txt("Charlie Chaplin")},
+		{types.Dec("3"), types.Txt("Sherlock Jr."), types.Dat(1924, 4, 21), types.Dec("1"), types.Dec("1"), types.Txt("Buster Keaton")},
+		append(nullFilm, types.Dec("3"), types.Txt("Harold Lloyd")),
+	}
+	want := &types.Relation{
+		Schema: wantSchema,
+		Rows:   wantRows,
+	}
+
+	// films full outer join people on films.director = people.id and films.id <> 1
+	left := NewLoad("films", sampleData.Films.Schema)
+	right := NewLoad("people", sampleData.People.Schema)
+	eq, err := NewBinaryOperation(
+		NewColumnReference(3, types.TypeDecimal),
+		BinaryOperatorEq,
+		NewColumnReference(4, types.TypeDecimal),
+	)
+	if err != nil {
+		t.Fatalf("NewBinaryOperation returned error: %v", err)
+	}
+	ne, err := NewBinaryOperation(
+		NewColumnReference(0, types.TypeDecimal),
+		BinaryOperatorNe,
+		NewConstant(types.Dec("1")),
+	)
+	if err != nil {
+		t.Fatalf("NewBinaryOperation returned error: %v", err)
+	}
+	condition, err := NewLogicalOperation(eq, LogicalOperatorAnd, ne)
+	if err != nil {
+		t.Fatalf("NewLogicalOperation returned error: %v", err)
+	}
+	join, err := NewJoin(JoinTypeFullOuter, left, right, condition)
+	if err != nil {
+		t.Fatalf("NewJoin returned error: %v", err)
+	}
+
+	gotSchema := join.Schema()
+	if !reflect.DeepEqual(gotSchema, wantSchema) {
+		t.Errorf("Schema returned %v, want %v", gotSchema, wantSchema)
+	}
+
+	got := mustRun(t, join, sampleData.Database)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Run returned %v, want %v", got, want)
+	}
+}
+
+// closeCounter wraps a plan and counts how often its iterators are closed.
+type closeCounter struct {
+	Plan
+	closed int
+}
+
+func (c *closeCounter) Open(ctx context.Context, db *storage.Database) (Iterator, error) {
+	it, err := c.Plan.Open(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	return &countingIterator{Iterator: it, counter: c}, nil
+}
+
+type countingIterator struct {
+	Iterator
+	counter *closeCounter
+}
+
+func (it *countingIterator) Close() {
+	it.counter.closed++
+	it.Iterator.Close()
+}
+
+func TestIterator(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	load := &closeCounter{Plan: NewLoad("films", sampleData.Films.Schema)}
+	condition, err := NewBinaryOperation(
+		NewColumnReference(3, types.TypeDecimal),
+		BinaryOperatorEq,
+		NewConstant(types.Dec("1")),
+	)
+	if err != nil {
+		t.Fatalf("NewBinaryOperation returned error: %v", err)
+	}
+	s, err := NewSelect(load, condition)
+	if err != nil {
+		t.Fatalf("NewSelect returned error: %v", err)
+	}
+	p, err := NewProject(s, []OutputColumn{SimpleColumn("films.name", 1, types.TypeText)})
+	if err != nil {
+		t.Fatalf("NewProject returned error: %v", err)
+	}
+
+	it := mustOpen(t, p, sampleData.Database)
+	if got, want := it.Schema(), p.Schema(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Schema returned %v, want %v", got, want)
+	}
+	want := [][]types.Value{
+		{types.Txt("The General")},
+		{types.Txt("Sherlock Jr.")},
+	}
+	for i, w := range want {
+		row, ok := mustNext(t, it)
+		if !ok {
+			t.Fatalf("Next returned no row %d", i)
+		}
+		if !reflect.DeepEqual(row, w) {
+			t.Errorf("Next returned %v, want %v", row, w)
+		}
+	}
+	if row, ok := mustNext(t, it); ok {
+		t.Errorf("Next returned extra row %v", row)
+	}
+	if _, ok := mustNext(t, it); ok {
+		t.Errorf("Next returned a row after the last one")
+	}
+	it.Close()
+	if load.closed != 1 {
+		t.Errorf("input was closed %d times, want 1", load.closed)
+	}
+
+	// closing an iterator before reading all rows also closes its inputs
+	load.closed = 0
+	it = mustOpen(t, p, sampleData.Database)
+	if _, ok := mustNext(t, it); !ok {
+		t.Fatalf("Next returned no row")
+	}
+	it.Close()
+	if load.closed != 1 {
+		t.Errorf("input was closed %d times, want 1", load.closed)
+	}
+}
+
+func TestJoinIterator(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	condition, err := NewBinaryOperation(
+		NewColumnReference(3, types.TypeDecimal),
+		BinaryOperatorEq,
+		NewColumnReference(4, types.TypeDecimal),
+	)
+	if err != nil {
+		t.Fatalf("NewBinaryOperation returned error: %v", err)
+	}
+	joinTypes := []JoinType{JoinTypeInner, JoinTypeLeftOuter, JoinTypeRightOuter, JoinTypeFullOuter}
+	for _, joinType := range joinTypes {
+		left := &closeCounter{Plan: NewLoad("films", sampleData.Films.Schema)}
+		right := &closeCounter{Plan: NewLoad("people", sampleData.People.Schema)}
+		join, err := NewJoin(joinType, left, right, condition)
+		if err != nil {
+			t.Fatalf("NewJoin returned error: %v", err)
+		}
+		it := mustOpen(t, join, sampleData.Database)
+		if _, ok := mustNext(t, it); !ok {
+			t.Fatalf("Next returned no row for %s join", joinType)
+		}
+		it.Close()
+		if left.closed != 1 || right.closed != 1 {
+			t.Errorf("%s join closed its inputs %d and %d times, want 1", joinType, left.closed, right.closed)
+		}
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	films := NewLoad("films", sampleData.Films.Schema)
+	people := NewLoad("people", sampleData.People.Schema)
+
+	// "director / 0", which fails for every row
+	divide, err := NewArithmeticOperation(
+		NewColumnReference(3, types.TypeDecimal),
+		ArithmeticOperatorDiv,
+		NewConstant(types.Dec("0")),
+	)
+	if err != nil {
+		t.Fatalf("NewArithmeticOperation returned error: %v", err)
+	}
+	condition, err := NewBinaryOperation(divide, BinaryOperatorEq, NewConstant(types.Dec("1")))
+	if err != nil {
+		t.Fatalf("NewBinaryOperation returned error: %v", err)
+	}
+
+	var plans []Plan
+	add := func(p Plan, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("creating plan returned error: %v", err)
+		}
+		plans = append(plans, p)
+	}
+	add(NewSelect(films, condition))
+	add(NewProject(films, []OutputColumn{{"x", divide}}))
+	add(NewSort(films, []SortKey{{divide, false, false}}))
+	add(NewAggregate(films, nil, []AggregateColumn{{"sum", AggregateFunctionSum, divide, false}}))
+	leftKeys := []Expression{divide}
+	rightKeys := []Expression{NewColumnReference(0, types.TypeDecimal)}
+	for _, joinType := range []JoinType{JoinTypeInner, JoinTypeRightOuter} {
+		add(NewJoin(joinType, films, people, condition))
+		add(NewHashJoin(joinType, films, people, leftKeys, rightKeys))
+		add(NewMergeJoin(joinType, films, people, leftKeys, rightKeys))
+	}
+	selected, err := NewSelect(films, condition)
+	if err != nil {
+		t.Fatalf("NewSelect returned error: %v", err)
+	}
+	add(NewLimit(selected, 1, 1))
+	plans = append(plans, NewDistinct(selected))
+
+	for _, p := range plans {
+		_, err := p.Run(context.Background(), sampleData.Database)
+		var runtimeError RuntimeError
+		if !errors.As(err, &runtimeError) {
+			t.Errorf("Run for\n%sreturned %v, want RuntimeError", Print(p), err)
+			continue
+		}
+		if runtimeError.Expression != divide {
+			t.Errorf("Run for\n%sreturned error for %v, want %v", Print(p), runtimeError.Expression, divide)
+		}
+	}
+
+	_, err = NewLoad("foo", sampleData.Films.Schema).Run(context.Background(), sampleData.Database)
+	var runtimeError RuntimeError
+	if !errors.As(err, &runtimeError) {
+		t.Errorf("Run for missing table returned %v, want RuntimeError", err)
+	}
+	_, err = selected.Open(context.Background(), storage.NewDatabase())
+	if err == nil {
+		t.Errorf("Open did not return error for missing table")
+	}
+}
@@ -0,0 +1,202 @@
+package query
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lfritz/toydb/storage"
+	"github.com/lfritz/toydb/types"
+)
+
+// A SortKey is an expression used to sort rows, with the sort direction and the position of nulls.
+type SortKey struct {
+	Expression Expression
+	Descending bool
+	NullsFirst bool
+}
+
+func (k SortKey) String() string {
+	direction := "asc"
+	if k.Descending {
+		direction = "desc"
+	}
+	nulls := "nulls last"
+	if k.NullsFirst {
+		nulls = "nulls first"
+	}
+	return fmt.Sprintf("%s %s %s", k.Expression, direction, nulls)
+}
+
+// A Sort step sorts rows by a list of keys. Rows that compare equal keep their relative order.
+//
+// If SpillThreshold is positive, the step holds only about that many bytes of rows in memory: it
+// sorts runs of rows that fit, writes each run to a temporary file and merges the runs, at most
+// maxMergeFanIn at a time. Otherwise it sorts all rows in memory.
+type Sort struct {
+	From           Plan
+	Keys           []SortKey
+	SpillThreshold int
+}
+
+func NewSort(from Plan, keys []SortKey) (*Sort, error) {
+	for _, k := range keys {
+		if err := k.Expression.Check(from.Schema()); err != nil {
+			return nil, err
+		}
+	}
+	return &Sort{
+		From: from,
+		Keys: keys,
+	}, nil
+}
+
+func (s *Sort) Schema() types.TableSchema {
+	return s.From.Schema()
+}
+
+func (s *Sort) Run(ctx context.Context, db *storage.Database) (*types.Relation, error) {
+	return run(ctx, s, db)
+}
+
+// Open reads and sorts all rows of the input before returning the first one.
+func (s *Sort) Open(ctx context.Context, db *storage.Database) (Iterator, error) {
+	from, err := s.From.Open(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	defer from.Close()
+	memory := newReservation(ctx, "Sort")
+	it, err := s.sort(ctx, from, memory)
+	if err != nil {
+		memory.release()
+		return nil, err
+	}
+	return it, nil
+}
+
+func (s *Sort) sort(ctx context.Context, from Iterator, memory *reservation) (Iterator, error) {
+	canceler := newCanceler(ctx)
+	current := new(sortRun)
+	runs := new(spilledRuns)
+	for {
+		values, ok, err := from.Next()
+		if err == nil && ok {
+			err = canceler.check()
+		}
+		if err != nil {
+			runs.remove()
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+
+		// evaluate the sort keys once for each row
+		row := &types.Row{
+			Schema: from.Schema(),
+			Values: values,
+		}
+		keys := make([]types.Value, len(s.Keys))
+		for j, k := range s.Keys {
+			keys[j], err = k.Expression.Evaluate(row)
+			if err != nil {
+				runs.remove()
+				return nil, err
+			}
+		}
+
+		size := types.RowSize(values) + types.RowSize(keys)
+		if s.SpillThreshold > 0 && len(current.rows) > 0 && current.size+size > s.SpillThreshold {
+			current.sort(s.compare)
+			file, err := current.spill()
+			if err != nil {
+				runs.remove()
+				return nil, err
+			}
+			if err := runs.add(file, s.compare, canceler); err != nil {
+				runs.remove()
+				return nil, err
+			}
+			current = new(sortRun)
+			memory.release()
+		}
+		if err := memory.add(size); err != nil {
+			runs.remove()
+			return nil, err
+		}
+		current.add(values, keys, size)
+	}
+
+	current.sort(s.compare)
+	relation := &types.Relation{
+		Schema: from.Schema(),
+		Rows:   current.rows,
+	}
+	if len(runs.files) == 0 {
+		return newSliceIterator(ctx, relation, memory), nil
+	}
+
+	// the final merge reads the run in memory as well
+	if err := runs.reduce(maxMergeFanIn-1, s.compare, canceler); err != nil {
+		runs.remove()
+		return nil, err
+	}
+	return newMergeIterator(ctx, s, from.Schema(), runs.files, current, memory)
+}
+
+// compare compares two lists of evaluated sort keys and returns a negative number if a comes before
+// b, a positive number if b comes before a, and zero if their order doesn't matter.
+func (s *Sort) compare(a, b []types.Value) int {
+	for i, k := range s.Keys {
+		x, y := a[i], b[i]
+		switch {
+		case x.Null() && y.Null():
+			continue
+		case x.Null():
+			if k.NullsFirst {
+				return -1
+			}
+			return 1
+		case y.Null():
+			if k.NullsFirst {
+				return 1
+			}
+			return -1
+		}
+
+		var result int
+		switch x.Compare(y) {
+		case types.ComparedLt:
+			result = -1
+		case types.ComparedGt:
+			result = 1
+		case types.ComparedEq:
+			continue
+		default:
+			panic(fmt.Sprintf("cannot compare %v and %v", x, y))
+		}
+		if k.Descending {
+			result = -result
+		}
+		return result
+	}
+	return 0
+}
+
+func (s *Sort) Print(printer *Printer) {
+	printer.Println("Sort {")
+	printer.Indent()
+	printer.Print("From: ")
+	s.From.Print(printer)
+	printer.Println("Keys:")
+	printer.Indent()
+	for i, k := range s.Keys {
+		printer.Println("(%d) %s", i, k)
+	}
+	printer.Unindent()
+	if s.SpillThreshold > 0 {
+		printer.Println("SpillThreshold: %d", s.SpillThreshold)
+	}
+	printer.Unindent()
+	printer.Println("}")
+}
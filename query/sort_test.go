@@ -0,0 +1,292 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/lfritz/toydb/storage"
+	"github.com/lfritz/toydb/types"
+)
+
+func TestSort(t *testing.T) {
+	sampleData := storage.GetSampleData()
+	releaseDate := NewColumnReference(2, types.TypeDate)
+	director := NewColumnReference(3, types.TypeDecimal)
+
+	cases := []struct {
+		keys []SortKey
+		want []string
+	}{
+		{
+			[]SortKey{{releaseDate, false, false}},
+			[]string{"The Kid", "Sherlock Jr.", "The General"},
+		},
+		{
+			[]SortKey{{releaseDate, true, false}},
+			[]string{"The General", "Sherlock Jr.", "The Kid"},
+		},
+		{
+			// stable for equal keys
+			[]SortKey{{director, false, false}},
+			[]string{"The General", "Sherlock Jr.", "The Kid"},
+		},
+		{
+			[]SortKey{{director, false, false}, {releaseDate, false, false}},
+			[]string{"Sherlock Jr.", "The General", "The Kid"},
+		},
+		{
+			[]SortKey{{director, true, false}, {releaseDate, true, false}},
+			[]string{"The Kid", "The General", "Sherlock Jr."},
+		},
+	}
+
+	for _, c := range cases {
+		s, err := NewSort(NewLoad("films", sampleData.Films.Schema), c.keys)
+		if err != nil {
+			t.Fatalf("NewSort returned error: %v", err)
+		}
+		result := mustRun(t, s, sampleData.Database)
+		var got, want []types.Value
+		for _, row := range result.Rows {
+			got = append(got, row[1])
+		}
+		for _, name := range c.want {
+			want = append(want, types.Txt(name))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Sort by %v returned %v, want %v", c.keys, got, want)
+		}
+	}
+}
+
+func TestSortNulls(t *testing.T) {
+	sampleData := storage.GetSampleData()
+
+	// people left outer join films on people.id = films.director
+	condition, err := NewBinaryOperation(
+		NewColumnReference(0, types.TypeDecimal),
+		BinaryOperatorEq,
+		NewColumnReference(5, types.TypeDecimal),
+	)
+	if err != nil {
+		t.Fatalf("NewBinaryOperation returned error: %v", err)
+	}
+	join, err := NewJoin(
+		JoinTypeLeftOuter,
+		NewLoad("people", sampleData.People.Schema),
+		NewLoad("films", sampleData.Films.Schema),
+		condition,
+	)
+	if err != nil {
+		t.Fatalf("NewJoin returned error: %v", err)
+	}
+	filmID := NewColumnReference(2, types.TypeDecimal)
+	null := types.NewNull(types.TypeDecimal)
+
+	cases := []struct {
+		key  SortKey
+		want []types.Value
+	}{
+		{SortKey{filmID, false, false}, []types.Value{types.Dec("1"), types.Dec("2"), types.Dec("3"), null}},
+		{SortKey{filmID, false, true}, []types.Value{null, types.Dec("1"), types.Dec("2"), types.Dec("3")}},
+		{SortKey{filmID, true, false}, []types.Value{types.Dec("3"), types.Dec("2"), types.Dec("1"), null}},
+		{SortKey{filmID, true, true}, []types.Value{null, types.Dec("3"), types.Dec("2"), types.Dec("1")}},
+	}
+
+	for _, c := range cases {
+		s, err := NewSort(join, []SortKey{c.key})
+		if err != nil {
+			t.Fatalf("NewSort returned error: %v", err)
+		}
+		result := mustRun(t, s, sampleData.Database)
+		var got []types.Value
+		for _, row := range result.Rows {
+			got = append(got, row[2])
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Sort by %v returned %v, want %v", c.key, got, c.want)
+		}
+	}
+}
+
+// spillTestData creates a database with a table "t" with one nullable column of each type and
+// enough rows to fill several sort runs. The keys have many duplicates.
+func spillTestData(t *testing.T) (*storage.Database, *Load) {
+	t.Helper()
+	db := storage.NewDatabase()
+	schema := types.TableSchema{
+		Columns: []types.ColumnSchema{
+			{"b", types.TypeBoolean, true},
+			{"t", types.TypeText, true},
+			{"d", types.TypeDecimal, true},
+			{"dt", types.TypeDate, true},
+		},
+	}
+	table, err := db.CreateTable("t", schema)
+	if err != nil {
+		t.Fatalf("CreateTable returned error: %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		row := []types.Value{
+			types.Boo(i%3 == 0),
+			types.Txt(fmt.Sprintf("row %d", i)),
+			types.Dec(fmt.Sprintf("%d.%d", (i*37)%50-25, i%7)),
+			types.Dat(2000+(i*13)%20, 1+i%12, 1+i%28),
+		}
+		for j := range row {
+			if (i+j)%11 == 0 {
+				row[j] = types.NewNull(row[j].Type())
+			}
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return db, NewLoad("t", schema)
+}
+
+// tempFiles returns the number of files in a directory.
+func tempFiles(t *testing.T, dir string) int {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir returned error: %v", err)
+	}
+	return len(entries)
+}
+
+func TestSortSpill(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	db, load := spillTestData(t)
+	b := NewColumnReference(0, types.TypeBoolean)
+	d := NewColumnReference(2, types.TypeDecimal)
+	dt := NewColumnReference(3, types.TypeDate)
+
+	keyLists := [][]SortKey{
+		{{d, false, false}},
+		{{d, true, true}},
+		{{b, false, true}, {dt, true, false}},
+		{{dt, false, false}, {d, false, true}},
+	}
+	for _, keys := range keyLists {
+		inMemory, err := NewSort(load, keys)
+		if err != nil {
+			t.Fatalf("NewSort returned error: %v", err)
+		}
+		want := mustRun(t, inMemory, db)
+
+		// with a threshold of one byte, each row is a run of its own, so runs are merged in several
+		// passes
+		for _, threshold := range []int{4000, 1} {
+			spilling, err := NewSort(load, keys)
+			if err != nil {
+				t.Fatalf("NewSort returned error: %v", err)
+			}
+			spilling.SpillThreshold = threshold
+
+			it := mustOpen(t, spilling, db)
+			if n := tempFiles(t, dir); n < 2 || n >= maxMergeFanIn {
+				t.Errorf("Sort with threshold %d has %d temporary files for the final merge, want 2 to %d",
+					threshold, n, maxMergeFanIn-1)
+			}
+			var got [][]types.Value
+			for {
+				row, ok := mustNext(t, it)
+				if !ok {
+					break
+				}
+				got = append(got, row)
+			}
+			it.Close()
+			if n := tempFiles(t, dir); n != 0 {
+				t.Errorf("Close left %d temporary files", n)
+			}
+			if !reflect.DeepEqual(got, want.Rows) {
+				t.Errorf("spilling Sort by %v with threshold %d returned different rows than in-memory Sort",
+					keys, threshold)
+			}
+		}
+	}
+}
+
+func TestSortSpillMemory(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	db, load := spillTestData(t)
+	s, err := NewSort(load, []SortKey{{NewColumnReference(2, types.TypeDecimal), false, false}})
+	if err != nil {
+		t.Fatalf("NewSort returned error: %v", err)
+	}
+
+	// the sort doesn't fit in memory ...
+	limit := 20000
+	ctx := WithMemoryAccountant(context.Background(), NewMemoryAccountant(limit))
+	it, err := s.Open(ctx, db)
+	var memoryLimitError MemoryLimitError
+	if !errors.As(err, &memoryLimitError) {
+		t.Fatalf("Open returned %v, want MemoryLimitError", err)
+	}
+
+	// ... but it works when spilling to disk
+	s.SpillThreshold = limit / 2
+	it, err = s.Open(ctx, db)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer it.Close()
+	rows, err := drain(it, newReservation(context.Background(), "test"))
+	if err != nil {
+		t.Fatalf("Next returned error: %v", err)
+	}
+	if len(rows) != 1000 {
+		t.Errorf("Sort returned %d rows, want 1000", len(rows))
+	}
+}
+
+// failAfter wraps a plan so its iterators return an error after n rows.
+type failAfter struct {
+	Plan
+	n int
+}
+
+var errTestFailure = errors.New("test failure")
+
+func (f *failAfter) Open(ctx context.Context, db *storage.Database) (Iterator, error) {
+	it, err := f.Plan.Open(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	return &failingIterator{Iterator: it, n: f.n}, nil
+}
+
+type failingIterator struct {
+	Iterator
+	n int
+}
+
+func (it *failingIterator) Next() ([]types.Value, bool, error) {
+	if it.n == 0 {
+		return nil, false, errTestFailure
+	}
+	it.n--
+	return it.Iterator.Next()
+}
+
+func TestSortSpillCleanup(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	db, load := spillTestData(t)
+	s, err := NewSort(&failAfter{load, 500}, []SortKey{{NewColumnReference(2, types.TypeDecimal), false, false}})
+	if err != nil {
+		t.Fatalf("NewSort returned error: %v", err)
+	}
+	s.SpillThreshold = 4000
+	if _, err := s.Run(context.Background(), db); !errors.Is(err, errTestFailure) {
+		t.Errorf("Run returned %v, want %v", err, errTestFailure)
+	}
+	if n := tempFiles(t, dir); n != 0 {
+		t.Errorf("Sort left %d temporary files after error", n)
+	}
+}
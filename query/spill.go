@@ -0,0 +1,383 @@
+package query
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/lfritz/toydb/types"
+)
+
+// A sortRun is a list of rows with their evaluated sort keys, for the Sort step.
+type sortRun struct {
+	rows [][]types.Value
+	keys [][]types.Value
+	size int
+}
+
+func (r *sortRun) add(row, keys []types.Value, size int) {
+	r.rows = append(r.rows, row)
+	r.keys = append(r.keys, keys)
+	r.size += size
+}
+
+// sort sorts the rows by their keys, keeping the order of rows that compare equal.
+func (r *sortRun) sort(compare func(a, b []types.Value) int) {
+	order := make([]int, len(r.rows))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return compare(r.keys[order[a]], r.keys[order[b]]) < 0
+	})
+	rows := make([][]types.Value, len(order))
+	keys := make([][]types.Value, len(order))
+	for i, j := range order {
+		rows[i] = r.rows[j]
+		keys[i] = r.keys[j]
+	}
+	r.rows, r.keys = rows, keys
+}
+
+// spill writes the rows and their keys to a temporary file and returns it, positioned at the start.
+func (r *sortRun) spill() (*os.File, error) {
+	w, err := newRunWriter()
+	if err != nil {
+		return nil, err
+	}
+	for i, row := range r.rows {
+		if err := w.write(row, r.keys[i]); err != nil {
+			w.abort()
+			return nil, err
+		}
+	}
+	return w.finish()
+}
+
+// A runWriter writes a sorted run to a temporary file. Each row is written as a record: its length
+// as a uvarint, then the encoded keys and the encoded row.
+type runWriter struct {
+	file           *os.File
+	w              *bufio.Writer
+	record, length []byte
+}
+
+func newRunWriter() (*runWriter, error) {
+	file, err := os.CreateTemp("", "toydb-sort-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating file for sort run: %w", err)
+	}
+	return &runWriter{
+		file: file,
+		w:    bufio.NewWriter(file),
+	}, nil
+}
+
+func (w *runWriter) write(row, keys []types.Value) error {
+	w.record = types.AppendRow(w.record[:0], keys)
+	w.record = types.AppendRow(w.record, row)
+	w.length = binary.AppendUvarint(w.length[:0], uint64(len(w.record)))
+	if _, err := w.w.Write(w.length); err != nil {
+		return fmt.Errorf("error writing sort run: %w", err)
+	}
+	if _, err := w.w.Write(w.record); err != nil {
+		return fmt.Errorf("error writing sort run: %w", err)
+	}
+	return nil
+}
+
+// finish returns the file, positioned at the start.
+func (w *runWriter) finish() (*os.File, error) {
+	err := w.w.Flush()
+	if err == nil {
+		_, err = w.file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		w.abort()
+		return nil, fmt.Errorf("error writing sort run: %w", err)
+	}
+	return w.file, nil
+}
+
+// abort deletes the file.
+func (w *runWriter) abort() {
+	removeRunFiles([]*os.File{w.file})
+}
+
+// maxMergeFanIn is the largest number of spilled runs that are merged at once, which limits the
+// number of files a Sort step keeps open.
+const maxMergeFanIn = 16
+
+// spilledRuns are the runs a Sort step has written to files, in input order. Each run has a level:
+// spilled runs have level 0, and when there are maxMergeFanIn runs of the same level, they're merged
+// into a single run of the next level. That way, each row is written about log(runs) times and only
+// a few files per level are open.
+type spilledRuns struct {
+	files  []*os.File
+	levels []int
+}
+
+// add adds a file with a spilled run and merges runs as needed.
+func (r *spilledRuns) add(file *os.File, compare func(a, b []types.Value) int, canceler *canceler) error {
+	r.files = append(r.files, file)
+	r.levels = append(r.levels, 0)
+	for {
+		// levels are decreasing, so runs of the same level are at the end
+		n := len(r.levels)
+		if n < maxMergeFanIn || r.levels[n-maxMergeFanIn] != r.levels[n-1] {
+			return nil
+		}
+		if err := r.merge(maxMergeFanIn, compare, canceler); err != nil {
+			return err
+		}
+	}
+}
+
+// reduce merges runs until there are at most n of them.
+func (r *spilledRuns) reduce(n int, compare func(a, b []types.Value) int, canceler *canceler) error {
+	for len(r.files) > n {
+		count := maxMergeFanIn
+		if excess := len(r.files) - n + 1; excess < count {
+			count = excess
+		}
+		if err := r.merge(count, compare, canceler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// merge merges the last n runs into one. Since the runs are adjacent, the merged run keeps the order
+// of rows that compare equal.
+func (r *spilledRuns) merge(n int, compare func(a, b []types.Value) int, canceler *canceler) error {
+	start := len(r.files) - n
+	readers := make([]*runReader, n)
+	for i, f := range r.files[start:] {
+		readers[i] = &runReader{index: i, file: bufio.NewReader(f)}
+	}
+	h, err := newRunHeap(readers, compare)
+	if err != nil {
+		return err
+	}
+	w, err := newRunWriter()
+	if err != nil {
+		return err
+	}
+	for {
+		row, keys, ok, err := h.next()
+		if err == nil && ok {
+			err = canceler.check()
+		}
+		if err == nil && ok {
+			err = w.write(row, keys)
+		}
+		if err != nil {
+			w.abort()
+			return err
+		}
+		if !ok {
+			break
+		}
+	}
+	file, err := w.finish()
+	if err != nil {
+		return err
+	}
+	level := r.levels[len(r.levels)-1] + 1
+	removeRunFiles(r.files[start:])
+	r.files = append(r.files[:start], file)
+	r.levels = append(r.levels[:start], level)
+	return nil
+}
+
+// remove closes and deletes the files.
+func (r *spilledRuns) remove() {
+	removeRunFiles(r.files)
+	r.files = nil
+	r.levels = nil
+}
+
+// removeRunFiles closes and deletes temporary files.
+func removeRunFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
+// A runReader reads the rows of a sorted run, either from a file or from memory.
+type runReader struct {
+	index int // position of the run in the input, to keep the sort stable
+	file  *bufio.Reader
+	run   *sortRun
+	next  int
+
+	// current row and its keys
+	row, keys []types.Value
+}
+
+// advance reads the next row into r.row and r.keys. It returns false at the end of the run.
+func (r *runReader) advance() (bool, error) {
+	if r.file == nil {
+		if r.next >= len(r.run.rows) {
+			return false, nil
+		}
+		r.row, r.keys = r.run.rows[r.next], r.run.keys[r.next]
+		r.next++
+		return true, nil
+	}
+
+	length, err := binary.ReadUvarint(r.file)
+	if errors.Is(err, io.EOF) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error reading sort run: %w", err)
+	}
+	record := make([]byte, length)
+	if _, err := io.ReadFull(r.file, record); err != nil {
+		return false, fmt.Errorf("error reading sort run: %w", err)
+	}
+	keys, n, err := types.DecodeRow(record)
+	if err != nil {
+		return false, fmt.Errorf("error decoding sort run: %w", err)
+	}
+	row, _, err := types.DecodeRow(record[n:])
+	if err != nil {
+		return false, fmt.Errorf("error decoding sort run: %w", err)
+	}
+	r.row, r.keys = row, keys
+	return true, nil
+}
+
+// A runHeap is a min-heap of run readers ordered by their current keys.
+type runHeap struct {
+	readers []*runReader
+	compare func(a, b []types.Value) int
+}
+
+func (h *runHeap) Len() int {
+	return len(h.readers)
+}
+
+func (h *runHeap) Less(i, j int) bool {
+	a, b := h.readers[i], h.readers[j]
+	if c := h.compare(a.keys, b.keys); c != 0 {
+		return c < 0
+	}
+	return a.index < b.index
+}
+
+func (h *runHeap) Swap(i, j int) {
+	h.readers[i], h.readers[j] = h.readers[j], h.readers[i]
+}
+
+func (h *runHeap) Push(x interface{}) {
+	h.readers = append(h.readers, x.(*runReader))
+}
+
+func (h *runHeap) Pop() interface{} {
+	last := h.readers[len(h.readers)-1]
+	h.readers = h.readers[:len(h.readers)-1]
+	return last
+}
+
+// newRunHeap returns a heap with the readers that have at least one row, each positioned at its
+// first row.
+func newRunHeap(readers []*runReader, compare func(a, b []types.Value) int) (*runHeap, error) {
+	h := &runHeap{compare: compare}
+	for _, r := range readers {
+		ok, err := r.advance()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			h.readers = append(h.readers, r)
+		}
+	}
+	heap.Init(h)
+	return h, nil
+}
+
+// next returns the row with the smallest keys and its keys, or false when all runs are done.
+func (h *runHeap) next() ([]types.Value, []types.Value, bool, error) {
+	if h.Len() == 0 {
+		return nil, nil, false, nil
+	}
+	r := h.readers[0]
+	row, keys := r.row, r.keys
+	ok, err := r.advance()
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if ok {
+		heap.Fix(h, 0)
+	} else {
+		heap.Pop(h)
+	}
+	return row, keys, true, nil
+}
+
+// A mergeIterator merges sorted runs that were spilled to files with a final run that's still in
+// memory. Closing it deletes the files.
+type mergeIterator struct {
+	schema   types.TableSchema
+	files    []*os.File
+	heap     *runHeap
+	canceler *canceler
+	memory   *reservation
+}
+
+func newMergeIterator(
+	ctx context.Context,
+	s *Sort,
+	schema types.TableSchema,
+	files []*os.File,
+	last *sortRun,
+	memory *reservation,
+) (*mergeIterator, error) {
+	readers := make([]*runReader, 0, len(files)+1)
+	for i, f := range files {
+		readers = append(readers, &runReader{index: i, file: bufio.NewReader(f)})
+	}
+	readers = append(readers, &runReader{index: len(files), run: last})
+	h, err := newRunHeap(readers, s.compare)
+	if err != nil {
+		removeRunFiles(files)
+		return nil, err
+	}
+	return &mergeIterator{
+		schema:   schema,
+		files:    files,
+		heap:     h,
+		canceler: newCanceler(ctx),
+		memory:   memory,
+	}, nil
+}
+
+func (it *mergeIterator) Schema() types.TableSchema {
+	return it.schema
+}
+
+func (it *mergeIterator) Next() ([]types.Value, bool, error) {
+	if it.heap.Len() == 0 {
+		return nil, false, nil
+	}
+	if err := it.canceler.check(); err != nil {
+		return nil, false, err
+	}
+	row, _, ok, err := it.heap.next()
+	return row, ok, err
+}
+
+func (it *mergeIterator) Close() {
+	removeRunFiles(it.files)
+	it.files = nil
+	it.memory.release()
+}
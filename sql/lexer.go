@@ -91,6 +91,10 @@ func (l *Lexer) Run() ([]Token, error) {
 		case LexerStatePunctuation:
 			switch {
 			case isPunctuation(r):
+				if isSingle(l.input[l.from]) || isSingle(r) {
+					l.tokenForPunctuation()
+					l.changeState(LexerStatePunctuation)
+				}
 			case isDigitOrDot(r):
 				l.tokenForPunctuation()
 				l.changeState(LexerStateNumber)
@@ -205,7 +209,16 @@ func isDigit(r rune) bool {
 
 func isPunctuation(r rune) bool {
 	switch r {
-	case ',', '.', ';', '=', '!', '<', '>', '(', ')', '*':
+	case ',', '.', ';', '=', '!', '<', '>', '(', ')', '*', '+', '-', '/', '%':
+		return true
+	}
+	return false
+}
+
+// isSingle returns true for punctuation characters that always form a token by themselves.
+func isSingle(r rune) bool {
+	switch r {
+	case '(', ')', '+', '-':
 		return true
 	}
 	return false
@@ -45,6 +45,14 @@ func TestLexerValid(t *testing.T) {
 			"select foo.x, bar.y from foo left outer join bar on foo.x = bar.x",
 			`select (identifier "foo") dot (identifier "x") comma (identifier "bar") dot (identifier "y") from (identifier "foo") left outer join (identifier "bar") on (identifier "foo") dot (identifier "x") eq (identifier "bar") dot (identifier "x")`,
 		},
+		{
+			"select * from foo where not ((x=1))",
+			`select star from (identifier "foo") where not openparen openparen (identifier "x") eq (number "1") closeparen closeparen`,
+		},
+		{
+			"select -a*-1, b/2 % c+d from foo",
+			`select minus (identifier "a") star minus (number "1") comma (identifier "b") slash (number "2") percent (identifier "c") plus (identifier "d") from (identifier "foo")`,
+		},
 	}
 	for _, c := range cases {
 		tokens, err := Tokenize(c.input)
@@ -69,8 +77,8 @@ func TestLexerError(t *testing.T) {
 			SyntaxError{Position: 10, Msg: `invalid SQL: ",,"`},
 		},
 		{
-			"select % from foo",
-			SyntaxError{Position: 7, Msg: `unexpected character: '%'`},
+			"select ? from foo",
+			SyntaxError{Position: 7, Msg: `unexpected character: '?'`},
 		},
 	}
 	for _, c := range cases {
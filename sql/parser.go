@@ -8,20 +8,31 @@ import (
 
 // Parse parses a select statement with optional semicolon at the end.
 func Parse(input string) (*SelectStatement, error) {
+	return parse(input, ParseSelectStatement)
+}
+
+// ParseStatement parses a select, "create index" or "drop index" statement with optional semicolon
+// at the end.
+func ParseStatement(input string) (Statement, error) {
+	return parse(input, parseStatement)
+}
+
+func parse[T any](input string, parser Parser[T]) (T, error) {
+	var zero T
 	ts, err := Tokenize(input)
 	if err != nil {
-		return nil, err
+		return zero, err
 	}
 	tokens := &TokenList{input, ts}
 
-	statement, tokens, err := ParseSelectStatement(tokens)
+	statement, tokens, err := parser(tokens)
 	if err != nil {
-		return nil, err
+		return zero, err
 	}
 	_ = tokens.Consume(TokenTypeSemicolon)
 
 	if err = tokens.ExpectEnd(); err != nil {
-		return nil, err
+		return zero, err
 	}
 
 	return statement, nil
@@ -29,6 +40,80 @@ func Parse(input string) (*SelectStatement, error) {
 
 type Parser[T any] func(tokens *TokenList) (T, *TokenList, error)
 
+func parseStatement(tokens *TokenList) (Statement, *TokenList, error) {
+	token, err := tokens.Peek(TokenTypeSelect, TokenTypeCreate, TokenTypeDrop)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch token.Type {
+	case TokenTypeCreate:
+		return ParseCreateIndexStatement(tokens)
+	case TokenTypeDrop:
+		return ParseDropIndexStatement(tokens)
+	default:
+		return ParseSelectStatement(tokens)
+	}
+}
+
+// ParseCreateIndexStatement parses "create index", the index name, "on", the table name, optionally
+// "using" and the name of the index method, and the list of columns in parentheses.
+func ParseCreateIndexStatement(tokens *TokenList) (*CreateIndexStatement, *TokenList, error) {
+	err := tokens.Consume(TokenTypeCreate)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = tokens.Consume(TokenTypeIndex)
+	if err != nil {
+		return nil, nil, err
+	}
+	name, err := tokens.Get(TokenTypeIdentifier)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = tokens.Consume(TokenTypeOn)
+	if err != nil {
+		return nil, nil, err
+	}
+	table, err := tokens.Get(TokenTypeIdentifier)
+	if err != nil {
+		return nil, nil, err
+	}
+	result := &CreateIndexStatement{
+		Name:  name.Text,
+		Table: table.Text,
+	}
+	if err := tokens.Consume(TokenTypeUsing); err == nil {
+		method, err := tokens.Get(TokenTypeIdentifier)
+		if err != nil {
+			return nil, nil, err
+		}
+		result.Method = method.Text
+	}
+
+	result.Columns, tokens, err = ParseColumnList(tokens)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, tokens, nil
+}
+
+// ParseDropIndexStatement parses "drop index" followed by the index name.
+func ParseDropIndexStatement(tokens *TokenList) (*DropIndexStatement, *TokenList, error) {
+	err := tokens.Consume(TokenTypeDrop)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = tokens.Consume(TokenTypeIndex)
+	if err != nil {
+		return nil, nil, err
+	}
+	name, err := tokens.Get(TokenTypeIdentifier)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &DropIndexStatement{Name: name.Text}, tokens, nil
+}
+
 func ParseSelectStatement(tokens *TokenList) (*SelectStatement, *TokenList, error) {
 	err := tokens.Consume(TokenTypeSelect)
 	if err != nil {
@@ -36,6 +121,10 @@ func ParseSelectStatement(tokens *TokenList) (*SelectStatement, *TokenList, erro
 	}
 	result := new(SelectStatement)
 
+	if err := tokens.Consume(TokenTypeDistinct); err == nil {
+		result.Distinct = true
+	}
+
 	result.What, tokens, err = ParseSelectList(tokens)
 	if err != nil {
 		return nil, nil, err
@@ -59,67 +148,306 @@ func ParseSelectStatement(tokens *TokenList) (*SelectStatement, *TokenList, erro
 		}
 	}
 
+	err = tokens.Consume(TokenTypeGroup)
+	if err == nil {
+		err = tokens.Consume(TokenTypeBy)
+		if err != nil {
+			return nil, nil, err
+		}
+		result.GroupBy, tokens, err = ParseGroupBy(tokens)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = tokens.Consume(TokenTypeHaving)
+	if err == nil {
+		result.Having, tokens, err = ParseExpression(tokens)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = tokens.Consume(TokenTypeOrder)
+	if err == nil {
+		err = tokens.Consume(TokenTypeBy)
+		if err != nil {
+			return nil, nil, err
+		}
+		result.OrderBy, tokens, err = ParseOrderBy(tokens)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = tokens.Consume(TokenTypeLimit)
+	if err == nil {
+		result.Limit, tokens, err = ParseExpression(tokens)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = tokens.Consume(TokenTypeOffset)
+	if err == nil {
+		result.Offset, tokens, err = ParseExpression(tokens)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return result, tokens, nil
+}
+
+// ParseGroupBy parses the comma-separated list of expressions after "group by".
+func ParseGroupBy(tokens *TokenList) ([]Expression, *TokenList, error) {
+	var result []Expression
+	for {
+		e, tokens, err := ParseExpression(tokens)
+		if err != nil {
+			return nil, nil, err
+		}
+		result = append(result, e)
+
+		err = tokens.Consume(TokenTypeComma)
+		if err != nil {
+			return result, tokens, nil
+		}
+	}
+}
+
+// ParseOrderBy parses the comma-separated list of sort keys after "order by".
+func ParseOrderBy(tokens *TokenList) ([]SortKey, *TokenList, error) {
+	var result []SortKey
+	for {
+		key, tokens, err := ParseSortKey(tokens)
+		if err != nil {
+			return nil, nil, err
+		}
+		result = append(result, key)
+
+		err = tokens.Consume(TokenTypeComma)
+		if err != nil {
+			return result, tokens, nil
+		}
+	}
+}
+
+// ParseSortKey parses an expression, optionally followed by "asc" or "desc" and by "nulls first" or
+// "nulls last".
+func ParseSortKey(tokens *TokenList) (SortKey, *TokenList, error) {
+	expression, tokens, err := ParseExpression(tokens)
+	if err != nil {
+		return SortKey{}, nil, err
+	}
+	result := SortKey{Expression: expression}
+
+	token, err := tokens.Get(TokenTypeAsc, TokenTypeDesc)
+	if err == nil {
+		result.Descending = token.Type == TokenTypeDesc
+	}
+
+	err = tokens.Consume(TokenTypeNulls)
+	if err == nil {
+		token, err := tokens.Get(TokenTypeFirst, TokenTypeLast)
+		if err != nil {
+			return SortKey{}, nil, err
+		}
+		result.Nulls = NullsLast
+		if token.Type == TokenTypeFirst {
+			result.Nulls = NullsFirst
+		}
+	}
+
 	return result, tokens, nil
 }
 
+// ParseTableReference parses the "from" clause: a comma-separated list of joined tables, which is
+// the same as a chain of cross joins.
 func ParseTableReference(tokens *TokenList) (TableReference, *TokenList, error) {
-	left, tokens, err := ParseTableName(tokens)
+	result, tokens, err := ParseJoinedTable(tokens)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	token, err := tokens.Get(TokenTypeLeft, TokenTypeRight, TokenTypeJoin)
+	for {
+		err = tokens.Consume(TokenTypeComma)
+		if err != nil {
+			break
+		}
+		var right TableReference
+		right, tokens, err = ParseJoinedTable(tokens)
+		if err != nil {
+			return nil, nil, err
+		}
+		result = &Join{
+			Type:  JoinTypeCross,
+			Left:  result,
+			Right: right,
+		}
+	}
+
+	return result, tokens, nil
+}
+
+// ParseJoinedTable parses a table, optionally followed by a chain of joins. Joins are
+// left-associative, so "a join b on ... join c on ..." joins a and b first.
+func ParseJoinedTable(tokens *TokenList) (TableReference, *TokenList, error) {
+	result, tokens, err := ParseTablePrimary(tokens)
 	if err != nil {
-		// this is not a join, it's just a single table name
-		return left, tokens, nil
+		return nil, nil, err
 	}
 
-	join := &Join{
-		Left: left,
+	for {
+		var token Token
+		natural := tokens.Consume(TokenTypeNatural) == nil
+		if natural {
+			token, err = tokens.Get(
+				TokenTypeInner,
+				TokenTypeLeft,
+				TokenTypeRight,
+				TokenTypeFull,
+				TokenTypeJoin,
+			)
+			if err != nil {
+				return nil, nil, err
+			}
+		} else {
+			token, err = tokens.Get(
+				TokenTypeInner,
+				TokenTypeLeft,
+				TokenTypeRight,
+				TokenTypeFull,
+				TokenTypeCross,
+				TokenTypeJoin,
+			)
+			if err != nil {
+				// no more joins
+				return result, tokens, nil
+			}
+		}
+
+		join := &Join{
+			Left:    result,
+			Natural: natural,
+		}
+
+		switch token.Type {
+		case TokenTypeLeft:
+			join.Type = JoinTypeLeftOuter
+		case TokenTypeRight:
+			join.Type = JoinTypeRightOuter
+		case TokenTypeFull:
+			join.Type = JoinTypeFullOuter
+		case TokenTypeCross:
+			join.Type = JoinTypeCross
+		}
+
+		switch token.Type {
+		case TokenTypeLeft, TokenTypeRight, TokenTypeFull:
+			_ = tokens.Consume(TokenTypeOuter)
+			fallthrough
+		case TokenTypeInner, TokenTypeCross:
+			err := tokens.Consume(TokenTypeJoin)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		join.Right, tokens, err = ParseTablePrimary(tokens)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch {
+		case join.Type == JoinTypeCross || join.Natural:
+			// no join condition
+		case tokens.Consume(TokenTypeUsing) == nil:
+			join.Using, tokens, err = ParseColumnList(tokens)
+			if err != nil {
+				return nil, nil, err
+			}
+		default:
+			err = tokens.Consume(TokenTypeOn)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			join.Condition, tokens, err = ParseExpression(tokens)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		result = join
 	}
+}
 
-	switch token.Type {
-	case TokenTypeLeft:
-		join.Type = JoinTypeLeftOuter
-	case TokenTypeRight:
-		join.Type = JoinTypeRightOuter
+// ParseColumnList parses a non-empty, comma-separated list of column names in parentheses.
+func ParseColumnList(tokens *TokenList) ([]string, *TokenList, error) {
+	err := tokens.Consume(TokenTypeOpenParen)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	switch token.Type {
-	case TokenTypeLeft, TokenTypeRight:
-		_ = tokens.Consume(TokenTypeOuter)
-		err := tokens.Consume(TokenTypeJoin)
+	var result []string
+	for {
+		name, err := tokens.Get(TokenTypeIdentifier)
 		if err != nil {
 			return nil, nil, err
 		}
+		result = append(result, name.Text)
+
+		err = tokens.Consume(TokenTypeComma)
+		if err != nil {
+			break
+		}
 	}
 
-	right, tokens, err := ParseTableName(tokens)
+	err = tokens.Consume(TokenTypeCloseParen)
 	if err != nil {
 		return nil, nil, err
 	}
-	join.Right = right
+	return result, tokens, nil
+}
 
-	err = tokens.Consume(TokenTypeOn)
+// ParseTablePrimary parses a table name or a joined table in parentheses.
+func ParseTablePrimary(tokens *TokenList) (TableReference, *TokenList, error) {
+	err := tokens.Consume(TokenTypeOpenParen)
 	if err != nil {
-		return nil, nil, err
+		return ParseTableName(tokens)
 	}
 
-	expression, tokens, err := ParseExpression(tokens)
+	result, tokens, err := ParseJoinedTable(tokens)
 	if err != nil {
 		return nil, nil, err
 	}
-	join.Condition = expression
 
-	return join, tokens, nil
+	err = tokens.Consume(TokenTypeCloseParen)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, tokens, nil
 }
 
+// ParseTableName parses a table name, optionally followed by an alias with or without "as".
 func ParseTableName(tokens *TokenList) (TableName, *TokenList, error) {
 	token, err := tokens.Get(TokenTypeIdentifier)
 	if err != nil {
 		return TableName{}, nil, err
 	}
-	return TableName{token.Text}, tokens, nil
+	result := TableName{Name: token.Text}
+
+	if err := tokens.Consume(TokenTypeAs); err == nil {
+		alias, err := tokens.Get(TokenTypeIdentifier)
+		if err != nil {
+			return TableName{}, nil, err
+		}
+		result.Alias = alias.Text
+	} else if alias, err := tokens.Get(TokenTypeIdentifier); err == nil {
+		result.Alias = alias.Text
+	}
+	return result, tokens, nil
 }
 
 func ParseSelectList(tokens *TokenList) (SelectList, *TokenList, error) {
@@ -128,11 +456,50 @@ func ParseSelectList(tokens *TokenList) (SelectList, *TokenList, error) {
 		return Star{}, tokens, nil
 	}
 
-	expressions, tokens, err := ParseExpressionList(tokens)
+	var expressions []Expression
+	first := true
+	for {
+		remaining := tokens.Len()
+		e, next, err := ParseSelectItem(tokens)
+		if err != nil {
+			if first && tokens.Len() == remaining {
+				// empty expression list is allowed
+				break
+			}
+			return nil, nil, err
+		}
+		tokens = next
+		first = false
+
+		expressions = append(expressions, e)
+
+		err = tokens.Consume(TokenTypeComma)
+		if err != nil {
+			break
+		}
+	}
+	return ExpressionList{Expressions: expressions}, tokens, nil
+}
+
+// ParseSelectItem parses an expression in the select list, optionally followed by an alias with or
+// without "as".
+func ParseSelectItem(tokens *TokenList) (Expression, *TokenList, error) {
+	e, tokens, err := ParseExpression(tokens)
 	if err != nil {
 		return nil, nil, err
 	}
-	return ExpressionList{Expressions: expressions}, tokens, nil
+
+	if err := tokens.Consume(TokenTypeAs); err == nil {
+		name, err := tokens.Get(TokenTypeIdentifier)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &Alias{Expression: e, Name: name.Text}, tokens, nil
+	}
+	if name, err := tokens.Get(TokenTypeIdentifier); err == nil {
+		return &Alias{Expression: e, Name: name.Text}, tokens, nil
+	}
+	return e, tokens, nil
 }
 
 func ParseExpressionList(tokens *TokenList) ([]Expression, *TokenList, error) {
@@ -161,12 +528,86 @@ func ParseExpressionList(tokens *TokenList) ([]Expression, *TokenList, error) {
 	return result, tokens, nil
 }
 
+// ParseExpression parses an expression, which may combine conditions with "and", "or" and "not".
 func ParseExpression(tokens *TokenList) (Expression, *TokenList, error) {
-	left, tokens, err := ParseValue(tokens)
+	left, tokens, err := ParseConjunction(tokens)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for {
+		err := tokens.Consume(TokenTypeOr)
+		if err != nil {
+			return left, tokens, nil
+		}
+		var right Expression
+		right, tokens, err = ParseConjunction(tokens)
+		if err != nil {
+			return nil, nil, err
+		}
+		left = &BinaryOperation{
+			Left:     left,
+			Operator: BinaryOperatorOr,
+			Right:    right,
+		}
+	}
+}
+
+// ParseConjunction parses one or more expressions joined by "and".
+func ParseConjunction(tokens *TokenList) (Expression, *TokenList, error) {
+	left, tokens, err := ParseNegation(tokens)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	for {
+		err := tokens.Consume(TokenTypeAnd)
+		if err != nil {
+			return left, tokens, nil
+		}
+		var right Expression
+		right, tokens, err = ParseNegation(tokens)
+		if err != nil {
+			return nil, nil, err
+		}
+		left = &BinaryOperation{
+			Left:     left,
+			Operator: BinaryOperatorAnd,
+			Right:    right,
+		}
+	}
+}
+
+// ParseNegation parses a comparison, optionally preceded by "not".
+func ParseNegation(tokens *TokenList) (Expression, *TokenList, error) {
+	err := tokens.Consume(TokenTypeNot)
+	if err != nil {
+		return ParseComparison(tokens)
+	}
+
+	operand, tokens, err := ParseNegation(tokens)
+	if err != nil {
+		return nil, nil, err
+	}
+	result := &UnaryOperation{
+		Operand:  operand,
+		Operator: UnaryOperatorNot,
+	}
+	return result, tokens, nil
+}
+
+// ParseComparison parses a sum, optionally compared to another sum or to null, or followed by "in"
+// or "not in" and a list of expressions in parentheses.
+func ParseComparison(tokens *TokenList) (Expression, *TokenList, error) {
+	left, tokens, err := ParseSum(tokens)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if tokens.Lookahead(0, TokenTypeIn) || tokens.Lookahead(0, TokenTypeNot) && tokens.Lookahead(1, TokenTypeIn) {
+		return parseIn(left, tokens)
+	}
+
 	token, err := tokens.Get(
 		TokenTypeEq, TokenTypeNe, TokenTypeLt, TokenTypeGt, TokenTypeLe, TokenTypeGe,
 		TokenTypeIs,
@@ -194,7 +635,7 @@ func ParseExpression(tokens *TokenList) (Expression, *TokenList, error) {
 	} else {
 		// binary operation
 		op := tokenToOperator[token.Type]
-		right, tokens, err := ParseValue(tokens)
+		right, tokens, err := ParseSum(tokens)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -207,13 +648,127 @@ func ParseExpression(tokens *TokenList) (Expression, *TokenList, error) {
 	}
 }
 
+// parseIn parses "in" or "not in" followed by a non-empty list of expressions in parentheses.
+func parseIn(operand Expression, tokens *TokenList) (Expression, *TokenList, error) {
+	not := tokens.Consume(TokenTypeNot) == nil
+	err := tokens.Consume(TokenTypeIn)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = tokens.Consume(TokenTypeOpenParen)
+	if err != nil {
+		return nil, nil, err
+	}
+	var list []Expression
+	for {
+		var e Expression
+		e, tokens, err = ParseSum(tokens)
+		if err != nil {
+			return nil, nil, err
+		}
+		list = append(list, e)
+		if err := tokens.Consume(TokenTypeComma); err != nil {
+			break
+		}
+	}
+	err = tokens.Consume(TokenTypeCloseParen)
+	if err != nil {
+		return nil, nil, err
+	}
+	var result Expression = &In{Operand: operand, List: list}
+	if not {
+		result = &UnaryOperation{Operand: result, Operator: UnaryOperatorNot}
+	}
+	return result, tokens, nil
+}
+
+// ParseSum parses one or more products joined by "+" or "-".
+func ParseSum(tokens *TokenList) (Expression, *TokenList, error) {
+	return parseArithmetic(tokens, ParseProduct, TokenTypePlus, TokenTypeMinus)
+}
+
+// ParseProduct parses one or more signed operands joined by "*", "/" or "%".
+func ParseProduct(tokens *TokenList) (Expression, *TokenList, error) {
+	return parseArithmetic(tokens, ParseSigned, TokenTypeStar, TokenTypeSlash, TokenTypePercent)
+}
+
+// parseArithmetic parses a left-associative chain of binary operations with the given operators.
+func parseArithmetic(tokens *TokenList, parse Parser[Expression], operators ...TokenType) (Expression, *TokenList, error) {
+	left, tokens, err := parse(tokens)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for {
+		token, err := tokens.Get(operators...)
+		if err != nil {
+			return left, tokens, nil
+		}
+		var right Expression
+		right, tokens, err = parse(tokens)
+		if err != nil {
+			return nil, nil, err
+		}
+		left = &BinaryOperation{
+			Left:     left,
+			Operator: tokenToOperator[token.Type],
+			Right:    right,
+		}
+	}
+}
+
+// ParseSigned parses an operand, optionally preceded by a minus sign. A minus sign in front of a
+// number literal is treated as part of the number.
+func ParseSigned(tokens *TokenList) (Expression, *TokenList, error) {
+	err := tokens.Consume(TokenTypeMinus)
+	if err != nil {
+		return ParseOperand(tokens)
+	}
+
+	operand, tokens, err := ParseSigned(tokens)
+	if err != nil {
+		return nil, nil, err
+	}
+	if number, ok := operand.(Number); ok {
+		return Number{number.Value.Neg()}, tokens, nil
+	}
+	result := &UnaryOperation{
+		Operand:  operand,
+		Operator: UnaryOperatorMinus,
+	}
+	return result, tokens, nil
+}
+
+// ParseOperand parses a value or an expression in parentheses.
+func ParseOperand(tokens *TokenList) (Expression, *TokenList, error) {
+	err := tokens.Consume(TokenTypeOpenParen)
+	if err != nil {
+		return ParseValue(tokens)
+	}
+
+	result, tokens, err := ParseExpression(tokens)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = tokens.Consume(TokenTypeCloseParen)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, tokens, nil
+}
+
 var tokenToOperator = map[TokenType]BinaryOperator{
-	TokenTypeEq: BinaryOperatorEq,
-	TokenTypeNe: BinaryOperatorNe,
-	TokenTypeLt: BinaryOperatorLt,
-	TokenTypeGt: BinaryOperatorGt,
-	TokenTypeLe: BinaryOperatorLe,
-	TokenTypeGe: BinaryOperatorGe,
+	TokenTypeEq:      BinaryOperatorEq,
+	TokenTypeNe:      BinaryOperatorNe,
+	TokenTypeLt:      BinaryOperatorLt,
+	TokenTypeGt:      BinaryOperatorGt,
+	TokenTypeLe:      BinaryOperatorLe,
+	TokenTypeGe:      BinaryOperatorGe,
+	TokenTypePlus:    BinaryOperatorAdd,
+	TokenTypeMinus:   BinaryOperatorSub,
+	TokenTypeStar:    BinaryOperatorMul,
+	TokenTypeSlash:   BinaryOperatorDiv,
+	TokenTypePercent: BinaryOperatorMod,
 }
 
 func ParseValue(tokens *TokenList) (Expression, *TokenList, error) {
@@ -240,10 +795,56 @@ func ParseValue(tokens *TokenList) (Expression, *TokenList, error) {
 		tokens.Consume()
 		return ParseDate(tokens)
 	default:
+		if tokens.Lookahead(1, TokenTypeOpenParen) {
+			return ParseFunctionCall(tokens)
+		}
 		return ParseColumnReference(tokens)
 	}
 }
 
+// ParseFunctionCall parses a function name followed by a comma-separated list of arguments, or a
+// star, in parentheses. The arguments can be preceded by "distinct".
+func ParseFunctionCall(tokens *TokenList) (Expression, *TokenList, error) {
+	name, err := tokens.Get(TokenTypeIdentifier)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = tokens.Consume(TokenTypeOpenParen)
+	if err != nil {
+		return nil, nil, err
+	}
+	result := &FunctionCall{Name: name.Text}
+
+	if err := tokens.Consume(TokenTypeStar); err == nil {
+		result.Star = true
+	} else {
+		if err := tokens.Consume(TokenTypeDistinct); err == nil {
+			result.Distinct = true
+		}
+		if result.Distinct || !tokens.Lookahead(0, TokenTypeCloseParen) {
+			for {
+				var argument Expression
+				argument, tokens, err = ParseExpression(tokens)
+				if err != nil {
+					return nil, nil, err
+				}
+				result.Arguments = append(result.Arguments, argument)
+
+				err = tokens.Consume(TokenTypeComma)
+				if err != nil {
+					break
+				}
+			}
+		}
+	}
+
+	err = tokens.Consume(TokenTypeCloseParen)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, tokens, nil
+}
+
 func ParseString(tokens *TokenList) (Expression, *TokenList, error) {
 	token, err := tokens.Get(TokenTypeString)
 	if err != nil {
@@ -18,6 +18,74 @@ func TestParse(t *testing.T) {
 		t.Error("Parse did not return error for statement with extra text at the end")
 	}
 
+	_, err = Parse("select foo from bar cross join baz on bar.x = baz.x")
+	if err == nil {
+		t.Error("Parse did not return error for cross join with condition")
+	}
+
+}
+
+func TestParseStatement(t *testing.T) {
+	cases := []struct {
+		input string
+		want  Statement
+	}{
+		{
+			"select * from films;",
+			&SelectStatement{What: Star{}, From: TableName{Name: "films"}},
+		},
+		{
+			"create index films_release on films (release_date)",
+			&CreateIndexStatement{Name: "films_release", Table: "films", Columns: []string{"release_date"}},
+		},
+		{
+			"create index films_director_name on films (director, name);",
+			&CreateIndexStatement{
+				Name:    "films_director_name",
+				Table:   "films",
+				Columns: []string{"director", "name"},
+			},
+		},
+		{
+			"create index films_id on films using hash (id)",
+			&CreateIndexStatement{Name: "films_id", Table: "films", Method: "hash", Columns: []string{"id"}},
+		},
+		{
+			"drop index films_release",
+			&DropIndexStatement{Name: "films_release"},
+		},
+	}
+	for _, c := range cases {
+		got, err := ParseStatement(c.input)
+		if err != nil {
+			t.Errorf("ParseStatement returned error for %q: %v", c.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ParseStatement for %q returned\n%v, want\n%v", c.input, got, c.want)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"films",
+		"create films",
+		"create index on films (id)",
+		"create index i films (id)",
+		"create index i on films",
+		"create index i on films ()",
+		"create index i on films (id) where id = 1",
+		"create index i on films using (id)",
+		"create index i on films (id) using hash",
+		"drop index",
+		"drop films",
+		"drop index a, b",
+	}
+	for _, input := range invalid {
+		if _, err := ParseStatement(input); err == nil {
+			t.Errorf("ParseStatement did not return error for %q", input)
+		}
+	}
 }
 
 func checkParser[T any](t *testing.T, name string, parse Parser[T], input string, want T) {
@@ -64,6 +132,12 @@ var condition1 = &BinaryOperation{
 	Right:    ColumnReference{Relation: "bar", Name: "x"},
 }
 
+var condition2 = &BinaryOperation{
+	Left:     ColumnReference{Relation: "bar", Name: "y"},
+	Operator: BinaryOperatorEq,
+	Right:    ColumnReference{Relation: "baz", Name: "y"},
+}
+
 func TestParseSelectStatement(t *testing.T) {
 	cases := []struct {
 		input string
@@ -84,8 +158,8 @@ func TestParseSelectStatement(t *testing.T) {
 				What: Star{},
 				From: &Join{
 					Type:      JoinTypeInner,
-					Left:      TableName{"foo"},
-					Right:     TableName{"bar"},
+					Left:      TableName{Name: "foo"},
+					Right:     TableName{Name: "bar"},
 					Condition: condition1,
 				},
 			},
@@ -102,6 +176,82 @@ func TestParseSelectStatement(t *testing.T) {
 				},
 			},
 		},
+		{
+			"select x from foo order by x desc, 2 nulls first, y asc nulls last",
+			&SelectStatement{
+				What: ExpressionList{
+					[]Expression{ColumnReference{Name: "x"}},
+				},
+				From: TableName{Name: "foo"},
+				OrderBy: []SortKey{
+					{ColumnReference{Name: "x"}, true, NullsDefault},
+					{Number{types.NewDecimal("2")}, false, NullsFirst},
+					{ColumnReference{Name: "y"}, false, NullsLast},
+				},
+			},
+		},
+		{
+			"select x, count(*) from foo group by x, y",
+			&SelectStatement{
+				What: ExpressionList{
+					[]Expression{
+						ColumnReference{Name: "x"},
+						&FunctionCall{Name: "count", Star: true},
+					},
+				},
+				From:    TableName{Name: "foo"},
+				GroupBy: []Expression{ColumnReference{Name: "x"}, ColumnReference{Name: "y"}},
+			},
+		},
+		{
+			"select x from foo group by x having count(*) > 1",
+			&SelectStatement{
+				What: ExpressionList{
+					[]Expression{ColumnReference{Name: "x"}},
+				},
+				From:    TableName{Name: "foo"},
+				GroupBy: []Expression{ColumnReference{Name: "x"}},
+				Having: &BinaryOperation{
+					Left:     &FunctionCall{Name: "count", Star: true},
+					Operator: BinaryOperatorGt,
+					Right:    Number{types.NewDecimal("1")},
+				},
+			},
+		},
+		{
+			"select distinct x, count(distinct y) from foo",
+			&SelectStatement{
+				Distinct: true,
+				What: ExpressionList{
+					[]Expression{
+						ColumnReference{Name: "x"},
+						&FunctionCall{
+							Name:      "count",
+							Distinct:  true,
+							Arguments: []Expression{ColumnReference{Name: "y"}},
+						},
+					},
+				},
+				From: TableName{Name: "foo"},
+			},
+		},
+		{
+			"select * from foo limit 10 offset 5",
+			&SelectStatement{
+				What:   Star{},
+				From:   TableName{Name: "foo"},
+				Limit:  Number{types.NewDecimal("10")},
+				Offset: Number{types.NewDecimal("5")},
+			},
+		},
+		{
+			"select * from foo offset 5",
+			&SelectStatement{
+				What:   Star{},
+				From:   TableName{Name: "foo"},
+				Offset: Number{types.NewDecimal("5")},
+			},
+		},
 	}
 	for _, c := range cases {
 		checkParser(t, "ParseSelectStatement", ParseSelectStatement, c.input, c.want)
@@ -111,6 +261,19 @@ func TestParseSelectStatement(t *testing.T) {
 		"",
 		"select x, * from foo",
 		"select x, y from",
+		"select x from foo order x",
+		"select x from foo order by",
+		"select x from foo order by x nulls",
+		"select x from foo order by x,",
+		"select x from foo group x",
+		"select x from foo group by",
+		"select x from foo group by x having",
+		"select x from foo limit",
+		"select x from foo limit 1 offset",
+		"select count(distinct) from foo",
+		"select count(distinct *) from foo",
+		"select x as from foo",
+		"select x as y z from foo",
 	}
 	for _, input := range invalid {
 		checkParserInvalid(t, "ParseSelectStatement", ParseSelectStatement, input)
@@ -122,13 +285,13 @@ func TestParseTableReference(t *testing.T) {
 		input string
 		want  TableReference
 	}{
-		{"foo", TableName{"foo"}},
+		{"foo", TableName{Name: "foo"}},
 		{
 			"foo join bar on foo.x = bar.x",
 			&Join{
 				Type:      JoinTypeInner,
-				Left:      TableName{"foo"},
-				Right:     TableName{"bar"},
+				Left:      TableName{Name: "foo"},
+				Right:     TableName{Name: "bar"},
 				Condition: condition1,
 			},
 		},
@@ -136,11 +299,128 @@ func TestParseTableReference(t *testing.T) {
 			"foo left outer join bar on foo.x = bar.x",
 			&Join{
 				Type:      JoinTypeLeftOuter,
-				Left:      TableName{"foo"},
-				Right:     TableName{"bar"},
+				Left:      TableName{Name: "foo"},
+				Right:     TableName{Name: "bar"},
+				Condition: condition1,
+			},
+		},
+		{"foo f", TableName{Name: "foo", Alias: "f"}},
+		{"foo as f", TableName{Name: "foo", Alias: "f"}},
+		{
+			"foo a join foo as b on a.x = b.x",
+			&Join{
+				Type:  JoinTypeInner,
+				Left:  TableName{Name: "foo", Alias: "a"},
+				Right: TableName{Name: "foo", Alias: "b"},
+				Condition: &BinaryOperation{
+					Left:     ColumnReference{Relation: "a", Name: "x"},
+					Operator: BinaryOperatorEq,
+					Right:    ColumnReference{Relation: "b", Name: "x"},
+				},
+			},
+		},
+		{
+			"foo inner join bar on foo.x = bar.x",
+			&Join{
+				Type:      JoinTypeInner,
+				Left:      TableName{Name: "foo"},
+				Right:     TableName{Name: "bar"},
+				Condition: condition1,
+			},
+		},
+		{
+			"foo full join bar on foo.x = bar.x",
+			&Join{
+				Type:      JoinTypeFullOuter,
+				Left:      TableName{Name: "foo"},
+				Right:     TableName{Name: "bar"},
+				Condition: condition1,
+			},
+		},
+		{
+			"foo cross join bar",
+			&Join{
+				Type:  JoinTypeCross,
+				Left:  TableName{Name: "foo"},
+				Right: TableName{Name: "bar"},
+			},
+		},
+		{
+			"foo join bar on foo.x = bar.x left join baz on bar.y = baz.y",
+			&Join{
+				Type: JoinTypeLeftOuter,
+				Left: &Join{
+					Type:      JoinTypeInner,
+					Left:      TableName{Name: "foo"},
+					Right:     TableName{Name: "bar"},
+					Condition: condition1,
+				},
+				Right:     TableName{Name: "baz"},
+				Condition: condition2,
+			},
+		},
+		{
+			"foo full outer join (bar join baz on bar.y = baz.y) on foo.x = bar.x",
+			&Join{
+				Type: JoinTypeFullOuter,
+				Left: TableName{Name: "foo"},
+				Right: &Join{
+					Type:      JoinTypeInner,
+					Left:      TableName{Name: "bar"},
+					Right:     TableName{Name: "baz"},
+					Condition: condition2,
+				},
 				Condition: condition1,
 			},
 		},
+		{
+			"foo, bar join baz on bar.y = baz.y",
+			&Join{
+				Type: JoinTypeCross,
+				Left: TableName{Name: "foo"},
+				Right: &Join{
+					Type:      JoinTypeInner,
+					Left:      TableName{Name: "bar"},
+					Right:     TableName{Name: "baz"},
+					Condition: condition2,
+				},
+			},
+		},
+		{
+			"foo join bar using (x, y)",
+			&Join{
+				Type:  JoinTypeInner,
+				Left:  TableName{Name: "foo"},
+				Right: TableName{Name: "bar"},
+				Using: []string{"x", "y"},
+			},
+		},
+		{
+			"foo natural left join bar natural join baz",
+			&Join{
+				Type: JoinTypeInner,
+				Left: &Join{
+					Type:    JoinTypeLeftOuter,
+					Left:    TableName{Name: "foo"},
+					Right:   TableName{Name: "bar"},
+					Natural: true,
+				},
+				Right:   TableName{Name: "baz"},
+				Natural: true,
+			},
+		},
+		{
+			"foo f, bar, baz",
+			&Join{
+				Type: JoinTypeCross,
+				Left: &Join{
+					Type:  JoinTypeCross,
+					Left:  TableName{Name: "foo", Alias: "f"},
+					Right: TableName{Name: "bar"},
+				},
+				Right: TableName{Name: "baz"},
+			},
+		},
 	}
 	for _, c := range cases {
 		checkParser(t, "ParseTableReference", ParseTableReference, c.input, c.want)
@@ -149,6 +429,20 @@ func TestParseTableReference(t *testing.T) {
 	invalid := []string{
 		"",
 		"123",
+		"foo,",
+		"foo cross bar",
+		"foo full bar on foo.x = bar.x",
+		"foo join bar using",
+		"foo join bar using ()",
+		"foo join bar using (x,)",
+		"foo join bar using x",
+		"foo natural cross join bar",
+		"foo natural bar",
+		"(foo join bar on foo.x = bar.x",
+		"()",
+		"foo join bar on foo.x = bar.x join",
+		"foo as",
+		"foo as 'f'",
 		"foo join bar",
 		"foo join bar on",
 		"foo join on foo.x = bar.x",
@@ -172,6 +466,31 @@ func TestParseSelectList(t *testing.T) {
 				Expressions: []Expression{String{Value: "a"}, String{Value: "b"}, String{Value: "c"}},
 			},
 		},
+		{
+			"a as x, b y, c",
+			ExpressionList{
+				Expressions: []Expression{
+					&Alias{Expression: ColumnReference{Name: "a"}, Name: "x"},
+					&Alias{Expression: ColumnReference{Name: "b"}, Name: "y"},
+					ColumnReference{Name: "c"},
+				},
+			},
+		},
+		{
+			"a * 2 total",
+			ExpressionList{
+				Expressions: []Expression{
+					&Alias{
+						Expression: &BinaryOperation{
+							Left:     ColumnReference{Name: "a"},
+							Operator: BinaryOperatorMul,
+							Right:    Number{types.NewDecimal("2")},
+						},
+						Name: "total",
+					},
+				},
+			},
+		},
 	}
 	for _, c := range cases {
 		checkParser(t, "ParseSelectList", ParseSelectList, c.input, c.want)
@@ -179,6 +498,8 @@ func TestParseSelectList(t *testing.T) {
 
 	invalid := []string{
 		"foo,",
+		"foo, bar as",
+		"foo, bar as 'x'",
 	}
 	for _, input := range invalid {
 		checkParserInvalid(t, "ParseSelectList", ParseSelectList, input)
@@ -224,6 +545,144 @@ func TestParseExpression(t *testing.T) {
 				Operator: UnaryOperatorIsNotNull,
 			},
 		},
+		{
+			"not foo",
+			&UnaryOperation{
+				Operand:  ColumnReference{Name: "foo"},
+				Operator: UnaryOperatorNot,
+			},
+		},
+		{
+			"a or b and not c",
+			&BinaryOperation{
+				Left:     ColumnReference{Name: "a"},
+				Operator: BinaryOperatorOr,
+				Right: &BinaryOperation{
+					Left:     ColumnReference{Name: "b"},
+					Operator: BinaryOperatorAnd,
+					Right: &UnaryOperation{
+						Operand:  ColumnReference{Name: "c"},
+						Operator: UnaryOperatorNot,
+					},
+				},
+			},
+		},
+		{
+			"a and b and c",
+			&BinaryOperation{
+				Left: &BinaryOperation{
+					Left:     ColumnReference{Name: "a"},
+					Operator: BinaryOperatorAnd,
+					Right:    ColumnReference{Name: "b"},
+				},
+				Operator: BinaryOperatorAnd,
+				Right:    ColumnReference{Name: "c"},
+			},
+		},
+		{
+			"(a or b) and x = 1",
+			&BinaryOperation{
+				Left: &BinaryOperation{
+					Left:     ColumnReference{Name: "a"},
+					Operator: BinaryOperatorOr,
+					Right:    ColumnReference{Name: "b"},
+				},
+				Operator: BinaryOperatorAnd,
+				Right: &BinaryOperation{
+					Left:     ColumnReference{Name: "x"},
+					Operator: BinaryOperatorEq,
+					Right:    Number{types.NewDecimal("1")},
+				},
+			},
+		},
+		{
+			"((a))",
+			ColumnReference{Name: "a"},
+		},
+		{
+			"a + b * c",
+			&BinaryOperation{
+				Left:     ColumnReference{Name: "a"},
+				Operator: BinaryOperatorAdd,
+				Right: &BinaryOperation{
+					Left:     ColumnReference{Name: "b"},
+					Operator: BinaryOperatorMul,
+					Right:    ColumnReference{Name: "c"},
+				},
+			},
+		},
+		{
+			"a - b - c",
+			&BinaryOperation{
+				Left: &BinaryOperation{
+					Left:     ColumnReference{Name: "a"},
+					Operator: BinaryOperatorSub,
+					Right:    ColumnReference{Name: "b"},
+				},
+				Operator: BinaryOperatorSub,
+				Right:    ColumnReference{Name: "c"},
+			},
+		},
+		{
+			"(a + b) % 2 / -c",
+			&BinaryOperation{
+				Left: &BinaryOperation{
+					Left: &BinaryOperation{
+						Left:     ColumnReference{Name: "a"},
+						Operator: BinaryOperatorAdd,
+						Right:    ColumnReference{Name: "b"},
+					},
+					Operator: BinaryOperatorMod,
+					Right:    Number{types.NewDecimal("2")},
+				},
+				Operator: BinaryOperatorDiv,
+				Right: &UnaryOperation{
+					Operand:  ColumnReference{Name: "c"},
+					Operator: UnaryOperatorMinus,
+				},
+			},
+		},
+		{
+			"price * quantity >= -1.5",
+			&BinaryOperation{
+				Left: &BinaryOperation{
+					Left:     ColumnReference{Name: "price"},
+					Operator: BinaryOperatorMul,
+					Right:    ColumnReference{Name: "quantity"},
+				},
+				Operator: BinaryOperatorGe,
+				Right:    Number{types.NewDecimal("-1.5")},
+			},
+		},
+		{
+			"id in (1, 2 + 3, x)",
+			&In{
+				Operand: ColumnReference{Name: "id"},
+				List: []Expression{
+					Number{types.NewDecimal("1")},
+					&BinaryOperation{
+						Left:     Number{types.NewDecimal("2")},
+						Operator: BinaryOperatorAdd,
+						Right:    Number{types.NewDecimal("3")},
+					},
+					ColumnReference{Name: "x"},
+				},
+			},
+		},
+		{
+			"a not in ('x') and b",
+			&BinaryOperation{
+				Left: &UnaryOperation{
+					Operand: &In{
+						Operand: ColumnReference{Name: "a"},
+						List:    []Expression{String{"x"}},
+					},
+					Operator: UnaryOperatorNot,
+				},
+				Operator: BinaryOperatorAnd,
+				Right:    ColumnReference{Name: "b"},
+			},
+		},
 	}
 	for _, c := range cases {
 		checkParser(t, "ParseExpression", ParseExpression, c.input, c.want)
@@ -234,6 +693,18 @@ func TestParseExpression(t *testing.T) {
 		"'hello' = ",
 		" = 'hello'",
 		"4 = is null",
+		"a and",
+		"or b",
+		"not",
+		"(a or b",
+		"(a or b) and",
+		"a +",
+		"* b",
+		"a * - ",
+		"a in ()",
+		"a in (1,)",
+		"a in 1",
+		"in (1)",
 	}
 	for _, input := range invalid {
 		checkParserInvalid(t, "ParseExpression", ParseExpression, input)
@@ -251,6 +722,28 @@ func TestParseValue(t *testing.T) {
 		{"foo", ColumnReference{Name: "foo"}},
 		{"foo.bar", ColumnReference{Relation: "foo", Name: "bar"}},
 		{"date '1999-12-31'", Date{Value: types.NewDate(1999, 12, 31)}},
+		{"count(*)", &FunctionCall{Name: "count", Star: true}},
+		{"now()", &FunctionCall{Name: "now"}},
+		{
+			"sum(a * b)",
+			&FunctionCall{
+				Name: "sum",
+				Arguments: []Expression{
+					&BinaryOperation{
+						Left:     ColumnReference{Name: "a"},
+						Operator: BinaryOperatorMul,
+						Right:    ColumnReference{Name: "b"},
+					},
+				},
+			},
+		},
+		{
+			"f(a, 1)",
+			&FunctionCall{
+				Name:      "f",
+				Arguments: []Expression{ColumnReference{Name: "a"}, Number{types.NewDecimal("1")}},
+			},
+		},
 	}
 	for _, c := range cases {
 		checkParser(t, "ParseValue", ParseValue, c.input, c.want)
@@ -259,6 +752,10 @@ func TestParseValue(t *testing.T) {
 	invalid := []string{
 		"",
 		",",
+		"count(",
+		"count(*",
+		"sum(a,)",
+		"sum(a b)",
 	}
 	for _, input := range invalid {
 		checkParserInvalid(t, "ParseValue", ParseValue, input)
@@ -14,9 +14,15 @@ type Statement interface {
 
 // A SelectStatement is a "select ... from ..." query.
 type SelectStatement struct {
-	What  SelectList
-	From  TableReference
-	Where Expression
+	Distinct bool
+	What     SelectList
+	From     TableReference
+	Where    Expression
+	GroupBy  []Expression
+	Having   Expression
+	OrderBy  []SortKey
+	Limit    Expression
+	Offset   Expression
 }
 
 func (q SelectStatement) String() string {
@@ -24,10 +30,114 @@ func (q SelectStatement) String() string {
 	if q.Where != nil {
 		where = fmt.Sprintf(", Where: %s", q.Where.String())
 	}
-	return fmt.Sprintf("SelectStatement(What: %s, From: %s%s)",
+	groupBy := ""
+	if len(q.GroupBy) > 0 {
+		list := make([]string, len(q.GroupBy))
+		for i, e := range q.GroupBy {
+			list[i] = e.String()
+		}
+		groupBy = fmt.Sprintf(", GroupBy: %s", strings.Join(list, ", "))
+	}
+	having := ""
+	if q.Having != nil {
+		having = fmt.Sprintf(", Having: %s", q.Having.String())
+	}
+	orderBy := ""
+	if len(q.OrderBy) > 0 {
+		list := make([]string, len(q.OrderBy))
+		for i, k := range q.OrderBy {
+			list[i] = k.String()
+		}
+		orderBy = fmt.Sprintf(", OrderBy: %s", strings.Join(list, ", "))
+	}
+	limit := ""
+	if q.Limit != nil {
+		limit = fmt.Sprintf(", Limit: %s", q.Limit.String())
+	}
+	offset := ""
+	if q.Offset != nil {
+		offset = fmt.Sprintf(", Offset: %s", q.Offset.String())
+	}
+	distinct := ""
+	if q.Distinct {
+		distinct = "Distinct, "
+	}
+	return fmt.Sprintf("SelectStatement(%sWhat: %s, From: %s%s%s%s%s%s%s)",
+		distinct,
 		q.What.String(),
 		q.From.String(),
-		where)
+		where,
+		groupBy,
+		having,
+		orderBy,
+		limit,
+		offset)
+}
+
+// A CreateIndexStatement is a "create index ... on ... (...)" statement. Method is the name given
+// in a "using" clause, or the empty string if there is none.
+type CreateIndexStatement struct {
+	Name    string
+	Table   string
+	Method  string
+	Columns []string
+}
+
+func (s CreateIndexStatement) String() string {
+	method := ""
+	if s.Method != "" {
+		method = fmt.Sprintf(", Method: %s", s.Method)
+	}
+	return fmt.Sprintf("CreateIndexStatement(Name: %s, Table: %s%s, Columns: %s)",
+		s.Name, s.Table, method, strings.Join(s.Columns, ", "))
+}
+
+// A DropIndexStatement is a "drop index ..." statement.
+type DropIndexStatement struct {
+	Name string
+}
+
+func (s DropIndexStatement) String() string {
+	return fmt.Sprintf("DropIndexStatement(Name: %s)", s.Name)
+}
+
+// A SortKey is an expression in the "order by" clause, with the sort direction and the position of
+// nulls.
+type SortKey struct {
+	Expression Expression
+	Descending bool
+	Nulls      NullsOrder
+}
+
+func (k SortKey) String() string {
+	direction := "asc"
+	if k.Descending {
+		direction = "desc"
+	}
+	return fmt.Sprintf("SortKey(%s, %s, %s)", k.Expression.String(), direction, k.Nulls.String())
+}
+
+// NullsOrder specifies if nulls are sorted before or after other values.
+type NullsOrder int
+
+const (
+	// NullsDefault sorts nulls as if they were larger than any other value, i.e. last for ascending
+	// order and first for descending order.
+	NullsDefault NullsOrder = iota
+	NullsFirst
+	NullsLast
+)
+
+func (o NullsOrder) String() string {
+	switch o {
+	case NullsDefault:
+		return "nulls default"
+	case NullsFirst:
+		return "nulls first"
+	case NullsLast:
+		return "nulls last"
+	}
+	return fmt.Sprintf("<unexpected nulls order: %d>", o)
 }
 
 // A table reference defines a single table or multiple joined tables.
@@ -37,27 +147,61 @@ type TableReference interface {
 
 // A TableName is a TableReference that specifies a single table.
 type TableName struct {
-	Name string
+	Name  string
+	Alias string
 }
 
 func (t TableName) String() string {
-	return fmt.Sprintf("Table(%s)", t.Name)
+	if t.Alias == "" {
+		return fmt.Sprintf("Table(%s)", t.Name)
+	}
+	return fmt.Sprintf("Table(%s, Alias: %s)", t.Name, t.Alias)
 }
 
-// A Join is a TableReference that specifies a join.
+// Reference returns the name used to refer to the table in the query: the alias if there is one,
+// otherwise the table name.
+func (t TableName) Reference() string {
+	if t.Alias == "" {
+		return t.Name
+	}
+	return t.Alias
+}
+
+// A Join is a TableReference that specifies a join. The rows to join are given by a Condition, by
+// a list of columns in a "using" clause, or, for a natural join, by the columns that have the same
+// name in both tables. The Condition is nil for cross joins, natural joins and joins with "using".
 type Join struct {
 	Type      JoinType
 	Left      TableReference
 	Right     TableReference
 	Condition Expression
+	Using     []string
+	Natural   bool
 }
 
 func (j *Join) String() string {
-	return fmt.Sprintf("Join(%s, %s, %s, %s)",
-		j.Type.String(),
+	joinType := j.Type.String()
+	if j.Natural {
+		joinType = "natural " + joinType
+	}
+	switch {
+	case j.Condition != nil:
+		return fmt.Sprintf("Join(%s, %s, %s, %s)",
+			joinType,
+			j.Left.String(),
+			j.Right.String(),
+			j.Condition.String())
+	case len(j.Using) > 0:
+		return fmt.Sprintf("Join(%s, %s, %s, Using(%s))",
+			joinType,
+			j.Left.String(),
+			j.Right.String(),
+			strings.Join(j.Using, ", "))
+	}
+	return fmt.Sprintf("Join(%s, %s, %s)",
+		joinType,
 		j.Left.String(),
-		j.Right.String(),
-		j.Condition.String())
+		j.Right.String())
 }
 
 type JoinType int
@@ -66,6 +210,8 @@ const (
 	JoinTypeInner JoinType = iota
 	JoinTypeLeftOuter
 	JoinTypeRightOuter
+	JoinTypeFullOuter
+	JoinTypeCross
 )
 
 func (t JoinType) String() string {
@@ -76,6 +222,10 @@ func (t JoinType) String() string {
 		return "left outer"
 	case JoinTypeRightOuter:
 		return "right outer"
+	case JoinTypeFullOuter:
+		return "full outer"
+	case JoinTypeCross:
+		return "cross"
 	}
 	return fmt.Sprintf("<unexpected join: %d>", t)
 }
@@ -123,6 +273,39 @@ func (r ColumnReference) String() string {
 	return fmt.Sprintf("Column(%s.%s)", r.Relation, r.Name)
 }
 
+// An Alias gives a name to an expression in the select list, as in "price * quantity as total".
+type Alias struct {
+	Expression Expression
+	Name       string
+}
+
+func (a *Alias) String() string {
+	return fmt.Sprintf("Alias(%s, %s)", a.Expression.String(), a.Name)
+}
+
+// A FunctionCall is a call to a function, for example "count(*)", "sum(price)" or
+// "count(distinct director)".
+type FunctionCall struct {
+	Name      string
+	Star      bool
+	Distinct  bool
+	Arguments []Expression
+}
+
+func (c *FunctionCall) String() string {
+	if c.Star {
+		return fmt.Sprintf("FunctionCall(%s, Star)", c.Name)
+	}
+	list := make([]string, len(c.Arguments))
+	for i, e := range c.Arguments {
+		list[i] = e.String()
+	}
+	if c.Distinct {
+		return fmt.Sprintf("FunctionCall(%s, Distinct, %s)", c.Name, strings.Join(list, ", "))
+	}
+	return fmt.Sprintf("FunctionCall(%s, %s)", c.Name, strings.Join(list, ", "))
+}
+
 // A String is an SQL string literal.
 type String struct {
 	Value string
@@ -184,15 +367,33 @@ const (
 	BinaryOperatorGt
 	BinaryOperatorLe
 	BinaryOperatorGe
+
+	// logical operators
+	BinaryOperatorAnd
+	BinaryOperatorOr
+
+	// arithmetic operators
+	BinaryOperatorAdd
+	BinaryOperatorSub
+	BinaryOperatorMul
+	BinaryOperatorDiv
+	BinaryOperatorMod
 )
 
 var binaryOperatorNames = map[BinaryOperator]string{
-	BinaryOperatorEq: "Eq",
-	BinaryOperatorNe: "Ne",
-	BinaryOperatorLt: "Lt",
-	BinaryOperatorGt: "Gt",
-	BinaryOperatorLe: "Le",
-	BinaryOperatorGe: "Ge",
+	BinaryOperatorEq:  "Eq",
+	BinaryOperatorNe:  "Ne",
+	BinaryOperatorLt:  "Lt",
+	BinaryOperatorGt:  "Gt",
+	BinaryOperatorLe:  "Le",
+	BinaryOperatorGe:  "Ge",
+	BinaryOperatorAnd: "And",
+	BinaryOperatorOr:  "Or",
+	BinaryOperatorAdd: "Add",
+	BinaryOperatorSub: "Sub",
+	BinaryOperatorMul: "Mul",
+	BinaryOperatorDiv: "Div",
+	BinaryOperatorMod: "Mod",
 }
 
 func (o BinaryOperator) String() string {
@@ -202,7 +403,23 @@ func (o BinaryOperator) String() string {
 	return fmt.Sprintf("unexpected binary operator: %d", o)
 }
 
-// A UnaryOperation is an expression with a unary operator, for example "name is not null".
+// An In expression checks if an expression is equal to any of a list of expressions, as in
+// "id in (1, 2, 3)".
+type In struct {
+	Operand Expression
+	List    []Expression
+}
+
+func (e *In) String() string {
+	list := make([]string, len(e.List))
+	for i, x := range e.List {
+		list[i] = x.String()
+	}
+	return fmt.Sprintf("In(Operand: %s, List: %s)", e.Operand.String(), strings.Join(list, ", "))
+}
+
+// A UnaryOperation is an expression with a unary operator, for example "name is not null", "not foo"
+// or "-price".
 type UnaryOperation struct {
 	Operand  Expression
 	Operator UnaryOperator
@@ -220,11 +437,19 @@ const (
 	// comparison with null
 	UnaryOperatorIsNull UnaryOperator = iota
 	UnaryOperatorIsNotNull
+
+	// logical negation
+	UnaryOperatorNot
+
+	// arithmetic negation
+	UnaryOperatorMinus
 )
 
 var unaryOperatorNames = map[UnaryOperator]string{
 	UnaryOperatorIsNull:    "IsNull",
 	UnaryOperatorIsNotNull: "IsNotNull",
+	UnaryOperatorNot:       "Not",
+	UnaryOperatorMinus:     "Minus",
 }
 
 func (o UnaryOperator) String() string {
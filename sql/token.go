@@ -27,6 +27,10 @@ const (
 	TokenTypeGt
 	TokenTypeLe
 	TokenTypeGe
+	TokenTypePlus
+	TokenTypeMinus
+	TokenTypeSlash
+	TokenTypePercent
 
 	// keywords
 	TokenTypeSelect
@@ -45,6 +49,28 @@ const (
 	TokenTypeFalse
 	TokenTypeTrue
 	TokenTypeDate
+	TokenTypeOrder
+	TokenTypeBy
+	TokenTypeAsc
+	TokenTypeDesc
+	TokenTypeNulls
+	TokenTypeFirst
+	TokenTypeLast
+	TokenTypeLimit
+	TokenTypeOffset
+	TokenTypeGroup
+	TokenTypeHaving
+	TokenTypeDistinct
+	TokenTypeAs
+	TokenTypeInner
+	TokenTypeFull
+	TokenTypeCross
+	TokenTypeNatural
+	TokenTypeUsing
+	TokenTypeCreate
+	TokenTypeDrop
+	TokenTypeIndex
+	TokenTypeIn
 )
 
 var tokenTypeNames = map[TokenType]string{
@@ -63,6 +89,10 @@ var tokenTypeNames = map[TokenType]string{
 	TokenTypeGt:         "gt",
 	TokenTypeLe:         "le",
 	TokenTypeGe:         "ge",
+	TokenTypePlus:       "plus",
+	TokenTypeMinus:      "minus",
+	TokenTypeSlash:      "slash",
+	TokenTypePercent:    "percent",
 	TokenTypeSelect:     "select",
 	TokenTypeFrom:       "from",
 	TokenTypeWhere:      "where",
@@ -79,6 +109,28 @@ var tokenTypeNames = map[TokenType]string{
 	TokenTypeFalse:      "false",
 	TokenTypeTrue:       "true",
 	TokenTypeDate:       "date",
+	TokenTypeOrder:      "order",
+	TokenTypeBy:         "by",
+	TokenTypeAsc:        "asc",
+	TokenTypeDesc:       "desc",
+	TokenTypeNulls:      "nulls",
+	TokenTypeFirst:      "first",
+	TokenTypeLast:       "last",
+	TokenTypeLimit:      "limit",
+	TokenTypeOffset:     "offset",
+	TokenTypeGroup:      "group",
+	TokenTypeHaving:     "having",
+	TokenTypeDistinct:   "distinct",
+	TokenTypeAs:         "as",
+	TokenTypeInner:      "inner",
+	TokenTypeFull:       "full",
+	TokenTypeCross:      "cross",
+	TokenTypeNatural:    "natural",
+	TokenTypeUsing:      "using",
+	TokenTypeCreate:     "create",
+	TokenTypeDrop:       "drop",
+	TokenTypeIndex:      "index",
+	TokenTypeIn:         "in",
 }
 
 func (t TokenType) String() string {
@@ -89,22 +141,44 @@ func (t TokenType) String() string {
 }
 
 var keywordMap = map[string]TokenType{
-	"select": TokenTypeSelect,
-	"from":   TokenTypeFrom,
-	"where":  TokenTypeWhere,
-	"and":    TokenTypeAnd,
-	"or":     TokenTypeOr,
-	"not":    TokenTypeNot,
-	"is":     TokenTypeIs,
-	"null":   TokenTypeNull,
-	"left":   TokenTypeLeft,
-	"right":  TokenTypeRight,
-	"outer":  TokenTypeOuter,
-	"join":   TokenTypeJoin,
-	"on":     TokenTypeOn,
-	"false":  TokenTypeFalse,
-	"true":   TokenTypeTrue,
-	"date":   TokenTypeDate,
+	"select":   TokenTypeSelect,
+	"from":     TokenTypeFrom,
+	"where":    TokenTypeWhere,
+	"and":      TokenTypeAnd,
+	"or":       TokenTypeOr,
+	"not":      TokenTypeNot,
+	"is":       TokenTypeIs,
+	"null":     TokenTypeNull,
+	"left":     TokenTypeLeft,
+	"right":    TokenTypeRight,
+	"outer":    TokenTypeOuter,
+	"join":     TokenTypeJoin,
+	"on":       TokenTypeOn,
+	"false":    TokenTypeFalse,
+	"true":     TokenTypeTrue,
+	"date":     TokenTypeDate,
+	"order":    TokenTypeOrder,
+	"by":       TokenTypeBy,
+	"asc":      TokenTypeAsc,
+	"desc":     TokenTypeDesc,
+	"nulls":    TokenTypeNulls,
+	"first":    TokenTypeFirst,
+	"last":     TokenTypeLast,
+	"limit":    TokenTypeLimit,
+	"offset":   TokenTypeOffset,
+	"group":    TokenTypeGroup,
+	"having":   TokenTypeHaving,
+	"distinct": TokenTypeDistinct,
+	"as":       TokenTypeAs,
+	"inner":    TokenTypeInner,
+	"full":     TokenTypeFull,
+	"cross":    TokenTypeCross,
+	"natural":  TokenTypeNatural,
+	"using":    TokenTypeUsing,
+	"create":   TokenTypeCreate,
+	"drop":     TokenTypeDrop,
+	"index":    TokenTypeIndex,
+	"in":       TokenTypeIn,
 }
 
 var punctuationMap = map[string]TokenType{
@@ -121,6 +195,10 @@ var punctuationMap = map[string]TokenType{
 	">":  TokenTypeGt,
 	"<=": TokenTypeLe,
 	">=": TokenTypeGe,
+	"+":  TokenTypePlus,
+	"-":  TokenTypeMinus,
+	"/":  TokenTypeSlash,
+	"%":  TokenTypePercent,
 }
 
 type Token struct {
@@ -32,6 +32,12 @@ func (l *TokenList) Peek(expected ...TokenType) (Token, error) {
 	return first, nil
 }
 
+// Lookahead returns true if the token at index i exists and has type t. Lookahead(0, t) checks the
+// next token.
+func (l *TokenList) Lookahead(i int, t TokenType) bool {
+	return i < len(l.tokens) && l.tokens[i].Type == t
+}
+
 // Get removes the next token from the list and returns it. The arguments are used in the same way
 // as for Peek.
 func (l *TokenList) Get(expected ...TokenType) (Token, error) {
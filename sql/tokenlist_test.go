@@ -63,6 +63,26 @@ func TestTokenListPeek(t *testing.T) {
 	}
 }
 
+func TestTokenListLookahead(t *testing.T) {
+	cases := []struct {
+		i    int
+		t    TokenType
+		want bool
+	}{
+		{0, TokenTypeSelect, true},
+		{1, TokenTypeStar, true},
+		{1, TokenTypeSelect, false},
+		{3, TokenTypeIdentifier, true},
+		{4, TokenTypeIdentifier, false},
+	}
+	for _, c := range cases {
+		got := someTokens.Lookahead(c.i, c.t)
+		if got != c.want {
+			t.Errorf("Lookahead(%d, %v) returned %v, want %v", c.i, c.t, got, c.want)
+		}
+	}
+}
+
 func TestTokenListGet(t *testing.T) {
 	l := someTokens
 	got, err := l.Get()
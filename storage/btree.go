@@ -0,0 +1,295 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/lfritz/toydb/types"
+)
+
+// btreeMaxSize is the largest number of entries in a leaf, and of children of an inner node, of a
+// B+tree. Nodes other than the root have at least half as many.
+const btreeMaxSize = 64
+
+// A btree is a B+tree mapping keys to rows. A key can appear with several rows, so each entry is
+// identified by its key and row together, and entries are ordered by key, then by row. Keys are
+// compared column by column with types.Value ordering, with nulls after other values.
+//
+// The leaves are linked, so a range of entries can be read by finding its start and following the
+// links.
+type btree struct {
+	root *btreeNode
+}
+
+// A btreeNode is a leaf, which holds entries, or an inner node, which holds children and the keys
+// separating them: all entries under children[i] are smaller than keys[i], and all entries under
+// children[i+1] are at least keys[i].
+type btreeNode struct {
+	leaf     bool
+	entries  []indexEntry // for leaves
+	next     *btreeNode   // for leaves
+	keys     []indexEntry // for inner nodes
+	children []*btreeNode // for inner nodes
+}
+
+// An indexEntry is an entry in an index: the key of a row, and where the row is stored.
+type indexEntry struct {
+	key []types.Value
+	row rowID
+}
+
+// A rowID identifies a row of a table: its page and slot in a heap file, or, for a table kept in
+// memory, its index in the table's rows, as the slot.
+type rowID struct {
+	page, slot int
+}
+
+func newBtree() *btree {
+	return &btree{root: &btreeNode{leaf: true}}
+}
+
+func (n *btreeNode) size() int {
+	if n.leaf {
+		return len(n.entries)
+	}
+	return len(n.children)
+}
+
+// compareKeys compares two keys column by column, or a prefix of them if one is shorter, and
+// returns -1, 0 or 1.
+func compareKeys(a, b []types.Value) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		x, y := a[i], b[i]
+		switch {
+		case x.Null() && y.Null():
+			continue
+		case x.Null():
+			return 1
+		case y.Null():
+			return -1
+		}
+		switch x.Compare(y) {
+		case types.ComparedLt:
+			return -1
+		case types.ComparedGt:
+			return 1
+		case types.ComparedEq:
+			continue
+		default:
+			panic(fmt.Sprintf("cannot compare %v and %v", x, y))
+		}
+	}
+	return 0
+}
+
+func compareEntries(a, b indexEntry) int {
+	if c := compareKeys(a.key, b.key); c != 0 {
+		return c
+	}
+	if c := compareInts(a.row.page, b.row.page); c != 0 {
+		return c
+	}
+	return compareInts(a.row.slot, b.row.slot)
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// search returns the index of the first entry for which before returns false, assuming it returns
+// true for a prefix of the entries and false for the rest.
+func search(entries []indexEntry, before func(indexEntry) bool) int {
+	lo, hi := 0, len(entries)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if before(entries[mid]) {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// child returns the index of the child of an inner node that an entry belongs under.
+func (n *btreeNode) child(e indexEntry) int {
+	return search(n.keys, func(k indexEntry) bool { return compareEntries(k, e) <= 0 })
+}
+
+// insert adds an entry to the tree.
+func (t *btree) insert(e indexEntry) {
+	right, separator, split := t.root.insert(e)
+	if split {
+		t.root = &btreeNode{
+			keys:     []indexEntry{separator},
+			children: []*btreeNode{t.root, right},
+		}
+	}
+}
+
+// insert adds an entry under a node. If the node gets too large, it splits it, keeping the first
+// half, and returns the new node with the second half and the key separating them.
+func (n *btreeNode) insert(e indexEntry) (*btreeNode, indexEntry, bool) {
+	if n.leaf {
+		i := search(n.entries, func(x indexEntry) bool { return compareEntries(x, e) < 0 })
+		n.entries = append(n.entries, indexEntry{})
+		copy(n.entries[i+1:], n.entries[i:])
+		n.entries[i] = e
+		if len(n.entries) <= btreeMaxSize {
+			return nil, indexEntry{}, false
+		}
+		mid := len(n.entries) / 2
+		right := &btreeNode{
+			leaf:    true,
+			entries: append([]indexEntry(nil), n.entries[mid:]...),
+			next:    n.next,
+		}
+		n.entries = n.entries[:mid:mid]
+		n.next = right
+		return right, right.entries[0], true
+	}
+
+	i := n.child(e)
+	right, separator, split := n.children[i].insert(e)
+	if !split {
+		return nil, indexEntry{}, false
+	}
+	n.keys = append(n.keys, indexEntry{})
+	copy(n.keys[i+1:], n.keys[i:])
+	n.keys[i] = separator
+	n.children = append(n.children, nil)
+	copy(n.children[i+2:], n.children[i+1:])
+	n.children[i+1] = right
+	if len(n.children) <= btreeMaxSize {
+		return nil, indexEntry{}, false
+	}
+	mid := len(n.keys) / 2
+	separator = n.keys[mid]
+	right = &btreeNode{
+		keys:     append([]indexEntry(nil), n.keys[mid+1:]...),
+		children: append([]*btreeNode(nil), n.children[mid+1:]...),
+	}
+	n.keys = n.keys[:mid:mid]
+	n.children = n.children[: mid+1 : mid+1]
+	return right, separator, true
+}
+
+// delete removes an entry from the tree, and returns false if it wasn't found.
+func (t *btree) delete(e indexEntry) bool {
+	if !t.root.delete(e) {
+		return false
+	}
+	if !t.root.leaf && len(t.root.children) == 1 {
+		t.root = t.root.children[0]
+	}
+	return true
+}
+
+// delete removes an entry under a node. If that leaves a child with fewer than the minimum number of
+// entries or children, it moves one over from a sibling or merges the child with a sibling.
+func (n *btreeNode) delete(e indexEntry) bool {
+	if n.leaf {
+		i := search(n.entries, func(x indexEntry) bool { return compareEntries(x, e) < 0 })
+		if i == len(n.entries) || compareEntries(n.entries[i], e) != 0 {
+			return false
+		}
+		n.entries = append(n.entries[:i], n.entries[i+1:]...)
+		return true
+	}
+
+	i := n.child(e)
+	if !n.children[i].delete(e) {
+		return false
+	}
+	if n.children[i].size() < btreeMaxSize/2 {
+		n.rebalance(i)
+	}
+	return true
+}
+
+// rebalance fixes a child that has too few entries or children.
+func (n *btreeNode) rebalance(i int) {
+	child := n.children[i]
+	switch {
+	case i > 0 && n.children[i-1].size() > btreeMaxSize/2:
+		left := n.children[i-1]
+		if child.leaf {
+			last := left.entries[len(left.entries)-1]
+			left.entries = left.entries[:len(left.entries)-1]
+			child.entries = append([]indexEntry{last}, child.entries...)
+			n.keys[i-1] = last
+		} else {
+			lastKey, lastChild := left.keys[len(left.keys)-1], left.children[len(left.children)-1]
+			left.keys = left.keys[:len(left.keys)-1]
+			left.children = left.children[:len(left.children)-1]
+			child.keys = append([]indexEntry{n.keys[i-1]}, child.keys...)
+			child.children = append([]*btreeNode{lastChild}, child.children...)
+			n.keys[i-1] = lastKey
+		}
+	case i < len(n.children)-1 && n.children[i+1].size() > btreeMaxSize/2:
+		right := n.children[i+1]
+		if child.leaf {
+			child.entries = append(child.entries, right.entries[0])
+			right.entries = append([]indexEntry(nil), right.entries[1:]...)
+			n.keys[i] = right.entries[0]
+		} else {
+			child.keys = append(child.keys, n.keys[i])
+			child.children = append(child.children, right.children[0])
+			n.keys[i] = right.keys[0]
+			right.keys = append([]indexEntry(nil), right.keys[1:]...)
+			right.children = append([]*btreeNode(nil), right.children[1:]...)
+		}
+	case i > 0:
+		n.merge(i - 1)
+	default:
+		n.merge(i)
+	}
+}
+
+// merge merges child i+1 into child i.
+func (n *btreeNode) merge(i int) {
+	left, right := n.children[i], n.children[i+1]
+	if left.leaf {
+		left.entries = append(left.entries, right.entries...)
+		left.next = right.next
+	} else {
+		left.keys = append(append(left.keys, n.keys[i]), right.keys...)
+		left.children = append(left.children, right.children...)
+	}
+	n.keys = append(n.keys[:i], n.keys[i+1:]...)
+	n.children = append(n.children[:i+1], n.children[i+2:]...)
+}
+
+// seek returns a cursor at the first entry for which before returns false, assuming it returns true
+// for the entries up to some point and false for the rest.
+func (t *btree) seek(before func(indexEntry) bool) *btreeCursor {
+	n := t.root
+	for !n.leaf {
+		n = n.children[search(n.keys, before)]
+	}
+	return &btreeCursor{leaf: n, i: search(n.entries, before)}
+}
+
+// A btreeCursor reads the entries of a B+tree in order, starting at some entry.
+type btreeCursor struct {
+	leaf *btreeNode
+	i    int
+}
+
+// next returns the next entry, or false if there are no more.
+func (c *btreeCursor) next() (indexEntry, bool) {
+	for c.i >= len(c.leaf.entries) {
+		if c.leaf.next == nil {
+			return indexEntry{}, false
+		}
+		c.leaf, c.i = c.leaf.next, 0
+	}
+	e := c.leaf.entries[c.i]
+	c.i++
+	return e, true
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/lfritz/toydb/types"
+)
+
+// checkBtree checks the invariants of a B+tree and returns its entries, read by following the links
+// between leaves.
+func checkBtree(t *testing.T, tree *btree) []indexEntry {
+	t.Helper()
+	var depth []int
+	var check func(n *btreeNode, level int, lower, upper *indexEntry)
+	check = func(n *btreeNode, level int, lower, upper *indexEntry) {
+		if n != tree.root && n.size() < btreeMaxSize/2 || n.size() > btreeMaxSize {
+			t.Fatalf("node has size %d", n.size())
+		}
+		if n.leaf {
+			depth = append(depth, level)
+			for i, e := range n.entries {
+				if i > 0 && compareEntries(n.entries[i-1], e) >= 0 {
+					t.Fatalf("leaf entries out of order")
+				}
+				if lower != nil && compareEntries(e, *lower) < 0 || upper != nil && compareEntries(e, *upper) >= 0 {
+					t.Fatalf("leaf entry outside the range given by its parents")
+				}
+			}
+			return
+		}
+		if len(n.keys) != len(n.children)-1 {
+			t.Fatalf("inner node has %d keys and %d children", len(n.keys), len(n.children))
+		}
+		for i, child := range n.children {
+			childLower, childUpper := lower, upper
+			if i > 0 {
+				childLower = &n.keys[i-1]
+			}
+			if i < len(n.keys) {
+				childUpper = &n.keys[i]
+			}
+			check(child, level+1, childLower, childUpper)
+		}
+	}
+	check(tree.root, 0, nil, nil)
+	for _, d := range depth {
+		if d != depth[0] {
+			t.Fatalf("leaves at different depths")
+		}
+	}
+
+	var result []indexEntry
+	c := tree.seek(func(indexEntry) bool { return false })
+	for {
+		e, ok := c.next()
+		if !ok {
+			return result
+		}
+		result = append(result, e)
+	}
+}
+
+func TestBtree(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	tree := newBtree()
+	var want []indexEntry
+	entry := func() indexEntry {
+		// few distinct keys, so there are many duplicates, and some nulls
+		key := types.Dec(string(rune('0' + r.Intn(10))))
+		if r.Intn(20) == 0 {
+			key = types.NewNull(types.TypeDecimal)
+		}
+		return indexEntry{
+			key: []types.Value{key, types.Txt(string(rune('a' + r.Intn(3))))},
+			row: rowID{page: r.Intn(100), slot: r.Intn(100)},
+		}
+	}
+
+	// grow the tree, then shrink it again
+	for round, inserts := range []int{5000, 1000, 100} {
+		for i := 0; i < inserts; i++ {
+			e := entry()
+			j := sort.Search(len(want), func(j int) bool { return compareEntries(want[j], e) >= 0 })
+			if j < len(want) && compareEntries(want[j], e) == 0 {
+				continue
+			}
+			tree.insert(e)
+			want = append(want[:j], append([]indexEntry{e}, want[j:]...)...)
+		}
+		deletes := len(want) * (round + 1) / 4
+		for i := 0; i < deletes; i++ {
+			j := r.Intn(len(want))
+			if !tree.delete(want[j]) {
+				t.Fatalf("delete returned false for entry in tree")
+			}
+			want = append(want[:j], want[j+1:]...)
+		}
+		if got := checkBtree(t, tree); !reflect.DeepEqual(got, want) {
+			t.Fatalf("after round %d, tree has %d entries, want %d", round, len(got), len(want))
+		}
+	}
+
+	// seek finds the first entry with a key of at least 5
+	five := []types.Value{types.Dec("5")}
+	c := tree.seek(func(e indexEntry) bool { return compareKeys(e.key, five) < 0 })
+	got, ok := c.next()
+	j := sort.Search(len(want), func(j int) bool { return compareKeys(want[j].key, five) >= 0 })
+	if !ok || j == len(want) || !reflect.DeepEqual(got, want[j]) {
+		t.Errorf("seek returned %v, %v, want %v", got, ok, want[j])
+	}
+}
+
+func TestBtreeDeleteMissing(t *testing.T) {
+	tree := newBtree()
+	for i := 0; i < 1000; i++ {
+		tree.insert(indexEntry{key: []types.Value{types.Dec("1")}, row: rowID{slot: i}})
+	}
+	if tree.delete(indexEntry{key: []types.Value{types.Dec("1")}, row: rowID{slot: 1000}}) {
+		t.Errorf("delete returned true for missing row")
+	}
+	if tree.delete(indexEntry{key: []types.Value{types.Dec("2")}, row: rowID{slot: 0}}) {
+		t.Errorf("delete returned true for missing key")
+	}
+	for i := 0; i < 1000; i++ {
+		if !tree.delete(indexEntry{key: []types.Value{types.Dec("1")}, row: rowID{slot: i}}) {
+			t.Fatalf("delete returned false for row %d", i)
+		}
+	}
+	if entries := checkBtree(t, tree); len(entries) != 0 {
+		t.Errorf("tree has %d entries after deleting all", len(entries))
+	}
+}
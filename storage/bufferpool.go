@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// DefaultBufferPoolFrames is the number of frames in the buffer pool of a database opened with Open.
+const DefaultBufferPoolFrames = 256
+
+// errBufferPoolFull is returned when a buffer pool needs a frame for a page but all of them are
+// pinned.
+var errBufferPoolFull = errors.New("buffer pool full")
+
+// A BufferPool caches pages of heap files in a fixed number of frames. A page is pinned while it's
+// used and can only be evicted when it's no longer pinned; modified pages are marked as dirty and
+// written back to the file when they're evicted or flushed. It's safe for concurrent use.
+type BufferPool struct {
+	mu     sync.Mutex
+	frames []frame
+	pages  map[pageID]int // frame holding each page
+	free   []int          // frames not holding any page
+	policy EvictionPolicy
+	stats  BufferPoolStats
+}
+
+// A pageID identifies a page of a heap file.
+type pageID struct {
+	heap *heapFile
+	n    int
+}
+
+type frame struct {
+	id    pageID
+	page  page
+	pins  int
+	dirty bool
+}
+
+// BufferPoolStats counts the page requests a buffer pool served from a frame (hits) or had to read
+// from a file (misses), the pages it evicted to make room, and the dirty pages it wrote to a file.
+type BufferPoolStats struct {
+	Hits      int
+	Misses    int
+	Evictions int
+	Writes    int
+}
+
+// NewBufferPool returns an empty buffer pool with the given number of frames that uses policy to
+// choose pages to evict.
+func NewBufferPool(frames int, policy EvictionPolicy) (*BufferPool, error) {
+	if frames < 1 {
+		return nil, fmt.Errorf("invalid number of frames for buffer pool: %d", frames)
+	}
+	b := &BufferPool{
+		frames: make([]frame, frames),
+		pages:  make(map[pageID]int),
+		policy: policy,
+	}
+	for i := frames - 1; i >= 0; i-- {
+		b.free = append(b.free, i)
+	}
+	policy.Init(frames)
+	return b, nil
+}
+
+// Stats returns the statistics collected since the buffer pool was created.
+func (b *BufferPool) Stats() BufferPoolStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stats
+}
+
+// fetch returns the frame holding a page of a heap file, reading it from the file if necessary, and
+// pins it. The caller has to unpin it when it's done with the page.
+func (b *BufferPool) fetch(h *heapFile, n int) (*frame, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := pageID{h, n}
+	if i, ok := b.pages[id]; ok {
+		b.stats.Hits++
+		return b.pin(i), nil
+	}
+	b.stats.Misses++
+	i, err := b.frameFor(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.readPage(n, &b.frames[i].page); err != nil {
+		b.release(i)
+		return nil, err
+	}
+	return b.pin(i), nil
+}
+
+// allocate adds an empty page to the end of a heap file and returns it pinned and dirty.
+func (b *BufferPool) allocate(h *heapFile) (*frame, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := pageID{h, h.pages}
+	i, err := b.frameFor(id)
+	if err != nil {
+		return nil, err
+	}
+	f := &b.frames[i]
+	f.page.init()
+	// write the page right away so the file always holds whole, valid pages
+	if err := h.writePage(id.n, &f.page); err != nil {
+		b.release(i)
+		return nil, err
+	}
+	h.pages++
+	return b.pin(i), nil
+}
+
+// unpin releases a page returned by fetch or allocate. If dirty is set, the caller modified it.
+func (b *BufferPool) unpin(f *frame, dirty bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if f.pins <= 0 {
+		panic(fmt.Sprintf("unpin called for page %d that isn't pinned", f.id.n))
+	}
+	f.pins--
+	f.dirty = f.dirty || dirty
+}
+
+func (b *BufferPool) pin(i int) *frame {
+	f := &b.frames[i]
+	f.pins++
+	b.policy.Access(i)
+	return f
+}
+
+// frameFor returns a frame to hold a page, taking a free frame or evicting the page chosen by the
+// eviction policy.
+func (b *BufferPool) frameFor(id pageID) (int, error) {
+	var i int
+	if n := len(b.free); n > 0 {
+		i = b.free[n-1]
+		b.free = b.free[:n-1]
+	} else {
+		var ok bool
+		i, ok = b.policy.Victim(func(i int) bool {
+			return b.frames[i].pins == 0
+		})
+		if !ok {
+			return 0, fmt.Errorf("%w: all %d frames are pinned", errBufferPoolFull, len(b.frames))
+		}
+		if err := b.write(i); err != nil {
+			return 0, err
+		}
+		delete(b.pages, b.frames[i].id)
+		b.stats.Evictions++
+	}
+	b.frames[i] = frame{id: id}
+	b.pages[id] = i
+	return i, nil
+}
+
+// release returns a frame that doesn't hold a page to the free list.
+func (b *BufferPool) release(i int) {
+	delete(b.pages, b.frames[i].id)
+	b.frames[i] = frame{}
+	b.free = append(b.free, i)
+}
+
+// write writes the page in a frame back to its file if it's dirty.
+func (b *BufferPool) write(i int) error {
+	f := &b.frames[i]
+	if !f.dirty {
+		return nil
+	}
+	if err := f.id.heap.writePage(f.id.n, &f.page); err != nil {
+		return err
+	}
+	f.dirty = false
+	b.stats.Writes++
+	return nil
+}
+
+// Flush writes all dirty pages back to their files.
+func (b *BufferPool) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := range b.frames {
+		if err := b.write(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drop writes the dirty pages of a heap file back to it and removes all its pages from the pool, so
+// the file can be closed. It returns an error if any of them is still pinned.
+func (b *BufferPool) drop(h *heapFile) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for id, i := range b.pages {
+		if id.heap != h {
+			continue
+		}
+		if b.frames[i].pins > 0 {
+			return fmt.Errorf("page %d of %s is still pinned", id.n, h.file.Name())
+		}
+		if err := b.write(i); err != nil {
+			return err
+		}
+		b.release(i)
+	}
+	return nil
+}
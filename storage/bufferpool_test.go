@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/lfritz/toydb/types"
+)
+
+func mustBufferPool(t *testing.T, frames int, policy EvictionPolicy) *BufferPool {
+	t.Helper()
+	pool, err := NewBufferPool(frames, policy)
+	if err != nil {
+		t.Fatalf("NewBufferPool returned error: %v", err)
+	}
+	return pool
+}
+
+// insertRow adds a row to the last page of a heap file, or to a new page if it doesn't fit, without
+// writing to the log.
+func insertRow(t *testing.T, h *heapFile, row []types.Value) {
+	t.Helper()
+	record := types.AppendRow(nil, row)
+	if h.pages > 0 {
+		f, err := h.pool.fetch(h, h.pages-1)
+		if err != nil {
+			t.Fatalf("fetch returned error: %v", err)
+		}
+		_, ok := f.page.insert(record)
+		h.pool.unpin(f, ok)
+		if ok {
+			return
+		}
+	}
+	f, err := h.pool.allocate(h)
+	if err != nil {
+		t.Fatalf("allocate returned error: %v", err)
+	}
+	if _, ok := f.page.insert(record); !ok {
+		t.Fatalf("row doesn't fit on an empty page")
+	}
+	h.pool.unpin(f, true)
+}
+
+// testHeapFile creates a heap file with rows from allTypesRow until it has the given number of
+// pages. It writes the pages to the file and leaves the buffer pool empty, with its statistics
+// reset.
+func testHeapFile(t *testing.T, pool *BufferPool, pages int) *heapFile {
+	t.Helper()
+	h, err := createHeapFile(filepath.Join(t.TempDir(), "t.heap"), pool)
+	if err != nil {
+		t.Fatalf("createHeapFile returned error: %v", err)
+	}
+	t.Cleanup(func() { h.close() })
+	for i := 0; h.pages < pages; i++ {
+		insertRow(t, h, allTypesRow(i))
+	}
+	if err := pool.drop(h); err != nil {
+		t.Fatalf("drop returned error: %v", err)
+	}
+	pool.stats = BufferPoolStats{}
+	return h
+}
+
+func TestNewBufferPoolInvalid(t *testing.T) {
+	if _, err := NewBufferPool(0, NewLRU()); err == nil {
+		t.Errorf("NewBufferPool did not return error for zero frames")
+	}
+}
+
+func TestBufferPoolStats(t *testing.T) {
+	cases := []struct {
+		frames int
+		want   BufferPoolStats
+	}{
+		// all pages fit, so the second scan only has hits
+		{10, BufferPoolStats{Hits: 8, Misses: 8}},
+		// a sequential scan of more pages than frames misses on every page with LRU
+		{4, BufferPoolStats{Misses: 16, Evictions: 12}},
+	}
+	for _, c := range cases {
+		pool := mustBufferPool(t, c.frames, NewLRU())
+		h := testHeapFile(t, pool, 8)
+		for scan := 0; scan < 2; scan++ {
+			for n := 0; n < h.pages; n++ {
+				if _, _, err := h.pageRows(n); err != nil {
+					t.Fatalf("pageRows returned error: %v", err)
+				}
+			}
+		}
+		if got := pool.Stats(); got != c.want {
+			t.Errorf("with %d frames, Stats returned %+v, want %+v", c.frames, got, c.want)
+		}
+	}
+}
+
+func TestBufferPoolPinned(t *testing.T) {
+	pool := mustBufferPool(t, 2, NewClock())
+	h := testHeapFile(t, pool, 3)
+	a, err := pool.fetch(h, 0)
+	if err != nil {
+		t.Fatalf("fetch returned error: %v", err)
+	}
+	b, err := pool.fetch(h, 1)
+	if err != nil {
+		t.Fatalf("fetch returned error: %v", err)
+	}
+	// pinning a page twice needs two unpins
+	if _, err := pool.fetch(h, 1); err != nil {
+		t.Fatalf("fetch returned error: %v", err)
+	}
+	pool.unpin(b, false)
+
+	if _, err := pool.fetch(h, 2); err == nil {
+		t.Errorf("fetch did not return error with all frames pinned")
+	}
+	if err := pool.drop(h); err == nil {
+		t.Errorf("drop did not return error for pinned pages")
+	}
+
+	pool.unpin(a, false)
+	c, err := pool.fetch(h, 2)
+	if err != nil {
+		t.Fatalf("fetch returned error: %v", err)
+	}
+	if c != a {
+		t.Errorf("fetch evicted a pinned page")
+	}
+	want := BufferPoolStats{Hits: 1, Misses: 4, Evictions: 1}
+	if got := pool.Stats(); got != want {
+		t.Errorf("Stats returned %+v, want %+v", got, want)
+	}
+	pool.unpin(b, false)
+	pool.unpin(c, false)
+}
+
+func TestBufferPoolFlush(t *testing.T) {
+	pool := mustBufferPool(t, 4, NewLRU())
+	h := testHeapFile(t, pool, 1)
+	row := []types.Value{types.Boo(true), types.Txt("new"), types.Dec("1"), types.Dat(2000, 1, 1)}
+	insertRow(t, h, row)
+	last := h.pages - 1
+
+	// the new row is only in the buffer pool until it's flushed
+	onDisk := func() int {
+		p := new(page)
+		if err := h.readPage(last, p); err != nil {
+			t.Fatalf("readPage returned error: %v", err)
+		}
+		return p.slots()
+	}
+	before := onDisk()
+	if err := pool.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	if after := onDisk(); after != before+1 {
+		t.Errorf("page has %d records on disk after Flush, want %d", after, before+1)
+	}
+	if writes := pool.Stats().Writes; writes != 1 {
+		t.Errorf("Flush wrote %d pages, want 1", writes)
+	}
+
+	// flushing again doesn't write clean pages
+	if err := pool.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	if writes := pool.Stats().Writes; writes != 1 {
+		t.Errorf("second Flush wrote %d pages, want 1", writes-1)
+	}
+
+	_, rows, err := h.pageRows(last)
+	if err != nil {
+		t.Fatalf("pageRows returned error: %v", err)
+	}
+	if got := rows[len(rows)-1]; !reflect.DeepEqual(got, row) {
+		t.Errorf("last row is %v, want %v", got, row)
+	}
+}
+
+func TestLRU(t *testing.T) {
+	p := NewLRU()
+	p.Init(3)
+	for _, frame := range []int{0, 1, 2, 0} {
+		p.Access(frame)
+	}
+	all := func(int) bool { return true }
+	if victim, ok := p.Victim(all); !ok || victim != 1 {
+		t.Errorf("Victim returned %d, %v, want 1", victim, ok)
+	}
+	if victim, ok := p.Victim(func(i int) bool { return i != 1 }); !ok || victim != 2 {
+		t.Errorf("Victim returned %d, %v, want 2", victim, ok)
+	}
+	if _, ok := p.Victim(func(int) bool { return false }); ok {
+		t.Errorf("Victim returned a frame with no evictable frames")
+	}
+}
+
+func TestClock(t *testing.T) {
+	p := NewClock()
+	p.Init(3)
+	for _, frame := range []int{0, 1, 2} {
+		p.Access(frame)
+	}
+	all := func(int) bool { return true }
+
+	// all frames were referenced, so the hand goes around once clearing their bits
+	if victim, ok := p.Victim(all); !ok || victim != 0 {
+		t.Errorf("Victim returned %d, %v, want 0", victim, ok)
+	}
+	// frame 1 gets a second chance after it's accessed again
+	p.Access(1)
+	if victim, ok := p.Victim(all); !ok || victim != 2 {
+		t.Errorf("Victim returned %d, %v, want 2", victim, ok)
+	}
+	if victim, ok := p.Victim(func(i int) bool { return i == 1 }); !ok || victim != 1 {
+		t.Errorf("Victim returned %d, %v, want 1", victim, ok)
+	}
+	if _, ok := p.Victim(func(int) bool { return false }); ok {
+		t.Errorf("Victim returned a frame with no evictable frames")
+	}
+}
@@ -1,22 +1,300 @@
 package storage
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
 
 	"github.com/lfritz/toydb/types"
 )
 
+// A Database holds a set of tables. A database created with NewDatabase is kept in memory only.
+// One opened with Open is stored in a directory, with a catalog file listing the tables, a heap file
+// for each table and a write-ahead log. Its rows are read and written through a buffer pool, so only
+// some pages of each table are held in memory. Each change is written to the log before the pages it
+// changes can be written to a heap file, so a database that wasn't closed, for example because the
+// program crashed, is recovered when it's opened again.
+//
+// A Database can be read by several goroutines at once, but changing it while it's read isn't
+// safe.
 type Database struct {
-	tables map[string]*types.Relation
+	tables map[string]*table
+	dir    string
+	pool   *BufferPool
+	log    *writeAheadLog
+	closed bool
+}
+
+// ErrClosed is the error returned when using a database stored in a directory after closing it.
+var ErrClosed = errors.New("database is closed")
+
+// A table holds its rows in a relation if the database is kept in memory, or in a heap file if it's
+// stored in a directory.
+type table struct {
+	schema   types.TableSchema
+	relation *types.Relation
+	heap     *heapFile
+	indexes  []*index
+
+	mu      sync.Mutex // guards indexed
+	indexed int        // number of rows of relation in the indexes
 }
 
 func NewDatabase() *Database {
 	return &Database{
-		tables: make(map[string]*types.Relation),
+		tables: make(map[string]*table),
 	}
 }
 
-func (d *Database) Table(name string) (*types.Relation, error) {
+// catalogFile is the name of the file listing the tables of a database stored in a directory.
+const catalogFile = "catalog.json"
+
+// A catalog is the contents of the catalog file. It lists the tables and holds the LSN to continue
+// numbering log records with, since the log is emptied when the database is closed.
+type catalog struct {
+	NextLSN uint64
+	Tables  []catalogEntry
+}
+
+// A catalogEntry describes a table in the catalog file.
+type catalogEntry struct {
+	Name    string
+	Schema  types.TableSchema
+	Indexes []catalogIndex
+}
+
+// A catalogIndex describes an index in the catalog file.
+type catalogIndex struct {
+	Name    string
+	Columns []string
+	Method  IndexMethod
+}
+
+// Open opens the database stored in a directory, creating the directory if it doesn't exist. It
+// uses a buffer pool with DefaultBufferPoolFrames frames and LRU eviction. The database should be
+// closed when it's no longer used.
+func Open(path string) (*Database, error) {
+	pool, err := NewBufferPool(DefaultBufferPoolFrames, NewLRU())
+	if err != nil {
+		return nil, err
+	}
+	return OpenWithBufferPool(path, pool)
+}
+
+// OpenWithBufferPool opens the database stored in a directory, like Open, with a given buffer pool.
+// Several databases can share a buffer pool. If the database wasn't closed, it redoes the committed
+// changes recorded in the log and undoes the others.
+func OpenWithBufferPool(path string, pool *BufferPool) (*Database, error) {
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, err
+	}
+	d := &Database{
+		tables: make(map[string]*table),
+		dir:    path,
+		pool:   pool,
+	}
+	c, err := d.readCatalog()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range c.Tables {
+		h, err := openHeapFile(d.heapPath(e.Name), pool)
+		if err != nil {
+			d.closeFiles()
+			return nil, err
+		}
+		t := &table{
+			schema: e.Schema,
+			heap:   h,
+		}
+		d.tables[e.Name] = t
+		for _, ci := range e.Indexes {
+			ix, err := newIndex(Index{Name: ci.Name, Table: e.Name, Columns: ci.Columns, Method: ci.Method}, e.Schema)
+			if err != nil {
+				d.closeFiles()
+				return nil, fmt.Errorf("invalid catalog file: %w", err)
+			}
+			t.indexes = append(t.indexes, ix)
+		}
+	}
+	log, records, err := openLog(path, c.NextLSN)
+	if err != nil {
+		d.closeFiles()
+		return nil, err
+	}
+	d.log = log
+	if err := d.recover(records); err != nil {
+		d.closeFiles()
+		return nil, err
+	}
+	for _, t := range d.tables {
+		for _, ix := range t.indexes {
+			if err := t.build(ix); err != nil {
+				d.closeFiles()
+				return nil, err
+			}
+		}
+	}
+	return d, nil
+}
+
+// Close writes the pages of a database stored in a directory to its files, empties the log and
+// closes the files. After that, the database can't be used any more. Close does nothing for a
+// database kept in memory or one that's already closed.
+func (d *Database) Close() error {
+	if d.dir == "" || d.closed {
+		return nil
+	}
+	d.closed = true
+	if d.log == nil {
+		return d.closeFiles()
+	}
+	err := d.checkpoint()
+	if closeErr := d.closeFiles(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// closeFiles closes the heap files and the log.
+func (d *Database) closeFiles() error {
+	var result error
+	for _, t := range d.tables {
+		if t.heap == nil {
+			continue
+		}
+		if err := t.heap.close(); err != nil && result == nil {
+			result = err
+		}
+		t.heap = nil
+	}
+	if d.log != nil {
+		if err := d.log.close(); err != nil && result == nil {
+			result = err
+		}
+		d.log = nil
+	}
+	return result
+}
+
+// recover redoes the committed changes in the log that may not have been written to the heap files
+// and undoes the changes of transactions that didn't commit, then writes the pages and empties the
+// log.
+func (d *Database) recover(records []logRecord) error {
+	for _, r := range records {
+		if err := d.redo(r); err != nil {
+			return fmt.Errorf("error redoing %s (LSN %d) from log: %w", r.kind, r.lsn, err)
+		}
+	}
+	return d.checkpoint()
+}
+
+// redo makes the change described by a log record unless it's already been made. A change to a page
+// has been made if the page's LSN is at least that of the record. A page image replaces the page,
+// which takes it back to before the transaction that recorded the image; the records that follow
+// it are redone from there. Indexes are only added or removed; they're built after recovery.
+func (d *Database) redo(r logRecord) error {
+	if r.kind == recordCreateTable {
+		if _, exists := d.tables[r.table]; exists {
+			return nil
+		}
+		h, err := createHeapFile(d.heapPath(r.table), d.pool)
+		if err != nil {
+			return err
+		}
+		d.tables[r.table] = &table{schema: r.schema, heap: h}
+		return nil
+	}
+
+	t, err := d.table(r.table)
+	if err != nil {
+		return err
+	}
+	switch r.kind {
+	case recordCreateIndex:
+		if _, _, exists := d.findIndex(r.index); exists {
+			return nil
+		}
+		ix, err := newIndex(Index{Name: r.index, Table: r.table, Columns: r.columns, Method: r.method}, t.schema)
+		if err != nil {
+			return err
+		}
+		t.indexes = append(t.indexes, ix)
+		return nil
+	case recordDropIndex:
+		if t, i, exists := d.findIndex(r.index); exists {
+			t.indexes = append(t.indexes[:i:i], t.indexes[i+1:]...)
+		}
+		return nil
+	}
+	for t.heap.pages <= r.page {
+		f, err := d.pool.allocate(t.heap)
+		if err != nil {
+			return err
+		}
+		d.pool.unpin(f, false)
+	}
+	f, err := d.pool.fetch(t.heap, r.page)
+	if err != nil {
+		return err
+	}
+	if r.kind == recordPageImage {
+		f.page = *r.image
+		d.pool.unpin(f, true)
+		return nil
+	}
+	if f.page.lsn() >= r.lsn {
+		d.pool.unpin(f, false)
+		return nil
+	}
+	original := f.page
+	var ok bool
+	switch r.kind {
+	case recordInsert:
+		var slot int
+		slot, ok = f.page.insert(types.AppendRow(nil, r.row))
+		ok = ok && slot == r.slot
+	case recordUpdate:
+		ok = f.page.update(r.slot, types.AppendRow(nil, r.row))
+	case recordDelete:
+		ok = f.page.delete(r.slot)
+	}
+	if !ok {
+		f.page = original
+		d.pool.unpin(f, false)
+		return fmt.Errorf("log record doesn't match page %d of %s", r.page, r.table)
+	}
+	f.page.setLSN(r.lsn)
+	d.pool.unpin(f, true)
+	return nil
+}
+
+// checkpoint writes all changed pages to the heap files and the tables to the catalog file, then
+// empties the log.
+func (d *Database) checkpoint() error {
+	if err := d.pool.Flush(); err != nil {
+		return err
+	}
+	for _, t := range d.tables {
+		if err := t.heap.file.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := d.writeCatalog(); err != nil {
+		return err
+	}
+	return d.log.reset()
+}
+
+func (d *Database) table(name string) (*table, error) {
+	if d.closed {
+		return nil, ErrClosed
+	}
 	t, ok := d.tables[name]
 	if !ok {
 		return nil, fmt.Errorf("table not found: %s", name)
@@ -24,14 +302,380 @@ func (d *Database) Table(name string) (*types.Relation, error) {
 	return t, nil
 }
 
+// Table returns the rows of a table. For a database kept in memory, it returns the table's
+// relation itself; for one stored in a directory, it reads the rows into a new relation.
+func (d *Database) Table(name string) (*types.Relation, error) {
+	t, err := d.table(name)
+	if err != nil {
+		return nil, err
+	}
+	if t.relation != nil {
+		return t.relation, nil
+	}
+	scan, err := d.Scan(name, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]types.Value
+	for {
+		row, ok, err := scan.Next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		rows = append(rows, row)
+	}
+	return &types.Relation{
+		Schema: t.schema,
+		Rows:   rows,
+	}, nil
+}
+
+// Schema returns the schema of a table.
+func (d *Database) Schema(name string) (types.TableSchema, error) {
+	t, err := d.table(name)
+	if err != nil {
+		return types.TableSchema{}, err
+	}
+	return t.schema, nil
+}
+
+// CreateTable adds an empty table. For a database kept in memory, it returns the table's relation,
+// which rows can be appended to directly. For one stored in a directory, it creates the table's
+// heap file and records the new table in the log, and it returns an empty relation that's not
+// connected to the table; rows have to be added with Insert.
 func (d *Database) CreateTable(name string, schema types.TableSchema) (*types.Relation, error) {
+	if d.closed {
+		return nil, ErrClosed
+	}
 	_, exists := d.tables[name]
 	if exists {
 		return nil, fmt.Errorf("table already exists: %s", name)
 	}
-	table := &types.Relation{
+	relation := &types.Relation{
 		Schema: schema,
 	}
-	d.tables[name] = table
-	return table, nil
+	if d.dir == "" {
+		d.tables[name] = &table{schema: schema, relation: relation}
+		return relation, nil
+	}
+
+	if !validTableName(name) {
+		return nil, fmt.Errorf("invalid table name: %q", name)
+	}
+	h, err := createHeapFile(d.heapPath(name), d.pool)
+	if err != nil {
+		return nil, err
+	}
+	tx := d.begin()
+	tx.log(logRecord{kind: recordCreateTable, table: name, schema: schema})
+	if err := tx.commit(); err != nil {
+		h.close()
+		return nil, err
+	}
+	d.tables[name] = &table{schema: schema, heap: h}
+	return relation, nil
+}
+
+// Insert adds a row to a table after checking it against the table's schema.
+func (d *Database) Insert(name string, row []types.Value) error {
+	t, err := d.table(name)
+	if err != nil {
+		return err
+	}
+	if t.relation != nil {
+		if err := t.relation.Insert(row); err != nil {
+			return err
+		}
+		t.syncIndexes()
+		return nil
+	}
+	if err := t.schema.Check(row); err != nil {
+		return err
+	}
+	tx := d.begin()
+	id, err := tx.insert(name, t.heap, row)
+	if err != nil {
+		tx.abort()
+		return err
+	}
+	if err := tx.commit(); err != nil {
+		return err
+	}
+	t.addToIndexes(row, id)
+	return nil
+}
+
+// Update replaces each row of a table for which match returns true with the row returned by update,
+// after checking it against the table's schema. It returns the number of rows updated. If it
+// returns an error with a count of zero, the table is unchanged; with a non-zero count, the rows
+// were updated, but the table's indexes couldn't be brought up to date. Match and update are called
+// with copies of the stored rows, so they may change the rows they're given.
+func (d *Database) Update(
+	name string,
+	match func([]types.Value) bool,
+	update func([]types.Value) []types.Value,
+) (int, error) {
+	t, err := d.table(name)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := t.matching(match)
+	if err != nil {
+		return 0, err
+	}
+	updated := make([][]types.Value, len(rows))
+	for i, r := range rows {
+		updated[i] = update(copyRow(r.row))
+		if err := t.schema.Check(updated[i]); err != nil {
+			return 0, err
+		}
+	}
+	stored := make([]storedRow, len(rows))
+	if t.relation != nil {
+		t.syncIndexes()
+		for i, r := range rows {
+			t.relation.Rows[r.id.slot] = updated[i]
+			stored[i] = storedRow{id: r.id, row: updated[i]}
+		}
+		return len(rows), t.reindex(rows, stored)
+	}
+	tx := d.begin()
+	for i, r := range rows {
+		id, err := tx.update(name, t.heap, r.id, updated[i])
+		if err != nil {
+			tx.abort()
+			return 0, err
+		}
+		stored[i] = storedRow{id: id, row: updated[i]}
+	}
+	if err := tx.commit(); err != nil {
+		return 0, err
+	}
+	return len(rows), t.reindex(rows, stored)
+}
+
+// Delete removes the rows of a table for which match returns true. It returns the number of rows
+// deleted. Like for Update, an error with a non-zero count means the rows were deleted but the
+// indexes couldn't be brought up to date, and match is called with copies of the stored rows.
+func (d *Database) Delete(name string, match func([]types.Value) bool) (int, error) {
+	t, err := d.table(name)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := t.matching(match)
+	if err != nil {
+		return 0, err
+	}
+	if t.relation != nil {
+		var kept [][]types.Value
+		next := 0
+		for i, row := range t.relation.Rows {
+			if next < len(rows) && rows[next].id.slot == i {
+				next++
+				continue
+			}
+			kept = append(kept, row)
+		}
+		t.relation.Rows = kept
+		t.rebuildIndexes()
+		return len(rows), nil
+	}
+	tx := d.begin()
+	for _, r := range rows {
+		if err := tx.delete(name, t.heap, r.id); err != nil {
+			tx.abort()
+			return 0, err
+		}
+	}
+	if err := tx.commit(); err != nil {
+		return 0, err
+	}
+	return len(rows), t.reindex(rows, nil)
+}
+
+// A storedRow is a row of a table with where it's stored.
+type storedRow struct {
+	id  rowID
+	row []types.Value
+}
+
+// matching returns the rows for which match returns true, in the order they're stored.
+func (t *table) matching(match func([]types.Value) bool) ([]storedRow, error) {
+	var result []storedRow
+	if t.relation != nil {
+		for i, row := range t.relation.Rows {
+			if match(copyRow(row)) {
+				result = append(result, storedRow{id: rowID{slot: i}, row: row})
+			}
+		}
+		return result, nil
+	}
+	for n := 0; n < t.heap.pages; n++ {
+		slots, rows, err := t.heap.pageRows(n)
+		if err != nil {
+			return nil, err
+		}
+		for i, row := range rows {
+			if match(copyRow(row)) {
+				result = append(result, storedRow{id: rowID{n, slots[i]}, row: row})
+			}
+		}
+	}
+	return result, nil
+}
+
+// copyRow returns a copy of a row to pass to a function that might change it.
+func copyRow(row []types.Value) []types.Value {
+	return append([]types.Value(nil), row...)
+}
+
+// Scan returns an iterator over part of a table's rows, for scanning a table with several
+// goroutines. The table is split into the given number of parts of about the same size, and the
+// iterator returns the rows of the part with the given index. With a single part, it returns all
+// rows.
+func (d *Database) Scan(name string, part, parts int) (*TableScan, error) {
+	t, err := d.table(name)
+	if err != nil {
+		return nil, err
+	}
+	if part < 0 || part >= parts {
+		return nil, fmt.Errorf("invalid part for scan: %d of %d", part, parts)
+	}
+	if t.relation != nil {
+		rows := t.relation.Rows
+		return &TableScan{
+			schema: t.schema,
+			rows:   rows[len(rows)*part/parts : len(rows)*(part+1)/parts],
+		}, nil
+	}
+	pages := t.heap.pages
+	return &TableScan{
+		schema: t.schema,
+		heap:   t.heap,
+		page:   pages * part / parts,
+		end:    pages * (part + 1) / parts,
+	}, nil
+}
+
+// A TableScan returns rows of a table one at a time. For a table stored in a heap file, it reads one
+// page at a time through the buffer pool, or, for an index scan, the page of each row.
+type TableScan struct {
+	schema    types.TableSchema
+	rows      [][]types.Value // rows of an in-memory table, or of the current page
+	next      int             // index into rows
+	heap      *heapFile
+	page, end int     // next page to read and end of the pages to read
+	ids       []rowID // rows to read for an index scan
+}
+
+// Schema returns the schema of the table.
+func (s *TableScan) Schema() types.TableSchema {
+	return s.schema
+}
+
+// Next returns the next row, or false if there are no more rows.
+func (s *TableScan) Next() ([]types.Value, bool, error) {
+	if len(s.ids) > 0 {
+		row, err := s.heap.row(s.ids[0])
+		if err != nil {
+			return nil, false, err
+		}
+		s.ids = s.ids[1:]
+		return row, true, nil
+	}
+	for s.next >= len(s.rows) {
+		if s.heap == nil || s.page >= s.end {
+			return nil, false, nil
+		}
+		_, rows, err := s.heap.pageRows(s.page)
+		if err != nil {
+			return nil, false, err
+		}
+		s.rows, s.next = rows, 0
+		s.page++
+	}
+	row := s.rows[s.next]
+	s.next++
+	return row, true, nil
+}
+
+// validTableName returns true if a table name can be used as part of a file name: if it's
+// non-empty and consists only of letters, digits and underscores.
+func validTableName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		ok := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *Database) heapPath(name string) string {
+	return filepath.Join(d.dir, name+".heap")
+}
+
+func (d *Database) readCatalog() (catalog, error) {
+	var c catalog
+	data, err := os.ReadFile(filepath.Join(d.dir, catalogFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("invalid catalog file: %w", err)
+	}
+	return c, nil
+}
+
+// writeCatalog replaces the catalog file with one listing the current tables and the log's next LSN.
+// It writes a new file and renames it, so the catalog file is never only partly written.
+func (d *Database) writeCatalog() error {
+	c := catalog{NextLSN: d.log.nextLSN}
+	for name, t := range d.tables {
+		e := catalogEntry{Name: name, Schema: t.schema}
+		for _, ix := range t.indexes {
+			e.Indexes = append(e.Indexes, catalogIndex{Name: ix.Name, Columns: ix.Columns, Method: ix.Method})
+		}
+		c.Tables = append(c.Tables, e)
+	}
+	sort.Slice(c.Tables, func(i, j int) bool {
+		return c.Tables[i].Name < c.Tables[j].Name
+	})
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(d.dir, catalogFile), data)
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory as path, writes it to
+// stable storage and renames it to path.
+func writeFileAtomic(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
 }
@@ -0,0 +1,544 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lfritz/toydb/types"
+)
+
+// allTypesSchema has one nullable column of each type.
+var allTypesSchema = types.TableSchema{
+	Columns: []types.ColumnSchema{
+		{Name: "b", Type: types.TypeBoolean, Null: true},
+		{Name: "t", Type: types.TypeText, Null: true},
+		{Name: "d", Type: types.TypeDecimal, Null: true},
+		{Name: "dt", Type: types.TypeDate, Null: true},
+	},
+}
+
+// allTypesRow returns a row for allTypesSchema, with some null values.
+func allTypesRow(i int) []types.Value {
+	row := []types.Value{
+		types.Boo(i%2 == 0),
+		types.Txt(fmt.Sprintf("row %d %s", i, strings.Repeat("x", i%50))),
+		types.Dec(fmt.Sprintf("%d.%d", i-500, i%10)),
+		types.Dat(1900+i%100, 1+i%12, 1+i%28),
+	}
+	if i%7 == 0 {
+		row[i%4] = types.NewNull(row[i%4].Type())
+	}
+	return row
+}
+
+func mustOpen(t *testing.T, dir string) *Database {
+	t.Helper()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	return db
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	db := mustOpen(t, dir)
+	if _, err := db.CreateTable("t", allTypesSchema); err != nil {
+		t.Fatalf("CreateTable returned error: %v", err)
+	}
+	if _, err := db.CreateTable("empty", allTypesSchema); err != nil {
+		t.Fatalf("CreateTable returned error: %v", err)
+	}
+	var want [][]types.Value
+	for i := 0; i < 1000; i++ {
+		row := allTypesRow(i)
+		if err := db.Insert("t", row); err != nil {
+			t.Fatalf("Insert returned error: %v", err)
+		}
+		want = append(want, row)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+
+	// the rows span several pages
+	info, err := os.Stat(filepath.Join(dir, "t.heap"))
+	if err != nil {
+		t.Fatalf("Stat returned error: %v", err)
+	}
+	if pages := info.Size() / PageSize; pages < 5 {
+		t.Errorf("heap file has %d pages, want at least 5", pages)
+	}
+
+	db = mustOpen(t, dir)
+	defer db.Close()
+	table, err := db.Table("t")
+	if err != nil {
+		t.Fatalf("Table returned error: %v", err)
+	}
+	if !reflect.DeepEqual(table.Schema, allTypesSchema) {
+		t.Errorf("reopened table has schema %v, want %v", table.Schema, allTypesSchema)
+	}
+	if !reflect.DeepEqual(table.Rows, want) {
+		t.Errorf("reopened table has %d rows, want the %d inserted rows", len(table.Rows), len(want))
+	}
+	empty, err := db.Table("empty")
+	if err != nil {
+		t.Fatalf("Table returned error: %v", err)
+	}
+	if len(empty.Rows) != 0 {
+		t.Errorf("reopened empty table has %d rows", len(empty.Rows))
+	}
+
+	// rows inserted after reopening are appended
+	row := allTypesRow(1000)
+	if err := db.Insert("t", row); err != nil {
+		t.Fatalf("Insert returned error: %v", err)
+	}
+	db.Close()
+	db = mustOpen(t, dir)
+	defer db.Close()
+	table, err = db.Table("t")
+	if err != nil {
+		t.Fatalf("Table returned error: %v", err)
+	}
+	if len(table.Rows) != 1001 || !reflect.DeepEqual(table.Rows[1000], row) {
+		t.Errorf("row inserted after reopening not found")
+	}
+	if _, err := db.CreateTable("t", allTypesSchema); err == nil {
+		t.Errorf("CreateTable did not return error for existing table")
+	}
+}
+
+func TestInsertInvalid(t *testing.T) {
+	db := mustOpen(t, t.TempDir())
+	defer db.Close()
+	if _, err := db.CreateTable("t", allTypesSchema); err != nil {
+		t.Fatalf("CreateTable returned error: %v", err)
+	}
+
+	rows := [][]types.Value{
+		{types.Boo(true)},
+		{types.Txt("x"), types.Txt("x"), types.Dec("1"), types.Dat(2000, 1, 1)},
+		{types.Boo(true), types.Txt(strings.Repeat("x", PageSize)), types.Dec("1"), types.Dat(2000, 1, 1)},
+	}
+	for _, row := range rows {
+		if err := db.Insert("t", row); err == nil {
+			t.Errorf("Insert did not return error for %v", row)
+		}
+	}
+	if err := db.Insert("missing", allTypesRow(0)); err == nil {
+		t.Errorf("Insert did not return error for missing table")
+	}
+	table, err := db.Table("t")
+	if err != nil {
+		t.Fatalf("Table returned error: %v", err)
+	}
+	if len(table.Rows) != 0 {
+		t.Errorf("table has %d rows after failed inserts", len(table.Rows))
+	}
+}
+
+func TestClosed(t *testing.T) {
+	db := mustOpen(t, t.TempDir())
+	if _, err := db.CreateTable("t", allTypesSchema); err != nil {
+		t.Fatalf("CreateTable returned error: %v", err)
+	}
+	if err := db.CreateIndex("t_b", "t", []string{"b"}); err != nil {
+		t.Fatalf("CreateIndex returned error: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+
+	all := func([]types.Value) bool { return true }
+	calls := map[string]func() error{
+		"CreateTable": func() error { _, err := db.CreateTable("u", allTypesSchema); return err },
+		"Insert":      func() error { return db.Insert("t", allTypesRow(0)) },
+		"Scan":        func() error { _, err := db.Scan("t", 0, 1); return err },
+		"Table":       func() error { _, err := db.Table("t"); return err },
+		"Update": func() error {
+			_, err := db.Update("t", all, func(row []types.Value) []types.Value { return row })
+			return err
+		},
+		"Delete":        func() error { _, err := db.Delete("t", all); return err },
+		"CreateIndex":   func() error { return db.CreateIndex("t_t", "t", []string{"t"}) },
+		"DropIndex":     func() error { return db.DropIndex("t_b") },
+		"IndexScan":     func() error { _, err := db.IndexScan("t_b", KeyRange{}); return err },
+		"HashIndexScan": func() error { _, err := db.HashIndexScan("t_b", nil); return err },
+	}
+	for name, call := range calls {
+		if err := call(); !errors.Is(err, ErrClosed) {
+			t.Errorf("%s after Close returned %v, want %v", name, err, ErrClosed)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Errorf("second Close returned error: %v", err)
+	}
+}
+
+func TestCreateTableInvalidName(t *testing.T) {
+	db := mustOpen(t, t.TempDir())
+	defer db.Close()
+	for _, name := range []string{"", "../t", "a b", "t.heap"} {
+		if _, err := db.CreateTable(name, allTypesSchema); err == nil {
+			t.Errorf("CreateTable did not return error for %q", name)
+		}
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	dir := t.TempDir()
+	db := mustOpen(t, dir)
+	if _, err := db.CreateTable("t", allTypesSchema); err != nil {
+		t.Fatalf("CreateTable returned error: %v", err)
+	}
+	if err := db.Insert("t", allTypesRow(1)); err != nil {
+		t.Fatalf("Insert returned error: %v", err)
+	}
+	db.Close()
+
+	// a heap file that's not a whole number of pages
+	path := filepath.Join(dir, "t.heap")
+	if err := os.Truncate(path, PageSize-1); err != nil {
+		t.Fatalf("Truncate returned error: %v", err)
+	}
+	if _, err := Open(dir); err == nil {
+		t.Errorf("Open did not return error for truncated heap file")
+	}
+
+	// a page with garbage in it is found when it's read
+	if err := os.WriteFile(path, []byte(strings.Repeat("\xff", PageSize)), 0o644); err != nil {
+		t.Fatalf("WriteFile returned error: %v", err)
+	}
+	db = mustOpen(t, dir)
+	if _, err := db.Table("t"); err == nil {
+		t.Errorf("Table did not return error for invalid page")
+	}
+	db.Close()
+
+	// a missing heap file
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("Remove returned error: %v", err)
+	}
+	if _, err := Open(dir); err == nil {
+		t.Errorf("Open did not return error for missing heap file")
+	}
+}
+
+func TestScan(t *testing.T) {
+	for _, dir := range []string{"", t.TempDir()} {
+		db := NewDatabase()
+		if dir != "" {
+			pool, err := NewBufferPool(3, NewClock())
+			if err != nil {
+				t.Fatalf("NewBufferPool returned error: %v", err)
+			}
+			db, err = OpenWithBufferPool(dir, pool)
+			if err != nil {
+				t.Fatalf("OpenWithBufferPool returned error: %v", err)
+			}
+		}
+		if _, err := db.CreateTable("t", allTypesSchema); err != nil {
+			t.Fatalf("CreateTable returned error: %v", err)
+		}
+		for i := 0; i < 1000; i++ {
+			if err := db.Insert("t", allTypesRow(i)); err != nil {
+				t.Fatalf("Insert returned error: %v", err)
+			}
+		}
+
+		// scan the table in parts, each with its own goroutine
+		const parts = 4
+		results := make([][][]types.Value, parts)
+		errs := make([]error, parts)
+		var wg sync.WaitGroup
+		for part := 0; part < parts; part++ {
+			scan, err := db.Scan("t", part, parts)
+			if err != nil {
+				t.Fatalf("Scan returned error: %v", err)
+			}
+			wg.Add(1)
+			go func(part int) {
+				defer wg.Done()
+				for {
+					row, ok, err := scan.Next()
+					if err != nil || !ok {
+						errs[part] = err
+						return
+					}
+					results[part] = append(results[part], row)
+				}
+			}(part)
+		}
+		wg.Wait()
+
+		var got [][]types.Value
+		for part := range results {
+			if errs[part] != nil {
+				t.Fatalf("Next returned error: %v", errs[part])
+			}
+			if len(results[part]) == 0 {
+				t.Errorf("part %d of scan returned no rows", part)
+			}
+			got = append(got, results[part]...)
+		}
+		for i, row := range got {
+			if !reflect.DeepEqual(row, allTypesRow(i)) {
+				t.Fatalf("row %d of scan is %v, want %v", i, row, allTypesRow(i))
+			}
+		}
+		if len(got) != 1000 {
+			t.Errorf("scan returned %d rows, want 1000", len(got))
+		}
+		if _, err := db.Scan("t", parts, parts); err == nil {
+			t.Errorf("Scan did not return error for invalid part")
+		}
+		db.Close()
+	}
+}
+
+func TestUpdateDelete(t *testing.T) {
+	for _, dir := range []string{"", t.TempDir()} {
+		db := NewDatabase()
+		if dir != "" {
+			db = mustOpen(t, dir)
+		}
+		if _, err := db.CreateTable("t", allTypesSchema); err != nil {
+			t.Fatalf("CreateTable returned error: %v", err)
+		}
+		var want [][]types.Value
+		for i := 0; i < 300; i++ {
+			row := allTypesRow(i)
+			if err := db.Insert("t", row); err != nil {
+				t.Fatalf("Insert returned error: %v", err)
+			}
+			want = append(want, row)
+		}
+
+		// update every third row, making it longer so some of them have to move
+		thirds := make(map[string]bool)
+		for i := 0; i < len(want); i += 3 {
+			thirds[types.RowKey(want[i])] = true
+		}
+		third := func(row []types.Value) bool { return thirds[types.RowKey(row)] }
+		longer := func(row []types.Value) []types.Value {
+			return []types.Value{row[0], types.Txt(strings.Repeat("y", 200)), row[2], row[3]}
+		}
+		n, err := db.Update("t", third, longer)
+		if err != nil {
+			t.Fatalf("Update returned error: %v", err)
+		}
+		if n != 100 {
+			t.Errorf("Update returned %d, want 100", n)
+		}
+		for i := range want {
+			if i%3 == 0 {
+				want[i] = longer(want[i])
+			}
+		}
+
+		// an update that breaks the schema changes nothing
+		_, err = db.Update("t", func([]types.Value) bool { return true }, func([]types.Value) []types.Value {
+			return []types.Value{types.Boo(true)}
+		})
+		if err == nil {
+			t.Errorf("Update did not return error for invalid row")
+		}
+
+		n, err = db.Delete("t", func(row []types.Value) bool { return row[0].IsTrue() })
+		if err != nil {
+			t.Fatalf("Delete returned error: %v", err)
+		}
+		var kept [][]types.Value
+		for _, row := range want {
+			if !row[0].IsTrue() {
+				kept = append(kept, row)
+			}
+		}
+		if n != len(want)-len(kept) {
+			t.Errorf("Delete returned %d, want %d", n, len(want)-len(kept))
+		}
+
+		if dir != "" {
+			db.Close()
+			db = mustOpen(t, dir)
+		}
+		table, err := db.Table("t")
+		if err != nil {
+			t.Fatalf("Table returned error: %v", err)
+		}
+		// updated rows that moved can be stored in space freed by other rows
+		got := table.Rows
+		sort.Slice(got, func(i, j int) bool { return types.RowKey(got[i]) < types.RowKey(got[j]) })
+		sort.Slice(kept, func(i, j int) bool { return types.RowKey(kept[i]) < types.RowKey(kept[j]) })
+		if !reflect.DeepEqual(got, kept) {
+			t.Errorf("table has %d rows after Update and Delete, want %d", len(got), len(kept))
+		}
+
+		if _, err := db.Delete("missing", func([]types.Value) bool { return true }); err == nil {
+			t.Errorf("Delete did not return error for missing table")
+		}
+		db.Close()
+	}
+}
+
+func TestUpdateDeleteChangeRow(t *testing.T) {
+	for _, dir := range []string{"", t.TempDir()} {
+		db := NewDatabase()
+		if dir != "" {
+			db = mustOpen(t, dir)
+		}
+		if _, err := db.CreateTable("t", allTypesSchema); err != nil {
+			t.Fatalf("CreateTable returned error: %v", err)
+		}
+		if err := db.CreateIndex("t_bd", "t", []string{"b", "d"}); err != nil {
+			t.Fatalf("CreateIndex returned error: %v", err)
+		}
+		if err := db.CreateIndexUsing("t_db", "t", []string{"d", "b"}, IndexMethodHash); err != nil {
+			t.Fatalf("CreateIndexUsing returned error: %v", err)
+		}
+		var want [][]types.Value
+		for i := 0; i < 100; i++ {
+			row := allTypesRow(i)
+			if err := db.Insert("t", row); err != nil {
+				t.Fatalf("Insert returned error: %v", err)
+			}
+			want = append(want, allTypesRow(i))
+		}
+
+		// callbacks that change the rows they're given don't change the stored rows or the indexes
+		isTrue := func(row []types.Value) bool {
+			result := row[0].IsTrue()
+			row[0] = types.NewNull(types.TypeBoolean)
+			return result
+		}
+		n, err := db.Update("t", isTrue, func(row []types.Value) []types.Value {
+			row[1] = types.Txt("updated")
+			row[2] = types.Dec("1")
+			return row
+		})
+		if err != nil {
+			t.Fatalf("Update returned error: %v", err)
+		}
+		var updated int
+		for i := range want {
+			if want[i][0].IsTrue() {
+				want[i][1] = types.Txt("updated")
+				want[i][2] = types.Dec("1")
+				updated++
+			}
+		}
+		if n != updated {
+			t.Errorf("Update returned %d, want %d", n, updated)
+		}
+		checkIndexScans(t, db, "t_bd")
+		checkHashIndexScans(t, db, "t_db")
+		n, err = db.Delete("t", func(row []types.Value) bool {
+			row[1] = types.Txt("deleted")
+			row[2] = types.Dec("2")
+			return row[0].IsTrue()
+		})
+		if err != nil {
+			t.Fatalf("Delete returned error: %v", err)
+		}
+		if n != updated {
+			t.Errorf("Delete returned %d, want %d", n, updated)
+		}
+		checkIndexScans(t, db, "t_bd")
+		checkHashIndexScans(t, db, "t_db")
+		var kept [][]types.Value
+		for _, row := range want {
+			if !row[0].IsTrue() {
+				kept = append(kept, row)
+			}
+		}
+		want = kept
+
+		table, err := db.Table("t")
+		if err != nil {
+			t.Fatalf("Table returned error: %v", err)
+		}
+		got := table.Rows
+		sort.Slice(got, func(i, j int) bool { return types.RowKey(got[i]) < types.RowKey(got[j]) })
+		sort.Slice(want, func(i, j int) bool { return types.RowKey(want[i]) < types.RowKey(want[j]) })
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("table has %d rows after Update and Delete, want %d", len(got), len(want))
+		}
+		db.Close()
+	}
+}
+
+func TestHeapFileReuse(t *testing.T) {
+	dir := t.TempDir()
+	db := mustOpen(t, dir)
+	schema := types.TableSchema{
+		Columns: []types.ColumnSchema{
+			{Name: "n", Type: types.TypeDecimal},
+			{Name: "s", Type: types.TypeText},
+		},
+	}
+	if _, err := db.CreateTable("t", schema); err != nil {
+		t.Fatalf("CreateTable returned error: %v", err)
+	}
+	row := func(n int, s string) []types.Value {
+		return []types.Value{types.Dec(fmt.Sprint(n)), types.Txt(s)}
+	}
+	for n := 0; n < 500; n++ {
+		if err := db.Insert("t", row(n, "x")); err != nil {
+			t.Fatalf("Insert returned error: %v", err)
+		}
+	}
+
+	// make a third of the rows longer, so they move, and shorter again, then delete a fifth of the
+	// rows and insert them again, reopening the database now and then
+	var pages int
+	for round := 0; round < 20; round++ {
+		if round%5 == 4 {
+			db.Close()
+			db = mustOpen(t, dir)
+		}
+		number := func(r []types.Value) int {
+			var n int
+			fmt.Sscan(r[0].String(), &n)
+			return n
+		}
+		third := func(r []types.Value) bool { return number(r)%3 == round%3 }
+		for _, s := range []string{strings.Repeat("y", 500), "x"} {
+			if _, err := db.Update("t", third, func(r []types.Value) []types.Value { return row(number(r), s) }); err != nil {
+				t.Fatalf("Update returned error: %v", err)
+			}
+		}
+		if _, err := db.Delete("t", func(r []types.Value) bool { return number(r)%5 == round%5 }); err != nil {
+			t.Fatalf("Delete returned error: %v", err)
+		}
+		for n := round % 5; n < 500; n += 5 {
+			if err := db.Insert("t", row(n, "x")); err != nil {
+				t.Fatalf("Insert returned error: %v", err)
+			}
+		}
+
+		// after the first round, the heap file doesn't grow any more
+		if round == 0 {
+			pages = db.tables["t"].heap.pages
+		} else if got := db.tables["t"].heap.pages; got > pages {
+			t.Fatalf("heap file grew from %d to %d pages in round %d", pages, got, round)
+		}
+	}
+
+	table, err := db.Table("t")
+	if err != nil {
+		t.Fatalf("Table returned error: %v", err)
+	}
+	if len(table.Rows) != 500 {
+		t.Errorf("table has %d rows, want 500", len(table.Rows))
+	}
+	db.Close()
+}
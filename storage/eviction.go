@@ -0,0 +1,83 @@
+package storage
+
+// An EvictionPolicy chooses the page a BufferPool evicts when it needs a frame and all frames hold
+// pages. Frames are numbered from zero. The buffer pool serializes calls to the policy.
+type EvictionPolicy interface {
+	// Init is called once, with the number of frames, before any other method.
+	Init(frames int)
+
+	// Access is called each time the page in a frame is pinned.
+	Access(frame int)
+
+	// Victim returns a frame for which evictable returns true, or false if there's none.
+	Victim(evictable func(frame int) bool) (int, bool)
+}
+
+// NewLRU returns a policy that evicts the least recently used page.
+func NewLRU() EvictionPolicy {
+	return &lru{}
+}
+
+// lru keeps a timestamp of the last access for each frame.
+type lru struct {
+	clock    uint64
+	lastUsed []uint64
+}
+
+func (p *lru) Init(frames int) {
+	p.lastUsed = make([]uint64, frames)
+}
+
+func (p *lru) Access(frame int) {
+	p.clock++
+	p.lastUsed[frame] = p.clock
+}
+
+func (p *lru) Victim(evictable func(frame int) bool) (int, bool) {
+	victim, found := 0, false
+	for i, t := range p.lastUsed {
+		if evictable(i) && (!found || t < p.lastUsed[victim]) {
+			victim, found = i, true
+		}
+	}
+	return victim, found
+}
+
+// NewClock returns a policy that approximates LRU with the clock algorithm: frames form a circle
+// with a hand pointing at one of them, and each frame has a reference bit that's set when its page
+// is accessed. To find a victim, the hand moves around the circle, clearing reference bits, until
+// it finds a frame whose bit is already clear.
+func NewClock() EvictionPolicy {
+	return &clock{}
+}
+
+type clock struct {
+	referenced []bool
+	hand       int
+}
+
+func (p *clock) Init(frames int) {
+	p.referenced = make([]bool, frames)
+}
+
+func (p *clock) Access(frame int) {
+	p.referenced[frame] = true
+}
+
+func (p *clock) Victim(evictable func(frame int) bool) (int, bool) {
+	// after one turn, all reference bits of evictable frames are clear
+	n := len(p.referenced)
+	for step := 0; step < 2*n; step++ {
+		i := p.hand
+		p.hand = (p.hand + 1) % n
+		if !evictable(i) {
+			continue
+		}
+		if p.referenced[i] {
+			p.referenced[i] = false
+			continue
+		}
+		return i, true
+	}
+	return 0, false
+}
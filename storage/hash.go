@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"hash/fnv"
+
+	"github.com/lfritz/toydb/types"
+)
+
+// hashBucketSize is the number of entries a bucket of an extendible hash table holds before it's
+// split.
+const hashBucketSize = 64
+
+// An extendibleHash is an extendible hash table mapping keys to rows. Its directory has 2^depth
+// slots, and the lowest depth bits of a key's hash select the slot for the key. Each slot points to
+// a bucket; a bucket with a local depth of d holds the entries whose hashes agree in their lowest d
+// bits, and is shared by the 2^(depth-d) slots for those bits. When a bucket is full, it's split in
+// two by one more bit of the hash, doubling the directory if the bucket's depth is already that of
+// the directory.
+//
+// Entries whose keys have the same hash can't be separated by splitting, so a bucket that only holds
+// such entries grows beyond hashBucketSize instead. Buckets aren't merged when entries are deleted.
+type extendibleHash struct {
+	depth     uint
+	directory []*hashBucket
+}
+
+type hashBucket struct {
+	depth   uint
+	entries []hashEntry
+}
+
+// A hashEntry is an entry in an extendible hash table with the hash of its key, so it doesn't have
+// to be computed again when the entry's bucket is split.
+type hashEntry struct {
+	hash uint64
+	indexEntry
+}
+
+func newExtendibleHash() *extendibleHash {
+	return &extendibleHash{directory: []*hashBucket{new(hashBucket)}}
+}
+
+// hashKey returns the hash of a key. Keys that are equal have the same hash, since types.RowKey is
+// the same for them.
+func hashKey(key []types.Value) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(types.RowKey(key)))
+	return h.Sum64()
+}
+
+// bucket returns the bucket for a hash.
+func (t *extendibleHash) bucket(hash uint64) *hashBucket {
+	return t.directory[hash&(1<<t.depth-1)]
+}
+
+// insert adds an entry to the hash table.
+func (t *extendibleHash) insert(e indexEntry) {
+	hash := hashKey(e.key)
+	for {
+		b := t.bucket(hash)
+		if len(b.entries) < hashBucketSize || !b.splittable(hash) {
+			b.entries = append(b.entries, hashEntry{hash, e})
+			return
+		}
+		t.split(b)
+	}
+}
+
+// splittable checks if splitting a bucket would separate its entries from each other or from an
+// entry with the given hash.
+func (b *hashBucket) splittable(hash uint64) bool {
+	for _, e := range b.entries {
+		if e.hash != hash {
+			return true
+		}
+	}
+	return false
+}
+
+// split replaces a bucket with two buckets of one more bit of depth.
+func (t *extendibleHash) split(b *hashBucket) {
+	if b.depth == t.depth {
+		t.directory = append(t.directory, t.directory...)
+		t.depth++
+	}
+	bit := uint64(1) << b.depth
+	zero := &hashBucket{depth: b.depth + 1}
+	one := &hashBucket{depth: b.depth + 1}
+	for _, e := range b.entries {
+		if e.hash&bit == 0 {
+			zero.entries = append(zero.entries, e)
+		} else {
+			one.entries = append(one.entries, e)
+		}
+	}
+	for i, x := range t.directory {
+		if x != b {
+			continue
+		}
+		if uint64(i)&bit == 0 {
+			t.directory[i] = zero
+		} else {
+			t.directory[i] = one
+		}
+	}
+}
+
+// delete removes an entry from the hash table, and returns false if it wasn't found.
+func (t *extendibleHash) delete(e indexEntry) bool {
+	hash := hashKey(e.key)
+	b := t.bucket(hash)
+	for i, x := range b.entries {
+		if x.hash == hash && compareEntries(x.indexEntry, e) == 0 {
+			b.entries = append(b.entries[:i], b.entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the rows with a key. A key with a null is never found, just like a comparison with
+// null is never true.
+func (t *extendibleHash) lookup(key []types.Value) []rowID {
+	if hasNull(key) {
+		return nil
+	}
+	hash := hashKey(key)
+	var result []rowID
+	for _, e := range t.bucket(hash).entries {
+		if e.hash == hash && compareKeys(e.key, key) == 0 {
+			result = append(result, e.row)
+		}
+	}
+	return result
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/lfritz/toydb/types"
+)
+
+// checkExtendibleHash checks the invariants of an extendible hash table and returns its entries.
+func checkExtendibleHash(t *testing.T, table *extendibleHash) []indexEntry {
+	t.Helper()
+	if len(table.directory) != 1<<table.depth {
+		t.Fatalf("directory has %d slots for depth %d", len(table.directory), table.depth)
+	}
+	var result []indexEntry
+	first := make(map[*hashBucket]int)
+	for i, b := range table.directory {
+		if b.depth > table.depth {
+			t.Fatalf("bucket has depth %d in directory of depth %d", b.depth, table.depth)
+		}
+		// the slots pointing to a bucket are those that agree with the first one in the lowest
+		// depth bits
+		mask := uint64(1)<<b.depth - 1
+		if j, ok := first[b]; ok {
+			if uint64(i)&mask != uint64(j) {
+				t.Fatalf("slots %d and %d point to the same bucket of depth %d", j, i, b.depth)
+			}
+			continue
+		}
+		if uint64(i)&mask != uint64(i) {
+			t.Fatalf("slot %d points to a bucket of depth %d that's not in slot %d", i, b.depth, uint64(i)&mask)
+		}
+		first[b] = i
+		if len(b.entries) > hashBucketSize && b.splittable(b.entries[0].hash) {
+			t.Fatalf("bucket has %d entries with different hashes", len(b.entries))
+		}
+		for _, e := range b.entries {
+			if e.hash != hashKey(e.key) || e.hash&mask != uint64(i)&mask {
+				t.Fatalf("entry in the wrong bucket")
+			}
+			result = append(result, e.indexEntry)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return compareEntries(result[i], result[j]) < 0 })
+	return result
+}
+
+func TestExtendibleHash(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	table := newExtendibleHash()
+	var want []indexEntry
+	entry := func() indexEntry {
+		// many distinct keys, a few of them with many rows, and some nulls
+		key := types.Dec(string(rune('0' + r.Intn(10))))
+		if r.Intn(2) == 0 {
+			key = types.Dec(string(rune('0'+r.Intn(10))) + string(rune('0'+r.Intn(10))) + "00")
+		}
+		if r.Intn(20) == 0 {
+			key = types.NewNull(types.TypeDecimal)
+		}
+		return indexEntry{
+			key: []types.Value{key, types.Txt(string(rune('a' + r.Intn(3))))},
+			row: rowID{page: r.Intn(100), slot: r.Intn(100)},
+		}
+	}
+
+	// grow the table, then shrink it again
+	for round, inserts := range []int{5000, 1000, 100} {
+		for i := 0; i < inserts; i++ {
+			e := entry()
+			j := sort.Search(len(want), func(j int) bool { return compareEntries(want[j], e) >= 0 })
+			if j < len(want) && compareEntries(want[j], e) == 0 {
+				continue
+			}
+			table.insert(e)
+			want = append(want[:j], append([]indexEntry{e}, want[j:]...)...)
+		}
+		deletes := len(want) * (round + 1) / 4
+		for i := 0; i < deletes; i++ {
+			j := r.Intn(len(want))
+			if !table.delete(want[j]) {
+				t.Fatalf("delete returned false for entry in table")
+			}
+			want = append(want[:j], want[j+1:]...)
+		}
+		if got := checkExtendibleHash(t, table); !reflect.DeepEqual(got, want) {
+			t.Fatalf("after round %d, table has %d entries, want %d", round, len(got), len(want))
+		}
+	}
+	if table.depth == 0 {
+		t.Errorf("table wasn't split")
+	}
+
+	// lookup finds the rows with a key, except for keys with nulls
+	for _, key := range [][]types.Value{
+		{types.Dec("5"), types.Txt("b")},
+		{types.Dec("1200"), types.Txt("a")},
+		{types.Dec("1200.0"), types.Txt("a")},
+		{types.Dec("5"), types.Txt("x")},
+		{types.NewNull(types.TypeDecimal), types.Txt("a")},
+	} {
+		var wantRows []rowID
+		for _, e := range want {
+			if !hasNull(key) && compareKeys(e.key, key) == 0 {
+				wantRows = append(wantRows, e.row)
+			}
+		}
+		got := table.lookup(key)
+		sort.Slice(got, func(i, j int) bool {
+			return compareEntries(indexEntry{row: got[i]}, indexEntry{row: got[j]}) < 0
+		})
+		if !reflect.DeepEqual(got, wantRows) {
+			t.Errorf("lookup for %v returned %d rows, want %d", key, len(got), len(wantRows))
+		}
+	}
+}
+
+func TestExtendibleHashDuplicates(t *testing.T) {
+	table := newExtendibleHash()
+	for i := 0; i < 1000; i++ {
+		table.insert(indexEntry{key: []types.Value{types.Dec("1")}, row: rowID{slot: i}})
+	}
+	if len(table.directory) != 1 {
+		t.Errorf("table with a single key has %d slots", len(table.directory))
+	}
+	if got := table.lookup([]types.Value{types.Dec("1")}); len(got) != 1000 {
+		t.Errorf("lookup returned %d rows, want 1000", len(got))
+	}
+	if table.delete(indexEntry{key: []types.Value{types.Dec("1")}, row: rowID{slot: 1000}}) {
+		t.Errorf("delete returned true for missing row")
+	}
+	if table.delete(indexEntry{key: []types.Value{types.Dec("2")}, row: rowID{slot: 0}}) {
+		t.Errorf("delete returned true for missing key")
+	}
+	for i := 0; i < 1000; i++ {
+		if !table.delete(indexEntry{key: []types.Value{types.Dec("1")}, row: rowID{slot: i}}) {
+			t.Fatalf("delete returned false for row %d", i)
+		}
+	}
+	if entries := checkExtendibleHash(t, table); len(entries) != 0 {
+		t.Errorf("table has %d entries after deleting all", len(entries))
+	}
+}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/lfritz/toydb/types"
+)
+
+// A heapFile stores the rows of a table in a file of pages. Each row is a record encoded with
+// types.AppendRow. Pages are accessed through a buffer pool.
+//
+// New rows are added to the last page, unless an earlier page has room for them in space freed by
+// deleting or updating rows. To find such a page, the heap file keeps a hint of the space freed on
+// each page, or -1 for pages it hasn't seen yet, for example in a file that was just opened. That
+// way, rows are stored in the order they were inserted as long as none are deleted or updated.
+type heapFile struct {
+	file  *os.File
+	pool  *BufferPool
+	pages int
+	free  []int
+}
+
+// createHeapFile creates an empty heap file at path, replacing any existing file.
+func createHeapFile(path string, pool *BufferPool) (*heapFile, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &heapFile{file: file, pool: pool}, nil
+}
+
+// openHeapFile opens an existing heap file.
+func openHeapFile(path string, pool *BufferPool) (*heapFile, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.Size()%PageSize != 0 {
+		file.Close()
+		return nil, fmt.Errorf("invalid heap file %s: size %d is not a multiple of the page size", path, info.Size())
+	}
+	return &heapFile{
+		file:  file,
+		pool:  pool,
+		pages: int(info.Size() / PageSize),
+	}, nil
+}
+
+// findPage returns the first page from start on that might have room for a record, or false if
+// there is none.
+func (h *heapFile) findPage(start int, record []byte) (int, bool) {
+	for n := start; n < h.pages; n++ {
+		if n == h.pages-1 || h.hasRoom(n, record) {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// hasRoom checks the hint for whether a page other than the last has room for a record.
+func (h *heapFile) hasRoom(n int, record []byte) bool {
+	return n >= len(h.free) || h.free[n] < 0 || h.free[n] >= len(record)
+}
+
+// seen checks if there's a hint for a page.
+func (h *heapFile) seen(n int) bool {
+	return n < len(h.free) && h.free[n] >= 0
+}
+
+// setFree sets the hint for a page.
+func (h *heapFile) setFree(n, free int) {
+	for len(h.free) <= n {
+		h.free = append(h.free, -1)
+	}
+	h.free[n] = free
+}
+
+// readPage reads a page from the file. It's called by the buffer pool.
+func (h *heapFile) readPage(n int, p *page) error {
+	if _, err := h.file.ReadAt(p[:], int64(n)*PageSize); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("error reading page %d of %s: %w", n, h.file.Name(), err)
+	}
+	if !p.check() {
+		return fmt.Errorf("invalid page %d in %s", n, h.file.Name())
+	}
+	return nil
+}
+
+// writePage writes a page to the file. It's called by the buffer pool.
+func (h *heapFile) writePage(n int, p *page) error {
+	if _, err := h.file.WriteAt(p[:], int64(n)*PageSize); err != nil {
+		return fmt.Errorf("error writing page %d of %s: %w", n, h.file.Name(), err)
+	}
+	return nil
+}
+
+// pageRows reads the rows stored in a page, with the slot of each row.
+func (h *heapFile) pageRows(n int) ([]int, [][]types.Value, error) {
+	f, err := h.pool.fetch(h, n)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer h.pool.unpin(f, false)
+	var slots []int
+	var rows [][]types.Value
+	for i := 0; i < f.page.slots(); i++ {
+		record, ok := f.page.record(i)
+		if !ok {
+			continue
+		}
+		row, _, err := types.DecodeRow(record)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid row in page %d of %s: %w", n, h.file.Name(), err)
+		}
+		slots = append(slots, i)
+		rows = append(rows, row)
+	}
+	return slots, rows, nil
+}
+
+// row reads a single row.
+func (h *heapFile) row(id rowID) ([]types.Value, error) {
+	f, err := h.pool.fetch(h, id.page)
+	if err != nil {
+		return nil, err
+	}
+	defer h.pool.unpin(f, false)
+	record, ok := f.page.record(id.slot)
+	if !ok {
+		return nil, fmt.Errorf("no row in slot %d of page %d of %s", id.slot, id.page, h.file.Name())
+	}
+	row, _, err := types.DecodeRow(record)
+	if err != nil {
+		return nil, fmt.Errorf("invalid row in page %d of %s: %w", id.page, h.file.Name(), err)
+	}
+	return row, nil
+}
+
+// close writes the file's pages from the buffer pool and the file itself to stable storage and
+// closes it.
+func (h *heapFile) close() error {
+	err := h.pool.drop(h)
+	if err == nil {
+		err = h.file.Sync()
+	}
+	if closeErr := h.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
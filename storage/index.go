@@ -0,0 +1,464 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lfritz/toydb/types"
+)
+
+// An Index describes an index on one or more columns of a table. Index names are unique within a
+// database.
+//
+// Indexes are B+trees or hash tables kept in memory. For a database stored in a directory, their
+// definitions are stored in the catalog file and the log like those of tables, and the indexes are
+// built from the tables' rows when the database is opened.
+type Index struct {
+	Name    string
+	Table   string
+	Columns []string
+	Method  IndexMethod
+}
+
+// An IndexMethod is the data structure used for an index.
+type IndexMethod int
+
+const (
+	// IndexMethodBtree is a B+tree, which can find the rows whose keys are in a range.
+	IndexMethodBtree IndexMethod = iota
+
+	// IndexMethodHash is an extendible hash table, which can only find the rows with a given key.
+	IndexMethodHash
+)
+
+func (m IndexMethod) String() string {
+	switch m {
+	case IndexMethodBtree:
+		return "btree"
+	case IndexMethodHash:
+		return "hash"
+	}
+	return fmt.Sprintf("IndexMethod(%d)", m)
+}
+
+// An index holds the B+tree or hash table of an index, with the positions of its columns in the
+// table's rows.
+type index struct {
+	Index
+	positions []int
+	tree      *btree          // for B+tree indexes
+	hash      *extendibleHash // for hash indexes
+}
+
+func newIndex(def Index, schema types.TableSchema) (*index, error) {
+	if def.Name == "" {
+		return nil, fmt.Errorf("invalid index name: %q", def.Name)
+	}
+	if def.Method != IndexMethodBtree && def.Method != IndexMethodHash {
+		return nil, fmt.Errorf("invalid method for index %s: %s", def.Name, def.Method)
+	}
+	if len(def.Columns) == 0 {
+		return nil, fmt.Errorf("index %s has no columns", def.Name)
+	}
+	positions := make([]int, len(def.Columns))
+	for i, name := range def.Columns {
+		positions[i] = -1
+		for j, c := range schema.Columns {
+			if c.Name == name {
+				positions[i] = j
+			}
+		}
+		if positions[i] < 0 {
+			return nil, fmt.Errorf("column not found in table %s: %s", def.Table, name)
+		}
+		for _, c := range def.Columns[:i] {
+			if c == name {
+				return nil, fmt.Errorf("column %s appears more than once in index %s", name, def.Name)
+			}
+		}
+	}
+	ix := &index{
+		Index:     def,
+		positions: positions,
+	}
+	ix.clear()
+	return ix, nil
+}
+
+// clear removes all entries from an index.
+func (ix *index) clear() {
+	switch ix.Method {
+	case IndexMethodBtree:
+		ix.tree = newBtree()
+	case IndexMethodHash:
+		ix.hash = newExtendibleHash()
+	}
+}
+
+// key returns the key of a row in the index.
+func (ix *index) key(row []types.Value) []types.Value {
+	key := make([]types.Value, len(ix.positions))
+	for i, p := range ix.positions {
+		key[i] = row[p]
+	}
+	return key
+}
+
+func (ix *index) add(row []types.Value, id rowID) {
+	e := indexEntry{key: ix.key(row), row: id}
+	if ix.hash != nil {
+		ix.hash.insert(e)
+		return
+	}
+	ix.tree.insert(e)
+}
+
+// remove removes a row from the index. It returns an error if the row isn't in the index under its
+// key, which means the index doesn't match the table.
+func (ix *index) remove(row []types.Value, id rowID) error {
+	e := indexEntry{key: ix.key(row), row: id}
+	var found bool
+	if ix.hash != nil {
+		found = ix.hash.delete(e)
+	} else {
+		found = ix.tree.delete(e)
+	}
+	if !found {
+		return fmt.Errorf("row %v not found in index %s", id, ix.Name)
+	}
+	return nil
+}
+
+// A Bound is one end of a KeyRange. Its key can have fewer values than the index has columns, in
+// which case it's compared with that many columns of each key.
+type Bound struct {
+	Key       []types.Value
+	Inclusive bool
+}
+
+// A KeyRange is a range of keys of an index. A nil bound means the range is unbounded on that side.
+// A key with a null in any of the columns compared with the bounds is never in a range, just like a
+// comparison with null is never true.
+type KeyRange struct {
+	Lower, Upper *Bound
+}
+
+func (r KeyRange) String() string {
+	lower, upper := "(-inf", "+inf)"
+	if r.Lower != nil {
+		lower = "(" + keyString(r.Lower.Key)
+		if r.Lower.Inclusive {
+			lower = "[" + keyString(r.Lower.Key)
+		}
+	}
+	if r.Upper != nil {
+		upper = keyString(r.Upper.Key) + ")"
+		if r.Upper.Inclusive {
+			upper = keyString(r.Upper.Key) + "]"
+		}
+	}
+	return fmt.Sprintf("%s, %s", lower, upper)
+}
+
+func keyString(key []types.Value) string {
+	list := make([]string, len(key))
+	for i, v := range key {
+		list[i] = v.String()
+	}
+	return "(" + strings.Join(list, ", ") + ")"
+}
+
+// scan returns the rows whose keys are in a range, in the order of the index.
+func (ix *index) scan(r KeyRange) []rowID {
+	columns := 0
+	before := func(indexEntry) bool { return false }
+	if r.Lower != nil {
+		columns = len(r.Lower.Key)
+		before = func(e indexEntry) bool {
+			compared := compareKeys(e.key, r.Lower.Key)
+			return compared < 0 || compared == 0 && !r.Lower.Inclusive
+		}
+	}
+	if r.Upper != nil && len(r.Upper.Key) > columns {
+		columns = len(r.Upper.Key)
+	}
+
+	c := ix.tree.seek(before)
+
+	var result []rowID
+	for {
+		e, ok := c.next()
+		if !ok {
+			return result
+		}
+		if r.Upper != nil {
+			compared := compareKeys(e.key, r.Upper.Key)
+			if compared > 0 || compared == 0 && !r.Upper.Inclusive {
+				return result
+			}
+		}
+		if hasNull(e.key[:columns]) {
+			continue
+		}
+		result = append(result, e.row)
+	}
+}
+
+func hasNull(values []types.Value) bool {
+	for _, v := range values {
+		if v.Null() {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateIndex creates a B+tree index on one or more columns of a table and adds the table's rows to
+// it.
+func (d *Database) CreateIndex(name, tableName string, columns []string) error {
+	return d.CreateIndexUsing(name, tableName, columns, IndexMethodBtree)
+}
+
+// CreateIndexUsing creates an index of the given method on one or more columns of a table and adds
+// the table's rows to it.
+func (d *Database) CreateIndexUsing(name, tableName string, columns []string, method IndexMethod) error {
+	if d.closed {
+		return ErrClosed
+	}
+	if _, _, ok := d.findIndex(name); ok {
+		return fmt.Errorf("index already exists: %s", name)
+	}
+	t, err := d.table(tableName)
+	if err != nil {
+		return err
+	}
+	ix, err := newIndex(Index{Name: name, Table: tableName, Columns: columns, Method: method}, t.schema)
+	if err != nil {
+		return err
+	}
+	if err := t.build(ix); err != nil {
+		return err
+	}
+	if d.dir != "" {
+		tx := d.begin()
+		tx.log(logRecord{
+			kind:    recordCreateIndex,
+			table:   tableName,
+			index:   name,
+			columns: columns,
+			method:  method,
+		})
+		if err := tx.commit(); err != nil {
+			return err
+		}
+	}
+	t.indexes = append(t.indexes, ix)
+	return nil
+}
+
+// DropIndex removes an index.
+func (d *Database) DropIndex(name string) error {
+	if d.closed {
+		return ErrClosed
+	}
+	t, i, ok := d.findIndex(name)
+	if !ok {
+		return fmt.Errorf("index not found: %s", name)
+	}
+	if d.dir != "" {
+		tx := d.begin()
+		tx.log(logRecord{kind: recordDropIndex, table: t.indexes[i].Table, index: name})
+		if err := tx.commit(); err != nil {
+			return err
+		}
+	}
+	t.indexes = append(t.indexes[:i:i], t.indexes[i+1:]...)
+	return nil
+}
+
+// Indexes returns the indexes of a table.
+func (d *Database) Indexes(tableName string) ([]Index, error) {
+	t, err := d.table(tableName)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Index, len(t.indexes))
+	for i, ix := range t.indexes {
+		result[i] = ix.Index
+	}
+	return result, nil
+}
+
+// IndexScan returns an iterator over the rows of a table whose keys in a B+tree index are in a
+// range, in the order of the index.
+func (d *Database) IndexScan(name string, r KeyRange) (*TableScan, error) {
+	if d.closed {
+		return nil, ErrClosed
+	}
+	t, position, ok := d.findIndex(name)
+	if !ok {
+		return nil, fmt.Errorf("index not found: %s", name)
+	}
+	ix := t.indexes[position]
+	if ix.Method != IndexMethodBtree {
+		return nil, fmt.Errorf("index %s is a %s index, which can't scan a range", name, ix.Method)
+	}
+	for _, b := range []*Bound{r.Lower, r.Upper} {
+		if b != nil && len(b.Key) > len(ix.Columns) {
+			return nil, fmt.Errorf("key range %s has more columns than index %s", r, name)
+		}
+	}
+	if t.relation != nil {
+		t.syncIndexes()
+	}
+	return t.scanRows(ix.scan(r)), nil
+}
+
+// HashIndexScan returns an iterator over the rows of a table whose keys in a hash index are any of a
+// list of keys, in the order of the keys. Each key has a value for each column of the index.
+func (d *Database) HashIndexScan(name string, keys [][]types.Value) (*TableScan, error) {
+	if d.closed {
+		return nil, ErrClosed
+	}
+	t, position, ok := d.findIndex(name)
+	if !ok {
+		return nil, fmt.Errorf("index not found: %s", name)
+	}
+	ix := t.indexes[position]
+	if ix.Method != IndexMethodHash {
+		return nil, fmt.Errorf("index %s is a %s index, not a hash index", name, ix.Method)
+	}
+	if t.relation != nil {
+		t.syncIndexes()
+	}
+	var ids []rowID
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if len(key) != len(ix.Columns) {
+			return nil, fmt.Errorf("key %s doesn't match the columns of index %s", keyString(key), name)
+		}
+		if seen[types.RowKey(key)] {
+			continue
+		}
+		seen[types.RowKey(key)] = true
+		ids = append(ids, ix.hash.lookup(key)...)
+	}
+	return t.scanRows(ids), nil
+}
+
+// scanRows returns an iterator over the rows of a table with the given IDs.
+func (t *table) scanRows(ids []rowID) *TableScan {
+	if t.relation != nil {
+		rows := make([][]types.Value, len(ids))
+		for i, id := range ids {
+			rows[i] = t.relation.Rows[id.slot]
+		}
+		return &TableScan{schema: t.schema, rows: rows}
+	}
+	return &TableScan{schema: t.schema, heap: t.heap, ids: ids}
+}
+
+// findIndex returns the table an index belongs to and its position in the table's indexes.
+func (d *Database) findIndex(name string) (*table, int, bool) {
+	for _, t := range d.tables {
+		for i, ix := range t.indexes {
+			if ix.Name == name {
+				return t, i, true
+			}
+		}
+	}
+	return nil, 0, false
+}
+
+// build adds the rows of a table to an index.
+func (t *table) build(ix *index) error {
+	if t.relation != nil {
+		t.syncIndexes()
+		for i, row := range t.relation.Rows {
+			ix.add(row, rowID{slot: i})
+		}
+		return nil
+	}
+	for n := 0; n < t.heap.pages; n++ {
+		slots, rows, err := t.heap.pageRows(n)
+		if err != nil {
+			return err
+		}
+		for i, row := range rows {
+			ix.add(row, rowID{n, slots[i]})
+		}
+	}
+	return nil
+}
+
+// syncIndexes adds the rows that were appended to the relation of a table kept in memory to its
+// indexes, since rows can be appended to it directly.
+func (t *table) syncIndexes() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rows := t.relation.Rows
+	for i := t.indexed; i < len(rows); i++ {
+		for _, ix := range t.indexes {
+			ix.add(rows[i], rowID{slot: i})
+		}
+	}
+	t.indexed = len(rows)
+}
+
+// rebuildIndexes empties the indexes of a table kept in memory and adds all its rows again. It's
+// needed after rows are deleted, which changes the positions of the rows after them.
+func (t *table) rebuildIndexes() {
+	t.mu.Lock()
+	for _, ix := range t.indexes {
+		ix.clear()
+	}
+	t.indexed = 0
+	t.mu.Unlock()
+	t.syncIndexes()
+}
+
+func (t *table) addToIndexes(row []types.Value, id rowID) {
+	for _, ix := range t.indexes {
+		ix.add(row, id)
+	}
+}
+
+func (t *table) removeFromIndexes(row []types.Value, id rowID) error {
+	for _, ix := range t.indexes {
+		if err := ix.remove(row, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reindex updates the indexes of a table after rows were changed: it removes the old rows and, if
+// updated isn't nil, adds the updated row at the same position for each. If an old row isn't in an
+// index, the indexes don't match the table, which shouldn't happen; they're rebuilt from the
+// table's rows then.
+func (t *table) reindex(old, updated []storedRow) error {
+	for i, r := range old {
+		if err := t.removeFromIndexes(r.row, r.id); err != nil {
+			return t.rebuildAllIndexes()
+		}
+		if updated != nil {
+			t.addToIndexes(updated[i].row, updated[i].id)
+		}
+	}
+	return nil
+}
+
+// rebuildAllIndexes empties the indexes of a table and adds all its rows again.
+func (t *table) rebuildAllIndexes() error {
+	if t.relation != nil {
+		t.rebuildIndexes()
+		return nil
+	}
+	for _, ix := range t.indexes {
+		ix.clear()
+		if err := t.build(ix); err != nil {
+			return fmt.Errorf("error rebuilding index %s: %w", ix.Name, err)
+		}
+	}
+	return nil
+}
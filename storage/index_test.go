@@ -0,0 +1,444 @@
+package storage
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/lfritz/toydb/types"
+)
+
+// testRanges are key ranges for an index on columns b and d of allTypesSchema.
+var testRanges = []KeyRange{
+	{},
+	{Lower: &Bound{Key: []types.Value{types.Boo(true)}, Inclusive: true}},
+	{
+		Lower: &Bound{Key: []types.Value{types.Boo(false)}, Inclusive: true},
+		Upper: &Bound{Key: []types.Value{types.Boo(false)}, Inclusive: true},
+	},
+	{
+		Lower: &Bound{Key: []types.Value{types.Boo(true), types.Dec("-400")}},
+		Upper: &Bound{Key: []types.Value{types.Boo(true), types.Dec("-300")}, Inclusive: true},
+	},
+	{
+		Lower: &Bound{Key: []types.Value{types.Boo(false), types.Dec("-350.5")}, Inclusive: true},
+		Upper: &Bound{Key: []types.Value{types.Boo(false)}, Inclusive: true},
+	},
+	{Upper: &Bound{Key: []types.Value{types.Boo(false), types.Dec("-450")}}},
+	{
+		Lower: &Bound{Key: []types.Value{types.Boo(true)}},
+		Upper: &Bound{Key: []types.Value{types.Boo(false)}},
+	},
+}
+
+// checkIndexScans compares the rows returned by IndexScan for testRanges with the rows of the table
+// that are in each range.
+func checkIndexScans(t *testing.T, db *Database, name string) {
+	t.Helper()
+	table, err := db.Table("t")
+	if err != nil {
+		t.Fatalf("Table returned error: %v", err)
+	}
+	key := func(row []types.Value) []types.Value { return []types.Value{row[0], row[2]} }
+	for _, r := range testRanges {
+		var want []string
+		for _, row := range table.Rows {
+			k := key(row)
+			if r.Lower != nil {
+				if hasNull(k[:len(r.Lower.Key)]) {
+					continue
+				}
+				compared := compareKeys(k, r.Lower.Key)
+				if compared < 0 || compared == 0 && !r.Lower.Inclusive {
+					continue
+				}
+			}
+			if r.Upper != nil {
+				if hasNull(k[:len(r.Upper.Key)]) {
+					continue
+				}
+				compared := compareKeys(k, r.Upper.Key)
+				if compared > 0 || compared == 0 && !r.Upper.Inclusive {
+					continue
+				}
+			}
+			want = append(want, types.RowKey(row))
+		}
+
+		scan, err := db.IndexScan(name, r)
+		if err != nil {
+			t.Fatalf("IndexScan returned error: %v", err)
+		}
+		var got []string
+		var last []types.Value
+		for {
+			row, ok, err := scan.Next()
+			if err != nil {
+				t.Fatalf("Next returned error: %v", err)
+			}
+			if !ok {
+				break
+			}
+			if last != nil && compareKeys(last, key(row)) > 0 {
+				t.Errorf("index scan for %s returned rows out of order", r)
+			}
+			last = key(row)
+			got = append(got, types.RowKey(row))
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("index scan for %s returned %d rows, want %d", r, len(got), len(want))
+		}
+	}
+}
+
+func TestIndex(t *testing.T) {
+	for _, dir := range []string{"", t.TempDir()} {
+		db := NewDatabase()
+		if dir != "" {
+			db = mustOpen(t, dir)
+		}
+		if _, err := db.CreateTable("t", allTypesSchema); err != nil {
+			t.Fatalf("CreateTable returned error: %v", err)
+		}
+		for i := 0; i < 200; i++ {
+			if err := db.Insert("t", allTypesRow(i)); err != nil {
+				t.Fatalf("Insert returned error: %v", err)
+			}
+		}
+
+		// the index gets the existing rows, and is maintained for changes
+		if err := db.CreateIndex("t_b_d", "t", []string{"b", "d"}); err != nil {
+			t.Fatalf("CreateIndex returned error: %v", err)
+		}
+		checkIndexScans(t, db, "t_b_d")
+		for i := 200; i < 400; i++ {
+			if err := db.Insert("t", allTypesRow(i)); err != nil {
+				t.Fatalf("Insert returned error: %v", err)
+			}
+		}
+		_, err := db.Update(
+			"t",
+			func(row []types.Value) bool { return row[0].IsTrue() },
+			func(row []types.Value) []types.Value {
+				return []types.Value{types.Boo(false), types.Txt("updated " + row[1].String()), row[2], row[3]}
+			},
+		)
+		if err != nil {
+			t.Fatalf("Update returned error: %v", err)
+		}
+		_, err = db.Delete("t", func(row []types.Value) bool { return row[3].Null() || row[0].Null() })
+		if err != nil {
+			t.Fatalf("Delete returned error: %v", err)
+		}
+		for i := 400; i < 450; i++ {
+			if err := db.Insert("t", allTypesRow(i)); err != nil {
+				t.Fatalf("Insert returned error: %v", err)
+			}
+		}
+		checkIndexScans(t, db, "t_b_d")
+
+		if dir == "" {
+			// rows appended to the relation directly are added to the index when it's used
+			table, err := db.Table("t")
+			if err != nil {
+				t.Fatalf("Table returned error: %v", err)
+			}
+			table.Rows = append(table.Rows, allTypesRow(1000), allTypesRow(1001))
+			checkIndexScans(t, db, "t_b_d")
+			continue
+		}
+
+		// the index is rebuilt when the database is opened again, after closing it or recovering
+		if err := db.Close(); err != nil {
+			t.Fatalf("Close returned error: %v", err)
+		}
+		db = mustOpen(t, dir)
+		checkIndexScans(t, db, "t_b_d")
+		if err := db.Insert("t", allTypesRow(2000)); err != nil {
+			t.Fatalf("Insert returned error: %v", err)
+		}
+		if err := db.CreateIndex("t_t", "t", []string{"t"}); err != nil {
+			t.Fatalf("CreateIndex returned error: %v", err)
+		}
+		crash(db)
+		db = mustOpen(t, dir)
+		checkIndexScans(t, db, "t_b_d")
+		indexes, err := db.Indexes("t")
+		if err != nil {
+			t.Fatalf("Indexes returned error: %v", err)
+		}
+		want := []Index{
+			{Name: "t_b_d", Table: "t", Columns: []string{"b", "d"}},
+			{Name: "t_t", Table: "t", Columns: []string{"t"}},
+		}
+		if !reflect.DeepEqual(indexes, want) {
+			t.Errorf("Indexes returned %v, want %v", indexes, want)
+		}
+
+		// a dropped index is gone after reopening
+		if err := db.DropIndex("t_b_d"); err != nil {
+			t.Fatalf("DropIndex returned error: %v", err)
+		}
+		crash(db)
+		db = mustOpen(t, dir)
+		if indexes, _ := db.Indexes("t"); len(indexes) != 1 {
+			t.Errorf("Indexes returned %v after DropIndex, want one index", indexes)
+		}
+		db.Close()
+	}
+}
+
+// testKeys are keys for an index on columns d and b of allTypesSchema.
+var testKeys = [][]types.Value{
+	{types.Dec("-490.0"), types.Boo(true)},
+	{types.Dec("-490"), types.Boo(true)},
+	{types.Dec("-489.1"), types.Boo(true)},
+	{types.Dec("-489.1"), types.Boo(false)},
+	{types.Dec("-100.0"), types.Boo(true)},
+	{types.Dec("1000"), types.Boo(true)},
+	{types.NewNull(types.TypeDecimal), types.Boo(true)},
+}
+
+// checkHashIndexScans compares the rows returned by HashIndexScan for each of testKeys, and for all
+// of them, with the rows of the table that have the key.
+func checkHashIndexScans(t *testing.T, db *Database, name string) {
+	t.Helper()
+	table, err := db.Table("t")
+	if err != nil {
+		t.Fatalf("Table returned error: %v", err)
+	}
+	lists := [][][]types.Value{testKeys}
+	for _, key := range testKeys {
+		lists = append(lists, [][]types.Value{key})
+	}
+	for _, keys := range lists {
+		var want []string
+		for _, row := range table.Rows {
+			for _, key := range keys {
+				k := []types.Value{row[2], row[0]}
+				if !hasNull(key) && !hasNull(k) && types.RowKey(k) == types.RowKey(key) {
+					want = append(want, types.RowKey(row))
+					break
+				}
+			}
+		}
+
+		scan, err := db.HashIndexScan(name, keys)
+		if err != nil {
+			t.Fatalf("HashIndexScan returned error: %v", err)
+		}
+		var got []string
+		for {
+			row, ok, err := scan.Next()
+			if err != nil {
+				t.Fatalf("Next returned error: %v", err)
+			}
+			if !ok {
+				break
+			}
+			got = append(got, types.RowKey(row))
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("hash index scan for %v returned %d rows, want %d", keys, len(got), len(want))
+		}
+	}
+}
+
+// TestIndexOutOfSync checks that indexes that don't match their table are rebuilt when rows are
+// changed, and that the changes are still reported.
+func TestIndexOutOfSync(t *testing.T) {
+	for _, dir := range []string{"", t.TempDir()} {
+		db := NewDatabase()
+		if dir != "" {
+			db = mustOpen(t, dir)
+		}
+		if _, err := db.CreateTable("t", allTypesSchema); err != nil {
+			t.Fatalf("CreateTable returned error: %v", err)
+		}
+		for i := 0; i < 100; i++ {
+			if err := db.Insert("t", allTypesRow(i)); err != nil {
+				t.Fatalf("Insert returned error: %v", err)
+			}
+		}
+		if err := db.CreateIndex("t_b_d", "t", []string{"b", "d"}); err != nil {
+			t.Fatalf("CreateIndex returned error: %v", err)
+		}
+		if err := db.CreateIndexUsing("t_db", "t", []string{"d", "b"}, IndexMethodHash); err != nil {
+			t.Fatalf("CreateIndexUsing returned error: %v", err)
+		}
+		isTrue := func(row []types.Value) bool { return row[0].IsTrue() }
+
+		// remove the first matching row from the indexes, as if they'd missed a change
+		breakIndexes := func() {
+			t.Helper()
+			table := db.tables["t"]
+			rows, err := table.matching(isTrue)
+			if err != nil {
+				t.Fatalf("matching returned error: %v", err)
+			}
+			if err := table.removeFromIndexes(rows[0].row, rows[0].id); err != nil {
+				t.Fatalf("removeFromIndexes returned error: %v", err)
+			}
+		}
+
+		breakIndexes()
+		n, err := db.Update("t", isTrue, func(row []types.Value) []types.Value {
+			return []types.Value{row[0], types.Txt("updated " + row[1].String()), types.Dec("1"), row[3]}
+		})
+		if err != nil || n == 0 {
+			t.Errorf("Update returned %d, %v", n, err)
+		}
+		checkIndexScans(t, db, "t_b_d")
+		checkHashIndexScans(t, db, "t_db")
+
+		breakIndexes()
+		n, err = db.Delete("t", isTrue)
+		if err != nil || n == 0 {
+			t.Errorf("Delete returned %d, %v", n, err)
+		}
+		checkIndexScans(t, db, "t_b_d")
+		checkHashIndexScans(t, db, "t_db")
+
+		if err := db.Close(); err != nil {
+			t.Fatalf("Close returned error: %v", err)
+		}
+	}
+}
+
+func TestHashIndex(t *testing.T) {
+	for _, dir := range []string{"", t.TempDir()} {
+		db := NewDatabase()
+		if dir != "" {
+			db = mustOpen(t, dir)
+		}
+		if _, err := db.CreateTable("t", allTypesSchema); err != nil {
+			t.Fatalf("CreateTable returned error: %v", err)
+		}
+		for i := 0; i < 200; i++ {
+			if err := db.Insert("t", allTypesRow(i)); err != nil {
+				t.Fatalf("Insert returned error: %v", err)
+			}
+		}
+
+		// the index gets the existing rows, and is maintained for changes
+		if err := db.CreateIndexUsing("t_d_b", "t", []string{"d", "b"}, IndexMethodHash); err != nil {
+			t.Fatalf("CreateIndexUsing returned error: %v", err)
+		}
+		checkHashIndexScans(t, db, "t_d_b")
+		for i := 200; i < 400; i++ {
+			if err := db.Insert("t", allTypesRow(i)); err != nil {
+				t.Fatalf("Insert returned error: %v", err)
+			}
+		}
+		_, err := db.Update(
+			"t",
+			func(row []types.Value) bool { return row[0].IsTrue() },
+			func(row []types.Value) []types.Value {
+				return []types.Value{types.Boo(false), row[1], row[2], row[3]}
+			},
+		)
+		if err != nil {
+			t.Fatalf("Update returned error: %v", err)
+		}
+		_, err = db.Delete("t", func(row []types.Value) bool { return row[3].Null() })
+		if err != nil {
+			t.Fatalf("Delete returned error: %v", err)
+		}
+		for i := 0; i < 50; i++ {
+			if err := db.Insert("t", allTypesRow(i)); err != nil {
+				t.Fatalf("Insert returned error: %v", err)
+			}
+		}
+		checkHashIndexScans(t, db, "t_d_b")
+		if dir == "" {
+			continue
+		}
+
+		// the index is rebuilt when the database is opened again, after closing it or recovering
+		if err := db.Close(); err != nil {
+			t.Fatalf("Close returned error: %v", err)
+		}
+		db = mustOpen(t, dir)
+		checkHashIndexScans(t, db, "t_d_b")
+		if err := db.CreateIndexUsing("t_t", "t", []string{"t"}, IndexMethodHash); err != nil {
+			t.Fatalf("CreateIndexUsing returned error: %v", err)
+		}
+		crash(db)
+		db = mustOpen(t, dir)
+		checkHashIndexScans(t, db, "t_d_b")
+		indexes, err := db.Indexes("t")
+		if err != nil {
+			t.Fatalf("Indexes returned error: %v", err)
+		}
+		want := []Index{
+			{Name: "t_d_b", Table: "t", Columns: []string{"d", "b"}, Method: IndexMethodHash},
+			{Name: "t_t", Table: "t", Columns: []string{"t"}, Method: IndexMethodHash},
+		}
+		if !reflect.DeepEqual(indexes, want) {
+			t.Errorf("Indexes returned %v, want %v", indexes, want)
+		}
+		db.Close()
+	}
+}
+
+func TestIndexInvalid(t *testing.T) {
+	db := mustOpen(t, t.TempDir())
+	defer db.Close()
+	if _, err := db.CreateTable("t", allTypesSchema); err != nil {
+		t.Fatalf("CreateTable returned error: %v", err)
+	}
+	if err := db.CreateIndex("t_b", "t", []string{"b"}); err != nil {
+		t.Fatalf("CreateIndex returned error: %v", err)
+	}
+	cases := []struct {
+		name, table string
+		columns     []string
+	}{
+		{"t_b", "t", []string{"d"}},
+		{"", "t", []string{"d"}},
+		{"t_d", "missing", []string{"d"}},
+		{"t_d", "t", nil},
+		{"t_d", "t", []string{"x"}},
+		{"t_d", "t", []string{"d", "d"}},
+	}
+	for _, c := range cases {
+		if err := db.CreateIndex(c.name, c.table, c.columns); err == nil {
+			t.Errorf("CreateIndex did not return error for %q on %s %v", c.name, c.table, c.columns)
+		}
+	}
+	if err := db.DropIndex("missing"); err == nil {
+		t.Errorf("DropIndex did not return error for missing index")
+	}
+	if _, err := db.IndexScan("missing", KeyRange{}); err == nil {
+		t.Errorf("IndexScan did not return error for missing index")
+	}
+	r := KeyRange{Lower: &Bound{Key: []types.Value{types.Boo(true), types.Boo(true)}}}
+	if _, err := db.IndexScan("t_b", r); err == nil {
+		t.Errorf("IndexScan did not return error for range with too many columns")
+	}
+
+	if err := db.CreateIndexUsing("t_d", "t", []string{"d"}, IndexMethod(5)); err == nil {
+		t.Errorf("CreateIndexUsing did not return error for invalid method")
+	}
+	if err := db.CreateIndexUsing("t_db", "t", []string{"d", "b"}, IndexMethodHash); err != nil {
+		t.Fatalf("CreateIndexUsing returned error: %v", err)
+	}
+	if _, err := db.IndexScan("t_d", KeyRange{}); err == nil {
+		t.Errorf("IndexScan did not return error for hash index")
+	}
+	key := []types.Value{types.Boo(true)}
+	if _, err := db.HashIndexScan("t_b", [][]types.Value{key}); err == nil {
+		t.Errorf("HashIndexScan did not return error for B+tree index")
+	}
+	if _, err := db.HashIndexScan("missing", [][]types.Value{key}); err == nil {
+		t.Errorf("HashIndexScan did not return error for missing index")
+	}
+	key = []types.Value{types.Dec("1"), types.Dec("2")}
+	if _, err := db.HashIndexScan("t_d", [][]types.Value{key}); err == nil {
+		t.Errorf("HashIndexScan did not return error for key with too many columns")
+	}
+}
@@ -0,0 +1,216 @@
+package storage
+
+import "encoding/binary"
+
+// PageSize is the size in bytes of the pages that heap files are made of.
+const PageSize = 4096
+
+// A page is a slotted page holding variable-length records. It starts with a header of two uint16
+// values, the number of slots and the offset where the record data starts, followed by the log
+// sequence number of the last change to the page as a uint64. The slot array follows the header,
+// with the offset and length of each record. Records are stored at the end of the page and grow
+// towards the slot array. A slot with length zero is empty; inserts reuse empty slots, and the
+// records are compacted when a record doesn't fit in the gap between the slot array and the data.
+type page [PageSize]byte
+
+const (
+	pageHeaderSize = 12
+	slotSize       = 4
+)
+
+// maxRecordSize is the size of the largest record that fits on an empty page.
+const maxRecordSize = PageSize - pageHeaderSize - slotSize
+
+// init makes p an empty page.
+func (p *page) init() {
+	*p = page{}
+	p.setDataStart(PageSize)
+}
+
+func (p *page) slots() int {
+	return int(binary.LittleEndian.Uint16(p[0:]))
+}
+
+func (p *page) setSlots(n int) {
+	binary.LittleEndian.PutUint16(p[0:], uint16(n))
+}
+
+func (p *page) dataStart() int {
+	return int(binary.LittleEndian.Uint16(p[2:]))
+}
+
+func (p *page) setDataStart(offset int) {
+	binary.LittleEndian.PutUint16(p[2:], uint16(offset))
+}
+
+// lsn returns the log sequence number of the last change to the page.
+func (p *page) lsn() uint64 {
+	return binary.LittleEndian.Uint64(p[4:])
+}
+
+func (p *page) setLSN(lsn uint64) {
+	binary.LittleEndian.PutUint64(p[4:], lsn)
+}
+
+// slot returns the offset and length of the record in a slot.
+func (p *page) slot(i int) (offset, length int) {
+	s := p[pageHeaderSize+i*slotSize:]
+	return int(binary.LittleEndian.Uint16(s)), int(binary.LittleEndian.Uint16(s[2:]))
+}
+
+func (p *page) setSlot(i, offset, length int) {
+	s := p[pageHeaderSize+i*slotSize:]
+	binary.LittleEndian.PutUint16(s, uint16(offset))
+	binary.LittleEndian.PutUint16(s[2:], uint16(length))
+}
+
+// record returns the record in a slot, sharing memory with the page, or false if the slot is empty.
+func (p *page) record(i int) ([]byte, bool) {
+	offset, length := p.slot(i)
+	if length == 0 {
+		return nil, false
+	}
+	return p[offset : offset+length], true
+}
+
+// insert adds a record to the page and returns its slot, or false if there isn't enough room. It
+// uses the first empty slot if there is one.
+func (p *page) insert(record []byte) (int, bool) {
+	if len(record) == 0 || len(record) > p.free() {
+		return 0, false
+	}
+	n := p.slots()
+	slot := n
+	for i := 0; i < n; i++ {
+		if _, length := p.slot(i); length == 0 {
+			slot = i
+			break
+		}
+	}
+	if slot == n {
+		n++
+	}
+	if len(record) > p.dataStart()-pageHeaderSize-n*slotSize {
+		p.compact()
+	}
+	offset := p.dataStart() - len(record)
+	copy(p[offset:], record)
+	p.setDataStart(offset)
+	p.setSlot(slot, offset, len(record))
+	p.setSlots(n)
+	return slot, true
+}
+
+// free returns the number of bytes available for a new record, counting the space of deleted
+// records and, if there's no empty slot, the space for a new slot.
+func (p *page) free() int {
+	free, empty := p.unused()
+	if !empty {
+		free -= slotSize
+	}
+	if free < 0 {
+		return 0
+	}
+	return free
+}
+
+// unused returns the number of bytes not taken by the header, the slot array or records, and
+// whether there's an empty slot.
+func (p *page) unused() (int, bool) {
+	unused := PageSize - pageHeaderSize - p.slots()*slotSize
+	empty := false
+	for i := 0; i < p.slots(); i++ {
+		_, length := p.slot(i)
+		unused -= length
+		empty = empty || length == 0
+	}
+	return unused, empty
+}
+
+// fragmented checks if records were deleted from the page or replaced by shorter ones, leaving space
+// that's only reused after compacting the page.
+func (p *page) fragmented() bool {
+	used := 0
+	for i := 0; i < p.slots(); i++ {
+		_, length := p.slot(i)
+		if length == 0 {
+			return true
+		}
+		used += length
+	}
+	return used < PageSize-p.dataStart()
+}
+
+// compact moves the records to the end of the page, so the space of deleted records and of the old
+// versions of updated records is in the gap between the slot array and the data. Slots keep their
+// numbers.
+func (p *page) compact() {
+	data := *p
+	offset := PageSize
+	for i := 0; i < p.slots(); i++ {
+		start, length := data.slot(i)
+		if length == 0 {
+			continue
+		}
+		offset -= length
+		copy(p[offset:], data[start:start+length])
+		p.setSlot(i, offset, length)
+	}
+	p.setDataStart(offset)
+}
+
+// delete empties a slot. The space of the record is reused once the page is compacted.
+func (p *page) delete(i int) bool {
+	if i >= p.slots() {
+		return false
+	}
+	if _, length := p.slot(i); length == 0 {
+		return false
+	}
+	p.setSlot(i, 0, 0)
+	return true
+}
+
+// update replaces the record in a slot. The new record is written in place of the old one if it's
+// not larger, or to the free space otherwise, compacting the page if necessary. It returns false if
+// the slot is empty or the new record doesn't fit.
+func (p *page) update(i int, record []byte) bool {
+	if i >= p.slots() || len(record) == 0 {
+		return false
+	}
+	offset, length := p.slot(i)
+	if length == 0 {
+		return false
+	}
+	if len(record) > length {
+		// the new record can use the space of the old one
+		if unused, _ := p.unused(); len(record) > unused+length {
+			return false
+		}
+		p.setSlot(i, 0, 0)
+		if len(record) > p.dataStart()-pageHeaderSize-p.slots()*slotSize {
+			p.compact()
+		}
+		offset = p.dataStart() - len(record)
+		p.setDataStart(offset)
+	}
+	copy(p[offset:], record)
+	p.setSlot(i, offset, len(record))
+	return true
+}
+
+// check returns false if the header or slot array point outside the page, which means it wasn't
+// written as a page.
+func (p *page) check() bool {
+	n := p.slots()
+	if p.dataStart() > PageSize || pageHeaderSize+n*slotSize > p.dataStart() {
+		return false
+	}
+	for i := 0; i < n; i++ {
+		offset, length := p.slot(i)
+		if length > 0 && (offset < p.dataStart() || offset+length > PageSize) {
+			return false
+		}
+	}
+	return true
+}
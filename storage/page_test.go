@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestPage(t *testing.T) {
+	p := new(page)
+	p.init()
+
+	var records [][]byte
+	for i := 0; ; i++ {
+		record := []byte(fmt.Sprintf("record %d", i))
+		slot, ok := p.insert(record)
+		if !ok {
+			break
+		}
+		if slot != i {
+			t.Fatalf("insert returned slot %d, want %d", slot, i)
+		}
+		records = append(records, record)
+	}
+
+	// each record takes its length plus a slot; the page is full when the next one doesn't fit
+	used := pageHeaderSize
+	for _, r := range records {
+		used += len(r) + slotSize
+	}
+	if free := PageSize - used; free >= len("record 999")+slotSize {
+		t.Errorf("page is full with %d bytes free", free)
+	}
+
+	if !p.check() {
+		t.Errorf("check returned false for valid page")
+	}
+	if got := p.slots(); got != len(records) {
+		t.Errorf("slots returned %d, want %d", got, len(records))
+	}
+	for i, want := range records {
+		got, ok := p.record(i)
+		if !ok || !bytes.Equal(got, want) {
+			t.Errorf("record(%d) returned %q, %v, want %q", i, got, ok, want)
+		}
+	}
+}
+
+func TestPageLimits(t *testing.T) {
+	p := new(page)
+	p.init()
+	if _, ok := p.insert(nil); ok {
+		t.Errorf("insert succeeded for empty record")
+	}
+	if _, ok := p.insert(make([]byte, maxRecordSize+1)); ok {
+		t.Errorf("insert succeeded for record of %d bytes", maxRecordSize+1)
+	}
+	if _, ok := p.insert(make([]byte, maxRecordSize)); !ok {
+		t.Errorf("insert failed for record of %d bytes", maxRecordSize)
+	}
+	if _, ok := p.insert([]byte{1}); ok {
+		t.Errorf("insert succeeded for full page")
+	}
+}
+
+func TestPageCheck(t *testing.T) {
+	p := new(page)
+	p.init()
+	p.insert([]byte("hello"))
+	p.setSlot(0, PageSize-2, 5)
+	if p.check() {
+		t.Errorf("check returned true for record past the end of the page")
+	}
+
+	p.init()
+	p.setSlots(2000)
+	if p.check() {
+		t.Errorf("check returned true for slot array overlapping records")
+	}
+}
+
+func TestPageReuse(t *testing.T) {
+	p := new(page)
+	p.init()
+	record := bytes.Repeat([]byte("x"), 100)
+	var n int
+	for {
+		if _, ok := p.insert(record); !ok {
+			break
+		}
+		n++
+	}
+
+	// an insert takes the first empty slot, compacting the page to make room
+	for i := 0; i < n; i += 2 {
+		p.delete(i)
+	}
+	for i := 0; i < n; i += 2 {
+		slot, ok := p.insert(record)
+		if !ok || slot != i {
+			t.Fatalf("insert returned %d, %v, want slot %d", slot, ok, i)
+		}
+	}
+	if _, ok := p.insert(record); ok {
+		t.Errorf("insert succeeded for full page")
+	}
+
+	// a record can grow into the space of deleted records
+	p.delete(0)
+	p.delete(1)
+	longer := bytes.Repeat([]byte("y"), 250)
+	if !p.update(2, longer) {
+		t.Fatalf("update failed for record that fits after compacting")
+	}
+	if p.update(3, bytes.Repeat([]byte("z"), 300)) {
+		t.Errorf("update succeeded for record that doesn't fit")
+	}
+	if !p.check() {
+		t.Errorf("check returned false for compacted page")
+	}
+	for i := 2; i < n; i++ {
+		want := record
+		if i == 2 {
+			want = longer
+		}
+		got, ok := p.record(i)
+		if !ok || !bytes.Equal(got, want) {
+			t.Errorf("record(%d) returned %q, %v, want %q", i, got, ok, want)
+		}
+	}
+}
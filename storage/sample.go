@@ -43,9 +43,9 @@ func GetSampleData() *SampleData {
 	}
 
 	database := &Database{
-		tables: map[string]*types.Relation{
-			"films":  films,
-			"people": people,
+		tables: map[string]*table{
+			"films":  {schema: filmsSchema, relation: films},
+			"people": {schema: peopleSchema, relation: people},
 		},
 	}
 
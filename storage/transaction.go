@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lfritz/toydb/types"
+)
+
+// A transaction makes the changes of one call that modifies a database stored in a directory. It
+// changes pages in the buffer pool and keeps them pinned until the changes are in the log, and it
+// keeps the original contents of each page it changes so it can undo the changes.
+//
+// If the buffer pool runs out of frames, the transaction steals its pages: it writes the original
+// contents of the pages it changed to the log as page images, along with its records so far, and
+// unpins the pages, so the buffer pool can write them to the heap files before the transaction
+// commits. Recovery restores the page images of a transaction that didn't commit.
+type transaction struct {
+	d       *Database
+	records []logRecord // records not yet written to the log
+	pinned  map[pageID]*frame
+	changed map[pageID]*changedPage
+	written bool // whether some of the transaction's records have been written to the log
+}
+
+// A changedPage is a page a transaction changed.
+type changedPage struct {
+	table    string
+	original page // contents before the transaction
+	logged   bool // whether original has been written to the log as a page image
+}
+
+func (d *Database) begin() *transaction {
+	return &transaction{
+		d:       d,
+		pinned:  make(map[pageID]*frame),
+		changed: make(map[pageID]*changedPage),
+	}
+}
+
+// page returns a page of a table to change, pinning it until the transaction commits or steals it.
+func (tx *transaction) page(name string, h *heapFile, n int) (*frame, error) {
+	id := pageID{h, n}
+	if f, ok := tx.pinned[id]; ok {
+		return f, nil
+	}
+	f, err := tx.pin(func() (*frame, error) { return h.pool.fetch(h, n) })
+	if err != nil {
+		return nil, err
+	}
+	tx.pinned[id] = f
+	if _, ok := tx.changed[id]; !ok {
+		tx.changed[id] = &changedPage{table: name, original: f.page}
+	}
+	return f, nil
+}
+
+// pin calls fetch to get a pinned page. If the buffer pool is full, it steals the transaction's
+// pages and tries again.
+func (tx *transaction) pin(fetch func() (*frame, error)) (*frame, error) {
+	f, err := fetch()
+	if errors.Is(err, errBufferPoolFull) && len(tx.pinned) > 0 {
+		if err := tx.steal(); err != nil {
+			return nil, err
+		}
+		f, err = fetch()
+	}
+	return f, err
+}
+
+// release unpins a page if the transaction hasn't changed it, so it can be evicted.
+func (tx *transaction) release(h *heapFile, n int) {
+	id := pageID{h, n}
+	f, c := tx.pinned[id], tx.changed[id]
+	if f.page != c.original {
+		return
+	}
+	delete(tx.pinned, id)
+	if !c.logged {
+		delete(tx.changed, id)
+	}
+	h.pool.unpin(f, false)
+}
+
+// steal writes the transaction's records so far to the log, preceded by page images of the pages it
+// changed that aren't in the log yet, and unpins its pages.
+func (tx *transaction) steal() error {
+	var records []logRecord
+	for id := range tx.pinned {
+		c := tx.changed[id]
+		if !c.logged {
+			records = append(records, logRecord{kind: recordPageImage, table: c.table, page: id.n, image: &c.original})
+		}
+	}
+	records = append(records, tx.records...)
+	if err := tx.d.log.write(records); err != nil {
+		return err
+	}
+	tx.setLSNs(records)
+	for id, f := range tx.pinned {
+		tx.changed[id].logged = true
+		id.heap.pool.unpin(f, true)
+	}
+	tx.pinned = make(map[pageID]*frame)
+	tx.records = nil
+	tx.written = true
+	return nil
+}
+
+// insert adds a row to the first page with room for it in space freed by deletes and updates, to
+// the last page or to a new page, and returns where it's stored.
+func (tx *transaction) insert(name string, h *heapFile, row []types.Value) (rowID, error) {
+	record := types.AppendRow(nil, row)
+	if len(record) > maxRecordSize {
+		return rowID{}, fmt.Errorf("row too large: %d bytes, maximum is %d", len(record), maxRecordSize)
+	}
+	for n, ok := h.findPage(0, record); ok; n, ok = h.findPage(n+1, record) {
+		f, err := tx.page(name, h, n)
+		if err != nil {
+			return rowID{}, err
+		}
+		last := n == h.pages-1
+		if !last && !h.seen(n) {
+			// only space that was freed before counts, not what's left at the end of a page
+			free := 0
+			if f.page.fragmented() {
+				free = f.page.free()
+			}
+			h.setFree(n, free)
+		}
+		if last || h.hasRoom(n, record) {
+			if slot, ok := f.page.insert(record); ok {
+				if !last {
+					h.setFree(n, f.page.free())
+				}
+				tx.log(logRecord{kind: recordInsert, table: name, page: n, slot: slot, row: row, frame: f})
+				return rowID{n, slot}, nil
+			}
+		}
+		if last {
+			h.setFree(n, 0)
+		} else {
+			h.setFree(n, f.page.free())
+		}
+		tx.release(h, n)
+	}
+	f, err := tx.pin(func() (*frame, error) { return h.pool.allocate(h) })
+	if err != nil {
+		return rowID{}, err
+	}
+	n := h.pages - 1
+	tx.pinned[pageID{h, n}] = f
+	tx.changed[pageID{h, n}] = &changedPage{table: name, original: f.page}
+	slot, _ := f.page.insert(record)
+	tx.log(logRecord{kind: recordInsert, table: name, page: n, slot: slot, row: row, frame: f})
+	return rowID{n, slot}, nil
+}
+
+// update replaces a row in place if the new row fits on the page, or deletes it and inserts it
+// again otherwise. It returns where the new row is stored.
+func (tx *transaction) update(name string, h *heapFile, id rowID, row []types.Value) (rowID, error) {
+	record := types.AppendRow(nil, row)
+	f, err := tx.page(name, h, id.page)
+	if err != nil {
+		return rowID{}, err
+	}
+	if f.page.update(id.slot, record) {
+		h.setFree(id.page, f.page.free())
+		tx.log(logRecord{kind: recordUpdate, table: name, page: id.page, slot: id.slot, row: row, frame: f})
+		return id, nil
+	}
+	if err := tx.delete(name, h, id); err != nil {
+		return rowID{}, err
+	}
+	return tx.insert(name, h, row)
+}
+
+func (tx *transaction) delete(name string, h *heapFile, id rowID) error {
+	f, err := tx.page(name, h, id.page)
+	if err != nil {
+		return err
+	}
+	if !f.page.delete(id.slot) {
+		return fmt.Errorf("no row in slot %d of page %d of %s", id.slot, id.page, name)
+	}
+	h.setFree(id.page, f.page.free())
+	tx.log(logRecord{kind: recordDelete, table: name, page: id.page, slot: id.slot, frame: f})
+	return nil
+}
+
+func (tx *transaction) log(r logRecord) {
+	tx.records = append(tx.records, r)
+}
+
+// setLSNs marks the pages changed by records written to the log with the LSN of their last change.
+func (tx *transaction) setLSNs(records []logRecord) {
+	for _, r := range records {
+		if r.frame != nil {
+			r.frame.page.setLSN(r.lsn)
+		}
+	}
+}
+
+// commit writes the transaction's records to the log followed by a commit record, then releases the
+// pages it changed, marking them with the LSN of their last change.
+func (tx *transaction) commit() error {
+	if len(tx.records) > 0 || tx.written {
+		records := append(tx.records, logRecord{kind: recordCommit})
+		if err := tx.d.log.write(records); err != nil {
+			tx.abort()
+			return err
+		}
+		tx.setLSNs(records)
+	}
+	for _, f := range tx.pinned {
+		f.id.heap.pool.unpin(f, true)
+	}
+	return nil
+}
+
+// abort undoes the transaction's changes to pages and releases them. If some of its records were
+// written to the log, it also writes an abort record, so recovery undoes them too. If that's not
+// possible, the log is marked as unusable, since the changes would be redone with those of the
+// next transaction that commits.
+func (tx *transaction) abort() {
+	for id, f := range tx.pinned {
+		c := tx.changed[id]
+		f.page = c.original
+		id.heap.pool.unpin(f, c.logged)
+	}
+	var err error
+	for id, c := range tx.changed {
+		if _, ok := tx.pinned[id]; ok {
+			continue
+		}
+		f, fetchErr := id.heap.pool.fetch(id.heap, id.n)
+		if fetchErr != nil {
+			err = fetchErr
+			continue
+		}
+		f.page = c.original
+		id.heap.pool.unpin(f, true)
+	}
+	if err == nil && tx.written {
+		err = tx.d.log.write([]logRecord{{kind: recordAbort}})
+	}
+	if err != nil && tx.d.log.failed == nil {
+		tx.d.log.failed = fmt.Errorf("error aborting transaction: %w", err)
+	}
+}
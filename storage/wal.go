@@ -0,0 +1,365 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/lfritz/toydb/types"
+)
+
+// The write-ahead log of a database stored in a directory records each change before it's made to
+// a heap file. Records are numbered with increasing log sequence numbers (LSNs), and each page of a
+// heap file holds the LSN of the last change made to it.
+//
+// The log is a sequence of records, each stored as its length and CRC-32 checksum, both uint32,
+// followed by the encoded record. An encoded record starts with its kind as a byte and its LSN as a
+// uvarint; the rest depends on the kind:
+//
+//   - create table: the table name, then the schema as JSON
+//   - insert, update: the table name, the page and slot as uvarints, then the row
+//   - delete: the table name, then the page and slot as uvarints
+//   - create index: the table name, the index name, the number of columns as a uvarint followed by
+//     the column names, then the index method as a uvarint
+//   - drop index: the table name, then the index name
+//   - page image: the table name, the page as a uvarint, then the page's contents
+//   - commit, abort: nothing
+//
+// Strings and JSON are stored as their length as a uvarint followed by the bytes; rows are encoded
+// with types.AppendRow.
+const logFile = "wal.log"
+
+type recordKind byte
+
+const (
+	recordCreateTable recordKind = iota + 1
+	recordInsert
+	recordUpdate
+	recordDelete
+	recordCommit
+	recordCreateIndex
+	recordDropIndex
+	recordPageImage
+	recordAbort
+)
+
+func (k recordKind) String() string {
+	switch k {
+	case recordCreateTable:
+		return "create table"
+	case recordInsert:
+		return "insert"
+	case recordUpdate:
+		return "update"
+	case recordDelete:
+		return "delete"
+	case recordCommit:
+		return "commit"
+	case recordCreateIndex:
+		return "create index"
+	case recordDropIndex:
+		return "drop index"
+	case recordPageImage:
+		return "page image"
+	case recordAbort:
+		return "abort"
+	}
+	return fmt.Sprintf("recordKind(%d)", k)
+}
+
+// A logRecord describes a change to a database. Inserts, updates and deletes are recorded for the
+// page and slot they change, so they can be redone exactly. A page image holds the contents of a
+// page before a transaction changed it, so the change can be undone if the transaction doesn't
+// commit.
+type logRecord struct {
+	kind    recordKind
+	lsn     uint64
+	table   string
+	schema  types.TableSchema // for create table records
+	page    int
+	slot    int
+	row     []types.Value // for insert and update records
+	index   string        // for create index and drop index records
+	columns []string      // for create index records
+	method  IndexMethod   // for create index records
+	image   *page         // for page image records
+
+	frame *frame // the frame of the page changed by the record, while it's not committed
+}
+
+const logRecordHeaderSize = 8
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+func (r *logRecord) encode() []byte {
+	b := []byte{byte(r.kind)}
+	b = binary.AppendUvarint(b, r.lsn)
+	switch r.kind {
+	case recordCreateTable:
+		schema, err := json.Marshal(r.schema)
+		if err != nil {
+			panic(fmt.Sprintf("error encoding schema: %v", err))
+		}
+		b = appendBytes(b, []byte(r.table))
+		b = appendBytes(b, schema)
+	case recordInsert, recordUpdate, recordDelete:
+		b = appendBytes(b, []byte(r.table))
+		b = binary.AppendUvarint(b, uint64(r.page))
+		b = binary.AppendUvarint(b, uint64(r.slot))
+		if r.kind != recordDelete {
+			b = types.AppendRow(b, r.row)
+		}
+	case recordCreateIndex, recordDropIndex:
+		b = appendBytes(b, []byte(r.table))
+		b = appendBytes(b, []byte(r.index))
+		if r.kind == recordCreateIndex {
+			b = binary.AppendUvarint(b, uint64(len(r.columns)))
+			for _, c := range r.columns {
+				b = appendBytes(b, []byte(c))
+			}
+			b = binary.AppendUvarint(b, uint64(r.method))
+		}
+	case recordPageImage:
+		b = appendBytes(b, []byte(r.table))
+		b = binary.AppendUvarint(b, uint64(r.page))
+		b = append(b, r.image[:]...)
+	}
+	return b
+}
+
+func appendBytes(b, data []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// errInvalidRecord is returned for a log record that can't be decoded.
+var errInvalidRecord = errors.New("invalid log record")
+
+func decodeLogRecord(b []byte) (logRecord, error) {
+	var r logRecord
+	if len(b) == 0 {
+		return r, errInvalidRecord
+	}
+	r.kind = recordKind(b[0])
+	b = b[1:]
+	uvarint := func() uint64 {
+		if b == nil {
+			return 0
+		}
+		x, n := binary.Uvarint(b)
+		if n <= 0 {
+			b = nil
+			return 0
+		}
+		b = b[n:]
+		return x
+	}
+	bytes := func() []byte {
+		n := uvarint()
+		if b == nil || n > uint64(len(b)) {
+			b = nil
+			return nil
+		}
+		result := b[:n]
+		b = b[n:]
+		return result
+	}
+
+	r.lsn = uvarint()
+	switch r.kind {
+	case recordCreateTable:
+		r.table = string(bytes())
+		schema := bytes()
+		if b != nil {
+			if err := json.Unmarshal(schema, &r.schema); err != nil {
+				return r, fmt.Errorf("%w: %v", errInvalidRecord, err)
+			}
+		}
+	case recordInsert, recordUpdate, recordDelete:
+		r.table = string(bytes())
+		r.page = int(uvarint())
+		r.slot = int(uvarint())
+		if r.kind != recordDelete && b != nil {
+			row, n, err := types.DecodeRow(b)
+			if err != nil {
+				return r, fmt.Errorf("%w: %v", errInvalidRecord, err)
+			}
+			r.row = row
+			b = b[n:]
+		}
+	case recordCreateIndex, recordDropIndex:
+		r.table = string(bytes())
+		r.index = string(bytes())
+		if r.kind == recordCreateIndex {
+			n := uvarint()
+			for i := uint64(0); i < n && b != nil; i++ {
+				r.columns = append(r.columns, string(bytes()))
+			}
+			r.method = IndexMethod(uvarint())
+		}
+	case recordPageImage:
+		r.table = string(bytes())
+		r.page = int(uvarint())
+		if len(b) < PageSize {
+			b = nil
+		} else {
+			r.image = new(page)
+			copy(r.image[:], b)
+			b = b[PageSize:]
+		}
+	case recordCommit, recordAbort:
+	default:
+		return r, fmt.Errorf("%w: unknown kind %d", errInvalidRecord, r.kind)
+	}
+	if b == nil || len(b) != 0 {
+		return r, errInvalidRecord
+	}
+	return r, nil
+}
+
+// A writeAheadLog appends records to the log file. If a failed write can't be removed from the
+// file, the log is unusable: later records would follow the partial one, so recovery wouldn't find
+// them, and failed is set to make commit return an error instead.
+type writeAheadLog struct {
+	file    walFile
+	nextLSN uint64
+	failed  error
+}
+
+// walFile is the part of *os.File used by the log, so tests can make writes fail.
+type walFile interface {
+	io.WriteSeeker
+	Sync() error
+	Truncate(size int64) error
+	Close() error
+}
+
+// openLog opens the log file in a directory, creating it if it doesn't exist, and reads it. It
+// returns the records to replay for recovery, in order: the changes made by committed transactions,
+// and the page images of transactions that were aborted or didn't finish, which undo their changes.
+// The log ends at the first record that's incomplete or has the wrong checksum, which is where
+// writing it stopped when the database crashed.
+//
+// The next LSN is one more than that of the last record read, but at least minLSN.
+func openLog(dir string, minLSN uint64) (*writeAheadLog, []logRecord, error) {
+	path := filepath.Join(dir, logFile)
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, err
+	}
+	log := &writeAheadLog{nextLSN: minLSN}
+	var replay, pending []logRecord
+	undo := func() {
+		for _, r := range pending {
+			if r.kind == recordPageImage {
+				replay = append(replay, r)
+			}
+		}
+		pending = nil
+	}
+	for len(data) >= logRecordHeaderSize {
+		length := binary.LittleEndian.Uint32(data)
+		checksum := binary.LittleEndian.Uint32(data[4:])
+		if uint64(length) > uint64(len(data)-logRecordHeaderSize) {
+			break
+		}
+		encoded := data[logRecordHeaderSize : logRecordHeaderSize+length]
+		if crc32.Checksum(encoded, crcTable) != checksum {
+			break
+		}
+		r, err := decodeLogRecord(encoded)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading %s: %w", path, err)
+		}
+		if r.lsn >= log.nextLSN {
+			log.nextLSN = r.lsn + 1
+		}
+		switch r.kind {
+		case recordCommit:
+			for _, r := range pending {
+				if r.kind != recordPageImage {
+					replay = append(replay, r)
+				}
+			}
+			pending = nil
+		case recordAbort:
+			undo()
+		default:
+			pending = append(pending, r)
+		}
+		data = data[logRecordHeaderSize+length:]
+	}
+	undo()
+
+	log.file, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	return log, replay, nil
+}
+
+// write assigns LSNs to records, appends them to the log and writes the log to stable storage. If
+// that fails, it truncates the log to where it was, so the next write doesn't follow a partial
+// record.
+func (l *writeAheadLog) write(records []logRecord) error {
+	if l.failed != nil {
+		return fmt.Errorf("log unusable after failed write: %w", l.failed)
+	}
+	size, err := l.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("error writing log: %w", err)
+	}
+	lsn := l.nextLSN
+	var b []byte
+	for i := range records {
+		records[i].lsn = l.nextLSN
+		l.nextLSN++
+		encoded := records[i].encode()
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(encoded)))
+		b = binary.LittleEndian.AppendUint32(b, crc32.Checksum(encoded, crcTable))
+		b = append(b, encoded...)
+	}
+	_, err = l.file.Write(b)
+	if err == nil {
+		err = l.file.Sync()
+	}
+	if err != nil {
+		l.nextLSN = lsn
+		l.undoWrite(size)
+		return fmt.Errorf("error writing log: %w", err)
+	}
+	return nil
+}
+
+// undoWrite truncates the log file to the given size after a failed write, marking the log as
+// unusable if that doesn't work.
+func (l *writeAheadLog) undoWrite(size int64) {
+	err := l.file.Truncate(size)
+	if err == nil {
+		_, err = l.file.Seek(size, io.SeekStart)
+	}
+	if err == nil {
+		err = l.file.Sync()
+	}
+	if err != nil {
+		l.failed = err
+	}
+}
+
+// reset empties the log. It's called after all changes have been written to the heap files.
+func (l *writeAheadLog) reset() error {
+	if err := l.file.Truncate(0); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+func (l *writeAheadLog) close() error {
+	return l.file.Close()
+}
@@ -0,0 +1,442 @@
+package storage
+
+import (
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/lfritz/toydb/types"
+)
+
+// crash closes the files of a database without writing its pages or emptying its log, as if the
+// program had stopped.
+func crash(d *Database) {
+	for _, t := range d.tables {
+		t.heap.file.Close()
+	}
+	d.log.file.Close()
+}
+
+// snapshot returns the rows of all tables in a database.
+func snapshot(t *testing.T, d *Database) map[string][][]types.Value {
+	t.Helper()
+	result := make(map[string][][]types.Value)
+	for name := range d.tables {
+		table, err := d.Table(name)
+		if err != nil {
+			t.Fatalf("Table returned error: %v", err)
+		}
+		result[name] = table.Rows
+	}
+	return result
+}
+
+// copyDir copies the files in a directory to a new temporary directory.
+func copyDir(t *testing.T, dir string) string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir returned error: %v", err)
+	}
+	result := t.TempDir()
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatalf("ReadFile returned error: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(result, e.Name()), data, 0o644); err != nil {
+			t.Fatalf("WriteFile returned error: %v", err)
+		}
+	}
+	return result
+}
+
+func logSize(t *testing.T, dir string) int64 {
+	t.Helper()
+	info, err := os.Stat(filepath.Join(dir, logFile))
+	if err != nil {
+		t.Fatalf("Stat returned error: %v", err)
+	}
+	return info.Size()
+}
+
+func TestLogRecordEncoding(t *testing.T) {
+	image := new(page)
+	image.init()
+	image.insert(types.AppendRow(nil, allTypesRow(9)))
+	records := []logRecord{
+		{kind: recordCreateTable, lsn: 1, table: "t", schema: allTypesSchema},
+		{kind: recordInsert, lsn: 2, table: "t", page: 3, slot: 4, row: allTypesRow(7)},
+		{kind: recordUpdate, lsn: 300, table: "t", page: 0, slot: 200, row: allTypesRow(8)},
+		{kind: recordDelete, lsn: 301, table: "t", page: 1000, slot: 0},
+		{kind: recordCreateIndex, lsn: 302, table: "t", index: "t_b_d", columns: []string{"b", "d"}},
+		{kind: recordDropIndex, lsn: 303, table: "t", index: "t_b_d"},
+		{kind: recordCreateIndex, lsn: 304, table: "t", index: "t_t", columns: []string{"t"}, method: IndexMethodHash},
+		{kind: recordCommit, lsn: 305},
+		{kind: recordPageImage, lsn: 306, table: "t", page: 7, image: image},
+		{kind: recordAbort, lsn: 307},
+	}
+	for _, r := range records {
+		encoded := r.encode()
+		got, err := decodeLogRecord(encoded)
+		if err != nil {
+			t.Errorf("decodeLogRecord returned error for %s record: %v", r.kind, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, r) {
+			t.Errorf("decodeLogRecord returned %+v, want %+v", got, r)
+		}
+		for n := 0; n < len(encoded); n++ {
+			if _, err := decodeLogRecord(encoded[:n]); err == nil {
+				t.Errorf("decodeLogRecord did not return error for %s record cut to %d bytes", r.kind, n)
+			}
+		}
+	}
+}
+
+// TestRecovery makes a series of changes, then simulates crashes that happened while the log was
+// written by cutting it off at different offsets. Recovering from each has to give the tables as
+// they were after the last change whose log records were completely written.
+func TestRecovery(t *testing.T) {
+	dir := t.TempDir()
+	pool := mustBufferPool(t, 64, NewLRU())
+	db, err := OpenWithBufferPool(dir, pool)
+	if err != nil {
+		t.Fatalf("OpenWithBufferPool returned error: %v", err)
+	}
+
+	ops := []func() error{
+		func() error {
+			_, err := db.CreateTable("a", allTypesSchema)
+			return err
+		},
+	}
+	for i := 0; i < 120; i++ {
+		row := allTypesRow(i)
+		ops = append(ops, func() error { return db.Insert("a", row) })
+	}
+	ops = append(ops, func() error {
+		_, err := db.CreateTable("b", allTypesSchema)
+		return err
+	})
+	for i := 0; i < 10; i++ {
+		row := allTypesRow(i)
+		ops = append(ops, func() error { return db.Insert("b", row) })
+	}
+	ops = append(ops,
+		// longer rows, so some have to be moved to another page
+		func() error {
+			_, err := db.Update(
+				"a",
+				func(row []types.Value) bool { return row[0].IsTrue() },
+				func(row []types.Value) []types.Value {
+					return []types.Value{row[0], types.Txt(row[1].String() + " updated"), row[2], row[3]}
+				},
+			)
+			return err
+		},
+		func() error {
+			_, err := db.Delete("a", func(row []types.Value) bool { return !row[0].IsTrue() })
+			return err
+		},
+		func() error {
+			_, err := db.Delete("b", func([]types.Value) bool { return true })
+			return err
+		},
+		func() error { return db.Insert("b", allTypesRow(1000)) },
+	)
+
+	// after each change, note the size of the log and the tables
+	sizes := []int64{0}
+	states := []map[string][][]types.Value{{}}
+	for _, op := range ops {
+		if err := op(); err != nil {
+			t.Fatalf("change returned error: %v", err)
+		}
+		sizes = append(sizes, logSize(t, dir))
+		states = append(states, snapshot(t, db))
+	}
+	if pool.Stats().Writes != 0 {
+		t.Fatalf("buffer pool wrote pages before the crash")
+	}
+	crash(db)
+
+	// cut off the log at each change and next to it, and at some random offsets
+	offsets := []int64{}
+	for _, size := range sizes {
+		offsets = append(offsets, size-1, size, size+1)
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		offsets = append(offsets, r.Int63n(sizes[len(sizes)-1]))
+	}
+	for _, offset := range offsets {
+		if offset < 0 || offset > sizes[len(sizes)-1] {
+			continue
+		}
+		crashed := copyDir(t, dir)
+		if err := os.Truncate(filepath.Join(crashed, logFile), offset); err != nil {
+			t.Fatalf("Truncate returned error: %v", err)
+		}
+		db, err := OpenWithBufferPool(crashed, mustBufferPool(t, 64, NewLRU()))
+		if err != nil {
+			t.Fatalf("with log cut off at %d, OpenWithBufferPool returned error: %v", offset, err)
+		}
+		i := sort.Search(len(sizes), func(i int) bool { return sizes[i] > offset }) - 1
+		if got, want := snapshot(t, db), states[i]; !reflect.DeepEqual(got, want) {
+			t.Errorf("with log cut off at %d, got tables after %d changes, want after %d", offset,
+				changesMatching(got, states), i)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("Close returned error: %v", err)
+		}
+	}
+}
+
+// changesMatching returns the number of changes after which the tables were as given, or -1.
+func changesMatching(got map[string][][]types.Value, states []map[string][][]types.Value) int {
+	for i, state := range states {
+		if reflect.DeepEqual(got, state) {
+			return i
+		}
+	}
+	return -1
+}
+
+// TestRecoveryEvicted checks recovery when some changed pages were written to the heap file before
+// the crash and some weren't.
+func TestRecoveryEvicted(t *testing.T) {
+	dir := t.TempDir()
+	pool := mustBufferPool(t, 2, NewClock())
+	db, err := OpenWithBufferPool(dir, pool)
+	if err != nil {
+		t.Fatalf("OpenWithBufferPool returned error: %v", err)
+	}
+	if _, err := db.CreateTable("t", allTypesSchema); err != nil {
+		t.Fatalf("CreateTable returned error: %v", err)
+	}
+	for i := 0; i < 500; i++ {
+		if err := db.Insert("t", allTypesRow(i)); err != nil {
+			t.Fatalf("Insert returned error: %v", err)
+		}
+	}
+	for i := 0; i < 500; i += 37 {
+		text := allTypesRow(i)[1]
+		match := func(row []types.Value) bool { return row[1].Identical(text) }
+		if i%2 == 0 {
+			_, err = db.Delete("t", match)
+		} else {
+			_, err = db.Update("t", match, func(row []types.Value) []types.Value {
+				return []types.Value{row[0], types.Txt("updated"), row[2], row[3]}
+			})
+		}
+		if err != nil {
+			t.Fatalf("change returned error: %v", err)
+		}
+	}
+	want := snapshot(t, db)
+	if pool.Stats().Writes == 0 {
+		t.Fatalf("buffer pool didn't write pages before the crash")
+	}
+	crash(db)
+
+	// recovering twice gives the same result
+	for i := 0; i < 2; i++ {
+		db, err = OpenWithBufferPool(dir, mustBufferPool(t, 2, NewClock()))
+		if err != nil {
+			t.Fatalf("OpenWithBufferPool returned error: %v", err)
+		}
+		if got := snapshot(t, db); !reflect.DeepEqual(got, want) {
+			t.Errorf("after recovery, tables differ from before the crash")
+		}
+		if size := logSize(t, dir); size != 0 {
+			t.Errorf("log has %d bytes after recovery", size)
+		}
+		crash(db)
+	}
+
+	// LSNs keep increasing after the log is emptied
+	db = mustOpen(t, dir)
+	next := db.log.nextLSN
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	db = mustOpen(t, dir)
+	defer db.Close()
+	if db.log.nextLSN != next {
+		t.Errorf("next LSN is %d after reopening, want %d", db.log.nextLSN, next)
+	}
+	if err := db.Insert("t", allTypesRow(0)); err != nil {
+		t.Fatalf("Insert returned error: %v", err)
+	}
+	if db.log.nextLSN <= next {
+		t.Errorf("next LSN is %d after insert, want more than %d", db.log.nextLSN, next)
+	}
+}
+
+// failingFile is a log file whose writes fail after writing part of the data. If truncateErr is
+// set, truncating it fails too.
+type failingFile struct {
+	walFile
+	truncateErr error
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (f *failingFile) Write(b []byte) (int, error) {
+	n, err := f.walFile.Write(b[:len(b)/2])
+	if err != nil {
+		return n, err
+	}
+	return n, errWriteFailed
+}
+
+func (f *failingFile) Truncate(size int64) error {
+	if f.truncateErr != nil {
+		return f.truncateErr
+	}
+	return f.walFile.Truncate(size)
+}
+
+func TestLogWriteError(t *testing.T) {
+	for _, truncateErr := range []error{nil, errors.New("truncate failed")} {
+		dir := t.TempDir()
+		db := mustOpen(t, dir)
+		if _, err := db.CreateTable("t", allTypesSchema); err != nil {
+			t.Fatalf("CreateTable returned error: %v", err)
+		}
+		if err := db.Insert("t", allTypesRow(0)); err != nil {
+			t.Fatalf("Insert returned error: %v", err)
+		}
+		file := db.log.file
+		db.log.file = &failingFile{walFile: file, truncateErr: truncateErr}
+		if err := db.Insert("t", allTypesRow(1)); !errors.Is(err, errWriteFailed) {
+			t.Errorf("Insert returned %v, want %v", err, errWriteFailed)
+		}
+		db.log.file = file
+
+		err := db.Insert("t", allTypesRow(2))
+		if truncateErr != nil {
+			// the partial record is still in the log, so the log can't be used any more
+			if !errors.Is(err, truncateErr) {
+				t.Errorf("Insert after failed truncate returned %v, want %v", err, truncateErr)
+			}
+			crash(db)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Insert returned error: %v", err)
+		}
+		want := snapshot(t, db)
+		crash(db)
+
+		db = mustOpen(t, dir)
+		if got := snapshot(t, db); !reflect.DeepEqual(got, want) {
+			t.Errorf("after recovery, got %v, want %v", got, want)
+		}
+		if got := len(want["t"]); got != 2 {
+			t.Errorf("table has %d rows, want 2", got)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("Close returned error: %v", err)
+		}
+	}
+}
+
+func TestSteal(t *testing.T) {
+	const frames = 4
+	open := func(dir string) *Database {
+		t.Helper()
+		db, err := OpenWithBufferPool(dir, mustBufferPool(t, frames, NewLRU()))
+		if err != nil {
+			t.Fatalf("OpenWithBufferPool returned error: %v", err)
+		}
+		return db
+	}
+	recovered := func(dir string, want map[string][][]types.Value) {
+		t.Helper()
+		db := open(dir)
+		if got := snapshot(t, db); !reflect.DeepEqual(got, want) {
+			t.Errorf("after recovery, tables differ from before the crash")
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("Close returned error: %v", err)
+		}
+	}
+	all := func(row []types.Value) bool { return true }
+
+	dir := t.TempDir()
+	db := open(dir)
+	if _, err := db.CreateTable("t", allTypesSchema); err != nil {
+		t.Fatalf("CreateTable returned error: %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		if err := db.Insert("t", allTypesRow(i)); err != nil {
+			t.Fatalf("Insert returned error: %v", err)
+		}
+	}
+	if pages := db.tables["t"].heap.pages; pages <= 2*frames {
+		t.Fatalf("table has %d pages, want more than %d", pages, 2*frames)
+	}
+
+	// changes to a table larger than the buffer pool, with rows moved to other pages
+	n, err := db.Update("t", all, func(row []types.Value) []types.Value {
+		return []types.Value{row[0], types.Txt(row[1].String() + " updated"), row[2], row[3]}
+	})
+	if err != nil || n != 1000 {
+		t.Fatalf("Update returned %d, %v", n, err)
+	}
+	n, err = db.Delete("t", func(row []types.Value) bool { return row[0].IsTrue() })
+	if err != nil || n == 0 {
+		t.Fatalf("Delete returned %d, %v", n, err)
+	}
+	want := snapshot(t, db)
+	if got := len(want["t"]); got != 1000-n {
+		t.Fatalf("table has %d rows, want %d", got, 1000-n)
+	}
+	crash(db)
+	recovered(dir, want)
+
+	// a transaction that doesn't finish is undone, even if the pages it changed were written
+	db = open(dir)
+	deleteAll := func() *transaction {
+		t.Helper()
+		rows, err := db.tables["t"].matching(all)
+		if err != nil {
+			t.Fatalf("matching returned error: %v", err)
+		}
+		tx := db.begin()
+		for _, r := range rows {
+			if err := tx.delete("t", db.tables["t"].heap, r.id); err != nil {
+				t.Fatalf("delete returned error: %v", err)
+			}
+		}
+		if !tx.written {
+			t.Fatalf("transaction didn't steal any pages")
+		}
+		return tx
+	}
+	deleteAll()
+	if db.pool.Stats().Writes == 0 {
+		t.Fatalf("buffer pool didn't write pages before the crash")
+	}
+	crash(db)
+	recovered(dir, want)
+
+	// so is one that's aborted, while later transactions are redone
+	db = open(dir)
+	deleteAll().abort()
+	if got := snapshot(t, db); !reflect.DeepEqual(got, want) {
+		t.Errorf("after abort, tables differ from before the transaction")
+	}
+	if err := db.Insert("t", allTypesRow(1000)); err != nil {
+		t.Fatalf("Insert returned error: %v", err)
+	}
+	want = snapshot(t, db)
+	crash(db)
+	recovered(dir, want)
+}
@@ -0,0 +1,134 @@
+package types
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// ErrDivisionByZero is returned when dividing by zero or computing a remainder modulo zero.
+var ErrDivisionByZero = errors.New("division by zero")
+
+// DivisionScale is the minimum number of digits after the dot in the result of a division.
+const DivisionScale = 16
+
+var bigTen = big.NewInt(10)
+
+// Neg returns -d.
+func (d Decimal) Neg() Decimal {
+	if len(d.digits) == 0 {
+		return d
+	}
+	d.negative = !d.negative
+	return d
+}
+
+// Add returns d + e.
+func (d Decimal) Add(e Decimal) Decimal {
+	a, b, scale := d.aligned(e)
+	return fromBig(a.Add(a, b), scale)
+}
+
+// Sub returns d - e.
+func (d Decimal) Sub(e Decimal) Decimal {
+	return d.Add(e.Neg())
+}
+
+// Mul returns d * e.
+func (d Decimal) Mul(e Decimal) Decimal {
+	a, aScale := d.big()
+	b, bScale := e.big()
+	return fromBig(a.Mul(a, b), aScale+bScale)
+}
+
+// Div returns d / e. The result is rounded half away from zero to DivisionScale digits after the
+// dot, or more if either operand has more digits after the dot.
+func (d Decimal) Div(e Decimal) (Decimal, error) {
+	a, aScale := d.big()
+	b, bScale := e.big()
+	if b.Sign() == 0 {
+		return Decimal{}, ErrDivisionByZero
+	}
+
+	scale := DivisionScale
+	if aScale > scale {
+		scale = aScale
+	}
+	if bScale > scale {
+		scale = bScale
+	}
+
+	// a / 10^aScale / (b / 10^bScale) == a * 10^(scale + bScale - aScale) / b / 10^scale
+	a.Mul(a, pow10(scale+bScale-aScale))
+	quotient, remainder := new(big.Int).QuoRem(a, b, new(big.Int))
+
+	// round half away from zero
+	remainder.Abs(remainder)
+	remainder.Lsh(remainder, 1)
+	if remainder.CmpAbs(b) >= 0 {
+		if a.Sign() == b.Sign() {
+			quotient.Add(quotient, big.NewInt(1))
+		} else {
+			quotient.Sub(quotient, big.NewInt(1))
+		}
+	}
+
+	return fromBig(quotient, scale), nil
+}
+
+// Mod returns the remainder of d / e. The result has the same sign as d.
+func (d Decimal) Mod(e Decimal) (Decimal, error) {
+	a, b, scale := d.aligned(e)
+	if b.Sign() == 0 {
+		return Decimal{}, ErrDivisionByZero
+	}
+	return fromBig(a.Rem(a, b), scale), nil
+}
+
+// big returns d as an integer and a scale, so that d == i / 10^scale.
+func (d Decimal) big() (i *big.Int, scale int) {
+	i = new(big.Int)
+	for _, digit := range d.digits {
+		i.Mul(i, bigTen)
+		i.Add(i, big.NewInt(int64(digit)))
+	}
+	if d.negative {
+		i.Neg(i)
+	}
+	return i, len(d.digits) - d.n
+}
+
+// aligned returns d and e as integers with the same scale.
+func (d Decimal) aligned(e Decimal) (a, b *big.Int, scale int) {
+	a, aScale := d.big()
+	b, bScale := e.big()
+	switch {
+	case aScale < bScale:
+		a.Mul(a, pow10(bScale-aScale))
+		scale = bScale
+	case aScale > bScale:
+		b.Mul(b, pow10(aScale-bScale))
+		scale = aScale
+	default:
+		scale = aScale
+	}
+	return
+}
+
+// fromBig returns the decimal i / 10^scale.
+func fromBig(i *big.Int, scale int) Decimal {
+	negative := i.Sign() < 0
+	text := new(big.Int).Abs(i).String()
+	if len(text) < scale {
+		text = strings.Repeat("0", scale-len(text)) + text
+	}
+	digits := make([]uint8, len(text))
+	for j, c := range text {
+		digits[j] = uint8(c - '0')
+	}
+	return normalize(negative, digits, len(text)-scale)
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(bigTen, big.NewInt(int64(n)), nil)
+}
@@ -0,0 +1,121 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecimalArithmetic(t *testing.T) {
+	cases := []struct {
+		a, b                     string
+		sum, difference, product string
+	}{
+		{"0", "0", "0", "0", "0"},
+		{"1", "2", "3", "-1", "2"},
+		{"1.5", "2.25", "3.75", "-0.75", "3.375"},
+		{"-1.5", "2.25", "0.75", "-3.75", "-3.375"},
+		{"-1.5", "-2.25", "-3.75", "0.75", "3.375"},
+		{"100", "0.01", "100.01", "99.99", "1"},
+		{"0.1", "0.9", "1", "-0.8", "0.09"},
+		{"999", "1", "1000", "998", "999"},
+		{"123.456", "-123.456", "0", "246.912", "-15241.383936"},
+	}
+	for _, c := range cases {
+		a := NewDecimal(c.a)
+		b := NewDecimal(c.b)
+		checks := []struct {
+			op        string
+			got, want Decimal
+		}{
+			{"+", a.Add(b), NewDecimal(c.sum)},
+			{"-", a.Sub(b), NewDecimal(c.difference)},
+			{"*", a.Mul(b), NewDecimal(c.product)},
+		}
+		for _, check := range checks {
+			if !reflect.DeepEqual(check.got, check.want) {
+				t.Errorf("%s %s %s == %v, want %v", c.a, check.op, c.b, check.got, check.want)
+			}
+		}
+	}
+}
+
+func TestDecimalNeg(t *testing.T) {
+	cases := []struct {
+		input, want string
+	}{
+		{"0", "0"},
+		{"1.5", "-1.5"},
+		{"-1.5", "1.5"},
+	}
+	for _, c := range cases {
+		got := NewDecimal(c.input).Neg()
+		want := NewDecimal(c.want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("-(%s) == %v, want %v", c.input, got, want)
+		}
+	}
+}
+
+func TestDecimalDiv(t *testing.T) {
+	cases := []struct {
+		a, b, want string
+	}{
+		{"6", "3", "2"},
+		{"1", "4", "0.25"},
+		{"-1", "4", "-0.25"},
+		{"1", "3", "0.3333333333333333"},
+		{"2", "3", "0.6666666666666667"},
+		{"-2", "3", "-0.6666666666666667"},
+		{"2", "-3", "-0.6666666666666667"},
+		{"-2", "-3", "0.6666666666666667"},
+		{"1", "0.00000000000000000003", "33333333333333333333.33333333333333333333"},
+		{"0.00000000000000000001", "3", "0.00000000000000000000"},
+		{"0.00000000000000000005", "1", "0.00000000000000000005"},
+		{"0", "7", "0"},
+	}
+	for _, c := range cases {
+		got, err := NewDecimal(c.a).Div(NewDecimal(c.b))
+		if err != nil {
+			t.Errorf("%s / %s returned error: %v", c.a, c.b, err)
+			continue
+		}
+		want := NewDecimal(c.want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s / %s == %v, want %v", c.a, c.b, got, want)
+		}
+	}
+
+	_, err := NewDecimal("1").Div(DecimalZero())
+	if err != ErrDivisionByZero {
+		t.Errorf("1 / 0 returned error %v, want %v", err, ErrDivisionByZero)
+	}
+}
+
+func TestDecimalMod(t *testing.T) {
+	cases := []struct {
+		a, b, want string
+	}{
+		{"7", "3", "1"},
+		{"-7", "3", "-1"},
+		{"7", "-3", "1"},
+		{"6", "3", "0"},
+		{"7.5", "2", "1.5"},
+		{"1", "0.3", "0.1"},
+	}
+	for _, c := range cases {
+		got, err := NewDecimal(c.a).Mod(NewDecimal(c.b))
+		if err != nil {
+			t.Errorf("%s %% %s returned error: %v", c.a, c.b, err)
+			continue
+		}
+		want := NewDecimal(c.want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s %% %s == %v, want %v", c.a, c.b, got, want)
+		}
+	}
+
+	_, err := NewDecimal("1").Mod(DecimalZero())
+	if err != ErrDivisionByZero {
+		t.Errorf("1 %% 0 returned error %v, want %v", err, ErrDivisionByZero)
+	}
+}
@@ -0,0 +1,184 @@
+package types
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The binary encoding of a value starts with a header byte holding the type, with the high bit set
+// for null. Non-null values follow:
+//
+//   - Boolean: one byte, 0 or 1
+//   - Text: the length in bytes as a uvarint, then the UTF-8 bytes
+//   - Decimal: one byte for the sign, the number of digits left of the dot as a uvarint, the number
+//     of digits as a uvarint, then one byte per digit
+//   - Date: the year as a uvarint, then one byte each for month and day
+//
+// A row is encoded as the number of values as a uvarint, followed by the values.
+
+const nullFlag = 0x80
+
+// errShortBuffer is returned when decoding runs past the end of the input.
+var errShortBuffer = errors.New("unexpected end of encoded value")
+
+// AppendValue appends the binary encoding of v to b and returns the extended buffer.
+func AppendValue(b []byte, v Value) []byte {
+	if v.null {
+		return append(b, byte(v.t)|nullFlag)
+	}
+	b = append(b, byte(v.t))
+	switch x := v.v.(type) {
+	case Boolean:
+		if x.value {
+			return append(b, 1)
+		}
+		return append(b, 0)
+	case Text:
+		b = binary.AppendUvarint(b, uint64(len(x.value)))
+		return append(b, x.value...)
+	case Decimal:
+		if x.negative {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+		b = binary.AppendUvarint(b, uint64(x.n))
+		b = binary.AppendUvarint(b, uint64(len(x.digits)))
+		return append(b, x.digits...)
+	case Date:
+		b = binary.AppendUvarint(b, uint64(x.year))
+		return append(b, byte(x.month), byte(x.day))
+	}
+	panic(fmt.Sprintf("unexpected BasicValue: %T", v.v))
+}
+
+// DecodeValue decodes a value from the start of b. It returns the value and the number of bytes
+// read.
+func DecodeValue(b []byte) (Value, int, error) {
+	d := decoder{b: b}
+	v := d.value()
+	if d.err != nil {
+		return Value{}, 0, d.err
+	}
+	return v, d.i, nil
+}
+
+// AppendRow appends the binary encoding of a row to b and returns the extended buffer.
+func AppendRow(b []byte, values []Value) []byte {
+	b = binary.AppendUvarint(b, uint64(len(values)))
+	for _, v := range values {
+		b = AppendValue(b, v)
+	}
+	return b
+}
+
+// DecodeRow decodes a row from the start of b. It returns the values and the number of bytes read.
+func DecodeRow(b []byte) ([]Value, int, error) {
+	d := decoder{b: b}
+	n := d.uvarint()
+	if d.err == nil && n > uint64(len(b)) {
+		d.err = fmt.Errorf("invalid number of values in encoded row: %d", n)
+	}
+	var values []Value
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		values = append(values, d.value())
+	}
+	if d.err != nil {
+		return nil, 0, d.err
+	}
+	return values, d.i, nil
+}
+
+// A decoder reads from a buffer, remembering the first error.
+type decoder struct {
+	b   []byte
+	i   int
+	err error
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if d.i >= len(d.b) {
+		d.err = errShortBuffer
+		return 0
+	}
+	c := d.b[d.i]
+	d.i++
+	return c
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	x, n := binary.Uvarint(d.b[d.i:])
+	if n <= 0 {
+		d.err = errShortBuffer
+		return 0
+	}
+	d.i += n
+	return x
+}
+
+// bytes returns the next n bytes, sharing memory with the buffer.
+func (d *decoder) bytes(n uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > uint64(len(d.b)-d.i) {
+		d.err = errShortBuffer
+		return nil
+	}
+	result := d.b[d.i : d.i+int(n)]
+	d.i += int(n)
+	return result
+}
+
+func (d *decoder) value() Value {
+	header := d.byte()
+	if d.err != nil {
+		return Value{}
+	}
+	t := Type(header &^ nullFlag)
+	if t < TypeBoolean || t > TypeDate {
+		d.err = fmt.Errorf("invalid type in encoded value: %d", t)
+		return Value{}
+	}
+	if header&nullFlag != 0 {
+		return NewNull(t)
+	}
+
+	var v BasicValue
+	switch t {
+	case TypeBoolean:
+		v = NewBoolean(d.byte() != 0)
+	case TypeText:
+		v = NewText(string(d.bytes(d.uvarint())))
+	case TypeDecimal:
+		negative := d.byte() != 0
+		n := d.uvarint()
+		digits := d.bytes(d.uvarint())
+		var x Decimal
+		if len(digits) > 0 {
+			// copy the digits so the value doesn't keep the buffer alive
+			x = Decimal{negative: negative, digits: append([]uint8(nil), digits...), n: int(n)}
+		}
+		v = x
+	case TypeDate:
+		year := d.uvarint()
+		month := d.byte()
+		day := d.byte()
+		date, ok := CheckDate(int(year), int(month), int(day))
+		if d.err == nil && !ok {
+			d.err = fmt.Errorf("invalid date in encoded value: %d-%d-%d", year, month, day)
+		}
+		v = date
+	}
+	if d.err != nil {
+		return Value{}
+	}
+	return NewValue(v)
+}
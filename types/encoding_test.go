@@ -0,0 +1,95 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func encodingTestValues() []Value {
+	return []Value{
+		Boo(true),
+		Boo(false),
+		NewNull(TypeBoolean),
+		Txt(""),
+		Txt("hello"),
+		Txt("Grüße, 世界"),
+		NewNull(TypeText),
+		Dec("0"),
+		Dec("123"),
+		Dec("-45.67"),
+		Dec("0.001"),
+		Dec("12345678901234567890.0987654321"),
+		NewNull(TypeDecimal),
+		Dat(1, 1, 1),
+		Dat(2024, 2, 29),
+		Dat(9999, 12, 31),
+		NewNull(TypeDate),
+	}
+}
+
+func TestEncodeValue(t *testing.T) {
+	for _, v := range encodingTestValues() {
+		b := AppendValue([]byte{0xff}, v)
+		got, n, err := DecodeValue(b[1:])
+		if err != nil {
+			t.Errorf("DecodeValue returned error for %v: %v", v, err)
+			continue
+		}
+		if n != len(b)-1 {
+			t.Errorf("DecodeValue for %v read %d bytes, want %d", v, n, len(b)-1)
+		}
+		if !reflect.DeepEqual(got, v) {
+			t.Errorf("DecodeValue returned %#v, want %#v", got, v)
+		}
+
+		// every truncated encoding is invalid
+		for i := 1; i < len(b)-1; i++ {
+			if _, _, err := DecodeValue(b[1 : 1+i]); err == nil {
+				t.Errorf("DecodeValue did not return error for %d bytes of %v", i, v)
+			}
+		}
+	}
+}
+
+func TestEncodeRow(t *testing.T) {
+	rows := [][]Value{
+		nil,
+		encodingTestValues(),
+		{Dec("1"), NewNull(TypeText)},
+	}
+	var b []byte
+	for _, row := range rows {
+		b = AppendRow(b, row)
+	}
+	for _, want := range rows {
+		got, n, err := DecodeRow(b)
+		if err != nil {
+			t.Fatalf("DecodeRow returned error: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("DecodeRow returned %v, want %v", got, want)
+		}
+		b = b[n:]
+	}
+	if len(b) != 0 {
+		t.Errorf("%d bytes left after decoding all rows", len(b))
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	cases := [][]byte{
+		{},
+		{0x7f},
+		{byte(TypeDate), 0x01, 13, 1},
+		{byte(TypeDate), 0x01, 2, 30},
+		{byte(TypeText), 0x05, 'a'},
+	}
+	for _, c := range cases {
+		if _, _, err := DecodeValue(c); err == nil {
+			t.Errorf("DecodeValue did not return error for %v", c)
+		}
+	}
+	if _, _, err := DecodeRow([]byte{0x05, byte(TypeBoolean), 1}); err == nil {
+		t.Errorf("DecodeRow did not return error for truncated row")
+	}
+}
@@ -1,5 +1,11 @@
 package types
 
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
 // The BasicValue interface is implemented by the basic types of the database.
 type BasicValue interface {
 	Type() Type
@@ -55,6 +61,58 @@ func (v Value) Compare(w Value) Compared {
 	return v.v.Compare(w.v)
 }
 
+// Identical returns true if v and w have the same type and value, treating two nulls of the same
+// type as identical. Unlike Compare, it never reports null; it's the notion of equality used for
+// grouping and for eliminating duplicates.
+func (v Value) Identical(w Value) bool {
+	if v.t != w.t || v.null != w.null {
+		return false
+	}
+	return v.null || v.v.Compare(w.v) == ComparedEq
+}
+
+// Key returns a string that's the same for two values exactly if they're identical, so it can be
+// used as a map key.
+func (v Value) Key() string {
+	return fmt.Sprintf("%d:%s", v.t, v)
+}
+
+// RowKey returns a string that's the same for two lists of values exactly if the values are
+// pairwise identical.
+func RowKey(values []Value) string {
+	builder := new(strings.Builder)
+	for _, v := range values {
+		fmt.Fprintf(builder, "%s,", v.Key())
+	}
+	return builder.String()
+}
+
+// Size returns an estimate of the number of bytes of memory used by v, including the digits of a
+// Decimal and the string of a Text.
+func (v Value) Size() int {
+	size := int(unsafe.Sizeof(v))
+	switch x := v.v.(type) {
+	case Boolean:
+		size += int(unsafe.Sizeof(x))
+	case Decimal:
+		size += int(unsafe.Sizeof(x)) + len(x.digits)
+	case Text:
+		size += int(unsafe.Sizeof(x)) + len(x.value)
+	case Date:
+		size += int(unsafe.Sizeof(x))
+	}
+	return size
+}
+
+// RowSize returns an estimate of the number of bytes of memory used by a row.
+func RowSize(values []Value) int {
+	size := int(unsafe.Sizeof(values))
+	for _, v := range values {
+		size += v.Size()
+	}
+	return size
+}
+
 func (v Value) String() string {
 	if v.null {
 		return "null"
@@ -47,3 +47,78 @@ func TestInvalidComparisons(t *testing.T) {
 		}
 	}
 }
+
+func TestValueIdentical(t *testing.T) {
+	cases := []struct {
+		a, b Value
+		want bool
+	}{
+		{Dec("4"), Dec("4"), true},
+		{Dec("4"), Dec("4.0"), true},
+		{Dec("4"), Dec("5"), false},
+		{Txt("a,b"), Txt("a,b"), true},
+		{Txt("a"), Txt("b"), false},
+		{NewNull(TypeDecimal), NewNull(TypeDecimal), true},
+		{NewNull(TypeDecimal), Dec("4"), false},
+		{Dec("4"), NewNull(TypeDecimal), false},
+		{NewNull(TypeDecimal), NewNull(TypeText), false},
+		{Txt("true"), Boo(true), false},
+	}
+	for _, c := range cases {
+		got := c.a.Identical(c.b)
+		if got != c.want {
+			t.Errorf("(%v).Identical(%v) == %v, want %v", c.a, c.b, got, c.want)
+		}
+		gotKey := c.a.Key() == c.b.Key()
+		if gotKey != c.want {
+			t.Errorf("(%v).Key() == (%v).Key() is %v, want %v", c.a, c.b, gotKey, c.want)
+		}
+	}
+}
+
+func TestRowKey(t *testing.T) {
+	cases := []struct {
+		a, b []Value
+		want bool
+	}{
+		{nil, nil, true},
+		{[]Value{Dec("1"), Txt("a")}, []Value{Dec("1"), Txt("a")}, true},
+		{[]Value{Dec("1"), NewNull(TypeText)}, []Value{Dec("1"), NewNull(TypeText)}, true},
+		{[]Value{Dec("1"), Txt("a")}, []Value{Dec("1"), Txt("b")}, false},
+		{[]Value{Txt("a,"), Txt("b")}, []Value{Txt("a"), Txt(",b")}, false},
+		{[]Value{Txt("a")}, []Value{Txt("a"), Txt("a")}, false},
+	}
+	for _, c := range cases {
+		got := RowKey(c.a) == RowKey(c.b)
+		if got != c.want {
+			t.Errorf("RowKey(%v) == RowKey(%v) is %v, want %v", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestValueSize(t *testing.T) {
+	// sizes grow with the number of digits and the length of strings
+	cases := []struct {
+		smaller, larger Value
+	}{
+		{NewNull(TypeDecimal), Dec("1")},
+		{Dec("1"), Dec("12345678901234567890")},
+		{Dec("1"), Dec("1.2345")},
+		{NewNull(TypeText), Txt("")},
+		{Txt("a"), Txt("hello, world")},
+	}
+	for _, c := range cases {
+		if c.smaller.Size() >= c.larger.Size() {
+			t.Errorf("(%v).Size() == %d, want less than (%v).Size() == %d",
+				c.smaller, c.smaller.Size(), c.larger, c.larger.Size())
+		}
+	}
+	if got, want := Txt("hello").Size()-Txt("").Size(), 5; got != want {
+		t.Errorf("Text of length 5 uses %d more bytes than empty Text, want %d", got, want)
+	}
+
+	row := []Value{Dec("1"), Txt("hello")}
+	if got, want := RowSize(row), RowSize(nil)+row[0].Size()+row[1].Size(); got != want {
+		t.Errorf("RowSize(%v) == %d, want %d", row, got, want)
+	}
+}